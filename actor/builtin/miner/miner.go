@@ -78,6 +78,12 @@ const (
 	ErrGetProofsModeFailed = 42
 	// ErrInsufficientCollateral indicates that the miner does not have sufficient collateral to commit additional sectors.
 	ErrInsufficientCollateral = 43
+	// ErrSectorNotCommitted indicates that a piece inclusion proof refers to a sector the miner has not committed.
+	ErrSectorNotCommitted = 44
+	// ErrProofsOutOfDate indicates that the miner's PoSts are too old to verify piece inclusion proofs against.
+	ErrProofsOutOfDate = 45
+	// ErrInvalidInclusionProof indicates that a piece inclusion proof did not validate.
+	ErrInvalidInclusionProof = 46
 )
 
 // Errors map error codes to revert errors this actor may return.
@@ -92,6 +98,9 @@ var Errors = map[uint8]error{
 	ErrInvalidSealProof:        errors.NewCodedRevertErrorf(ErrInvalidSealProof, "seal proof was invalid"),
 	ErrGetProofsModeFailed:     errors.NewCodedRevertErrorf(ErrGetProofsModeFailed, "failed to get proofs mode"),
 	ErrInsufficientCollateral:  errors.NewCodedRevertErrorf(ErrInsufficientCollateral, "insufficient collateral"),
+	ErrSectorNotCommitted:      errors.NewCodedRevertErrorf(ErrSectorNotCommitted, "sector not committed"),
+	ErrProofsOutOfDate:         errors.NewCodedRevertErrorf(ErrProofsOutOfDate, "proofs out of date"),
+	ErrInvalidInclusionProof:   errors.NewCodedRevertErrorf(ErrInvalidInclusionProof, "invalid inclusion proof"),
 }
 
 // Actor is the miner actor.
@@ -206,6 +215,14 @@ var minerExports = exec.Exports{
 		Params: []abi.Type{abi.AttoFIL, abi.Integer},
 		Return: []abi.Type{abi.Integer},
 	},
+	"cancelAsk": &exec.FunctionSignature{
+		Params: []abi.Type{abi.Integer},
+		Return: []abi.Type{},
+	},
+	"pruneExpiredAsks": &exec.FunctionSignature{
+		Params: nil,
+		Return: []abi.Type{abi.Integer},
+	},
 	"getAsks": &exec.FunctionSignature{
 		Params: nil,
 		Return: []abi.Type{abi.UintArray},
@@ -293,14 +310,7 @@ func (ma *Actor) AddAsk(ctx exec.VMContext, price types.AttoFIL, expiry *big.Int
 		id := big.NewInt(0).Set(state.NextAskID)
 		state.NextAskID = state.NextAskID.Add(state.NextAskID, big.NewInt(1))
 
-		// filter out expired asks
-		asks := state.Asks
-		state.Asks = state.Asks[:0]
-		for _, a := range asks {
-			if ctx.BlockHeight().LessThan(a.Expiry) {
-				state.Asks = append(state.Asks, a)
-			}
-		}
+		pruneExpiredAsks(&state, ctx.BlockHeight())
 
 		if !expiry.IsUint64() {
 			return nil, errors.NewRevertError("expiry was invalid")
@@ -327,6 +337,61 @@ func (ma *Actor) AddAsk(ctx exec.VMContext, price types.AttoFIL, expiry *big.Int
 	return askID, 0, nil
 }
 
+// CancelAsk removes an ask from this miners ask list, so clients can no longer
+// propose deals against it.
+func (ma *Actor) CancelAsk(ctx exec.VMContext, askid *big.Int) (uint8, error) {
+	if err := ctx.Charge(actor.DefaultGasCost); err != nil {
+		return exec.ErrInsufficientGas, errors.RevertErrorWrap(err, "Insufficient gas")
+	}
+
+	var state State
+	_, err := actor.WithState(ctx, &state, func() (interface{}, error) {
+		if ctx.Message().From != state.Worker {
+			return nil, Errors[ErrCallerUnauthorized]
+		}
+
+		for i, a := range state.Asks {
+			if a.ID.Cmp(askid) == 0 {
+				state.Asks = append(state.Asks[:i], state.Asks[i+1:]...)
+				return nil, nil
+			}
+		}
+		return nil, Errors[ErrAskNotFound]
+	})
+	if err != nil {
+		return errors.CodeError(err), err
+	}
+
+	return 0, nil
+}
+
+// PruneExpiredAsks removes the expired asks from this miners ask list, and
+// returns how many were removed.
+func (ma *Actor) PruneExpiredAsks(ctx exec.VMContext) (*big.Int, uint8, error) {
+	if err := ctx.Charge(actor.DefaultGasCost); err != nil {
+		return nil, exec.ErrInsufficientGas, errors.RevertErrorWrap(err, "Insufficient gas")
+	}
+
+	var state State
+	out, err := actor.WithState(ctx, &state, func() (interface{}, error) {
+		if ctx.Message().From != state.Worker {
+			return nil, Errors[ErrCallerUnauthorized]
+		}
+
+		return big.NewInt(int64(pruneExpiredAsks(&state, ctx.BlockHeight()))), nil
+	})
+	if err != nil {
+		return nil, errors.CodeError(err), err
+	}
+
+	pruned, ok := out.(*big.Int)
+	if !ok {
+		return nil, 1, errors.NewRevertErrorf("expected an Integer return value from call, but got %T instead", out)
+	}
+
+	return pruned, 0, nil
+}
+
 // GetAsks returns all the asks for this miner. (TODO: this isnt a great function signature, it returns the asks in a
 // serialized array. Consider doing this some other way)
 func (ma *Actor) GetAsks(ctx exec.VMContext) ([]uint64, uint8, error) {
@@ -598,17 +663,17 @@ func (ma *Actor) VerifyPieceInclusion(ctx exec.VMContext, commP []byte, sectorID
 		sectorIDstr := strconv.FormatUint(sectorID, 10)
 		commitment, ok := state.SectorCommitments[sectorIDstr]
 		if !ok {
-			return nil, errors.NewRevertError("sector not committed")
+			return nil, Errors[ErrSectorNotCommitted]
 		}
 
 		// If miner is not up-to-date on their PoSts, proof is invalid
 		if state.LastPoSt == nil {
-			return nil, errors.NewRevertError("proofs out of date")
+			return nil, Errors[ErrProofsOutOfDate]
 		}
 
 		clientProofsTimeout := state.LastPoSt.Add(types.NewBlockHeight(PieceInclusionGracePeriodBlocks))
 		if ctx.BlockHeight().GreaterThan(clientProofsTimeout) {
-			return nil, errors.NewRevertError("proofs out of date")
+			return nil, Errors[ErrProofsOutOfDate]
 		}
 
 		// Verify proof proves CommP is in sector's CommD
@@ -620,7 +685,7 @@ func (ma *Actor) VerifyPieceInclusion(ctx exec.VMContext, commP []byte, sectorID
 		}
 
 		if !valid {
-			return nil, errors.NewRevertError("invalid inclusion proof")
+			return nil, Errors[ErrInvalidInclusionProof]
 		}
 
 		return nil, nil
@@ -929,6 +994,19 @@ func LatePoStFee(pledgeCollateral types.AttoFIL, provingPeriodEnd *types.BlockHe
 // Internal functions
 //
 
+// pruneExpiredAsks removes the asks expired at height from the miner's ask list,
+// and returns how many were removed.
+func pruneExpiredAsks(state *State, height *types.BlockHeight) int {
+	asks := state.Asks
+	state.Asks = state.Asks[:0]
+	for _, a := range asks {
+		if height.LessThan(a.Expiry) {
+			state.Asks = append(state.Asks, a)
+		}
+	}
+	return len(asks) - len(state.Asks)
+}
+
 func currentProvingPeriodPoStChallengeSeed(ctx exec.VMContext, state State) (types.PoStChallengeSeed, error) {
 	bytes, err := ctx.SampleChainRandomness(state.ProvingPeriodEnd)
 	if err != nil {
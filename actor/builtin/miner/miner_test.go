@@ -92,6 +92,87 @@ func TestAskFunctions(t *testing.T) {
 	assert.Len(t, askids, 2)
 }
 
+func TestCancelAsk(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	st, vms := th.RequireCreateStorages(ctx, t)
+
+	minerAddr := th.CreateTestMiner(t, st, vms, address.TestAddress, th.RequireRandomPeerID(t))
+
+	pdata := actor.MustConvertParams(types.NewAttoFILFromFIL(5), big.NewInt(1500))
+	msg := types.NewMessage(address.TestAddress, minerAddr, 1, types.ZeroAttoFIL, "addAsk", pdata)
+	_, err := th.ApplyTestMessage(st, vms, msg, types.NewBlockHeight(1))
+	require.NoError(t, err)
+
+	t.Run("only the worker can cancel an ask", func(t *testing.T) {
+		pdata := actor.MustConvertParams(big.NewInt(0))
+		msg := types.NewMessage(address.TestAddress2, minerAddr, th.RequireGetNonce(t, st, address.TestAddress2), types.ZeroAttoFIL, "cancelAsk", pdata)
+		result, err := th.ApplyTestMessage(st, vms, msg, types.NewBlockHeight(2))
+		require.NoError(t, err)
+		assert.Equal(t, Errors[ErrCallerUnauthorized], result.ExecutionError)
+	})
+
+	t.Run("cancels an ask", func(t *testing.T) {
+		pdata := actor.MustConvertParams(big.NewInt(0))
+		msg := types.NewMessage(address.TestAddress, minerAddr, 2, types.ZeroAttoFIL, "cancelAsk", pdata)
+		result, err := th.ApplyTestMessage(st, vms, msg, types.NewBlockHeight(2))
+		require.NoError(t, err)
+		require.NoError(t, result.ExecutionError)
+
+		pdata = actor.MustConvertParams(big.NewInt(0))
+		msg = types.NewMessage(address.TestAddress, minerAddr, 3, types.ZeroAttoFIL, "getAsk", pdata)
+		result, err = th.ApplyTestMessage(st, vms, msg, types.NewBlockHeight(3))
+		require.NoError(t, err)
+		assert.Equal(t, Errors[ErrAskNotFound], result.ExecutionError)
+	})
+
+	t.Run("fails to cancel an ask that doesn't exist", func(t *testing.T) {
+		pdata := actor.MustConvertParams(big.NewInt(3453))
+		msg := types.NewMessage(address.TestAddress, minerAddr, 4, types.ZeroAttoFIL, "cancelAsk", pdata)
+		result, err := th.ApplyTestMessage(st, vms, msg, types.NewBlockHeight(4))
+		require.NoError(t, err)
+		assert.Equal(t, Errors[ErrAskNotFound], result.ExecutionError)
+	})
+}
+
+func TestPruneExpiredAsks(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	st, vms := th.RequireCreateStorages(ctx, t)
+
+	minerAddr := th.CreateTestMiner(t, st, vms, address.TestAddress, th.RequireRandomPeerID(t))
+
+	pdata := actor.MustConvertParams(types.NewAttoFILFromFIL(5), big.NewInt(10))
+	msg := types.NewMessage(address.TestAddress, minerAddr, 1, types.ZeroAttoFIL, "addAsk", pdata)
+	_, err := th.ApplyTestMessage(st, vms, msg, types.NewBlockHeight(1))
+	require.NoError(t, err)
+
+	pdata = actor.MustConvertParams(types.NewAttoFILFromFIL(6), big.NewInt(1000))
+	msg = types.NewMessage(address.TestAddress, minerAddr, 2, types.ZeroAttoFIL, "addAsk", pdata)
+	_, err = th.ApplyTestMessage(st, vms, msg, types.NewBlockHeight(2))
+	require.NoError(t, err)
+
+	msg = types.NewMessage(address.TestAddress, minerAddr, 3, types.ZeroAttoFIL, "pruneExpiredAsks", nil)
+	result, err := th.ApplyTestMessage(st, vms, msg, types.NewBlockHeight(20))
+	require.NoError(t, err)
+	require.NoError(t, result.ExecutionError)
+	assert.Equal(t, big.NewInt(1), big.NewInt(0).SetBytes(result.Receipt.Return[0]))
+
+	miner, err := st.GetActor(ctx, minerAddr)
+	require.NoError(t, err)
+
+	var minerStorage State
+	builtin.RequireReadState(t, vms, minerAddr, miner, &minerStorage)
+	require.Len(t, minerStorage.Asks, 1)
+	assert.Equal(t, uint64(1), minerStorage.Asks[0].ID.Uint64())
+}
+
 func TestGetWorker(t *testing.T) {
 	tf.UnitTest(t)
 
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"strings"
+	"time"
 
 	"github.com/ipfs/go-ipfs-cmdkit"
 	"github.com/ipfs/go-ipfs-cmds"
@@ -12,6 +14,7 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/plumbing/history"
 	"github.com/filecoin-project/go-filecoin/types"
 )
 
@@ -23,6 +26,47 @@ var walletCmd = &cmds.Command{
 		"balance": balanceCmd,
 		"import":  walletImportCmd,
 		"export":  walletExportCmd,
+		"hd":      walletHDCmd,
+		"lock":    walletLockCmd,
+		"unlock":  walletUnlockCmd,
+	},
+}
+
+var walletUnlockCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Unlock an encrypted wallet",
+		ShortDescription: `
+Unlocks an encrypted wallet with its passphrase so that its keys can be used to
+sign messages and blocks. While locked, the wallet refuses to sign. The wallet
+locks again after --timeout, or when 'go-filecoin wallet lock' is run. A timeout
+of 0 keeps the wallet unlocked until it is locked explicitly or the daemon stops.
+
+The passphrase is read from stdin if it is not given as an argument.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("passphrase", true, false, "Passphrase the wallet is encrypted with").EnableStdin(),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.StringOption("timeout", "Time after which the wallet locks again. e.g., 300s, 1.5h, 2h45m.").WithDefault("5m"),
+	},
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		timeout, err := time.ParseDuration(req.Options["timeout"].(string))
+		if err != nil {
+			return errors.Wrap(err, "Invalid timeout string")
+		}
+
+		passphrase := strings.TrimRight(req.Arguments[0], "\r\n")
+		return GetPorcelainAPI(env).WalletUnlock([]byte(passphrase), timeout)
+	},
+}
+
+var walletLockCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Lock an encrypted wallet, so that it refuses to sign until unlocked",
+	},
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		return GetPorcelainAPI(env).WalletLock()
 	},
 }
 
@@ -35,6 +79,7 @@ var addrsCmd = &cmds.Command{
 		"new":     addrsNewCmd,
 		"lookup":  addrsLookupCmd,
 		"default": defaultAddressCmd,
+		"history": addrsHistoryCmd,
 	},
 }
 
@@ -132,6 +177,52 @@ var defaultAddressCmd = &cmds.Command{
 	},
 }
 
+var addrsHistoryCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "List the messages and value transfers from or to an address",
+		ShortDescription: `
+Lists, oldest first, the messages on chain from or to an address, and the value
+transferred from or to it by actors while executing messages. Transfers made by
+actors are marked "internal", and show the cid of the message that caused them.
+The node must be configured to index address history (history.enabled).
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("address", true, false, "Address to list the history of"),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.Uint64Option("since", "Only list entries at or above this block height").WithDefault(uint64(0)),
+	},
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		addr, err := address.NewFromString(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+
+		entries, err := GetPorcelainAPI(env).AddressHistory(addr, req.Options["since"].(uint64))
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := re.Emit(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Type: history.Entry{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, entry *history.Entry) error {
+			kind := "message"
+			if entry.Internal {
+				kind = "internal"
+			}
+			_, err := fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%d\n", entry.Height, entry.MessageCid, kind, entry.From, entry.To, entry.Value, entry.Method, entry.ExitCode)
+			return err
+		}),
+	},
+}
+
 var balanceCmd = &cmds.Command{
 	Arguments: []cmdkit.Argument{
 		cmdkit.StringArg("address", true, false, "Address to get balance for"),
@@ -162,3 +162,26 @@ func TestWalletExportPrivateKeyConsistentDisplay(t *testing.T) {
 
 	assert.Contains(t, exportJSON, exportTextPrivateKey)
 }
+
+func TestWalletHDRestore(t *testing.T) {
+	tf.IntegrationTest(t)
+
+	d1 := th.NewDaemon(t).Start()
+	defer d1.ShutdownSuccess()
+
+	mnemonic := d1.RunSuccess("wallet", "hd", "new").ReadStdoutTrimNewlines()
+	assert.Len(t, strings.Fields(mnemonic), 24)
+	assert.Equal(t, mnemonic, d1.RunSuccess("wallet", "hd", "export").ReadStdoutTrimNewlines())
+	d1.RunFail("already has a seed", "wallet", "hd", "new")
+
+	addr1 := d1.CreateAddress()
+	addr2 := d1.CreateAddress()
+
+	t.Log("a wallet restored from the mnemonic derives the same addresses")
+	d2 := th.NewDaemon(t).Start()
+	defer d2.ShutdownSuccess()
+
+	d2.RunSuccess("wallet", "hd", "import", mnemonic)
+	assert.Equal(t, addr1, d2.CreateAddress())
+	assert.Equal(t, addr2, d2.CreateAddress())
+}
@@ -1,17 +1,23 @@
 package commands
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"strconv"
+	"text/tabwriter"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-ipfs-cmdkit"
 	"github.com/ipfs/go-ipfs-cmds"
 	"github.com/ipfs/go-ipfs-files"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/pkg/errors"
 
 	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/plumbing/datakeys"
 	"github.com/filecoin-project/go-filecoin/porcelain"
+	"github.com/filecoin-project/go-filecoin/protocol/storage"
 	"github.com/filecoin-project/go-filecoin/protocol/storage/storagedeal"
 	"github.com/filecoin-project/go-filecoin/types"
 )
@@ -25,8 +31,12 @@ var clientCmd = &cmds.Command{
 		"import":               clientImportDataCmd,
 		"propose-storage-deal": clientProposeStorageDealCmd,
 		"query-storage-deal":   clientQueryStorageDealCmd,
+		"store":                clientStoreCmd,
 		"list-asks":            clientListAsksCmd,
 		"payments":             paymentsCmd,
+		"deals":                clientDealsCmd,
+		"data-keys":            clientDataKeysCmd,
+		"verify-deal":          clientVerifyDealCmd,
 	},
 }
 
@@ -36,7 +46,8 @@ var clientCatCmd = &cmds.Command{
 		ShortDescription: `
 Prints data from the storage market specified with a given CID to stdout. The
 only argument should be the CID to return. The data will be returned in whatever
-format was provided with the data initially.
+format was provided with the data initially. Data imported with --encrypt is
+decrypted if its key is in the local key store.
 `,
 	},
 	Arguments: []cmdkit.Argument{
@@ -53,7 +64,12 @@ format was provided with the data initially.
 			return err
 		}
 
-		return re.Emit(dr)
+		r, err := GetPorcelainAPI(env).ClientDecrypt(c, dr)
+		if err != nil {
+			return err
+		}
+
+		return re.Emit(r)
 	},
 }
 
@@ -64,11 +80,20 @@ var clientImportDataCmd = &cmds.Command{
 Imports data previously exported with the client cat command into the storage
 market. This command takes only one argument, the path of the file to import.
 See the go-filecoin client cat command for more details.
+
+With --encrypt, the data is encrypted with a new key, or the hex encoded key
+given with --key, before it is imported. The key is kept in the local key store
+by the CID of the imported data, so client cat can decrypt it. Use client
+data-keys export to decrypt the data on another node.
 `,
 	},
 	Arguments: []cmdkit.Argument{
 		cmdkit.FileArg("file", true, false, "Path to file to import").EnableStdin(),
 	},
+	Options: []cmdkit.Option{
+		cmdkit.BoolOption("encrypt", "Encrypt the data before importing it"),
+		cmdkit.StringOption("key", "Hex encoded key to encrypt the data with, implies --encrypt"),
+	},
 	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
 		iter := req.Files.Entries()
 		if !iter.Next() {
@@ -80,7 +105,23 @@ See the go-filecoin client cat command for more details.
 			return fmt.Errorf("given file was not a files.File")
 		}
 
-		out, err := GetPorcelainAPI(env).DAGImportData(req.Context, fi)
+		encrypt, _ := req.Options["encrypt"].(bool)
+		var key []byte
+		if hexKey, ok := req.Options["key"].(string); ok {
+			var err error
+			if key, err = datakeys.ParseKey(hexKey); err != nil {
+				return err
+			}
+			encrypt = true
+		}
+
+		var out ipld.Node
+		var err error
+		if encrypt {
+			out, err = GetPorcelainAPI(env).ClientImportEncrypted(req.Context, fi, key)
+		} else {
+			out, err = GetPorcelainAPI(env).DAGImportData(req.Context, fi)
+		}
 		if err != nil {
 			return err
 		}
@@ -111,6 +152,15 @@ Duration should be specified with the number of blocks for which to store the
 data. New blocks are generated about every 30 seconds, so the time given should
 be represented as a count of 30 second intervals. For example, 1 minute would
 be 2, 1 hour would be 120, and 1 day would be 2880.
+
+If --retries is given and the miner rejects the proposal, or the deal fails
+later, the deal is proposed to other miners, cheapest ask first, up to that
+many times. Only asks of at most --max-retry-price are used.
+
+With --offline, the miner does not fetch the data over the network. Send it
+to the miner by other means, as a file or a CAR, for them to import with:
+
+$ go-filecoin miner import-deal-data <deal id> <file>
 `,
 	},
 	Arguments: []cmdkit.Argument{
@@ -121,9 +171,19 @@ be 2, 1 hour would be 120, and 1 day would be 2880.
 	},
 	Options: []cmdkit.Option{
 		cmdkit.BoolOption("allow-duplicates", "Allows duplicate proposals to be created. Unless this flag is set, you will not be able to make more than one deal per piece per miner. This protection exists to prevent erroneous duplicate deals."),
+		cmdkit.BoolOption("offline", "Send the data to the miner out of band; the miner imports it with 'miner import-deal-data'"),
+		cmdkit.UintOption("retries", "Number of other miners to propose the deal to if it is rejected or fails").WithDefault(uint(0)),
+		cmdkit.StringOption("max-retry-price", "Highest ask price, in FIL per byte per block, to propose the deal again at").WithDefault("0"),
 	},
 	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
 		allowDuplicates, _ := req.Options["allow-duplicates"].(bool)
+		offline, _ := req.Options["offline"].(bool)
+
+		retries, _ := req.Options["retries"].(uint)
+		maxRetryPrice, ok := types.NewAttoFILFromFILString(req.Options["max-retry-price"].(string))
+		if !ok {
+			return ErrInvalidPrice
+		}
 
 		miner, err := address.NewFromString(req.Arguments[0])
 		if err != nil {
@@ -145,7 +205,8 @@ be 2, 1 hour would be 120, and 1 day would be 2880.
 			return err
 		}
 
-		resp, err := GetStorageAPI(env).ProposeStorageDeal(req.Context, data, miner, askid, duration, allowDuplicates)
+		retry := storage.RetryPolicy{Retries: retries, MaxPrice: maxRetryPrice}
+		resp, err := GetStorageAPI(env).ProposeStorageDeal(req.Context, data, miner, askid, duration, allowDuplicates, offline, retry)
 		if err != nil {
 			return err
 		}
@@ -163,6 +224,90 @@ be 2, 1 hour would be 120, and 1 day would be 2880.
 	},
 }
 
+var clientStoreCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Store data with several storage miners",
+		ShortDescription: `
+Stores data with --replicas distinct miners. The data is proposed to the
+cheapest reachable miners asking at most --max-price, with payments set up as
+for client propose-storage-deal. When a deal is rejected or fails, the data is
+proposed to the next miner, until enough deals are complete or no miner is left
+within the price. With --budget, no deal is proposed that would bring the total
+price of the deals accepted, including those that failed since, above it.
+
+The command doesn't return until enough deals are complete, which takes at
+least as long as sealing the data; use client deals watch to follow the deals
+meanwhile. Deals already proposed are still followed if the command is
+interrupted.
+
+The state of every deal proposed is printed as a table once done.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("data", true, false, "CID of the data to be stored"),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.UintOption("replicas", "Number of miners to store the data with").WithDefault(uint(1)),
+		cmdkit.StringOption("max-price", "Highest ask price, in FIL per byte per block, to store the data at"),
+		cmdkit.Uint64Option("duration", "Time in blocks (about 30 seconds per block) to store data"),
+		cmdkit.StringOption("budget", "Most FIL to spend on the deals in total"),
+	},
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		data, err := cid.Decode(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+
+		replicas, _ := req.Options["replicas"].(uint)
+
+		maxPriceStr, ok := req.Options["max-price"].(string)
+		if !ok {
+			return errors.New("--max-price is required")
+		}
+		maxPrice, ok := types.NewAttoFILFromFILString(maxPriceStr)
+		if !ok {
+			return ErrInvalidPrice
+		}
+
+		duration, ok := req.Options["duration"].(uint64)
+		if !ok || duration == 0 {
+			return errors.New("--duration is required")
+		}
+
+		var budget *types.AttoFIL
+		if budgetStr, ok := req.Options["budget"].(string); ok {
+			b, ok := types.NewAttoFILFromFILString(budgetStr)
+			if !ok {
+				return ErrInvalidPrice
+			}
+			budget = &b
+		}
+
+		statuses, err := GetStorageAPI(env).Replicate(req.Context, data, replicas, duration, maxPrice, budget)
+		if statuses != nil {
+			if emitErr := re.Emit(statuses); emitErr != nil {
+				return emitErr
+			}
+		}
+		return err
+	},
+	Type: []*storage.ReplicaStatus{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, statuses []*storage.ReplicaStatus) error {
+			tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "MINER\tDEAL\tSTATE\tMESSAGE") // nolint: errcheck
+			for _, status := range statuses {
+				dealID := "-"
+				if status.ProposalCid.Defined() {
+					dealID = status.ProposalCid.String()
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", status.Miner, dealID, status.State, status.Message) // nolint: errcheck
+			}
+			return tw.Flush()
+		}),
+	},
+}
+
 var clientQueryStorageDealCmd = &cmds.Command{
 	Helptext: cmdkit.HelpText{
 		Tagline: "Query a storage deal's status",
@@ -198,17 +343,279 @@ format is specified with the --enc flag.
 	},
 }
 
+var clientVerifyDealCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Verify that the data of complete storage deals is still proven",
+		ShortDescription: `
+Checks on chain that the sector holding the data of the deal specified by the id
+is still committed, that its miner is up to date with its proofs of spacetime
+and that the piece inclusion proof the miner gave verifies. Without an id, every
+complete deal is verified. Prints the verdict of each deal: healthy, pending
+when the proof can't be verified until the miner's next PoSt (as for a deal
+completed before the miner's first PoSt), at-risk when the miner is late with its
+PoSt or the deal ended, or lost, followed by the problems found.
+
+The node verifies complete deals periodically, and alerts on deals that got
+worse through 'client deals watch'.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("id", false, false, "CID of deal to verify"),
+	},
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		if len(req.Arguments) == 0 {
+			healths, err := GetStorageAPI(env).VerifyCompleteDeals(req.Context)
+			if err != nil {
+				return err
+			}
+			for _, health := range healths {
+				if err := re.Emit(health); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		proposalCid, err := cid.Decode(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+		health, err := GetPorcelainAPI(env).ClientVerifyDeal(req.Context, proposalCid)
+		if err != nil {
+			return err
+		}
+		return re.Emit(health)
+	},
+	Type: porcelain.DealHealth{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, health *porcelain.DealHealth) error {
+			if _, err := fmt.Fprintf(w, "%s %s %d %s\n", health.ProposalCid, health.Miner, health.SectorID, health.Verdict); err != nil {
+				return err
+			}
+			for _, problem := range health.Problems {
+				if _, err := fmt.Fprintf(w, "\t%s\n", problem); err != nil {
+					return err
+				}
+			}
+			return nil
+		}),
+	},
+}
+
+var clientDealsCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Follow the storage deals proposed by this node",
+	},
+	Subcommands: map[string]*cmds.Command{
+		"watch":      clientDealsWatchCmd,
+		"renew":      clientDealsRenewCmd,
+		"auto-renew": clientDealsAutoRenewCmd,
+	},
+}
+
+var clientDealsRenewCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Renew a complete storage deal",
+		ShortDescription: `
+Proposes to the miner of a complete deal to store its data for --duration more
+blocks, counted from now, at the miner's cheapest ask, with new payments. A
+miner still holding the data in a sector doesn't seal it again. If the miner
+rejects the renewal and --retries is given, the data is proposed to other
+miners as for client propose-storage-deal, which needs the data to be held
+locally.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("id", true, false, "CID of the deal to renew"),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.Uint64Option("duration", "Time in blocks (about 30 seconds per block) to store data"),
+		cmdkit.UintOption("retries", "Number of other miners to propose the deal to if the renewal is rejected").WithDefault(uint(0)),
+		cmdkit.StringOption("max-retry-price", "Highest ask price, in FIL per byte per block, to propose the deal to other miners at").WithDefault("0"),
+	},
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		proposalCid, err := cid.Decode(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+
+		duration, ok := req.Options["duration"].(uint64)
+		if !ok || duration == 0 {
+			return errors.New("--duration is required")
+		}
+
+		retries, _ := req.Options["retries"].(uint)
+		maxRetryPrice, ok := types.NewAttoFILFromFILString(req.Options["max-retry-price"].(string))
+		if !ok {
+			return ErrInvalidPrice
+		}
+
+		retry := storage.RetryPolicy{Retries: retries, MaxPrice: maxRetryPrice}
+		resp, err := GetStorageAPI(env).RenewDeal(req.Context, proposalCid, duration, retry)
+		if err != nil {
+			return err
+		}
+
+		return re.Emit(resp)
+	},
+	Type: storagedeal.Response{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, resp *storagedeal.Response) error {
+			fmt.Fprintf(w, "State:   %s\n", resp.State.String())       // nolint: errcheck
+			fmt.Fprintf(w, "Message: %s\n", resp.Message)              // nolint: errcheck
+			fmt.Fprintf(w, "DealID:  %s\n", resp.ProposalCid.String()) // nolint: errcheck
+			return nil
+		}),
+	},
+}
+
+var clientDealsAutoRenewCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Renew a storage deal automatically before it ends",
+		ShortDescription: `
+Sets the policy to renew a deal --before blocks before it ends, for --duration
+blocks, as client deals renew does. Each renewal is renewed in turn under the
+same policy. Use --off to stop renewing the deal.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("id", true, false, "CID of the deal to renew"),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.Uint64Option("duration", "Time in blocks to renew the deal for"),
+		cmdkit.Uint64Option("before", "Number of blocks before the deal ends to renew it").WithDefault(uint64(120)),
+		cmdkit.Uint64Option("retries", "Number of other miners to propose the deal to if the renewal is rejected").WithDefault(uint64(0)),
+		cmdkit.StringOption("max-retry-price", "Highest ask price, in FIL per byte per block, to propose the deal to other miners at").WithDefault("0"),
+		cmdkit.BoolOption("off", "Stop renewing the deal"),
+	},
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		proposalCid, err := cid.Decode(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+
+		if off, _ := req.Options["off"].(bool); off {
+			return GetStorageAPI(env).SetRenewPolicy(req.Context, proposalCid, nil)
+		}
+
+		duration, ok := req.Options["duration"].(uint64)
+		if !ok || duration == 0 {
+			return errors.New("--duration is required")
+		}
+
+		maxRetryPrice, ok := types.NewAttoFILFromFILString(req.Options["max-retry-price"].(string))
+		if !ok {
+			return ErrInvalidPrice
+		}
+
+		return GetStorageAPI(env).SetRenewPolicy(req.Context, proposalCid, &storagedeal.RenewPolicy{
+			Duration: duration,
+			Before:   req.Options["before"].(uint64),
+			Retries:  req.Options["retries"].(uint64),
+			MaxPrice: maxRetryPrice,
+		})
+	},
+}
+
+var clientDealsWatchCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show the progress of storage deals as it happens",
+		ShortDescription: `
+Prints the state of the storage deals in progress, then each change of state
+until interrupted. If deal ids are given, only those deals are shown. Deals
+proposed to another miner after failing are shown with the id of the new deal.
+Complete deals found at risk or lost are shown with the problems found.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("id", false, true, "CIDs of deals to watch"),
+	},
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		watched := make(map[cid.Cid]bool)
+		for _, arg := range req.Arguments {
+			c, err := cid.Decode(arg)
+			if err != nil {
+				return err
+			}
+			watched[c] = true
+		}
+		shown := func(u *storage.DealUpdate) bool {
+			if len(watched) == 0 {
+				return true
+			}
+			if !watched[u.ProposalCid] {
+				return false
+			}
+			// follow the deal to the miner it is proposed to next
+			if u.Replacement.Defined() {
+				watched[u.Replacement] = true
+			}
+			return true
+		}
+
+		// subscribe first so no change is missed between listing and watching
+		updates, unsubscribe := GetStorageAPI(env).WatchDeals()
+		defer unsubscribe()
+
+		tracked, err := GetStorageAPI(env).TrackedDeals(req.Context)
+		if err != nil {
+			return err
+		}
+		for _, u := range tracked {
+			if !shown(u) {
+				continue
+			}
+			if err := re.Emit(u); err != nil {
+				return err
+			}
+		}
+
+		for {
+			select {
+			case <-req.Context.Done():
+				return nil
+			case u, ok := <-updates:
+				if !ok {
+					return nil
+				}
+				if !shown(&u) {
+					continue
+				}
+				if err := re.Emit(&u); err != nil {
+					return err
+				}
+			}
+		}
+	},
+	Type: storage.DealUpdate{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, u *storage.DealUpdate) error {
+			fmt.Fprintf(w, "%s %s %s %s\n", u.ProposalCid, u.Miner, u.State, u.Message) // nolint: errcheck
+			if u.Replacement.Defined() {
+				fmt.Fprintf(w, "%s proposed again as %s\n", u.ProposalCid, u.Replacement) // nolint: errcheck
+			}
+			return nil
+		}),
+	},
+}
+
 var clientListAsksCmd = &cmds.Command{
 	Helptext: cmdkit.HelpText{
 		Tagline: "List all asks in the storage market",
 		ShortDescription: `
-Lists all asks in the storage market. This command takes no arguments. Results
-will be returned as a space separated table with miner, id, price and expiration
-respectively.
+Lists all asks in the storage market that haven't expired. This command takes
+no arguments. Results will be returned as a space separated table with miner,
+id, price and expiration respectively.
 `,
 	},
+	Options: []cmdkit.Option{
+		cmdkit.BoolOption("include-expired", "Also list the expired asks"),
+	},
 	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
 		asksCh := GetPorcelainAPI(env).ClientListAsks(req.Context)
+		if includeExpired, _ := req.Options["include-expired"].(bool); includeExpired {
+			asksCh = GetPorcelainAPI(env).ClientListAllAsks(req.Context)
+		}
 
 		for a := range asksCh {
 			if a.Error != nil {
@@ -271,3 +678,82 @@ var paymentsCmd = &cmds.Command{
 		}),
 	},
 }
+
+var clientDataKeysCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Manage the keys of data imported with --encrypt",
+	},
+	Subcommands: map[string]*cmds.Command{
+		"export": clientDataKeysExportCmd,
+		"import": clientDataKeysImportCmd,
+	},
+}
+
+var clientDataKeysExportCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Export the keys of encrypted data",
+		ShortDescription: `
+Prints the keys of all the data imported with --encrypt as JSON, so they can be
+imported on another node with client data-keys import. Anyone holding the keys
+can decrypt the data.
+`,
+	},
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		entries, err := GetPorcelainAPI(env).DataKeysLs()
+		if err != nil {
+			return err
+		}
+		if entries == nil {
+			entries = []*datakeys.Entry{}
+		}
+		return re.Emit(entries)
+	},
+	Type: []*datakeys.Entry{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, entries []*datakeys.Entry) error {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(entries)
+		}),
+	},
+}
+
+var clientDataKeysImportCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Import keys exported with client data-keys export",
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.FileArg("file", true, false, "Path to the exported keys").EnableStdin(),
+	},
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		iter := req.Files.Entries()
+		if !iter.Next() {
+			return fmt.Errorf("no file given: %s", iter.Err())
+		}
+
+		fi, ok := iter.Node().(files.File)
+		if !ok {
+			return fmt.Errorf("given file was not a files.File")
+		}
+
+		var entries []*datakeys.Entry
+		if err := json.NewDecoder(fi).Decode(&entries); err != nil {
+			return fmt.Errorf("invalid keys file: %s", err)
+		}
+
+		for _, entry := range entries {
+			if err := GetPorcelainAPI(env).DataKeyPut(entry.Root, entry.Key); err != nil {
+				return err
+			}
+		}
+
+		return re.Emit(uint64(len(entries)))
+	},
+	Type: uint64(0),
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, n uint64) error {
+			_, err := fmt.Fprintf(w, "imported %d keys\n", n)
+			return err
+		}),
+	},
+}
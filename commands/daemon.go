@@ -135,12 +135,13 @@ func runAPIAndWait(ctx context.Context, nd *node.Node, config *config.Config, re
 
 	servenv := &Env{
 		// TODO: should this be the passed in context?  Issue 2641
-		blockMiningAPI: nd.BlockMiningAPI,
-		ctx:            context.Background(),
-		inspectorAPI:   NewInspectorAPI(nd.Repo),
-		porcelainAPI:   nd.PorcelainAPI,
-		retrievalAPI:   nd.RetrievalAPI,
-		storageAPI:     nd.StorageAPI,
+		blockMiningAPI:  nd.BlockMiningAPI,
+		ctx:             context.Background(),
+		inspectorAPI:    NewInspectorAPI(nd.Repo),
+		porcelainAPI:    nd.PorcelainAPI,
+		retrievalAPI:    nd.RetrievalAPI,
+		storageAPI:      nd.StorageAPI,
+		storageMinerAPI: nd.StorageMinerAPI,
 	}
 
 	cfg := cmdhttp.NewServerConfig()
@@ -2,8 +2,11 @@ package commands
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-ipfs-cmdkit"
@@ -11,6 +14,8 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/plumbing/strgdls"
+	"github.com/filecoin-project/go-filecoin/protocol/storage"
 	"github.com/filecoin-project/go-filecoin/protocol/storage/storagedeal"
 	"github.com/filecoin-project/go-filecoin/types"
 )
@@ -25,18 +30,39 @@ var dealsCmd = &cmds.Command{
 		Tagline: "Manage and inspect deals made by or with this node",
 	},
 	Subcommands: map[string]*cmds.Command{
-		"list":   dealsListCmd,
-		"redeem": dealsRedeemCmd,
-		"show":   dealsShowCmd,
+		"export":   dealsExportCmd,
+		"list":     dealsListCmd,
+		"redeem":   dealsRedeemCmd,
+		"show":     dealsShowCmd,
+		"vouchers": dealsVouchersCmd,
 	},
 }
 
 // DealsListResult represents the subset of deal data returned by deals list
 type DealsListResult struct {
-	Miner       address.Address `json:"minerAddress"`
-	PieceCid    cid.Cid         `json:"pieceCid"`
-	ProposalCid cid.Cid         `json:"proposalCid"`
-	State       string          `json:"state"`
+	Miner       address.Address    `json:"minerAddress"`
+	Client      address.Address    `json:"clientAddress"`
+	PieceCid    cid.Cid            `json:"pieceCid"`
+	ProposalCid cid.Cid            `json:"proposalCid"`
+	State       string             `json:"state"`
+	Created     *time.Time         `json:"created,omitempty"`
+	Expiry      *types.BlockHeight `json:"expiry,omitempty"`
+}
+
+// dealsQueryOptions are the options of the commands selecting deals with a query.
+var dealsQueryOptions = []cmdkit.Option{
+	cmdkit.BoolOption(clientOnly, "c", "only return deals made as a client"),
+	cmdkit.BoolOption(minerOnly, "m", "only return deals made as a miner"),
+	cmdkit.StringOption("state", "only return deals in these comma separated states"),
+	cmdkit.StringOption("miner-address", "only return deals made with this miner"),
+	cmdkit.StringOption("client-address", "only return deals paid for by this client"),
+	cmdkit.StringOption("piece", "only return deals storing the piece with this CID"),
+	cmdkit.StringOption("created-after", "only return deals created at or after this RFC 3339 time"),
+	cmdkit.StringOption("created-before", "only return deals created before this RFC 3339 time"),
+	cmdkit.StringOption("expires-after", "only return deals ending at or after this block height"),
+	cmdkit.StringOption("expires-before", "only return deals ending before this block height"),
+	cmdkit.Uint64Option("offset", "number of matching deals to skip"),
+	cmdkit.Uint64Option("limit", "maximum number of deals to return, 0 for all"),
 }
 
 var dealsListCmd = &cmds.Command{
@@ -45,37 +71,42 @@ var dealsListCmd = &cmds.Command{
 		ShortDescription: `
 Lists all recorded deals made by or with this node. This may include pending
 deals, active deals, finished deals and cancelled deals.
+`,
+		LongDescription: `
+Lists all recorded deals made by or with this node. This may include pending
+deals, active deals, finished deals and cancelled deals. Deals are listed
+oldest first, and can be filtered by state, miner, client, piece, creation time
+and expiry, and paged through with --offset and --limit:
+
+  go-filecoin deals list --state=staged,complete --expires-before=20000 --limit=50
+
+Deal states are unknown, rejected, accepted, started, failed, staged and
+complete. Use deals export to get the full records of the deals as JSON.
 `,
 	},
-	Options: []cmdkit.Option{
-		cmdkit.BoolOption(clientOnly, "c", "only return deals made as a client"),
-		cmdkit.BoolOption(minerOnly, "m", "only return deals made as a miner"),
-	},
+	Options: dealsQueryOptions,
 	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
-		minerAddress, _ := GetPorcelainAPI(env).ConfigGet("mining.minerAddress")
-		dealsCh, err := GetPorcelainAPI(env).DealsLs(req.Context)
-		if err != nil {
+		q, ok, err := dealsQueryFromOptions(req, env)
+		if err != nil || !ok {
 			return err
 		}
 
-		filterForMiner, _ := req.Options[minerOnly].(bool)
-		filterForClient, _ := req.Options[clientOnly].(bool)
+		deals, err := GetPorcelainAPI(env).DealsQuery(q)
+		if err != nil {
+			return err
+		}
 
-		for deal := range dealsCh {
-			if deal.Err != nil {
-				return deal.Err
-			}
-			if filterForMiner && deal.Deal.Miner != minerAddress {
-				continue
-			}
-			if filterForClient && deal.Deal.Miner == minerAddress {
-				continue
-			}
+		for _, deal := range deals {
 			out := &DealsListResult{
-				Miner:       deal.Deal.Miner,
-				PieceCid:    deal.Deal.Proposal.PieceRef,
-				ProposalCid: deal.Deal.Response.ProposalCid,
-				State:       deal.Deal.Response.State.String(),
+				Miner:       deal.Miner,
+				PieceCid:    deal.Proposal.PieceRef,
+				ProposalCid: deal.Response.ProposalCid,
+				State:       deal.Response.State.String(),
+				Client:      deal.Proposal.Payment.Payer,
+				Expiry:      deal.Proposal.EndHeight(),
+			}
+			if created := deal.CreatedAt(); !created.IsZero() {
+				out.Created = &created
 			}
 			if err = re.Emit(out); err != nil {
 				return err
@@ -94,6 +125,118 @@ deals, active deals, finished deals and cancelled deals.
 	},
 }
 
+var dealsExportCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Export deals as JSON",
+		ShortDescription: `
+Prints the full records of the deals made by or with this node as a JSON array,
+oldest first. It takes the same filters as deals list.
+`,
+	},
+	Options: dealsQueryOptions,
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		deals := []*storagedeal.Deal{}
+		q, ok, err := dealsQueryFromOptions(req, env)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if deals, err = GetPorcelainAPI(env).DealsQuery(q); err != nil {
+				return err
+			}
+		}
+		return re.Emit(deals)
+	},
+	Type: []*storagedeal.Deal{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, deals []*storagedeal.Deal) error {
+			encoder := json.NewEncoder(w)
+			encoder.SetIndent("", "\t")
+			return encoder.Encode(deals)
+		}),
+	},
+}
+
+// dealsQueryFromOptions builds the deals query of the dealsQueryOptions of req. It returns
+// false if no deal can match, which is when only deals made as a miner are asked for and
+// this node has no miner.
+func dealsQueryFromOptions(req *cmds.Request, env cmds.Environment) (strgdls.Query, bool, error) {
+	var q strgdls.Query
+	var err error
+
+	minerValue, err := GetPorcelainAPI(env).ConfigGet("mining.minerAddress")
+	if err != nil {
+		return q, false, err
+	}
+	minerAddress, _ := minerValue.(address.Address)
+
+	if filterForMiner, _ := req.Options[minerOnly].(bool); filterForMiner {
+		if minerAddress.Empty() {
+			return q, false, nil
+		}
+		q.Miner = minerAddress
+	}
+	if filterForClient, _ := req.Options[clientOnly].(bool); filterForClient {
+		q.ExcludeMiner = minerAddress
+	}
+
+	if states, ok := req.Options["state"].(string); ok {
+		for _, name := range strings.Split(states, ",") {
+			state, err := storagedeal.ParseState(strings.TrimSpace(name))
+			if err != nil {
+				return q, false, err
+			}
+			q.States = append(q.States, state)
+		}
+	}
+
+	if o := req.Options["miner-address"]; o != nil {
+		minerAddr, err := address.NewFromString(o.(string))
+		if err != nil {
+			return q, false, errors.Wrap(err, "invalid miner address")
+		}
+		if !q.Miner.Empty() && q.Miner != minerAddr {
+			return q, false, nil
+		}
+		q.Miner = minerAddr
+	}
+	if o := req.Options["client-address"]; o != nil {
+		if q.Client, err = address.NewFromString(o.(string)); err != nil {
+			return q, false, errors.Wrap(err, "invalid client address")
+		}
+	}
+	if o := req.Options["piece"]; o != nil {
+		if q.PieceRef, err = cid.Decode(o.(string)); err != nil {
+			return q, false, errors.Wrap(err, "invalid piece cid")
+		}
+	}
+
+	if o := req.Options["created-after"]; o != nil {
+		if q.CreatedAfter, err = time.Parse(time.RFC3339, o.(string)); err != nil {
+			return q, false, errors.Wrap(err, "invalid created-after time")
+		}
+	}
+	if o := req.Options["created-before"]; o != nil {
+		if q.CreatedBefore, err = time.Parse(time.RFC3339, o.(string)); err != nil {
+			return q, false, errors.Wrap(err, "invalid created-before time")
+		}
+	}
+	if o := req.Options["expires-after"]; o != nil {
+		if q.ExpiresAfter, err = optionalBlockHeight(o); err != nil {
+			return q, false, err
+		}
+	}
+	if o := req.Options["expires-before"]; o != nil {
+		if q.ExpiresBefore, err = optionalBlockHeight(o); err != nil {
+			return q, false, err
+		}
+	}
+
+	q.Offset, _ = req.Options["offset"].(uint64)
+	q.Limit, _ = req.Options["limit"].(uint64)
+	return q, true, nil
+}
+
 var dealsRedeemCmd = &cmds.Command{
 	Helptext: cmdkit.HelpText{
 		Tagline: "Redeem vouchers for a deal",
@@ -116,7 +259,7 @@ Redeem vouchers for FIL on the storage deal specified with the given deal CID.
 			return err
 		}
 
-		gasPrice, gasLimit, preview, err := parseGasOptions(req)
+		gasPrice, gasLimit, preview, err := parseGasOptions(req, env)
 		if err != nil {
 			return err
 		}
@@ -258,3 +401,45 @@ func paymentVouchersResult(vouchers []*types.PaymentVoucher) (pvres []*PaymenVou
 	}
 	return pvres, nil
 }
+
+var dealsVouchersCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "List the payment vouchers of the miner's complete deals",
+		ShortDescription: `
+Lists the payment vouchers of the complete deals made with this node's miner,
+with the deal, block height at which the voucher becomes valid, amount paid up
+to the voucher and state: pending, redeeming, redeemed or expired. Vouchers are
+cumulative, so redeeming a voucher redeems the earlier vouchers of its deal.
+
+The miner redeems vouchers as they become valid, within the gas budget set in
+the vouchers section of the config, unless vouchers.autoRedeem is false.
+`,
+	},
+	Options: []cmdkit.Option{
+		cmdkit.StringOption("state", "Only list vouchers in this state"),
+	},
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		statuses, err := GetStorageMinerAPI(env).Vouchers(req.Context)
+		if err != nil {
+			return err
+		}
+
+		state, _ := req.Options["state"].(string)
+		for _, status := range statuses {
+			if state != "" && string(status.State) != state {
+				continue
+			}
+			if err := re.Emit(status); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Type: storage.VoucherStatus{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, status *storage.VoucherStatus) error {
+			_, err := fmt.Fprintf(w, "%s %s %s %s\n", status.ProposalCid, status.ValidAt, status.Amount, status.State)
+			return err
+		}),
+	},
+}
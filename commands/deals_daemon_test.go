@@ -165,6 +165,29 @@ func TestDealsList(t *testing.T) {
 		assert.NotContains(t, minerOutput, dealCid)
 	})
 
+	t.Run("with --miner-address", func(t *testing.T) {
+		clientOutput := clientDaemon.RunSuccess("deals", "list", "--miner-address", fixtures.TestMiners[0]).ReadStdoutTrimNewlines()
+		assert.Contains(t, clientOutput, dealCid)
+
+		clientOutput = clientDaemon.RunSuccess("deals", "list", "--miner-address", fixtures.TestMiners[1]).ReadStdoutTrimNewlines()
+		assert.NotContains(t, clientOutput, dealCid)
+	})
+
+	t.Run("with --state and --offset", func(t *testing.T) {
+		clientOutput := clientDaemon.RunSuccess("deals", "list", "--state", "rejected,failed").ReadStdoutTrimNewlines()
+		assert.NotContains(t, clientOutput, dealCid)
+
+		clientOutput = clientDaemon.RunSuccess("deals", "list", "--offset", "1").ReadStdoutTrimNewlines()
+		assert.NotContains(t, clientOutput, dealCid)
+
+		clientDaemon.RunFail("unknown deal state", "deals", "list", "--state", "sealed")
+	})
+
+	t.Run("export", func(t *testing.T) {
+		clientOutput := clientDaemon.RunSuccess("deals", "export", "--client").ReadStdoutTrimNewlines()
+		assert.Contains(t, clientOutput, dealCid)
+	})
+
 	t.Run("with --help", func(t *testing.T) {
 		clientOutput := clientDaemon.RunSuccess("deals", "list", "--help").ReadStdoutTrimNewlines()
 		assert.Contains(t, clientOutput, "only return deals made as a client")
@@ -13,12 +13,13 @@ import (
 
 // Env is the environment passed to commands. Implements cmds.Environment.
 type Env struct {
-	blockMiningAPI *block.MiningAPI
-	ctx            context.Context
-	porcelainAPI   *porcelain.API
-	retrievalAPI   *retrieval.API
-	storageAPI     *storage.API
-	inspectorAPI   *Inspector
+	blockMiningAPI  *block.MiningAPI
+	ctx             context.Context
+	porcelainAPI    *porcelain.API
+	retrievalAPI    *retrieval.API
+	storageAPI      *storage.API
+	storageMinerAPI *storage.MinerAPI
+	inspectorAPI    *Inspector
 }
 
 var _ cmds.Environment = (*Env)(nil)
@@ -52,6 +53,12 @@ func GetStorageAPI(env cmds.Environment) *storage.API {
 	return ce.storageAPI
 }
 
+// GetStorageMinerAPI returns the storage miner api from the given environment.
+func GetStorageMinerAPI(env cmds.Environment) *storage.MinerAPI {
+	ce := env.(*Env)
+	return ce.storageMinerAPI
+}
+
 // GetInspectorAPI returns the inspector api from the given environment.
 func GetInspectorAPI(env cmds.Environment) *Inspector {
 	ce := env.(*Env)
@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -15,6 +16,7 @@ import (
 	"github.com/ipfs/go-ipfs-cmdkit"
 	"github.com/ipfs/go-ipfs-cmds"
 	"github.com/libp2p/go-libp2p-crypto"
+	"github.com/pkg/errors"
 
 	"github.com/filecoin-project/go-filecoin/address"
 	"github.com/filecoin-project/go-filecoin/config"
@@ -24,6 +26,7 @@ import (
 	"github.com/filecoin-project/go-filecoin/paths"
 	"github.com/filecoin-project/go-filecoin/repo"
 	"github.com/filecoin-project/go-filecoin/types"
+	"github.com/filecoin-project/go-filecoin/wallet"
 )
 
 var initCmd = &cmds.Command{
@@ -40,6 +43,7 @@ var initCmd = &cmds.Command{
 		cmdkit.BoolOption(DevnetTest, "when set, populates config bootstrap addrs with the dns multiaddrs of the test devnet and other test devnet specific bootstrap parameters."),
 		cmdkit.BoolOption(DevnetNightly, "when set, populates config bootstrap addrs with the dns multiaddrs of the nightly devnet and other nightly devnet specific bootstrap parameters"),
 		cmdkit.BoolOption(DevnetUser, "when set, populates config bootstrap addrs with the dns multiaddrs of the user devnet and other user devnet specific bootstrap parameters"),
+		cmdkit.StringOption(WalletPassphraseFile, "path of file containing a passphrase to encrypt the wallet's keys with; the wallet must then be unlocked with 'wallet unlock' before it can sign"),
 	},
 	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
 		newConfig, err := getConfigFromOptions(req.Options)
@@ -80,7 +84,20 @@ var initCmd = &cmds.Command{
 			return err
 		}
 
-		return node.Init(req.Context, rep, genesisFile, initopts...)
+		if err := node.Init(req.Context, rep, genesisFile, initopts...); err != nil {
+			return err
+		}
+
+		if passphraseFile, ok := req.Options[WalletPassphraseFile].(string); ok {
+			passphrase, err := ioutil.ReadFile(passphraseFile)
+			if err != nil {
+				return errors.Wrap(err, "failed to read wallet passphrase file")
+			}
+			if err := wallet.EncryptDatastore(rep.WalletDatastore(), bytes.TrimRight(passphrase, "\r\n")); err != nil {
+				return errors.Wrap(err, "failed to encrypt wallet")
+			}
+		}
+		return nil
 	},
 	Encoders: cmds.EncoderMap{
 		cmds.Text: cmds.MakeEncoder(initTextEncoder),
@@ -78,6 +78,9 @@ const (
 	// DevnetUser populates config bootstrap addrs with the dns multiaddrs of the user devnet and other user devnet specific bootstrap parameters
 	DevnetUser = "devnet-user"
 
+	// WalletPassphraseFile is the name of the option for specifying a file containing the passphrase to encrypt the wallet with
+	WalletPassphraseFile = "wallet-passphrase-file"
+
 	// IsRelay when set causes the the daemon to provide libp2p relay
 	// services allowing other filecoin nodes behind NATs to talk directly.
 	IsRelay = "is-relay"
@@ -155,6 +158,10 @@ var rootSubcmdsLocal = map[string]*cmds.Command{
 	"version": versionCmd,
 }
 
+// subcommands of daemon commands that run locally, without a daemon, e.g. because they use the
+// repo directly. set during init() to avoid configuration loops.
+var subcmdsLocal []*cmds.Command
+
 // all top level commands, available on daemon. set during init() to avoid configuration loops.
 var rootSubcmdsDaemon = map[string]*cmds.Command{
 	"actor":            actorCmd,
@@ -186,6 +193,8 @@ var rootSubcmdsDaemon = map[string]*cmds.Command{
 }
 
 func init() {
+	subcmdsLocal = []*cmds.Command{msgSignCmd}
+
 	for k, v := range rootSubcmdsLocal {
 		rootCmd.Subcommands[k] = v
 	}
@@ -306,6 +315,11 @@ func requiresDaemon(req *cmds.Request) bool {
 			return false
 		}
 	}
+	for _, cmd := range subcmdsLocal {
+		if req.Command == cmd {
+			return false
+		}
+	}
 	return true
 }
 
@@ -327,33 +341,44 @@ func isConnectionRefused(err error) bool {
 	return syscallErr.Err == syscall.ECONNREFUSED
 }
 
-var priceOption = cmdkit.StringOption("gas-price", "Price (FIL e.g. 0.00013) to pay for each GasUnits consumed mining this message")
-var limitOption = cmdkit.Uint64Option("gas-limit", "Maximum number of GasUnits this message is allowed to consume")
+var priceOption = cmdkit.StringOption("gas-price", "Price (FIL e.g. 0.00013) to pay for each GasUnits consumed mining this message (estimated from recent messages if omitted)")
+var limitOption = cmdkit.Uint64Option("gas-limit", "Maximum number of GasUnits this message is allowed to consume (estimated by previewing the message if omitted)")
 var previewOption = cmdkit.BoolOption("preview", "Preview the Gas cost of this command without actually executing it")
 
-func parseGasOptions(req *cmds.Request) (types.AttoFIL, types.GasUnits, bool, error) {
+// parseGasOptions parses the gas price, gas limit and preview options. An omitted gas price is
+// estimated from the prices paid by recently mined messages; an explicit one, even zero, is used
+// as given. An omitted limit is returned as nil, for the node to estimate it by previewing the
+// message; an explicit one, even zero, is used as given.
+func parseGasOptions(req *cmds.Request, env cmds.Environment) (types.AttoFIL, *types.GasUnits, bool, error) {
+	var price types.AttoFIL
 	priceOption := req.Options["gas-price"]
 	if priceOption == nil {
-		return types.ZeroAttoFIL, types.NewGasUnits(0), false, errors.New("price option is required")
-	}
-
-	price, ok := types.NewAttoFILFromFILString(priceOption.(string))
-	if !ok {
-		return types.ZeroAttoFIL, types.NewGasUnits(0), false, errors.New("invalid gas price (specify FIL as a decimal number)")
+		var err error
+		price, err = GetPorcelainAPI(env).MessageEstimateGasPrice(req.Context)
+		if err != nil {
+			return types.ZeroAttoFIL, nil, false, err
+		}
+	} else {
+		var ok bool
+		price, ok = types.NewAttoFILFromFILString(priceOption.(string))
+		if !ok {
+			return types.ZeroAttoFIL, nil, false, errors.New("invalid gas price (specify FIL as a decimal number)")
+		}
 	}
 
+	var gasLimit *types.GasUnits
 	limitOption := req.Options["gas-limit"]
-	if limitOption == nil {
-		return types.ZeroAttoFIL, types.NewGasUnits(0), false, errors.New("limit option is required")
-	}
-
-	gasLimitInt, ok := limitOption.(uint64)
-	if !ok {
-		msg := fmt.Sprintf("invalid gas limit: %s", limitOption)
-		return types.ZeroAttoFIL, types.NewGasUnits(0), false, errors.New(msg)
+	if limitOption != nil {
+		gasLimitInt, ok := limitOption.(uint64)
+		if !ok {
+			msg := fmt.Sprintf("invalid gas limit: %s", limitOption)
+			return types.ZeroAttoFIL, nil, false, errors.New(msg)
+		}
+		limit := types.NewGasUnits(gasLimitInt)
+		gasLimit = &limit
 	}
 
 	preview, _ := req.Options["preview"].(bool)
 
-	return price, types.NewGasUnits(gasLimitInt), preview, nil
+	return price, gasLimit, preview, nil
 }
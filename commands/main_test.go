@@ -18,6 +18,10 @@ func TestRequiresDaemon(t *testing.T) {
 	reqWithoutDaemon, err := cmds.NewRequest(context.Background(), []string{}, nil, []string{"daemon"}, nil, daemonCmd)
 	assert.NoError(t, err)
 
+	reqSubcmdWithoutDaemon, err := cmds.NewRequest(context.Background(), []string{}, nil, []string{"abcd"}, nil, msgSignCmd)
+	assert.NoError(t, err)
+
 	assert.True(t, requiresDaemon(reqWithDaemon))
 	assert.False(t, requiresDaemon(reqWithoutDaemon))
+	assert.False(t, requiresDaemon(reqSubcmdWithoutDaemon))
 }
@@ -1,11 +1,15 @@
 package commands
 
 import (
+	"bytes"
 	"context"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ipfs/go-cid"
@@ -20,6 +24,7 @@ import (
 	"github.com/filecoin-project/go-filecoin/plumbing/cst"
 	"github.com/filecoin-project/go-filecoin/plumbing/msg"
 	"github.com/filecoin-project/go-filecoin/types"
+	"github.com/filecoin-project/go-filecoin/wallet"
 )
 
 var msgCmd = &cmds.Command{
@@ -27,9 +32,14 @@ var msgCmd = &cmds.Command{
 		Tagline: "Send and monitor messages",
 	},
 	Subcommands: map[string]*cmds.Command{
-		"send":   msgSendCmd,
-		"status": msgStatusCmd,
-		"wait":   msgWaitCmd,
+		"create":     msgCreateCmd,
+		"replace":    msgReplaceCmd,
+		"send":       msgSendCmd,
+		"send-batch": msgSendBatchCmd,
+		"sign":       msgSignCmd,
+		"status":     msgStatusCmd,
+		"submit":     msgSubmitCmd,
+		"wait":       msgWaitCmd,
 	},
 }
 
@@ -81,7 +91,7 @@ var msgSendCmd = &cmds.Command{
 			}
 		}
 
-		gasPrice, gasLimit, preview, err := parseGasOptions(req)
+		gasPrice, gasLimit, preview, err := parseGasOptions(req, env)
 		if err != nil {
 			return err
 		}
@@ -140,6 +150,266 @@ var msgSendCmd = &cmds.Command{
 	},
 }
 
+var msgCreateCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Create an unsigned message to be signed offline",
+		ShortDescription: `
+Creates a message without signing or sending it, so that it can be signed
+elsewhere, e.g. by 'go-filecoin message sign' on an air-gapped machine, and then
+sent with 'go-filecoin message submit'. The message's nonce follows on from the
+sender's mined messages and those in the outbox; sending any other message from
+the same address before submitting this one invalidates it.
+
+The output is the hex encoding of the message's CBOR serialization (a
+MeteredMessage: the message along with its gas price and limit). These are
+exactly the bytes that are signed.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("target", true, false, "Address of the actor to send the message to"),
+		cmdkit.StringArg("method", false, false, "The method to invoke on the target actor"),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.StringOption("value", "Value to send with message in FIL"),
+		cmdkit.StringOption("from", "Address to send message from"),
+		priceOption,
+		limitOption,
+	},
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		target, err := address.NewFromString(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+
+		rawVal := req.Options["value"]
+		if rawVal == nil {
+			rawVal = "0"
+		}
+		val, ok := types.NewAttoFILFromFILString(rawVal.(string))
+		if !ok {
+			return errors.New("mal-formed value")
+		}
+
+		fromAddr, err := optionalAddr(req.Options["from"])
+		if err != nil {
+			return err
+		}
+		if fromAddr.Empty() {
+			fromAddr, err = GetPorcelainAPI(env).WalletDefaultAddress()
+			if err != nil {
+				return err
+			}
+		}
+
+		gasPrice, gasLimit, _, err := parseGasOptions(req, env)
+		if err != nil {
+			return err
+		}
+
+		method, ok := req.Options["method"].(string)
+		if !ok {
+			method = ""
+		}
+
+		var limit types.GasUnits
+		if gasLimit != nil {
+			limit = *gasLimit
+		} else {
+			limit, err = GetPorcelainAPI(env).MessageEstimateGasLimit(req.Context, fromAddr, target, method)
+			if err != nil {
+				return err
+			}
+		}
+
+		msg, err := GetPorcelainAPI(env).MessageCreate(req.Context, fromAddr, target, val, gasPrice, limit, method)
+		if err != nil {
+			return err
+		}
+
+		encoded, err := msg.Marshal()
+		if err != nil {
+			return err
+		}
+
+		return re.Emit(hex.EncodeToString(encoded))
+	},
+	Type: string(""),
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, encoded string) error {
+			_, err := fmt.Fprintln(w, encoded)
+			return err
+		}),
+	},
+}
+
+var msgSignCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Sign a message created by 'message create' with a local wallet",
+		ShortDescription: `
+Signs a message with a key from the wallet in the local repo (see --repodir). An
+encrypted wallet is unlocked with the passphrase in --wallet-passphrase-file.
+This command does not use a running daemon, and cannot run while a daemon has the
+repo open, so it can be used on a machine that is not connected to the network.
+
+The input is a message as output by 'go-filecoin message create'. The output is
+the hex encoding of the signed message's CBOR serialization (a SignedMessage),
+to be sent with 'go-filecoin message submit'.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("message", true, false, "Hex encoded message to sign"),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.StringOption(WalletPassphraseFile, "path of file containing the passphrase of an encrypted wallet"),
+	},
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		encoded, err := hex.DecodeString(strings.TrimSpace(req.Arguments[0]))
+		if err != nil {
+			return errors.Wrap(err, "message is not hex encoded")
+		}
+
+		var msg types.MeteredMessage
+		if err := msg.Unmarshal(encoded); err != nil {
+			return errors.Wrap(err, "failed to decode message")
+		}
+
+		rep, err := getRepo(req)
+		if err != nil {
+			return errors.Wrap(err, "failed to open repo (is a daemon running?)")
+		}
+		// The only error Close can return is that the repo has already been closed
+		defer rep.Close() // nolint: errcheck
+
+		backend, err := wallet.NewBackend(rep.WalletDatastore())
+		if err != nil {
+			return errors.Wrap(err, "failed to open wallet")
+		}
+		w := wallet.New(backend)
+
+		if passphraseFile, ok := req.Options[WalletPassphraseFile].(string); ok {
+			passphrase, err := ioutil.ReadFile(passphraseFile)
+			if err != nil {
+				return errors.Wrap(err, "failed to read wallet passphrase file")
+			}
+			if err := w.Unlock(bytes.TrimRight(passphrase, "\r\n"), 0); err != nil {
+				return err
+			}
+		}
+
+		signed, err := types.NewSignedMessage(msg.Message, w, msg.GasPrice, msg.GasLimit)
+		if err != nil {
+			return errors.Wrap(err, "failed to sign message")
+		}
+
+		encoded, err = signed.Marshal()
+		if err != nil {
+			return err
+		}
+
+		return re.Emit(hex.EncodeToString(encoded))
+	},
+	Type: string(""),
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, encoded string) error {
+			_, err := fmt.Fprintln(w, encoded)
+			return err
+		}),
+	},
+}
+
+var msgSubmitCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Send a message signed outside the node",
+		ShortDescription: `
+Sends a signed message, as output by 'go-filecoin message sign', adding it to the
+outbox and message pool and publishing it to the network. The input is the hex
+encoding of the signed message's CBOR serialization. Prints the message's CID.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("message", true, false, "Hex encoded signed message to send"),
+	},
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		encoded, err := hex.DecodeString(strings.TrimSpace(req.Arguments[0]))
+		if err != nil {
+			return errors.Wrap(err, "message is not hex encoded")
+		}
+
+		var signed types.SignedMessage
+		if err := signed.Unmarshal(encoded); err != nil {
+			return errors.Wrap(err, "failed to decode signed message")
+		}
+
+		c, err := GetPorcelainAPI(env).MessageSubmit(req.Context, &signed)
+		if err != nil {
+			return err
+		}
+
+		return re.Emit(c)
+	},
+	Type: cid.Cid{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, c cid.Cid) error {
+			return PrintString(w, c)
+		}),
+	},
+}
+
+var msgReplaceCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Replace a stuck outbox message with one paying a higher gas price",
+		ShortDescription: `
+Re-signs a message in the outbox with the same nonce and a new gas price,
+publishes it and, once the message pool accepts it, swaps it into the outbox
+queue. If --gas-price is omitted, the lowest price the message pool accepts as a
+replacement is used (see mpool.replacePriceBumpPercent). If --gas-limit is
+omitted, the original message's gas limit is kept.
+
+Stuck messages can also be replaced automatically, see mpool.autoBumpAfterRounds
+and mpool.autoBumpMaxGasPrice.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("cid", true, false, "CID of the outbox message to replace"),
+	},
+	Options: []cmdkit.Option{
+		priceOption,
+		limitOption,
+	},
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		msgCid, err := cid.Parse(req.Arguments[0])
+		if err != nil {
+			return errors.Wrap(err, "invalid cid "+req.Arguments[0])
+		}
+
+		gasPrice := types.ZeroAttoFIL
+		if rawPrice, ok := req.Options["gas-price"].(string); ok {
+			gasPrice, ok = types.NewAttoFILFromFILString(rawPrice)
+			if !ok {
+				return errors.New("invalid gas price (specify FIL as a decimal number)")
+			}
+		}
+
+		gasLimit := types.NewGasUnits(0)
+		if rawLimit, ok := req.Options["gas-limit"].(uint64); ok {
+			gasLimit = types.NewGasUnits(rawLimit)
+		}
+
+		c, err := GetPorcelainAPI(env).MessageReplaceWithDefaultGas(req.Context, msgCid, gasPrice, gasLimit)
+		if err != nil {
+			return err
+		}
+
+		return re.Emit(c)
+	},
+	Type: cid.Cid{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, c cid.Cid) error {
+			return PrintString(w, c)
+		}),
+	},
+}
+
 // WaitResult is the result of a message wait call.
 type WaitResult struct {
 	Message   *types.SignedMessage
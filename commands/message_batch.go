@@ -0,0 +1,304 @@
+package commands
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-ipfs-cmdkit"
+	"github.com/ipfs/go-ipfs-cmds"
+	"github.com/ipfs/go-ipfs-files"
+	"github.com/pkg/errors"
+
+	"github.com/filecoin-project/go-filecoin/abi"
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/core"
+	"github.com/filecoin-project/go-filecoin/types"
+)
+
+// MessageSendBatchResult is the return type for message send-batch command
+type MessageSendBatchResult struct {
+	Rows []MessageBatchRowResult
+}
+
+// MessageBatchRowResult is the outcome of sending the message for one row of a batch manifest.
+// Receipt is only set if the command waited for the message to be mined.
+type MessageBatchRowResult struct {
+	Row     int
+	To      address.Address
+	Value   types.AttoFIL
+	Cid     cid.Cid
+	Receipt *types.MessageReceipt
+	Error   string
+}
+
+// batchRow is one row of a batch manifest. Params are ABI encoded.
+type batchRow struct {
+	To     address.Address
+	Value  types.AttoFIL
+	Method string
+	Params []byte
+}
+
+// batchRowJSON is the JSON representation of a batch manifest row.
+type batchRowJSON struct {
+	To     string `json:"to"`
+	Value  string `json:"value"`
+	Method string `json:"method"`
+	Params string `json:"params"`
+}
+
+var msgSendBatchCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Send a batch of messages listed in a manifest file",
+		ShortDescription: `
+Sends a message for each row of a manifest file, all from the same address. The
+messages are given a contiguous range of nonces, so other messages sent from the
+address at the same time cannot interleave with them. A row whose message cannot
+be sent doesn't use a nonce, and doesn't prevent the rest of the batch from being
+sent. The whole batch is refused if it has more messages than the message pool
+will accept from the address (see the mpool.maxNonceGap and
+mpool.maxPendingPerSender config options).
+
+The manifest is either CSV or JSON; by default the format is chosen by the file's
+extension (.json or anything else for CSV). Each CSV record has the fields:
+
+  to,value[,method[,params]]
+
+A first record starting with the field "to" is treated as a header. A JSON
+manifest is a list of objects with the fields "to", "value", "method" and
+"params". In either format, value is in FIL and params, only allowed when a
+method is given, are the hex encoding of the method's ABI encoded parameters.
+
+Prints a table with a row for each message, along with its CID or why it failed.
+With --wait, the command waits for all the messages to be mined, and the table
+includes each message's exit code.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.FileArg("file", true, false, "Path to the manifest file").EnableStdin(),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.StringOption("from", "Address to send messages from"),
+		cmdkit.StringOption("format", "Manifest format, csv or json (default chosen by file extension)"),
+		priceOption,
+		limitOption,
+		cmdkit.BoolOption("wait", "Wait for all messages to be mined").WithDefault(false),
+		cmdkit.StringOption("timeout", "Maximum time to wait for messages with --wait. e.g., 300ms, 1.5h, 2h45m.").WithDefault("10m"),
+	},
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		iter := req.Files.Entries()
+		if !iter.Next() {
+			return fmt.Errorf("no file given: %s", iter.Err())
+		}
+
+		fi, ok := iter.Node().(files.File)
+		if !ok {
+			return fmt.Errorf("given file was not a files.File")
+		}
+
+		format, _ := req.Options["format"].(string)
+		if format == "" {
+			format = "csv"
+			if strings.HasSuffix(strings.ToLower(iter.Name()), ".json") {
+				format = "json"
+			}
+		}
+
+		rows, err := parseBatchManifest(fi, format)
+		if err != nil {
+			return err
+		}
+
+		fromAddr, err := optionalAddr(req.Options["from"])
+		if err != nil {
+			return err
+		}
+		if fromAddr.Empty() {
+			fromAddr, err = GetPorcelainAPI(env).WalletDefaultAddress()
+			if err != nil {
+				return err
+			}
+		}
+
+		gasPrice, gasLimit, _, err := parseGasOptions(req, env)
+		if err != nil {
+			return err
+		}
+
+		wait, _ := req.Options["wait"].(bool)
+		timeoutDuration, err := time.ParseDuration(req.Options["timeout"].(string))
+		if err != nil {
+			return errors.Wrap(err, "Invalid timeout string")
+		}
+
+		msgs := make([]core.BatchMessage, len(rows))
+		for i, row := range rows {
+			params, err := decodeBatchParams(req.Context, env, row)
+			if err != nil {
+				return errors.Wrapf(err, "row %d", i+1)
+			}
+			msgs[i] = core.BatchMessage{
+				To:     row.To,
+				Value:  row.Value,
+				Method: row.Method,
+				Params: params,
+			}
+		}
+
+		sent, err := GetPorcelainAPI(env).MessageSendBatch(req.Context, fromAddr, gasPrice, gasLimit, msgs)
+		if err != nil {
+			return err
+		}
+
+		res := MessageSendBatchResult{Rows: make([]MessageBatchRowResult, len(rows))}
+		for i, row := range rows {
+			res.Rows[i] = MessageBatchRowResult{
+				Row:   i + 1,
+				To:    row.To,
+				Value: row.Value,
+				Cid:   sent[i].Cid,
+			}
+			if sent[i].Err != nil {
+				res.Rows[i].Error = sent[i].Err.Error()
+			}
+		}
+
+		if wait {
+			ctx, cancel := context.WithTimeout(req.Context, timeoutDuration)
+			defer cancel()
+
+			for i := range res.Rows {
+				row := &res.Rows[i]
+				if row.Error != "" {
+					continue
+				}
+				err := GetPorcelainAPI(env).MessageWait(ctx, row.Cid, func(blk *types.Block, msg *types.SignedMessage, receipt *types.MessageReceipt) error {
+					row.Receipt = receipt
+					return nil
+				})
+				if err != nil {
+					row.Error = errors.Wrap(err, "failed waiting for message").Error()
+				}
+			}
+		}
+
+		return re.Emit(&res)
+	},
+	Type: MessageSendBatchResult{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, res *MessageSendBatchResult) error {
+			tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "ROW\tTO\tVALUE\tCID\tSTATUS") // nolint: errcheck
+			for _, row := range res.Rows {
+				status := "sent"
+				if row.Error != "" {
+					status = "failed: " + row.Error
+				} else if row.Receipt != nil {
+					status = fmt.Sprintf("mined (exit code %d)", row.Receipt.ExitCode)
+				}
+
+				msgCid := "-"
+				if row.Cid.Defined() {
+					msgCid = row.Cid.String()
+				}
+				fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", row.Row, row.To, row.Value, msgCid, status) // nolint: errcheck
+			}
+			return tw.Flush()
+		}),
+	},
+}
+
+// decodeBatchParams decodes a row's ABI encoded params, using the signature of the target
+// actor's method, so they can be sent with the message.
+func decodeBatchParams(ctx context.Context, env cmds.Environment, row batchRow) ([]interface{}, error) {
+	if len(row.Params) == 0 {
+		return nil, nil
+	}
+
+	sig, err := GetPorcelainAPI(env).ActorGetSignature(ctx, row.To, row.Method)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get signature of method %s", row.Method)
+	}
+
+	vals, err := abi.DecodeValues(row.Params, sig.Params)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid params")
+	}
+	return abi.FromValues(vals), nil
+}
+
+// parseBatchManifest reads the rows of a batch manifest in the given format, csv or json.
+func parseBatchManifest(r io.Reader, format string) ([]batchRow, error) {
+	var raw []batchRowJSON
+	switch format {
+	case "csv":
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read CSV manifest")
+		}
+		for i, record := range records {
+			if i == 0 && strings.TrimSpace(record[0]) == "to" {
+				continue
+			}
+			if len(record) < 2 || len(record) > 4 {
+				return nil, errors.Errorf("CSV record %d has %d fields, expected 2 to 4", i+1, len(record))
+			}
+			for len(record) < 4 {
+				record = append(record, "")
+			}
+			raw = append(raw, batchRowJSON{To: record[0], Value: record[1], Method: record[2], Params: record[3]})
+		}
+	case "json":
+		if err := json.NewDecoder(r).Decode(&raw); err != nil {
+			return nil, errors.Wrap(err, "failed to read JSON manifest")
+		}
+	default:
+		return nil, errors.Errorf("unknown manifest format %s, expected csv or json", format)
+	}
+
+	if len(raw) == 0 {
+		return nil, errors.New("manifest contains no messages")
+	}
+
+	rows := make([]batchRow, len(raw))
+	for i, r := range raw {
+		to, err := address.NewFromString(strings.TrimSpace(r.To))
+		if err != nil {
+			return nil, errors.Wrapf(err, "row %d: invalid target address", i+1)
+		}
+
+		value, ok := types.NewAttoFILFromFILString(strings.TrimSpace(r.Value))
+		if !ok {
+			return nil, errors.Errorf("row %d: mal-formed value %s", i+1, r.Value)
+		}
+
+		params, err := hex.DecodeString(strings.TrimSpace(r.Params))
+		if err != nil {
+			return nil, errors.Wrapf(err, "row %d: params are not hex encoded", i+1)
+		}
+
+		method := strings.TrimSpace(r.Method)
+		if method == "" && len(params) > 0 {
+			return nil, errors.Errorf("row %d: params given without a method", i+1)
+		}
+
+		rows[i] = batchRow{
+			To:     to,
+			Value:  value,
+			Method: method,
+			Params: params,
+		}
+	}
+	return rows, nil
+}
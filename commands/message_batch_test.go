@@ -0,0 +1,91 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	tf "github.com/filecoin-project/go-filecoin/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/types"
+)
+
+func TestParseBatchManifest(t *testing.T) {
+	tf.UnitTest(t)
+
+	addrGetter := address.NewForTestGetter()
+	alice := addrGetter()
+	bob := addrGetter()
+
+	t.Run("reads CSV with and without header", func(t *testing.T) {
+		manifest := "to,value,method,params\n" +
+			alice.String() + ",1.5\n" +
+			bob.String() + ", 2, someMethod, 0a0b\n"
+
+		rows, err := parseBatchManifest(strings.NewReader(manifest), "csv")
+		require.NoError(t, err)
+		require.Len(t, rows, 2)
+
+		assert.Equal(t, alice, rows[0].To)
+		assert.Equal(t, mustParseFIL(t, "1.5"), rows[0].Value)
+		assert.Equal(t, "", rows[0].Method)
+		assert.Empty(t, rows[0].Params)
+
+		assert.Equal(t, bob, rows[1].To)
+		assert.Equal(t, types.NewAttoFILFromFIL(2), rows[1].Value)
+		assert.Equal(t, "someMethod", rows[1].Method)
+		assert.Equal(t, []byte{0x0a, 0x0b}, rows[1].Params)
+
+		rows, err = parseBatchManifest(strings.NewReader(alice.String()+",1\n"), "csv")
+		require.NoError(t, err)
+		require.Len(t, rows, 1)
+		assert.Equal(t, types.NewAttoFILFromFIL(1), rows[0].Value)
+	})
+
+	t.Run("reads JSON", func(t *testing.T) {
+		manifest := `[
+			{"to": "` + alice.String() + `", "value": "3"},
+			{"to": "` + bob.String() + `", "value": "0.25", "method": "someMethod", "params": "ff"}
+		]`
+
+		rows, err := parseBatchManifest(strings.NewReader(manifest), "json")
+		require.NoError(t, err)
+		require.Len(t, rows, 2)
+		assert.Equal(t, alice, rows[0].To)
+		assert.Equal(t, types.NewAttoFILFromFIL(3), rows[0].Value)
+		assert.Equal(t, bob, rows[1].To)
+		assert.Equal(t, mustParseFIL(t, "0.25"), rows[1].Value)
+		assert.Equal(t, []byte{0xff}, rows[1].Params)
+	})
+
+	t.Run("rejects invalid manifests", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			manifest string
+			format   string
+			err      string
+		}{
+			"unknown format":        {alice.String() + ",1", "xml", "unknown manifest format"},
+			"empty":                 {"to,value\n", "csv", "no messages"},
+			"missing value":         {alice.String() + "\n", "csv", "has 1 fields"},
+			"bad address":           {"xyz,1\n", "csv", "row 1: invalid target address"},
+			"bad value":             {alice.String() + ",1\n" + bob.String() + ",lots\n", "csv", "row 2: mal-formed value"},
+			"bad params":            {alice.String() + ",1,someMethod,xyz\n", "csv", "not hex encoded"},
+			"params without method": {alice.String() + ",1,,ff\n", "csv", "params given without a method"},
+			"bad JSON":              {"{", "json", "failed to read JSON manifest"},
+		} {
+			t.Run(name, func(t *testing.T) {
+				_, err := parseBatchManifest(strings.NewReader(tc.manifest), tc.format)
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+			})
+		}
+	})
+}
+
+func mustParseFIL(t *testing.T, s string) types.AttoFIL {
+	v, ok := types.NewAttoFILFromFILString(s)
+	require.True(t, ok)
+	return v
+}
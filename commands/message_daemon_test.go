@@ -2,6 +2,9 @@ package commands_test
 
 import (
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
 	"strconv"
 	"strings"
 	"testing"
@@ -187,3 +190,70 @@ func TestMessageStatus(t *testing.T) {
 		assert.NotContains(t, status, "On chain")
 	})
 }
+
+func TestMessageCreateSignSubmit(t *testing.T) {
+	tf.IntegrationTest(t)
+
+	d := makeTestDaemonWithMinerAndStart(t)
+	defer d.ShutdownSuccess()
+
+	created := d.RunSuccess("message", "create",
+		"--from", fixtures.TestAddresses[0],
+		"--gas-price", "1",
+		"--gas-limit", "300",
+		"--value", "10",
+		fixtures.TestAddresses[1],
+	).ReadStdoutTrimNewlines()
+
+	// Signing uses the repo directly, so it requires that the daemon isn't running.
+	d.RunFail("failed to open repo", "message", "sign", created)
+	d.Stop()
+	signed := d.RunSuccess("message", "sign", created).ReadStdoutTrimNewlines()
+	d.Start()
+
+	msgcid := d.RunSuccess("message", "submit", signed).ReadStdoutTrimNewlines()
+	d.RunSuccess("mining", "once")
+	d.RunSuccess("message", "wait",
+		"--message=false",
+		"--receipt=false",
+		"--timeout=1m",
+		msgcid,
+	)
+}
+
+func TestMessageSendBatch(t *testing.T) {
+	tf.IntegrationTest(t)
+
+	d := makeTestDaemonWithMinerAndStart(t)
+	defer d.ShutdownSuccess()
+
+	manifest, err := ioutil.TempFile("", "manifest*.csv")
+	require.NoError(t, err)
+	defer os.Remove(manifest.Name()) // nolint: errcheck
+
+	_, err = fmt.Fprintf(manifest, "to,value\n%s,10\nxyz,1\n%s,5.5\n", fixtures.TestAddresses[1], fixtures.TestAddresses[2])
+	require.NoError(t, err)
+	require.NoError(t, manifest.Close())
+
+	t.Log("[failure] invalid row")
+	d.RunFail("row 2: invalid target address",
+		"message", "send-batch",
+		"--from", fixtures.TestAddresses[0],
+		"--gas-price", "1", "--gas-limit", "300",
+		manifest.Name(),
+	)
+
+	require.NoError(t, ioutil.WriteFile(manifest.Name(), []byte(fmt.Sprintf("to,value\n%s,10\n%s,5.5\n", fixtures.TestAddresses[1], fixtures.TestAddresses[2])), 0644))
+
+	t.Log("[success] sends all rows")
+	out := d.RunSuccess("message", "send-batch",
+		"--from", fixtures.TestAddresses[0],
+		"--gas-price", "1", "--gas-limit", "300",
+		manifest.Name(),
+	).ReadStdout()
+	assert.Equal(t, 2, strings.Count(out, "sent"))
+
+	d.RunSuccess("mining", "once")
+	pending := d.RunSuccess("mpool", "ls").ReadStdoutTrimNewlines()
+	assert.Equal(t, "", pending)
+}
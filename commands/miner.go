@@ -10,11 +10,13 @@ import (
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-ipfs-cmdkit"
 	"github.com/ipfs/go-ipfs-cmds"
+	"github.com/ipfs/go-ipfs-files"
 	"github.com/libp2p/go-libp2p-peer"
 	"github.com/pkg/errors"
 
 	"github.com/filecoin-project/go-filecoin/address"
 	"github.com/filecoin-project/go-filecoin/porcelain"
+	"github.com/filecoin-project/go-filecoin/protocol/storage"
 	"github.com/filecoin-project/go-filecoin/types"
 )
 
@@ -23,11 +25,14 @@ var minerCmd = &cmds.Command{
 		Tagline: "Manage a single miner actor",
 	},
 	Subcommands: map[string]*cmds.Command{
-		"create":        minerCreateCmd,
-		"owner":         minerOwnerCmd,
-		"power":         minerPowerCmd,
-		"set-price":     minerSetPriceCmd,
-		"update-peerid": minerUpdatePeerIDCmd,
+		"asks":             minerAsksCmd,
+		"create":           minerCreateCmd,
+		"import-deal-data": minerImportDealDataCmd,
+		"owner":            minerOwnerCmd,
+		"post":             minerPoStCmd,
+		"power":            minerPowerCmd,
+		"set-price":        minerSetPriceCmd,
+		"update-peerid":    minerUpdatePeerIDCmd,
 	},
 }
 
@@ -106,7 +111,7 @@ additional sectors.`,
 			return ErrInvalidCollateral
 		}
 
-		gasPrice, gasLimit, preview, err := parseGasOptions(req)
+		gasPrice, gasLimit, preview, err := parseGasOptions(req, env)
 		if err != nil {
 			return err
 		}
@@ -208,7 +213,7 @@ This command waits for the ask to be mined.`,
 			return fmt.Errorf("expiry must be a valid integer")
 		}
 
-		gasPrice, gasLimit, preview, err := parseGasOptions(req)
+		gasPrice, gasLimit, preview, err := parseGasOptions(req, env)
 		if err != nil {
 			return err
 		}
@@ -270,6 +275,176 @@ This command waits for the ask to be mined.`,
 	},
 }
 
+var minerAsksCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Manage the asks of a miner",
+		ShortDescription: `An ask expires once the chain reaches its expiry. Expired asks stay in the
+miner actor until the next ask is added or they are pruned.`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"cancel": minerAsksCancelCmd,
+		"ls":     minerAsksLsCmd,
+		"prune":  minerAsksPruneCmd,
+	},
+}
+
+var minerAsksLsCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "List the asks of a miner",
+		ShortDescription: `Lists the asks of the miner that haven't expired as a space separated table with
+miner, id, price and expiration respectively.`,
+	},
+	Options: []cmdkit.Option{
+		cmdkit.StringOption("miner", "The address of the miner owning the asks, defaults to this node's miner"),
+		cmdkit.BoolOption("include-expired", "Also list the expired asks"),
+	},
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		minerAddr, err := minerAddressOption(req, env)
+		if err != nil {
+			return err
+		}
+		includeExpired, _ := req.Options["include-expired"].(bool)
+
+		asks, err := GetPorcelainAPI(env).MinerListAsks(req.Context, minerAddr, includeExpired)
+		if err != nil {
+			return err
+		}
+
+		for _, ask := range asks {
+			out := &porcelain.Ask{
+				Miner:  minerAddr,
+				Price:  ask.Price,
+				Expiry: ask.Expiry,
+				ID:     ask.ID.Uint64(),
+			}
+			if err := re.Emit(out); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Type: porcelain.Ask{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, ask *porcelain.Ask) error {
+			_, err := fmt.Fprintf(w, "%s %.3d %s %s\n", ask.Miner, ask.ID, ask.Price, ask.Expiry)
+			return err
+		}),
+	},
+}
+
+var minerAsksCancelCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Withdraw an ask of a miner",
+		ShortDescription: `Removes the ask with the given id from the miner actor, so clients stop making
+deals against it. This command waits for the withdrawal to be mined.`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("id", true, false, "The id of the ask"),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.StringOption("from", "Address to send from"),
+		cmdkit.StringOption("miner", "The address of the miner owning the ask"),
+		priceOption,
+		limitOption,
+	},
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		askID, err := strconv.ParseUint(req.Arguments[0], 10, 64)
+		if err != nil {
+			return errors.Wrap(err, "ask id must be a valid integer")
+		}
+
+		fromAddr, err := optionalAddr(req.Options["from"])
+		if err != nil {
+			return err
+		}
+		minerAddr, err := optionalAddr(req.Options["miner"])
+		if err != nil {
+			return errors.Wrap(err, "miner must be an address")
+		}
+
+		gasPrice, gasLimit, _, err := parseGasOptions(req, env)
+		if err != nil {
+			return err
+		}
+
+		msgCid, err := GetPorcelainAPI(env).MinerCancelAsk(req.Context, fromAddr, minerAddr, gasPrice, gasLimit, askID)
+		if err != nil {
+			return err
+		}
+		return re.Emit(msgCid)
+	},
+	Type: cid.Cid{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, c cid.Cid) error {
+			return PrintString(w, c)
+		}),
+	},
+}
+
+var minerAsksPruneCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Remove the expired asks of a miner",
+		ShortDescription: `Removes the expired asks from the miner actor and prints how many were removed.
+This command waits for the removal to be mined.`,
+	},
+	Options: []cmdkit.Option{
+		cmdkit.StringOption("from", "Address to send from"),
+		cmdkit.StringOption("miner", "The address of the miner owning the asks"),
+		priceOption,
+		limitOption,
+	},
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		fromAddr, err := optionalAddr(req.Options["from"])
+		if err != nil {
+			return err
+		}
+		minerAddr, err := optionalAddr(req.Options["miner"])
+		if err != nil {
+			return errors.Wrap(err, "miner must be an address")
+		}
+
+		gasPrice, gasLimit, _, err := parseGasOptions(req, env)
+		if err != nil {
+			return err
+		}
+
+		pruned, err := GetPorcelainAPI(env).MinerPruneExpiredAsks(req.Context, fromAddr, minerAddr, gasPrice, gasLimit)
+		if err != nil {
+			return err
+		}
+		return re.Emit(pruned)
+	},
+	Type: uint64(0),
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, pruned uint64) error {
+			_, err := fmt.Fprintf(w, "removed %d expired asks\n", pruned)
+			return err
+		}),
+	},
+}
+
+// minerAddressOption returns the address of the miner option, defaulting to the miner of
+// this node.
+func minerAddressOption(req *cmds.Request, env cmds.Environment) (address.Address, error) {
+	if req.Options["miner"] != nil {
+		minerAddr, err := address.NewFromString(req.Options["miner"].(string))
+		if err != nil {
+			return address.Undef, errors.Wrap(err, "miner must be an address")
+		}
+		return minerAddr, nil
+	}
+
+	minerValue, err := GetPorcelainAPI(env).ConfigGet("mining.minerAddress")
+	if err != nil {
+		return address.Undef, err
+	}
+	minerAddr, ok := minerValue.(address.Address)
+	if !ok || minerAddr.Empty() {
+		return address.Undef, errors.New("no miner address given and none configured in mining.minerAddress")
+	}
+	return minerAddr, nil
+}
+
 // MinerUpdatePeerIDResult is the return type for miner update-peerid command
 type MinerUpdatePeerIDResult struct {
 	Cid     cid.Cid
@@ -277,6 +452,52 @@ type MinerUpdatePeerIDResult struct {
 	Preview bool
 }
 
+var minerImportDealDataCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Import the data of a deal transferred offline",
+		ShortDescription: `
+Imports the data a client sent out of band for a storage deal proposed with
+--offline, and stages it for sealing. The data is either the file the client
+imported, or a CAR of its DAG if --car is given. It must match the piece of the
+deal.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("deal", true, false, "CID of the deal proposal"),
+		cmdkit.FileArg("file", true, false, "Path to the data of the deal").EnableStdin(),
+	},
+	Options: []cmdkit.Option{
+		cmdkit.BoolOption("car", "The data is a CAR rather than a file"),
+	},
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		proposalCid, err := cid.Decode(req.Arguments[0])
+		if err != nil {
+			return err
+		}
+		isCar, _ := req.Options["car"].(bool)
+
+		iter := req.Files.Entries()
+		if !iter.Next() {
+			return fmt.Errorf("no file given: %s", iter.Err())
+		}
+		fi, ok := iter.Node().(files.File)
+		if !ok {
+			return fmt.Errorf("given file was not a files.File")
+		}
+
+		if err := GetStorageMinerAPI(env).ImportDealData(req.Context, proposalCid, fi, isCar); err != nil {
+			return err
+		}
+		return re.Emit(proposalCid)
+	},
+	Type: cid.Cid{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, c cid.Cid) error {
+			return PrintString(w, c)
+		}),
+	},
+}
+
 var minerUpdatePeerIDCmd = &cmds.Command{
 	Helptext: cmdkit.HelpText{
 		Tagline:          "Change the libp2p identity that a miner is operating",
@@ -308,7 +529,7 @@ var minerUpdatePeerIDCmd = &cmds.Command{
 			return err
 		}
 
-		gasPrice, gasLimit, preview, err := parseGasOptions(req)
+		gasPrice, gasLimit, preview, err := parseGasOptions(req, env)
 		if err != nil {
 			return err
 		}
@@ -403,6 +624,62 @@ var minerOwnerCmd = &cmds.Command{
 	},
 }
 
+var minerPoStCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Follow the proofs of spacetime of the miner",
+	},
+	Subcommands: map[string]*cmds.Command{
+		"status": minerPoStStatusCmd,
+	},
+}
+
+var minerPoStStatusCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Show the PoSt history of the miner's proving periods",
+		ShortDescription: `Prints one line per proving period, oldest first, with the start and end of
+the period, state of its PoSt, number of attempts, late fee paid and cid of the
+PoSt message, followed by the error of the last failed attempt, if any.
+
+The PoSt of a proving period is generating, retrying, submitted, failed once it
+failed mining.postRetries more times than allowed, or missed when too late for
+the miner actor to accept it.
+`,
+	},
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		periods, err := GetStorageMinerAPI(env).PoStStatus()
+		if err != nil {
+			return err
+		}
+
+		for _, period := range periods {
+			if err := re.Emit(period); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Type: storage.PoStPeriod{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, period *storage.PoStPeriod) error {
+			msgCid := "-"
+			if period.MessageCid != nil {
+				msgCid = period.MessageCid.String()
+			}
+			if _, err := fmt.Fprintf(w, "%s %s %s %d %s %s\n", period.Start, period.End, period.State, len(period.Attempts), period.Fee, msgCid); err != nil {
+				return err
+			}
+			for i := len(period.Attempts) - 1; i >= 0; i-- {
+				if period.Attempts[i].Error == "" {
+					continue
+				}
+				_, err := fmt.Fprintf(w, "\tattempt at %s: %s\n", period.Attempts[i].Height, period.Attempts[i].Error)
+				return err
+			}
+			return nil
+		}),
+	},
+}
+
 var minerPowerCmd = &cmds.Command{
 	Helptext: cmdkit.HelpText{
 		Tagline: "Get the power of a miner versus the total storage market power",
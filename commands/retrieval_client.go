@@ -1,11 +1,19 @@
 package commands
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-ipfs-cmdkit"
 	"github.com/ipfs/go-ipfs-cmds"
 
 	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/plumbing/datakeys"
+	"github.com/filecoin-project/go-filecoin/protocol/retrieval"
+	"github.com/filecoin-project/go-filecoin/types"
 )
 
 var retrievalClientCmd = &cmds.Command{
@@ -13,6 +21,7 @@ var retrievalClientCmd = &cmds.Command{
 		Tagline: "Manage retrieval client operations",
 	},
 	Subcommands: map[string]*cmds.Command{
+		"query":          clientQueryCmd,
 		"retrieve-piece": clientRetrievePieceCmd,
 	},
 }
@@ -20,11 +29,31 @@ var retrievalClientCmd = &cmds.Command{
 var clientRetrievePieceCmd = &cmds.Command{
 	Helptext: cmdkit.HelpText{
 		Tagline: "Read out piece data stored by a miner on the network",
+		ShortDescription: `
+Retrieves a piece from a miner. Miners may charge for retrievals: the piece is
+paid for from a payment channel to the miner, with a voucher every time a part of
+it is received. A channel created by an earlier retrieval is reused if it has
+enough funds left, otherwise a new channel holding the price of the piece is
+created. The retrieval fails if the price is above --max-price, which is zero by
+default.
+
+Use --offset and --length to retrieve a byte range of the piece.
+
+Pieces that were imported with client import --encrypt are decrypted if their
+key is in the local key store. Such pieces only decrypt as a whole, so --offset
+and --length are refused for them; ranges of other pieces are returned as stored.
+`,
 	},
 	Arguments: []cmdkit.Argument{
 		cmdkit.StringArg("miner", true, false, "Retrieval miner actor address"),
 		cmdkit.StringArg("cid", true, false, "Content identifier of piece to read"),
 	},
+	Options: []cmdkit.Option{
+		cmdkit.StringOption("from", "Address to pay for the retrieval from"),
+		cmdkit.StringOption("max-price", "Maximum price (FIL e.g. 0.01) to pay for the piece").WithDefault("0"),
+		cmdkit.Uint64Option("offset", "Number of bytes to skip").WithDefault(uint64(0)),
+		cmdkit.Uint64Option("length", "Number of bytes to retrieve (all remaining bytes if zero)").WithDefault(uint64(0)),
+	},
 	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
 		minerAddr, err := address.NewFromString(req.Arguments[0])
 		if err != nil {
@@ -36,16 +65,134 @@ var clientRetrievePieceCmd = &cmds.Command{
 			return err
 		}
 
+		fromAddr, err := optionalAddr(req.Options["from"])
+		if err != nil {
+			return err
+		}
+
+		maxPrice, ok := types.NewAttoFILFromFILString(req.Options["max-price"].(string))
+		if !ok {
+			return ErrInvalidAmount
+		}
+
+		pr := retrieval.PieceRange{
+			Offset: req.Options["offset"].(uint64),
+			Length: req.Options["length"].(uint64),
+		}
+		if pr.Offset != 0 || pr.Length != 0 {
+			// check before paying for data that would come out encrypted
+			_, err := GetPorcelainAPI(env).DataKeyGet(pieceCID)
+			if err == nil {
+				return fmt.Errorf("piece %s is encrypted and only decrypts as a whole, retrieve it without --offset and --length", pieceCID)
+			}
+			if err != datakeys.ErrKeyNotFound {
+				return err
+			}
+		}
+
 		mpid, err := GetPorcelainAPI(env).MinerGetPeerID(req.Context, minerAddr)
 		if err != nil {
 			return err
 		}
 
-		readCloser, err := GetRetrievalAPI(env).RetrievePiece(req.Context, pieceCID, mpid, minerAddr)
+		readCloser, err := GetRetrievalAPI(env).RetrievePiece(req.Context, pieceCID, pr, mpid, minerAddr, fromAddr, maxPrice)
 		if err != nil {
 			return err
 		}
 
-		return re.Emit(readCloser)
+		if pr.Offset != 0 || pr.Length != 0 {
+			return re.Emit(readCloser)
+		}
+
+		r, err := GetPorcelainAPI(env).ClientDecrypt(pieceCID, readCloser)
+		if err != nil {
+			readCloser.Close() // nolint: errcheck
+			return err
+		}
+
+		return re.Emit(struct {
+			io.Reader
+			io.Closer
+		}{r, readCloser})
 	},
 }
+
+// RetrievalQueryResult is the answer of one miner to a retrieval query.
+type RetrievalQueryResult struct {
+	Miner           address.Address `json:"miner"`
+	Available       bool            `json:"available"`
+	Size            uint64          `json:"size"`
+	PricePerByte    types.AttoFIL   `json:"pricePerByte"`
+	PaymentInterval uint64          `json:"paymentInterval"`
+	Error           string          `json:"error,omitempty"`
+}
+
+var clientQueryCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Ask miners whether they serve a piece, and at what price",
+		ShortDescription: `
+Asks one or many retrieval miners, given as a comma separated list of miner
+actor addresses, whether they hold a piece. Results are returned as a space
+separated table with miner, availability, size in bytes, price per byte (FIL)
+and payment interval in bytes, or the reason the miner can't serve the piece.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("miner", true, false, "Comma separated retrieval miner actor addresses"),
+		cmdkit.StringArg("cid", true, false, "Content identifier of piece to query"),
+	},
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		var minerAddrs []address.Address
+		for _, s := range strings.Split(req.Arguments[0], ",") {
+			minerAddr, err := address.NewFromString(strings.TrimSpace(s))
+			if err != nil {
+				return err
+			}
+			minerAddrs = append(minerAddrs, minerAddr)
+		}
+
+		pieceCID, err := cid.Decode(req.Arguments[1])
+		if err != nil {
+			return err
+		}
+
+		for _, minerAddr := range minerAddrs {
+			result := RetrievalQueryResult{Miner: minerAddr}
+
+			res, err := queryMiner(req.Context, env, minerAddr, pieceCID)
+			if err != nil {
+				result.Error = err.Error()
+			} else {
+				result.Available = res.Available
+				result.Size = res.Size
+				result.PricePerByte = res.PricePerByte
+				result.PaymentInterval = res.PaymentInterval
+				result.Error = res.ErrorMessage
+			}
+
+			if err := re.Emit(&result); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Type: RetrievalQueryResult{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, res *RetrievalQueryResult) error {
+			if !res.Available {
+				_, err := fmt.Fprintf(w, "%s unavailable %s\n", res.Miner, res.Error)
+				return err
+			}
+			_, err := fmt.Fprintf(w, "%s available %d %s %d\n", res.Miner, res.Size, res.PricePerByte, res.PaymentInterval)
+			return err
+		}),
+	},
+}
+
+func queryMiner(ctx context.Context, env cmds.Environment, minerAddr address.Address, pieceCID cid.Cid) (*retrieval.RetrievalQueryResponse, error) {
+	mpid, err := GetPorcelainAPI(env).MinerGetPeerID(ctx, minerAddr)
+	if err != nil {
+		return nil, err
+	}
+	return GetRetrievalAPI(env).QueryPiece(ctx, pieceCID, mpid)
+}
@@ -3,6 +3,7 @@ package commands_test
 import (
 	"context"
 	"math/big"
+	"strings"
 	"testing"
 
 	"github.com/ipfs/go-ipfs-files"
@@ -10,6 +11,8 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/fixtures"
+	th "github.com/filecoin-project/go-filecoin/testhelpers"
 	tf "github.com/filecoin-project/go-filecoin/testhelpers/testflags"
 	"github.com/filecoin-project/go-filecoin/tools/fast"
 	"github.com/filecoin-project/go-filecoin/tools/fast/fastesting"
@@ -49,3 +52,17 @@ func TestSelfDialRetrievalGoodError(t *testing.T) {
 	assert.Error(t, err)
 	fastesting.AssertStdErrContains(t, env.GenesisMiner, "attempting to retrieve piece from self")
 }
+
+func TestRetrievePieceRangeOfEncryptedPiece(t *testing.T) {
+	tf.IntegrationTest(t)
+
+	d := th.NewDaemon(t).Start()
+	defer d.ShutdownSuccess()
+
+	dataCid := d.RunWithStdin(strings.NewReader("satyamevajayate"), "client", "import", "--encrypt").ReadStdoutTrimNewlines()
+
+	// refused before the miner is looked up or paid
+	d.RunFail("only decrypts as a whole",
+		"retrieval-client", "retrieve-piece", fixtures.TestMiners[0], dataCid, "--offset", "1",
+	)
+}
@@ -0,0 +1,146 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ipfs/go-ipfs-cmdkit"
+	"github.com/ipfs/go-ipfs-cmds"
+
+	"github.com/filecoin-project/go-filecoin/wallet/hd"
+)
+
+// WalletHDMnemonicResult is the result of commands that show the wallet's mnemonic.
+type WalletHDMnemonicResult struct {
+	Mnemonic string
+}
+
+var walletHDCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Derive wallet addresses from a seed that can be restored from a mnemonic",
+		ShortDescription: `
+By default, each new address has an unrelated random key, so a backup of the
+wallet is only good until the next address is created. A hierarchical
+deterministic (HD) wallet derives new keys from a seed, so that all of them can
+be recovered from the seed's mnemonic phrase. Addresses already in the wallet
+when it gets a seed, and imported addresses, are not derived from the seed.
+
+An encrypted wallet must be unlocked to get a seed, and its seed is encrypted
+with the wallet's passphrase.
+`,
+	},
+	Subcommands: map[string]*cmds.Command{
+		"new":    walletHDNewCmd,
+		"import": walletHDImportCmd,
+		"export": walletHDExportCmd,
+		"rescan": walletHDRescanCmd,
+	},
+}
+
+var hdPathOption = cmdkit.StringOption("path", "Derivation path of the account whose children are the wallet's keys").WithDefault(hd.DefaultAccountPath)
+
+var mnemonicEncoders = cmds.EncoderMap{
+	cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, res *WalletHDMnemonicResult) error {
+		_, err := fmt.Fprintln(w, res.Mnemonic)
+		return err
+	}),
+}
+
+var walletHDNewCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Give the wallet a new random seed, and print its mnemonic",
+		ShortDescription: `
+Generates a new random seed for the wallet to derive new addresses from, and
+prints its mnemonic. Write the mnemonic down and keep it safe: anyone who has it
+can spend from the addresses derived from it.
+`,
+	},
+	Options: []cmdkit.Option{
+		hdPathOption,
+	},
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		mnemonic, err := GetPorcelainAPI(env).WalletHDInit("", req.Options["path"].(string))
+		if err != nil {
+			return err
+		}
+		return re.Emit(&WalletHDMnemonicResult{Mnemonic: mnemonic})
+	},
+	Type:     &WalletHDMnemonicResult{},
+	Encoders: mnemonicEncoders,
+}
+
+var walletHDImportCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Give the wallet the seed of a mnemonic, to restore its addresses",
+		ShortDescription: `
+Sets the wallet's seed to that of the given mnemonic, which is read from stdin
+if not given as an argument. Run 'go-filecoin wallet hd rescan' afterward to add
+the addresses derived from the seed that have been used to the wallet.
+`,
+	},
+	Arguments: []cmdkit.Argument{
+		cmdkit.StringArg("mnemonic", true, false, "Mnemonic phrase of the seed").EnableStdin(),
+	},
+	Options: []cmdkit.Option{
+		hdPathOption,
+	},
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		mnemonic := strings.Join(strings.Fields(req.Arguments[0]), " ")
+		_, err := GetPorcelainAPI(env).WalletHDInit(mnemonic, req.Options["path"].(string))
+		return err
+	},
+}
+
+var walletHDExportCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Print the mnemonic of the wallet's seed",
+	},
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		mnemonic, err := GetPorcelainAPI(env).WalletHDMnemonic()
+		if err != nil {
+			return err
+		}
+		return re.Emit(&WalletHDMnemonicResult{Mnemonic: mnemonic})
+	},
+	Type:     &WalletHDMnemonicResult{},
+	Encoders: mnemonicEncoders,
+}
+
+var walletHDRescanCmd = &cmds.Command{
+	Helptext: cmdkit.HelpText{
+		Tagline: "Add the addresses derived from the wallet's seed that have been used on chain",
+		ShortDescription: `
+Derives addresses from the wallet's seed in order, adding those with an actor on
+chain to the wallet, until --gap consecutive addresses have none. Prints the
+addresses found. The chain should be synced first.
+`,
+	},
+	Options: []cmdkit.Option{
+		cmdkit.UintOption("gap", "Number of consecutive unused addresses after which to stop").WithDefault(uint(20)),
+	},
+	Run: func(req *cmds.Request, re cmds.ResponseEmitter, env cmds.Environment) error {
+		gap := req.Options["gap"].(uint)
+		addrs, err := GetPorcelainAPI(env).WalletHDRescan(req.Context, uint32(gap))
+		if err != nil {
+			return err
+		}
+
+		var alr AddressLsResult
+		for _, addr := range addrs {
+			alr.Addresses = append(alr.Addresses, addr.String())
+		}
+		return re.Emit(&alr)
+	},
+	Type: &AddressLsResult{},
+	Encoders: cmds.EncoderMap{
+		cmds.Text: cmds.MakeTypedEncoder(func(req *cmds.Request, w io.Writer, addrs *AddressLsResult) error {
+			for _, addr := range addrs.Addresses {
+				if _, err := fmt.Fprintln(w, addr); err != nil {
+					return err
+				}
+			}
+			return nil
+		}),
+	},
+}
@@ -20,13 +20,17 @@ type Config struct {
 	API           *APIConfig           `json:"api"`
 	Bootstrap     *BootstrapConfig     `json:"bootstrap"`
 	Datastore     *DatastoreConfig     `json:"datastore"`
+	DealPolicy    *DealPolicyConfig    `json:"dealPolicy"`
+	GasEstimation *GasEstimationConfig `json:"gasEstimation"`
 	Heartbeat     *HeartbeatConfig     `json:"heartbeat"`
+	History       *HistoryConfig       `json:"history"`
 	Mining        *MiningConfig        `json:"mining"`
 	Mpool         *MessagePoolConfig   `json:"mpool"`
 	Net           string               `json:"net"`
 	Observability *ObservabilityConfig `json:"observability"`
 	SectorBase    *SectorBaseConfig    `json:"sectorbase"`
 	Swarm         *SwarmConfig         `json:"swarm"`
+	Vouchers      *VoucherConfig       `json:"vouchers"`
 	Wallet        *WalletConfig        `json:"wallet"`
 }
 
@@ -107,6 +111,14 @@ type MiningConfig struct {
 	MinerAddress            address.Address `json:"minerAddress"`
 	AutoSealIntervalSeconds uint            `json:"autoSealIntervalSeconds"`
 	StoragePrice            types.AttoFIL   `json:"storagePrice"`
+	RetrievalPrice          types.AttoFIL   `json:"retrievalPrice"`
+	// PoStSafetyMargin is how many blocks a PoSt is expected to take to generate and be
+	// mined. Failed PoSts are retried on time only while that many blocks are left in the
+	// proving period.
+	PoStSafetyMargin uint64 `json:"postSafetyMargin"`
+	// PoStRetries is how many times a failed PoSt generation or submission is retried in a
+	// proving period.
+	PoStRetries uint `json:"postRetries"`
 }
 
 func newDefaultMiningConfig() *MiningConfig {
@@ -114,12 +126,78 @@ func newDefaultMiningConfig() *MiningConfig {
 		MinerAddress:            address.Undef,
 		AutoSealIntervalSeconds: 120,
 		StoragePrice:            types.ZeroAttoFIL,
+		RetrievalPrice:          types.ZeroAttoFIL,
+		PoStSafetyMargin:        20,
+		PoStRetries:             3,
+	}
+}
+
+// DealPolicyConfig holds the rules by which a storage miner accepts deal proposals, on top of
+// checking their payment. Zero values leave the corresponding rule out.
+type DealPolicyConfig struct {
+	// MinPieceSize is the smallest piece, in bytes, the miner stores.
+	MinPieceSize uint64 `json:"minPieceSize"`
+	// MaxPieceSize is the largest piece, in bytes, the miner stores. Pieces must fit in a
+	// sector regardless.
+	MaxPieceSize uint64 `json:"maxPieceSize"`
+	// MinPrice is the lowest price per byte per block the miner accepts.
+	MinPrice types.AttoFIL `json:"minPrice"`
+	// MaxDuration is the longest deal, in blocks, the miner accepts.
+	MaxDuration uint64 `json:"maxDuration"`
+	// AllowedClients, if not empty, are the only clients the miner makes deals with.
+	AllowedClients []address.Address `json:"allowedClients"`
+	// DeniedClients are clients the miner doesn't make deals with.
+	DeniedClients []address.Address `json:"deniedClients"`
+	// MaxStagedBytes caps the total size of the pieces of accepted deals that aren't sealed yet.
+	MaxStagedBytes uint64 `json:"maxStagedBytes"`
+	// MaxConcurrentDeals caps the number of accepted deals that aren't sealed yet.
+	MaxConcurrentDeals uint `json:"maxConcurrentDeals"`
+	// DecisionHook is the path of an executable that decides on proposals passing all other
+	// rules. It is given the proposal as JSON on stdin and answers with a JSON object such as
+	// {"accept": false, "reason": "..."} on stdout.
+	DecisionHook string `json:"decisionHook"`
+}
+
+func newDefaultDealPolicyConfig() *DealPolicyConfig {
+	return &DealPolicyConfig{
+		MinPrice:       types.ZeroAttoFIL,
+		AllowedClients: []address.Address{},
+		DeniedClients:  []address.Address{},
+	}
+}
+
+// VoucherConfig holds how a miner redeems the payment vouchers of its storage deals and retrievals.
+type VoucherConfig struct {
+	// AutoRedeem turns on redeeming vouchers as soon as they are valid.
+	AutoRedeem bool `json:"autoRedeem"`
+	// GasPrice is the gas price of redeem messages.
+	GasPrice types.AttoFIL `json:"gasPrice"`
+	// GasLimit is the gas limit of redeem messages.
+	GasLimit types.GasUnits `json:"gasLimit"`
+	// MaxGasPerBlock caps the gas limits of the redeem messages sent each block. Zero means
+	// no cap.
+	MaxGasPerBlock types.GasUnits `json:"maxGasPerBlock"`
+	// EolMargin is the number of blocks before the eol of a payment channel from which its
+	// vouchers are redeemed first, and even if the gas costs more than they are worth.
+	EolMargin uint64 `json:"eolMargin"`
+}
+
+func newDefaultVoucherConfig() *VoucherConfig {
+	return &VoucherConfig{
+		AutoRedeem:     true,
+		GasPrice:       types.NewGasPrice(1),
+		GasLimit:       types.NewGasUnits(300),
+		MaxGasPerBlock: types.NewGasUnits(3000),
+		EolMargin:      100,
 	}
 }
 
 // WalletConfig holds all configuration options related to the wallet.
 type WalletConfig struct {
 	DefaultAddress address.Address `json:"defaultAddress,omitempty"`
+	// RemoteSigner is the path of the unix socket of a remote signer holding the wallet's keys.
+	// If set, the node signs with the remote signer instead of keys in its repo.
+	RemoteSigner string `json:"remoteSigner,omitempty"`
 }
 
 func newDefaultWalletConfig() *WalletConfig {
@@ -151,6 +229,20 @@ func newDefaultHeartbeatConfig() *HeartbeatConfig {
 	}
 }
 
+// HistoryConfig holds all configuration options related to the address history index.
+type HistoryConfig struct {
+	// Enabled makes the node index the messages and value transfers from and to each
+	// address, so that 'address history' can list them. Indexing the chain takes time and
+	// disk space, so it is off by default.
+	Enabled bool `json:"enabled"`
+}
+
+func newDefaultHistoryConfig() *HistoryConfig {
+	return &HistoryConfig{
+		Enabled: false,
+	}
+}
+
 // ObservabilityConfig is a container for configuration related to observables.
 type ObservabilityConfig struct {
 	Metrics *MetricsConfig `json:"metrics"`
@@ -207,12 +299,52 @@ type MessagePoolConfig struct {
 	MaxPoolSize uint `json:"maxPoolSize"`
 	// MaxNonceGap is the maximum nonce of a message past the last received on chain
 	MaxNonceGap types.Uint64 `json:"maxNonceGap"`
+	// MaxPendingPerSender is the maximum number of pending messages the pool will hold from a single sender
+	MaxPendingPerSender uint `json:"maxPendingPerSender"`
+	// MaxBytesPerSender is the maximum total size in bytes of pending messages the pool will hold from a single sender
+	MaxBytesPerSender uint `json:"maxBytesPerSender"`
+	// ReplacePriceBumpPercent is the minimum percentage by which the gas price of a message must exceed that
+	// of a pending message with the same sender and nonce in order to replace it
+	ReplacePriceBumpPercent uint `json:"replacePriceBumpPercent"`
+	// AutoBumpAfterRounds is the number of rounds a message sent from this node waits in the outbound
+	// queue before it is replaced with one bumping its gas price by ReplacePriceBumpPercent. It should be
+	// less than the rounds after which the queue gives up on messages. Zero turns automatic bumping off
+	AutoBumpAfterRounds uint64 `json:"autoBumpAfterRounds"`
+	// AutoBumpMaxGasPrice is the highest gas price automatic bumping raises a message's price to
+	AutoBumpMaxGasPrice types.AttoFIL `json:"autoBumpMaxGasPrice"`
 }
 
 func newDefaultMessagePoolConfig() *MessagePoolConfig {
 	return &MessagePoolConfig{
-		MaxPoolSize: 10000,
-		MaxNonceGap: 100,
+		MaxPoolSize:             10000,
+		MaxNonceGap:             100,
+		MaxPendingPerSender:     1000,
+		MaxBytesPerSender:       1 << 20,
+		ReplacePriceBumpPercent: 10,
+		AutoBumpAfterRounds:     0,
+		AutoBumpMaxGasPrice:     types.NewGasPrice(1000),
+	}
+}
+
+// GasEstimationConfig holds all configuration options related to estimating the gas price and
+// limit of outgoing messages when they are not specified.
+type GasEstimationConfig struct {
+	// LimitMarginPercent is the percentage added to a message's previewed gas usage to set its gas limit
+	LimitMarginPercent uint `json:"limitMarginPercent"`
+	// PricePercentile is the percentile of gas prices paid by recently mined messages used as the gas price
+	PricePercentile uint `json:"pricePercentile"`
+	// PriceLookbackTipsets is the number of most recent tipsets whose messages are sampled for gas prices
+	PriceLookbackTipsets uint `json:"priceLookbackTipsets"`
+	// MinGasPrice is the lowest gas price that will be estimated, used when no recent messages are found
+	MinGasPrice types.AttoFIL `json:"minGasPrice"`
+}
+
+func newDefaultGasEstimationConfig() *GasEstimationConfig {
+	return &GasEstimationConfig{
+		LimitMarginPercent:   20,
+		PricePercentile:      50,
+		PriceLookbackTipsets: 10,
+		MinGasPrice:          types.NewGasPrice(1),
 	}
 }
 
@@ -237,10 +369,14 @@ func NewDefaultConfig() *Config {
 		API:           newDefaultAPIConfig(),
 		Bootstrap:     newDefaultBootstrapConfig(),
 		Datastore:     newDefaultDatastoreConfig(),
+		DealPolicy:    newDefaultDealPolicyConfig(),
+		GasEstimation: newDefaultGasEstimationConfig(),
 		Swarm:         newDefaultSwarmConfig(),
 		Mining:        newDefaultMiningConfig(),
+		Vouchers:      newDefaultVoucherConfig(),
 		Wallet:        newDefaultWalletConfig(),
 		Heartbeat:     newDefaultHeartbeatConfig(),
+		History:       newDefaultHistoryConfig(),
 		Net:           "",
 		Mpool:         newDefaultMessagePoolConfig(),
 		SectorBase:    newDefaultSectorbaseConfig(),
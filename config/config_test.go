@@ -66,20 +66,48 @@ func TestWriteFile(t *testing.T) {
 		"type": "badgerds",
 		"path": "badger"
 	},
+	"dealPolicy": {
+		"minPieceSize": 0,
+		"maxPieceSize": 0,
+		"minPrice": "0",
+		"maxDuration": 0,
+		"allowedClients": [],
+		"deniedClients": [],
+		"maxStagedBytes": 0,
+		"maxConcurrentDeals": 0,
+		"decisionHook": ""
+	},
+	"gasEstimation": {
+		"limitMarginPercent": 20,
+		"pricePercentile": 50,
+		"priceLookbackTipsets": 10,
+		"minGasPrice": "0.000000000000000001"
+	},
 	"heartbeat": {
 		"beatTarget": "",
 		"beatPeriod": "3s",
 		"reconnectPeriod": "10s",
 		"nickname": ""
 	},
+	"history": {
+		"enabled": false
+	},
 	"mining": {
 		"minerAddress": "empty",
 		"autoSealIntervalSeconds": 120,
-		"storagePrice": "0"
+		"storagePrice": "0",
+		"retrievalPrice": "0",
+		"postSafetyMargin": 20,
+		"postRetries": 3
 	},
 	"mpool": {
 		"maxPoolSize": 10000,
-		"maxNonceGap": "100"
+		"maxNonceGap": "100",
+		"maxPendingPerSender": 1000,
+		"maxBytesPerSender": 1048576,
+		"replacePriceBumpPercent": 10,
+		"autoBumpAfterRounds": 0,
+		"autoBumpMaxGasPrice": "0.000000000000001"
 	},
 	"net": "",
 	"observability": {
@@ -100,6 +128,13 @@ func TestWriteFile(t *testing.T) {
 	"swarm": {
 		"address": "/ip4/0.0.0.0/tcp/6000"
 	},
+	"vouchers": {
+		"autoRedeem": true,
+		"gasPrice": "0.000000000000000001",
+		"gasLimit": "300",
+		"maxGasPerBlock": "3000",
+		"eolMargin": 100
+	},
 	"wallet": {
 		"defaultAddress": "empty"
 	}
@@ -40,6 +40,10 @@ type BlockRewarder interface {
 type ApplicationResult struct {
 	Receipt        *types.MessageReceipt
 	ExecutionError error
+	// InternalTransfers are the value transfers made by actors sending messages to other
+	// actors while the message executed. They are empty if ExecutionError is set, since
+	// its state changes were reverted.
+	InternalTransfers []vm.InternalTransfer
 }
 
 // ProcessTipSetResponse records the results of successfully applied messages,
@@ -290,7 +294,8 @@ func (p *DefaultProcessor) ApplyMessage(ctx context.Context, st state.Tree, vms
 
 	cachedStateTree := state.NewCachedStateTree(st)
 
-	r, err := p.attemptApplyMessage(ctx, cachedStateTree, vms, msg, bh, gasTracker, ancestors)
+	var transfers []vm.InternalTransfer
+	r, err := p.attemptApplyMessage(ctx, cachedStateTree, vms, msg, bh, gasTracker, ancestors, &transfers)
 	if err == nil {
 		err = cachedStateTree.Commit(ctx)
 		if err != nil {
@@ -322,6 +327,7 @@ func (p *DefaultProcessor) ApplyMessage(ctx context.Context, st state.Tree, vms
 		// includes errInsufficientFunds because we don't want the message
 		// to be replayable.
 		executionError = err
+		transfers = nil
 		log.Infof("ApplyMessage failed: %s %s", executionError, msg.String())
 	}
 
@@ -336,7 +342,7 @@ func (p *DefaultProcessor) ApplyMessage(ctx context.Context, st state.Tree, vms
 		return nil, errors.FaultErrorWrap(err, "could not set from actor after inc nonce")
 	}
 
-	return &ApplicationResult{Receipt: r, ExecutionError: executionError}, nil
+	return &ApplicationResult{Receipt: r, ExecutionError: executionError, InternalTransfers: transfers}, nil
 }
 
 var (
@@ -438,7 +444,7 @@ func PreviewQueryMethod(ctx context.Context, st state.Tree, vms vm.StorageMap, t
 // should deal with trying to apply the message to the state tree whereas
 // ApplyMessage should deal with any side effects and how it should be presented
 // to the caller. attemptApplyMessage should only be called from ApplyMessage.
-func (p *DefaultProcessor) attemptApplyMessage(ctx context.Context, st *state.CachedTree, store vm.StorageMap, msg *types.SignedMessage, bh *types.BlockHeight, gasTracker *vm.GasTracker, ancestors []types.TipSet) (*types.MessageReceipt, error) {
+func (p *DefaultProcessor) attemptApplyMessage(ctx context.Context, st *state.CachedTree, store vm.StorageMap, msg *types.SignedMessage, bh *types.BlockHeight, gasTracker *vm.GasTracker, ancestors []types.TipSet, transfers *[]vm.InternalTransfer) (*types.MessageReceipt, error) {
 	gasTracker.ResetForNewMessage(msg.MeteredMessage)
 	if err := blockGasLimitError(gasTracker); err != nil {
 		return &types.MessageReceipt{
@@ -492,6 +498,7 @@ func (p *DefaultProcessor) attemptApplyMessage(ctx context.Context, st *state.Ca
 		GasTracker:  gasTracker,
 		BlockHeight: bh,
 		Ancestors:   ancestors,
+		Transfers:   transfers,
 	}
 	vmCtx := vm.NewVMContext(vmCtxParams)
 
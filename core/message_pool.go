@@ -2,18 +2,23 @@ package core
 
 import (
 	"context"
+	"math/big"
 	"sync"
 
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
 	"github.com/pkg/errors"
 
 	"github.com/filecoin-project/go-filecoin/address"
 	"github.com/filecoin-project/go-filecoin/config"
 	"github.com/filecoin-project/go-filecoin/metrics"
+	"github.com/filecoin-project/go-filecoin/repo"
 	"github.com/filecoin-project/go-filecoin/types"
 )
 
 var mpSize = metrics.NewInt64Gauge("message_pool_size", "The size of the message pool")
+var mpEvictions = metrics.NewInt64Counter("message_pool_evictions", "Number of messages evicted from the message pool to make room for higher priced messages")
+var mpReplacements = metrics.NewInt64Counter("message_pool_replacements", "Number of messages replaced in the message pool by a higher priced message with the same nonce")
 
 // MessagePoolValidator defines a validator that ensures a message can go through the pool.
 type MessagePoolValidator interface {
@@ -26,40 +31,96 @@ type MessagePoolValidator interface {
 // via network or directly created via user command that have yet to be included
 // in a block. Messages are removed as they are processed.
 //
+// When the pool is full a new message may displace the cheapest message in the
+// pool. Only the highest nonce pending from each sender is a candidate for
+// eviction so that the remaining messages from a sender stay contiguous.
+// The number and total size of pending messages from any one sender is capped.
+// A message with the same sender and nonce as a pending message replaces it if
+// its gas price is sufficiently higher (see MinimumReplacementGasPrice).
+//
+// A pool constructed with NewPersistentMessagePool records its messages in a datastore
+// so they can be restored after a restart.
+//
 // MessagePool is safe for concurrent access.
 type MessagePool struct {
 	lk sync.RWMutex
 
-	cfg           *config.MessagePoolConfig
-	validator     MessagePoolValidator
-	pending       map[cid.Cid]*timedmessage // all pending messages
-	addressNonces map[addressNonce]bool     // set of address nonce pairs used to efficiently validate duplicate nonces
+	cfg       *config.MessagePoolConfig
+	validator MessagePoolValidator
+	pending   map[cid.Cid]*timedmessage                  // all pending messages
+	senders   map[address.Address]*senderPendingMessages // pending messages indexed by sender and nonce
+	ds        repo.Datastore                             // records pending messages, or nil if the pool is not persisted
 }
 
 type timedmessage struct {
 	message *types.SignedMessage
 	addedAt uint64
+	size    uint
 }
 
-type addressNonce struct {
-	addr  address.Address
-	nonce uint64
+// senderPendingMessages tracks the pending messages from a single sender.
+type senderPendingMessages struct {
+	byNonce map[uint64]cid.Cid
+	bytes   uint
 }
 
-func newAddressNonce(msg *types.SignedMessage) addressNonce {
-	return addressNonce{addr: msg.From, nonce: uint64(msg.Nonce)}
+// highestNonce returns the largest nonce pending from the sender.
+func (spm *senderPendingMessages) highestNonce() uint64 {
+	var highest uint64
+	for nonce := range spm.byNonce {
+		if nonce > highest {
+			highest = nonce
+		}
+	}
+	return highest
 }
 
 // NewMessagePool constructs a new MessagePool.
 func NewMessagePool(cfg *config.MessagePoolConfig, validator MessagePoolValidator) *MessagePool {
 	return &MessagePool{
-		cfg:           cfg,
-		validator:     validator,
-		pending:       make(map[cid.Cid]*timedmessage),
-		addressNonces: make(map[addressNonce]bool),
+		cfg:       cfg,
+		validator: validator,
+		pending:   make(map[cid.Cid]*timedmessage),
+		senders:   make(map[address.Address]*senderPendingMessages),
 	}
 }
 
+// NewPersistentMessagePool constructs a new MessagePool which records its messages in a datastore.
+// Messages previously recorded there are not added to the pool until Restore is called.
+func NewPersistentMessagePool(cfg *config.MessagePoolConfig, validator MessagePoolValidator, ds repo.Datastore) *MessagePool {
+	pool := NewMessagePool(cfg, validator)
+	pool.ds = ds
+	return pool
+}
+
+// Restore adds the messages recorded in the pool's datastore back to the pool, validating each against
+// the current state. Messages that are no longer valid, such as those already mined, are discarded.
+func (pool *MessagePool) Restore(ctx context.Context) error {
+	if pool.ds == nil {
+		return nil
+	}
+
+	var keys []datastore.Key
+	var stored []*pooledMessage
+	err := queryObjects(pool.ds, mpoolDatastorePrefix, func() interface{} { return &pooledMessage{} }, func(key datastore.Key, obj interface{}) {
+		keys = append(keys, key)
+		stored = append(stored, obj.(*pooledMessage))
+	})
+	if err != nil {
+		return err
+	}
+
+	for i, pm := range stored {
+		if _, err := pool.Add(ctx, pm.Msg, pm.AddedAt); err != nil {
+			log.Infof("discarding stored message: %s", err)
+			if err := pool.ds.Delete(keys[i]); err != nil {
+				return errors.Wrap(err, "failed to delete stored message")
+			}
+		}
+	}
+	return nil
+}
+
 // Add adds a message to the pool, tagged with the block height at which it was received.
 // Does nothing if the message is already in the pool.
 func (pool *MessagePool) Add(ctx context.Context, msg *types.SignedMessage, height uint64) (cid.Cid, error) {
@@ -77,12 +138,48 @@ func (pool *MessagePool) Add(ctx context.Context, msg *types.SignedMessage, heig
 		return c, nil
 	}
 
-	if err = pool.validateMessage(ctx, msg); err != nil {
+	bytes, err := msg.Marshal()
+	if err != nil {
+		return cid.Undef, errors.Wrap(err, "failed to marshal message")
+	}
+	size := uint(len(bytes))
+
+	replaced, err := pool.validateMessage(ctx, msg, size)
+	if err != nil {
 		return cid.Undef, errors.Wrap(err, "validation error adding message to pool")
 	}
 
-	pool.pending[c] = &timedmessage{message: msg, addedAt: height}
-	pool.addressNonces[newAddressNonce(msg)] = true
+	if replaced.Defined() {
+		pool.remove(replaced)
+		mpReplacements.Inc(ctx, 1)
+	} else if uint(len(pool.pending)) >= pool.cfg.MaxPoolSize {
+		evict, ok := pool.evictionCandidate(msg)
+		if !ok {
+			return cid.Undef, errors.Errorf("message pool is full (%d messages) and message gas price %s is too low to evict", pool.cfg.MaxPoolSize, msg.GasPrice)
+		}
+		pool.remove(evict)
+		mpEvictions.Inc(ctx, 1)
+	}
+
+	if pool.ds != nil {
+		key, err := mpoolKey(msg)
+		if err != nil {
+			return cid.Undef, err
+		}
+		if err := putObject(pool.ds, key, &pooledMessage{Msg: msg, AddedAt: height}); err != nil {
+			return cid.Undef, err
+		}
+	}
+
+	pool.pending[c] = &timedmessage{message: msg, addedAt: height, size: size}
+	spm, ok := pool.senders[msg.From]
+	if !ok {
+		spm = &senderPendingMessages{byNonce: make(map[uint64]cid.Cid)}
+		pool.senders[msg.From] = spm
+	}
+	spm.byNonce[uint64(msg.Nonce)] = c
+	spm.bytes += size
+
 	mpSize.Set(ctx, int64(len(pool.pending)))
 	return c, nil
 }
@@ -117,26 +214,80 @@ func (pool *MessagePool) Remove(c cid.Cid) {
 	pool.lk.Lock()
 	defer pool.lk.Unlock()
 
+	pool.remove(c)
+	mpSize.Set(context.TODO(), int64(len(pool.pending)))
+}
+
+// remove removes the message by CID from the pending pool and the sender index.
+// The caller must hold the pool lock.
+func (pool *MessagePool) remove(c cid.Cid) {
 	msg, ok := pool.pending[c]
-	if ok {
-		delete(pool.addressNonces, newAddressNonce(msg.message))
-		delete(pool.pending, c)
+	if !ok {
+		return
 	}
-	mpSize.Set(context.TODO(), int64(len(pool.pending)))
+	delete(pool.pending, c)
+
+	if pool.ds != nil {
+		key, err := mpoolKey(msg.message)
+		if err == nil {
+			err = pool.ds.Delete(key)
+		}
+		if err != nil {
+			log.Errorf("failed to delete stored message %s: %s", c, err)
+		}
+	}
+
+	spm, ok := pool.senders[msg.message.From]
+	if !ok {
+		return
+	}
+	delete(spm.byNonce, uint64(msg.message.Nonce))
+	spm.bytes -= msg.size
+	if len(spm.byNonce) == 0 {
+		delete(pool.senders, msg.message.From)
+	}
+}
+
+// evictionCandidate returns the CID of the message that should be evicted to make
+// room for msg, if any. The candidate is the lowest priced message among each
+// sender's highest nonce message, and it is only returned if msg pays a strictly
+// higher gas price. A sender's own messages are never evicted in favour of a
+// higher nonce from the same sender since that would leave a nonce gap.
+// The caller must hold the pool lock.
+func (pool *MessagePool) evictionCandidate(msg *types.SignedMessage) (cid.Cid, bool) {
+	var candidate *timedmessage
+	var candidateCid cid.Cid
+	for _, spm := range pool.senders {
+		c := spm.byNonce[spm.highestNonce()]
+		tm := pool.pending[c]
+		if candidate == nil || tm.message.GasPrice.LessThan(candidate.message.GasPrice) ||
+			// among equally priced messages prefer the one received most recently
+			(tm.message.GasPrice.Equal(candidate.message.GasPrice) && tm.addedAt > candidate.addedAt) {
+			candidate = tm
+			candidateCid = c
+		}
+	}
+
+	if candidate == nil || !candidate.message.GasPrice.LessThan(msg.GasPrice) {
+		return cid.Undef, false
+	}
+	if candidate.message.From == msg.From && candidate.message.Nonce < msg.Nonce {
+		return cid.Undef, false
+	}
+	return candidateCid, true
 }
 
 // LargestNonce returns the largest nonce used by a message from address in the pool.
 // If no messages from address are found, found will be false.
 func (pool *MessagePool) LargestNonce(address address.Address) (largest uint64, found bool) {
-	for _, m := range pool.Pending() {
-		if m.From == address {
-			found = true
-			if uint64(m.Nonce) > largest {
-				largest = uint64(m.Nonce)
-			}
-		}
+	pool.lk.RLock()
+	defer pool.lk.RUnlock()
+
+	spm, found := pool.senders[address]
+	if !found {
+		return 0, false
 	}
-	return
+	return spm.highestNonce(), true
 }
 
 // PendingBefore returns the CIDs of messages added with height less than `minimumHeight`.
@@ -153,19 +304,52 @@ func (pool *MessagePool) PendingBefore(minimumHeight uint64) []cid.Cid {
 	return cids
 }
 
-// validateMessage validates that too many messages aren't added to the pool and the ones that are
-// have a high probability of making it through processing.
-func (pool *MessagePool) validateMessage(ctx context.Context, message *types.SignedMessage) error {
-	if uint(len(pool.pending)) >= pool.cfg.MaxPoolSize {
-		return errors.Errorf("message pool is full (%d messages)", pool.cfg.MaxPoolSize)
+// validateMessage validates that a sender doesn't add too many messages to the pool and the ones that
+// are added have a high probability of making it through processing. Whether the pool as a whole has
+// room for the message is decided by the caller.
+// If the message validly replaces a pending message with the same nonce, the CID of the message
+// to be replaced is returned.
+func (pool *MessagePool) validateMessage(ctx context.Context, message *types.SignedMessage, size uint) (cid.Cid, error) {
+	replaced := cid.Undef
+	var pendingCount, pendingBytes uint
+	if spm, ok := pool.senders[message.From]; ok {
+		pendingCount, pendingBytes = uint(len(spm.byNonce)), spm.bytes
+
+		// check whether a message with this nonce already exists and, if so, whether this one may replace it
+		if existingCid, found := spm.byNonce[uint64(message.Nonce)]; found {
+			existing := pool.pending[existingCid]
+			minPrice := MinimumReplacementGasPrice(existing.message.GasPrice, pool.cfg.ReplacePriceBumpPercent)
+			if message.GasPrice.LessThan(minPrice) {
+				return cid.Undef, errors.Errorf("message pool contains message with same actor and nonce but different cid (replacement requires gas price of at least %s)", minPrice)
+			}
+			replaced = existingCid
+			pendingCount--
+			pendingBytes -= existing.size
+		}
+	}
+
+	if pendingCount >= pool.cfg.MaxPendingPerSender {
+		return cid.Undef, errors.Errorf("message pool contains too many messages from %s (%d messages)", message.From, pool.cfg.MaxPendingPerSender)
 	}
 
-	// check that message with this nonce does not already exist
-	_, found := pool.addressNonces[newAddressNonce(message)]
-	if found {
-		return errors.Errorf("message pool contains message with same actor and nonce but different cid")
+	if pendingBytes+size > pool.cfg.MaxBytesPerSender {
+		return cid.Undef, errors.Errorf("message pool contains too many bytes from %s (%d bytes)", message.From, pool.cfg.MaxBytesPerSender)
 	}
 
 	// check that the message is likely to succeed in processing
-	return pool.validator.Validate(ctx, message)
+	if err := pool.validator.Validate(ctx, message); err != nil {
+		return cid.Undef, err
+	}
+	return replaced, nil
+}
+
+// MinimumReplacementGasPrice returns the lowest gas price a message must offer to replace a
+// pending message with the given gas price, given a minimum bump percentage. The result is
+// always strictly greater than the original price.
+func MinimumReplacementGasPrice(price types.AttoFIL, bumpPercent uint) types.AttoFIL {
+	bumped := price.MulBigInt(big.NewInt(int64(100 + bumpPercent))).DivCeil(types.NewGasPrice(100))
+	if bumped.LessEqual(price) {
+		return price.Add(types.NewGasPrice(1))
+	}
+	return bumped
 }
@@ -11,6 +11,7 @@ import (
 
 	"github.com/filecoin-project/go-filecoin/address"
 	"github.com/filecoin-project/go-filecoin/config"
+	"github.com/filecoin-project/go-filecoin/repo"
 	th "github.com/filecoin-project/go-filecoin/testhelpers"
 	tf "github.com/filecoin-project/go-filecoin/testhelpers/testflags"
 	"github.com/filecoin-project/go-filecoin/types"
@@ -63,8 +64,8 @@ func TestMessagePoolValidate(t *testing.T) {
 	tf.UnitTest(t)
 
 	t.Run("message pool rejects messages after it reaches its limit", func(t *testing.T) {
-		// pull the default size from the default config value
 		mpoolCfg := config.NewDefaultConfig().Mpool
+		mpoolCfg.MaxPoolSize = 10
 		maxMessagePoolSize := mpoolCfg.MaxPoolSize
 		ctx := context.Background()
 		pool := core.NewMessagePool(mpoolCfg, th.NewMockMessagePoolValidator())
@@ -85,6 +86,44 @@ func TestMessagePoolValidate(t *testing.T) {
 		assert.Len(t, pool.Pending(), int(maxMessagePoolSize))
 	})
 
+	t.Run("rejects messages from a sender with too many pending messages", func(t *testing.T) {
+		mpoolCfg := config.NewDefaultConfig().Mpool
+		mpoolCfg.MaxPendingPerSender = 3
+		ctx := context.Background()
+		pool := core.NewMessagePool(mpoolCfg, th.NewMockMessagePoolValidator())
+
+		for nonce := 0; nonce < 3; nonce++ {
+			_, err := pool.Add(ctx, newPricedMessage(mockSigner.Addresses[0], uint64(nonce), 1), 0)
+			require.NoError(t, err)
+		}
+
+		_, err := pool.Add(ctx, newPricedMessage(mockSigner.Addresses[0], 3, 1), 0)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "too many messages")
+
+		// other senders are unaffected
+		_, err = pool.Add(ctx, newPricedMessage(mockSigner.Addresses[1], 0, 1), 0)
+		require.NoError(t, err)
+		assert.Len(t, pool.Pending(), 4)
+	})
+
+	t.Run("rejects messages from a sender with too many pending bytes", func(t *testing.T) {
+		mpoolCfg := config.NewDefaultConfig().Mpool
+		ctx := context.Background()
+
+		smsg := newPricedMessage(mockSigner.Addresses[0], 0, 1)
+		bytes, err := smsg.Marshal()
+		require.NoError(t, err)
+		mpoolCfg.MaxBytesPerSender = uint(len(bytes)*2 + 1)
+		pool := core.NewMessagePool(mpoolCfg, th.NewMockMessagePoolValidator())
+
+		core.MustAdd(pool, 0, smsg, newPricedMessage(mockSigner.Addresses[0], 1, 1))
+
+		_, err = pool.Add(ctx, newPricedMessage(mockSigner.Addresses[0], 2, 1), 0)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "too many bytes")
+	})
+
 	t.Run("validates no two messages are added with same nonce", func(t *testing.T) {
 		ctx := context.Background()
 		pool := core.NewMessagePool(config.NewDefaultConfig().Mpool, th.NewMockMessagePoolValidator())
@@ -99,6 +138,30 @@ func TestMessagePoolValidate(t *testing.T) {
 		assert.Contains(t, err.Error(), "message with same actor and nonce")
 	})
 
+	t.Run("replaces a message with the same nonce and a sufficiently higher gas price", func(t *testing.T) {
+		ctx := context.Background()
+		mpoolCfg := config.NewDefaultConfig().Mpool
+		mpoolCfg.ReplacePriceBumpPercent = 10
+		pool := core.NewMessagePool(mpoolCfg, th.NewMockMessagePoolValidator())
+
+		original := newPricedMessage(mockSigner.Addresses[0], 0, 100)
+		originalCid, err := pool.Add(ctx, original, 0)
+		require.NoError(t, err)
+
+		_, err = pool.Add(ctx, newPricedMessage(mockSigner.Addresses[0], 0, 109), 0)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "message with same actor and nonce")
+
+		replacementCid, err := pool.Add(ctx, newPricedMessage(mockSigner.Addresses[0], 0, 110), 0)
+		require.NoError(t, err)
+		assert.Len(t, pool.Pending(), 1)
+
+		_, found := pool.Get(originalCid)
+		assert.False(t, found)
+		_, found = pool.Get(replacementCid)
+		assert.True(t, found)
+	})
+
 	t.Run("validates using supplied validator", func(t *testing.T) {
 		ctx := context.Background()
 		validator := th.NewMockMessagePoolValidator()
@@ -158,6 +221,120 @@ func TestMessagePoolAsync(t *testing.T) {
 	assert.Len(t, pool.Pending(), int(count))
 }
 
+func TestMessagePoolEviction(t *testing.T) {
+	tf.UnitTest(t)
+
+	newFullPool := func(t *testing.T) *core.MessagePool {
+		mpoolCfg := config.NewDefaultConfig().Mpool
+		mpoolCfg.MaxPoolSize = 4
+		pool := core.NewMessagePool(mpoolCfg, th.NewMockMessagePoolValidator())
+
+		core.MustAdd(pool, 0,
+			newPricedMessage(mockSigner.Addresses[0], 0, 5),
+			newPricedMessage(mockSigner.Addresses[0], 1, 5),
+			newPricedMessage(mockSigner.Addresses[1], 0, 2),
+			newPricedMessage(mockSigner.Addresses[1], 1, 3),
+		)
+		return pool
+	}
+
+	t.Run("evicts the highest nonce of the cheapest sender", func(t *testing.T) {
+		ctx := context.Background()
+		pool := newFullPool(t)
+
+		_, err := pool.Add(ctx, newPricedMessage(mockSigner.Addresses[2], 0, 4), 0)
+		require.NoError(t, err)
+		assert.Len(t, pool.Pending(), 4)
+
+		// sender 1's nonce 1 is evicted even though its nonce 0 is cheaper
+		largest, found := pool.LargestNonce(mockSigner.Addresses[1])
+		assert.True(t, found)
+		assert.Equal(t, uint64(0), largest)
+		_, found = pool.LargestNonce(mockSigner.Addresses[2])
+		assert.True(t, found)
+	})
+
+	t.Run("rejects messages that do not outbid the eviction candidate", func(t *testing.T) {
+		ctx := context.Background()
+		pool := newFullPool(t)
+
+		_, err := pool.Add(ctx, newPricedMessage(mockSigner.Addresses[2], 0, 3), 0)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "message pool is full")
+
+		largest, found := pool.LargestNonce(mockSigner.Addresses[1])
+		assert.True(t, found)
+		assert.Equal(t, uint64(1), largest)
+	})
+
+	t.Run("does not evict a sender's messages for a higher nonce from the same sender", func(t *testing.T) {
+		ctx := context.Background()
+		pool := newFullPool(t)
+
+		_, err := pool.Add(ctx, newPricedMessage(mockSigner.Addresses[1], 2, 10), 0)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "message pool is full")
+	})
+}
+
+func TestMessagePoolRestore(t *testing.T) {
+	tf.UnitTest(t)
+
+	t.Run("restores messages recorded in the datastore", func(t *testing.T) {
+		ctx := context.Background()
+		ds := repo.NewInMemoryRepo().Datastore()
+
+		pool := core.NewPersistentMessagePool(config.NewDefaultConfig().Mpool, th.NewMockMessagePoolValidator(), ds)
+		msg1 := newPricedMessage(mockSigner.Addresses[0], 0, 1)
+		msg2 := newPricedMessage(mockSigner.Addresses[1], 0, 1)
+		msg3 := newPricedMessage(mockSigner.Addresses[2], 0, 1)
+		core.MustAdd(pool, 5, msg1, msg2)
+		c3, err := pool.Add(ctx, msg3, 6)
+		require.NoError(t, err)
+		pool.Remove(c3)
+
+		restored := core.NewPersistentMessagePool(config.NewDefaultConfig().Mpool, th.NewMockMessagePoolValidator(), ds)
+		assert.Len(t, restored.Pending(), 0)
+		require.NoError(t, restored.Restore(ctx))
+		assert.Len(t, restored.Pending(), 2)
+		assert.Len(t, restored.PendingBefore(6), 2)
+
+		c1, err := msg1.Cid()
+		require.NoError(t, err)
+		m, ok := restored.Get(c1)
+		assert.True(t, ok)
+		assert.Equal(t, msg1, m)
+	})
+
+	t.Run("discards messages that no longer validate", func(t *testing.T) {
+		ctx := context.Background()
+		ds := repo.NewInMemoryRepo().Datastore()
+
+		pool := core.NewPersistentMessagePool(config.NewDefaultConfig().Mpool, th.NewMockMessagePoolValidator(), ds)
+		core.MustAdd(pool, 0, newPricedMessage(mockSigner.Addresses[0], 0, 1))
+
+		validator := th.NewMockMessagePoolValidator()
+		validator.Valid = false
+		restored := core.NewPersistentMessagePool(config.NewDefaultConfig().Mpool, validator, ds)
+		require.NoError(t, restored.Restore(ctx))
+		assert.Len(t, restored.Pending(), 0)
+
+		// the invalid message is no longer recorded
+		again := core.NewPersistentMessagePool(config.NewDefaultConfig().Mpool, th.NewMockMessagePoolValidator(), ds)
+		require.NoError(t, again.Restore(ctx))
+		assert.Len(t, again.Pending(), 0)
+	})
+}
+
+func TestMinimumReplacementGasPrice(t *testing.T) {
+	tf.UnitTest(t)
+
+	assert.Equal(t, types.NewGasPrice(110), core.MinimumReplacementGasPrice(types.NewGasPrice(100), 10))
+	assert.Equal(t, types.NewGasPrice(12), core.MinimumReplacementGasPrice(types.NewGasPrice(11), 10))
+	assert.Equal(t, types.NewGasPrice(1), core.MinimumReplacementGasPrice(types.NewGasPrice(0), 10))
+	assert.Equal(t, types.NewGasPrice(101), core.MinimumReplacementGasPrice(types.NewGasPrice(100), 0))
+}
+
 func TestLargestNonce(t *testing.T) {
 	tf.UnitTest(t)
 
@@ -206,6 +383,15 @@ func TestLargestNonce(t *testing.T) {
 	})
 }
 
+func newPricedMessage(from address.Address, nonce uint64, gasPrice int64) *types.SignedMessage {
+	msg := types.NewMessage(from, address.NewForTestGetter()(), nonce, types.ZeroAttoFIL, "", nil)
+	smsg, err := types.NewSignedMessage(*msg, mockSigner, types.NewGasPrice(gasPrice), types.NewGasUnits(0))
+	if err != nil {
+		panic(err)
+	}
+	return smsg
+}
+
 func mustSetNonce(signer types.Signer, message *types.SignedMessage, nonce types.Uint64) *types.SignedMessage {
 	return mustResignMessage(signer, message, func(m *types.Message) {
 		m.Nonce = nonce
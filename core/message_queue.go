@@ -2,12 +2,15 @@ package core
 
 import (
 	"context"
+	"sort"
 	"sync"
 
+	"github.com/ipfs/go-datastore"
 	"github.com/pkg/errors"
 
 	"github.com/filecoin-project/go-filecoin/address"
 	"github.com/filecoin-project/go-filecoin/metrics"
+	"github.com/filecoin-project/go-filecoin/repo"
 	"github.com/filecoin-project/go-filecoin/types"
 )
 
@@ -23,11 +26,15 @@ var (
 // not enforced.
 // A message queue is intended to record outbound messages that have been transmitted but not yet appeared in a block,
 // where the stamp could be block height.
+// A queue constructed with NewPersistentMessageQueue writes every change through to a datastore so
+// that its contents survive a restart.
 // MessageQueue is safe for concurrent access.
 type MessageQueue struct {
 	lk sync.RWMutex
 	// Message queues keyed by sending actor address, in nonce order
 	queues map[address.Address][]*QueuedMessage
+	// Datastore recording queued messages, or nil if the queue is not persisted
+	ds repo.Datastore
 }
 
 // QueuedMessage is a message an the stamp it was enqueued with.
@@ -43,6 +50,38 @@ func NewMessageQueue() *MessageQueue {
 	}
 }
 
+// NewPersistentMessageQueue constructs a queue that records its messages in a datastore, initially
+// holding any messages already recorded there. If the recorded messages for an address do not
+// form a contiguous nonce sequence, those following the first gap are discarded.
+func NewPersistentMessageQueue(ds repo.Datastore) (*MessageQueue, error) {
+	mq := &MessageQueue{
+		queues: make(map[address.Address][]*QueuedMessage),
+		ds:     ds,
+	}
+
+	err := queryObjects(ds, outboxDatastorePrefix, func() interface{} { return &QueuedMessage{} }, func(key datastore.Key, obj interface{}) {
+		qm := obj.(*QueuedMessage)
+		mq.queues[qm.Msg.From] = append(mq.queues[qm.Msg.From], qm)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for sender, q := range mq.queues {
+		sort.Slice(q, func(i, j int) bool { return q[i].Msg.Nonce < q[j].Msg.Nonce })
+		for i := 1; i < len(q); i++ {
+			if q[i].Msg.Nonce != q[i-1].Msg.Nonce+1 {
+				log.Warningf("Discarding %d stored outbound messages from %s after nonce gap at %d", len(q)-i, sender, q[i-1].Msg.Nonce)
+				mq.deleteStored(q[i:])
+				q = q[:i]
+				break
+			}
+		}
+		mq.queues[sender] = q
+	}
+	return mq, nil
+}
+
 // Enqueue appends a new message for an address. If the queue already contains any messages for
 // from same address, the new message's nonce must be exactly one greater than the largest nonce
 // present.
@@ -62,10 +101,43 @@ func (mq *MessageQueue) Enqueue(ctx context.Context, msg *types.SignedMessage, s
 			return errors.Errorf("Invalid nonce %d, expected %d", msg.Nonce, nextNonce)
 		}
 	}
-	mq.queues[msg.From] = append(q, &QueuedMessage{msg, stamp})
+	qm := &QueuedMessage{msg, stamp}
+	if mq.ds != nil {
+		if err := putObject(mq.ds, outboxKey(msg.From, uint64(msg.Nonce)), qm); err != nil {
+			return err
+		}
+	}
+	mq.queues[msg.From] = append(q, qm)
 	return nil
 }
 
+// Replace swaps a queued message for another with the same sender and nonce (e.g. re-signed with a
+// higher gas price), re-stamping it with `stamp`. Returns the message that was replaced.
+// Returns an error if no queued message has the same sender and nonce.
+func (mq *MessageQueue) Replace(ctx context.Context, msg *types.SignedMessage, stamp uint64) (*types.SignedMessage, error) {
+	defer func() {
+		mqOldestGa.Set(ctx, int64(mq.Oldest()))
+	}()
+
+	mq.lk.Lock()
+	defer mq.lk.Unlock()
+
+	for _, qm := range mq.queues[msg.From] {
+		if qm.Msg.Nonce == msg.Nonce {
+			if mq.ds != nil {
+				if err := putObject(mq.ds, outboxKey(msg.From, uint64(msg.Nonce)), &QueuedMessage{msg, stamp}); err != nil {
+					return nil, err
+				}
+			}
+			replaced := qm.Msg
+			qm.Msg = msg
+			qm.Stamp = stamp
+			return replaced, nil
+		}
+	}
+	return nil, errors.Errorf("No queued message from %s with nonce %d", msg.From, msg.Nonce)
+}
+
 // RemoveNext removes and returns a single message from the queue, if it bears the expected nonce value, with found = true.
 // Returns found = false if the queue is empty or the expected nonce is less than any in the queue for that address
 // (indicating the message had already been removed).
@@ -84,6 +156,7 @@ func (mq *MessageQueue) RemoveNext(ctx context.Context, sender address.Address,
 	if len(q) > 0 {
 		head := q[0]
 		if expectedNonce == uint64(head.Msg.Nonce) {
+			mq.deleteStored(q[:1])
 			mq.queues[sender] = q[1:] // pop the head
 			msg = head.Msg
 			found = true
@@ -95,6 +168,31 @@ func (mq *MessageQueue) RemoveNext(ctx context.Context, sender address.Address,
 	return
 }
 
+// RemoveLast removes and returns the last message queued for an address, if it bears the expected nonce
+// value, with found = true. Returns found = false if the queue is empty.
+// Returns an error if the last message in the queue has a different nonce.
+func (mq *MessageQueue) RemoveLast(ctx context.Context, sender address.Address, expectedNonce uint64) (msg *types.SignedMessage, found bool, err error) {
+	defer func() {
+		mqSizeGa.Set(ctx, mq.Size())
+		mqOldestGa.Set(ctx, int64(mq.Oldest()))
+	}()
+
+	mq.lk.Lock()
+	defer mq.lk.Unlock()
+
+	q := mq.queues[sender]
+	if len(q) == 0 {
+		return nil, false, nil
+	}
+	last := q[len(q)-1]
+	if uint64(last.Msg.Nonce) != expectedNonce {
+		return nil, false, errors.Errorf("Last message for %s has nonce %d, expected %d", sender, last.Msg.Nonce, expectedNonce)
+	}
+	mq.deleteStored(q[len(q)-1:])
+	mq.queues[sender] = q[:len(q)-1]
+	return last.Msg, true, nil
+}
+
 // Clear removes all messages for a single sender address.
 // Returns whether the queue was non-empty before being cleared.
 func (mq *MessageQueue) Clear(ctx context.Context, sender address.Address) bool {
@@ -107,6 +205,7 @@ func (mq *MessageQueue) Clear(ctx context.Context, sender address.Address) bool
 	defer mq.lk.Unlock()
 
 	q := mq.queues[sender]
+	mq.deleteStored(q)
 	delete(mq.queues, sender)
 	return len(q) > 0
 }
@@ -132,6 +231,7 @@ func (mq *MessageQueue) ExpireBefore(ctx context.Context, stamp uint64) map[addr
 			for _, m := range q {
 				expired[sender] = append(expired[sender], m.Msg)
 			}
+			mq.deleteStored(q)
 
 			mq.queues[sender] = []*QueuedMessage{}
 		}
@@ -213,3 +313,18 @@ func (mq *MessageQueue) List(sender address.Address) []*QueuedMessage {
 	}
 	return out
 }
+
+// deleteStored removes queued messages from the datastore, if the queue is persisted.
+// Failures are logged rather than returned; a message left behind is discarded or
+// re-validated when the queue is next loaded.
+// The caller must hold the queue lock.
+func (mq *MessageQueue) deleteStored(qms []*QueuedMessage) {
+	if mq.ds == nil {
+		return
+	}
+	for _, qm := range qms {
+		if err := mq.ds.Delete(outboxKey(qm.Msg.From, uint64(qm.Msg.Nonce))); err != nil {
+			log.Errorf("failed to delete stored outbound message %s/%d: %s", qm.Msg.From, qm.Msg.Nonce, err)
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/filecoin-project/go-filecoin/address"
 	"github.com/filecoin-project/go-filecoin/core"
+	"github.com/filecoin-project/go-filecoin/repo"
 	tf "github.com/filecoin-project/go-filecoin/testhelpers/testflags"
 	"github.com/filecoin-project/go-filecoin/types"
 )
@@ -288,6 +289,69 @@ func TestMessageQueue(t *testing.T) {
 		assertNoNonce(q, bob)
 	})
 
+	t.Run("replace", func(t *testing.T) {
+		msgs := []*types.SignedMessage{
+			mm.NewSignedMessage(alice, 0),
+			mm.NewSignedMessage(alice, 1),
+		}
+		q := core.NewMessageQueue()
+		requireEnqueue(q, msgs[0], 100)
+		requireEnqueue(q, msgs[1], 101)
+
+		replacement := mm.NewSignedMessage(alice, 0)
+		replaced, err := q.Replace(ctx, replacement, 105)
+		require.NoError(t, err)
+		assert.Equal(t, msgs[0], replaced)
+
+		assert.Equal(t, &core.QueuedMessage{Msg: replacement, Stamp: 105}, q.List(alice)[0])
+		assert.Equal(t, &core.QueuedMessage{Msg: msgs[1], Stamp: 101}, q.List(alice)[1])
+		assertLargestNonce(q, alice, 1)
+
+		// no message with this nonce
+		_, err = q.Replace(ctx, mm.NewSignedMessage(alice, 2), 105)
+		assert.Error(t, err)
+		_, err = q.Replace(ctx, mm.NewSignedMessage(bob, 0), 105)
+		assert.Error(t, err)
+	})
+
+	t.Run("persistent queue reloads from datastore", func(t *testing.T) {
+		ds := repo.NewInMemoryRepo().Datastore()
+		fromAlice := []*types.SignedMessage{
+			mm.NewSignedMessage(alice, 0),
+			mm.NewSignedMessage(alice, 1),
+			mm.NewSignedMessage(alice, 2),
+		}
+		fromBob := []*types.SignedMessage{
+			mm.NewSignedMessage(bob, 10),
+			mm.NewSignedMessage(bob, 11),
+		}
+
+		q, err := core.NewPersistentMessageQueue(ds)
+		require.NoError(t, err)
+		requireEnqueue(q, fromAlice[0], 100)
+		requireEnqueue(q, fromAlice[1], 101)
+		requireEnqueue(q, fromAlice[2], 102)
+		requireEnqueue(q, fromBob[0], 200)
+		requireEnqueue(q, fromBob[1], 201)
+
+		requireRemoveNext(q, alice, 0)
+		replacement := mm.NewSignedMessage(alice, 1)
+		_, err = q.Replace(ctx, replacement, 103)
+		require.NoError(t, err)
+		q.Clear(ctx, bob)
+
+		reloaded, err := core.NewPersistentMessageQueue(ds)
+		require.NoError(t, err)
+		assert.Equal(t, q.List(alice), reloaded.List(alice))
+		assert.Equal(t, &core.QueuedMessage{Msg: replacement, Stamp: 103}, reloaded.List(alice)[0])
+		assert.Equal(t, &core.QueuedMessage{Msg: fromAlice[2], Stamp: 102}, reloaded.List(alice)[1])
+		assertNoNonce(reloaded, bob)
+
+		// messages enqueued after reloading follow on from the reloaded ones
+		requireEnqueue(reloaded, mm.NewSignedMessage(alice, 3), 104)
+		assertLargestNonce(reloaded, alice, 3)
+	})
+
 	t.Run("oldest is correct", func(t *testing.T) {
 		fromAlice := []*types.SignedMessage{
 			mm.NewSignedMessage(alice, 0),
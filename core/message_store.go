@@ -0,0 +1,78 @@
+package core
+
+import (
+	"strconv"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/pkg/errors"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/repo"
+	"github.com/filecoin-project/go-filecoin/types"
+)
+
+// Datastore namespaces under which the outbound message queue and the message pool are persisted.
+const (
+	outboxDatastorePrefix = "outbox"
+	mpoolDatastorePrefix  = "mpool"
+)
+
+func init() {
+	cbor.RegisterCborType(QueuedMessage{})
+	cbor.RegisterCborType(pooledMessage{})
+}
+
+// pooledMessage is the persisted form of a message pool entry.
+type pooledMessage struct {
+	Msg     *types.SignedMessage
+	AddedAt uint64
+}
+
+// outboxKey returns the datastore key for a queued message from sender with the given nonce.
+func outboxKey(sender address.Address, nonce uint64) datastore.Key {
+	return datastore.KeyWithNamespaces([]string{outboxDatastorePrefix, sender.String(), strconv.FormatUint(nonce, 10)})
+}
+
+// mpoolKey returns the datastore key for a pooled message.
+func mpoolKey(msg *types.SignedMessage) (datastore.Key, error) {
+	c, err := msg.Cid()
+	if err != nil {
+		return datastore.Key{}, err
+	}
+	return datastore.KeyWithNamespaces([]string{mpoolDatastorePrefix, c.String()}), nil
+}
+
+// putObject cbor encodes obj and stores it under key.
+func putObject(ds repo.Datastore, key datastore.Key, obj interface{}) error {
+	datum, err := cbor.DumpObject(obj)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal message")
+	}
+	if err := ds.Put(key, datum); err != nil {
+		return errors.Wrapf(err, "failed to store message at %s", key)
+	}
+	return nil
+}
+
+// queryObjects decodes every entry stored under prefix, calling newObj to allocate a value for each.
+func queryObjects(ds repo.Datastore, prefix string, newObj func() interface{}, cb func(key datastore.Key, obj interface{})) error {
+	results, err := ds.Query(query.Query{Prefix: "/" + prefix})
+	if err != nil {
+		return errors.Wrapf(err, "failed to query %s messages from datastore", prefix)
+	}
+	defer results.Close() // nolint: errcheck
+
+	for entry := range results.Next() {
+		if entry.Error != nil {
+			return errors.Wrapf(entry.Error, "failed to read %s message from datastore", prefix)
+		}
+		obj := newObj()
+		if err := cbor.DecodeInto(entry.Value, obj); err != nil {
+			return errors.Wrapf(err, "failed to unmarshal message at %s", entry.Key)
+		}
+		cb(datastore.NewKey(entry.Key), obj)
+	}
+	return nil
+}
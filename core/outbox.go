@@ -7,6 +7,7 @@ import (
 	"github.com/filecoin-project/go-filecoin/abi"
 	"github.com/filecoin-project/go-filecoin/actor"
 	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/config"
 	"github.com/filecoin-project/go-filecoin/consensus"
 	"github.com/filecoin-project/go-filecoin/metrics"
 	"github.com/filecoin-project/go-filecoin/types"
@@ -33,6 +34,9 @@ type Outbox struct {
 	chains outboxChainProvider
 	actors actorProvider
 
+	// Limits of the message pool the publisher adds messages to.
+	poolCfg *config.MessagePoolConfig
+
 	// Protects the "next nonce" calculation to avoid collisions.
 	nonceLock sync.Mutex
 }
@@ -51,11 +55,37 @@ type publisher interface {
 	Publish(ctx context.Context, message *types.SignedMessage, height uint64) error
 }
 
+// NotPooledError is returned by a publisher when the message pool rejects a message, in which
+// case the message was not broadcast either.
+type NotPooledError struct {
+	Err error
+}
+
+func (e *NotPooledError) Error() string {
+	return e.Err.Error()
+}
+
+// BatchMessage describes one message of a batch sent with Outbox.SendBatch.
+type BatchMessage struct {
+	To       address.Address
+	Value    types.AttoFIL
+	GasLimit types.GasUnits
+	Method   string
+	Params   []interface{}
+}
+
+// BatchResult is the outcome of sending one message of a batch. Err is nil if the message was
+// queued and published. Cid identifies any message that was queued, even if publishing it failed.
+type BatchResult struct {
+	Cid cid.Cid
+	Err error
+}
+
 var msgSendErrCt = metrics.NewInt64Counter("message_sender_error", "Number of errors encountered while sending a message")
 
 // NewOutbox creates a new outbox
 func NewOutbox(signer types.Signer, validator consensus.SignedMessageValidator, queue *MessageQueue,
-	publisher publisher, policy QueuePolicy, chains outboxChainProvider, actors actorProvider) *Outbox {
+	publisher publisher, policy QueuePolicy, chains outboxChainProvider, actors actorProvider, poolCfg *config.MessagePoolConfig) *Outbox {
 	return &Outbox{
 		signer:    signer,
 		validator: validator,
@@ -64,6 +94,7 @@ func NewOutbox(signer types.Signer, validator consensus.SignedMessageValidator,
 		policy:    policy,
 		chains:    chains,
 		actors:    actors,
+		poolCfg:   poolCfg,
 	}
 }
 
@@ -131,9 +162,419 @@ func (ob *Outbox) Send(ctx context.Context, from, to address.Address, value type
 	return signed.Cid()
 }
 
-// HandleNewHead maintains the message queue in response to a new head tipset.
+// SendBatch marshals and sends a batch of messages from one address, reserving a contiguous range
+// of nonces for them so that concurrent sends from the same address cannot interleave. It returns
+// a result for each message, in the same order. A message that cannot be signed, is invalid or is
+// rejected by the message pool is skipped and its nonce given to the next message, so it doesn't
+// prevent the rest of the batch from being mined.
+// An error is returned, and no messages sent, if the batch as a whole cannot be sent, including
+// when the message pool would not accept that many messages from the address (see batchCapacity).
+func (ob *Outbox) SendBatch(ctx context.Context, from address.Address, gasPrice types.AttoFIL, msgs []BatchMessage) (results []BatchResult, err error) {
+	defer func() {
+		if err != nil {
+			msgSendErrCt.Inc(ctx, int64(len(msgs)))
+		}
+	}()
+
+	// Lock to reserve the nonces for the whole batch.
+	ob.nonceLock.Lock()
+	defer ob.nonceLock.Unlock()
+
+	head := ob.chains.GetHead()
+
+	fromActor, err := ob.actors.GetActorAt(ctx, head, from)
+	if err != nil {
+		return nil, errors.Wrapf(err, "no actor at address %s", from)
+	}
+
+	nonce, err := nextNonce(fromActor, ob.queue, from)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed calculating nonce for actor at %s", from)
+	}
+
+	capacity, pendingBytes, err := ob.batchCapacity(fromActor, from, nonce)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(msgs)) > capacity {
+		return nil, errors.Errorf("batch of %d messages exceeds the %d more the message pool will accept from %s", len(msgs), capacity, from)
+	}
+
+	height, err := tipsetHeight(ob.chains, head)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get block height")
+	}
+
+	results = make([]BatchResult, len(msgs))
+	var queueErr error
+	for i, m := range msgs {
+		if queueErr != nil {
+			// Later messages can't be queued without an earlier one's nonce.
+			results[i].Err = queueErr
+			msgSendErrCt.Inc(ctx, 1)
+			continue
+		}
+
+		var size uint
+		var queueFailed bool
+		results[i].Cid, size, queueFailed, results[i].Err = ob.sendBatchMessage(ctx, from, nonce, gasPrice, m, fromActor, height, pendingBytes)
+		if queueFailed {
+			queueErr = results[i].Err
+		}
+		if results[i].Err != nil {
+			msgSendErrCt.Inc(ctx, 1)
+		}
+		if results[i].Cid.Defined() {
+			// The message holds its nonce, even if it failed to be broadcast.
+			pendingBytes += size
+			nonce++
+		}
+	}
+	return results, nil
+}
+
+// sendBatchMessage signs, queues and publishes one message of a batch with the given nonce.
+// It returns the message's CID and size if it was sent. The message is removed from the queue
+// again if the message pool rejects it, so that its nonce can be used by the next message.
+// queueFailed is true if the outbound queue itself failed, in which case no later message
+// may be sent.
+func (ob *Outbox) sendBatchMessage(ctx context.Context, from address.Address, nonce uint64, gasPrice types.AttoFIL, m BatchMessage,
+	fromActor *actor.Actor, height uint64, pendingBytes uint) (c cid.Cid, size uint, queueFailed bool, err error) {
+	signed, err := ob.signBatchMessage(ctx, from, nonce, gasPrice, m, fromActor)
+	if err != nil {
+		return cid.Undef, 0, false, err
+	}
+
+	encoded, err := signed.Marshal()
+	if err != nil {
+		return cid.Undef, 0, false, errors.Wrap(err, "failed to marshal message")
+	}
+	size = uint(len(encoded))
+	if pendingBytes+size > ob.poolCfg.MaxBytesPerSender {
+		return cid.Undef, 0, false, errors.Errorf("message pool would hold too many bytes from %s (%d bytes)", from, ob.poolCfg.MaxBytesPerSender)
+	}
+
+	c, err = signed.Cid()
+	if err != nil {
+		return cid.Undef, 0, false, err
+	}
+
+	// Add to the local message queue/pool at the last possible moment before broadcasting to network.
+	if err := ob.queue.Enqueue(ctx, signed, height); err != nil {
+		return cid.Undef, 0, true, errors.Wrap(err, "failed to add message to outbound queue")
+	}
+
+	if err := ob.publisher.Publish(ctx, signed, height); err != nil {
+		if _, notPooled := errors.Cause(err).(*NotPooledError); !notPooled {
+			// The message is pooled and keeps its nonce, even though it wasn't broadcast.
+			return c, size, false, err
+		}
+		if _, _, err := ob.queue.RemoveLast(ctx, from, nonce); err != nil {
+			return cid.Undef, 0, true, errors.Wrap(err, "failed to remove rejected message from outbound queue")
+		}
+		return cid.Undef, 0, false, err
+	}
+	return c, size, false, nil
+}
+
+// batchCapacity returns how many more messages from an address the message pool will accept,
+// given the nonce the next one will carry, and the total size of those the pool already holds.
+// The pool rejects a message whose nonce is more than MaxNonceGap past the actor's, and limits
+// the number and size of pending messages from each sender. The pending messages from this
+// node's own addresses are those in the outbound queue, from the actor's nonce up.
+func (ob *Outbox) batchCapacity(fromActor *actor.Actor, from address.Address, nonce uint64) (uint64, uint, error) {
+	actorNonce, err := actor.NextNonce(fromActor)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "failed calculating nonce for actor at %s", from)
+	}
+
+	var pending uint64
+	var pendingBytes uint
+	for _, qm := range ob.queue.List(from) {
+		if uint64(qm.Msg.Nonce) < actorNonce {
+			continue
+		}
+		encoded, err := qm.Msg.Marshal()
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "failed to marshal queued message")
+		}
+		pending++
+		pendingBytes += uint(len(encoded))
+	}
+
+	maxNonce := actorNonce + uint64(ob.poolCfg.MaxNonceGap)
+	if nonce > maxNonce || pending >= uint64(ob.poolCfg.MaxPendingPerSender) {
+		return 0, pendingBytes, nil
+	}
+	capacity := maxNonce - nonce + 1
+	if room := uint64(ob.poolCfg.MaxPendingPerSender) - pending; room < capacity {
+		capacity = room
+	}
+	return capacity, pendingBytes, nil
+}
+
+func (ob *Outbox) signBatchMessage(ctx context.Context, from address.Address, nonce uint64, gasPrice types.AttoFIL, m BatchMessage, fromActor *actor.Actor) (*types.SignedMessage, error) {
+	encodedParams, err := abi.ToEncodedValues(m.Params...)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid params")
+	}
+
+	rawMsg := types.NewMessage(from, m.To, nonce, m.Value, m.Method, encodedParams)
+	signed, err := types.NewSignedMessage(*rawMsg, ob.signer, gasPrice, m.GasLimit)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to sign message")
+	}
+
+	if err := ob.validator.Validate(ctx, signed, fromActor); err != nil {
+		return nil, errors.Wrap(err, "invalid message")
+	}
+	return signed, nil
+}
+
+// SendSigned sends a message that was signed elsewhere, e.g. by a wallet kept outside the node,
+// retaining it in the outbound message queue. The message's nonce must follow on from those of
+// the sender's mined and queued messages.
+func (ob *Outbox) SendSigned(ctx context.Context, signed *types.SignedMessage) (out cid.Cid, err error) {
+	defer func() {
+		if err != nil {
+			msgSendErrCt.Inc(ctx, 1)
+		}
+	}()
+
+	// Lock to avoid racing with a concurrent send from the same actor.
+	ob.nonceLock.Lock()
+	defer ob.nonceLock.Unlock()
+
+	head := ob.chains.GetHead()
+
+	fromActor, err := ob.actors.GetActorAt(ctx, head, signed.From)
+	if err != nil {
+		return cid.Undef, errors.Wrapf(err, "no actor at address %s", signed.From)
+	}
+
+	nonce, err := nextNonce(fromActor, ob.queue, signed.From)
+	if err != nil {
+		return cid.Undef, errors.Wrapf(err, "failed calculating nonce for actor at %s", signed.From)
+	}
+	if uint64(signed.Nonce) != nonce {
+		return cid.Undef, errors.Errorf("invalid nonce %d, expected %d", signed.Nonce, nonce)
+	}
+
+	err = ob.validator.Validate(ctx, signed, fromActor)
+	if err != nil {
+		return cid.Undef, errors.Wrap(err, "invalid message")
+	}
+
+	height, err := tipsetHeight(ob.chains, head)
+	if err != nil {
+		return cid.Undef, errors.Wrap(err, "failed to get block height")
+	}
+
+	if err := ob.queue.Enqueue(ctx, signed, height); err != nil {
+		return cid.Undef, errors.Wrap(err, "failed to add message to outbound queue")
+	}
+
+	err = ob.publisher.Publish(ctx, signed, height)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	return signed.Cid()
+}
+
+// NextNonce returns the nonce the next message sent from an address should carry, accounting
+// for both the actor's mined messages and those waiting in the outbound queue.
+func (ob *Outbox) NextNonce(ctx context.Context, from address.Address) (uint64, error) {
+	ob.nonceLock.Lock()
+	defer ob.nonceLock.Unlock()
+
+	fromActor, err := ob.actors.GetActorAt(ctx, ob.chains.GetHead(), from)
+	if err != nil {
+		return 0, errors.Wrapf(err, "no actor at address %s", from)
+	}
+	return nextNonce(fromActor, ob.queue, from)
+}
+
+// Replace re-signs a queued message with a new gas price and limit, publishes it and, once the
+// message pool accepts it, swaps it into the outbound message queue in place of the original. If the
+// pool rejects the replacement, e.g. because its price isn't bumped enough, the original stays queued.
+// The replacement keeps the original's nonce, so whichever of the two is mined first supersedes the other.
+func (ob *Outbox) Replace(ctx context.Context, msgCid cid.Cid, gasPrice types.AttoFIL, gasLimit types.GasUnits) (out cid.Cid, err error) {
+	defer func() {
+		if err != nil {
+			msgSendErrCt.Inc(ctx, 1)
+		}
+	}()
+
+	// Lock to avoid racing with a concurrent send from the same actor.
+	ob.nonceLock.Lock()
+	defer ob.nonceLock.Unlock()
+
+	original, err := ob.findQueued(msgCid)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	head := ob.chains.GetHead()
+
+	fromActor, err := ob.actors.GetActorAt(ctx, head, original.From)
+	if err != nil {
+		return cid.Undef, errors.Wrapf(err, "no actor at address %s", original.From)
+	}
+
+	signed, err := types.NewSignedMessage(original.Message, ob.signer, gasPrice, gasLimit)
+	if err != nil {
+		return cid.Undef, errors.Wrap(err, "failed to sign message")
+	}
+
+	err = ob.validator.Validate(ctx, signed, fromActor)
+	if err != nil {
+		return cid.Undef, errors.Wrap(err, "invalid message")
+	}
+
+	height, err := tipsetHeight(ob.chains, head)
+	if err != nil {
+		return cid.Undef, errors.Wrap(err, "failed to get block height")
+	}
+
+	pubErr := ob.publisher.Publish(ctx, signed, height)
+	if _, notPooled := errors.Cause(pubErr).(*NotPooledError); notPooled {
+		return cid.Undef, pubErr
+	}
+
+	// The pool holds the replacement now, even if it wasn't broadcast.
+	if _, err := ob.queue.Replace(ctx, signed, height); err != nil {
+		return cid.Undef, errors.Wrap(err, "failed to replace message in outbound queue")
+	}
+	if pubErr != nil {
+		return cid.Undef, pubErr
+	}
+
+	return signed.Cid()
+}
+
+// Restore reconciles the outbound message queue, as loaded from storage after a restart, with the
+// current chain head. Queued messages that have already been mined are removed and the rest are
+// re-validated and re-published. If any message from a sender is no longer valid, that sender's
+// whole queue is cleared, since its later messages could never be mined.
+func (ob *Outbox) Restore(ctx context.Context) error {
+	ob.nonceLock.Lock()
+	defer ob.nonceLock.Unlock()
+
+	head := ob.chains.GetHead()
+	height, err := tipsetHeight(ob.chains, head)
+	if err != nil {
+		return errors.Wrap(err, "failed to get block height")
+	}
+
+	for _, sender := range ob.queue.Queues() {
+		fromActor, err := ob.actors.GetActorAt(ctx, head, sender)
+		if err != nil {
+			log.Errorf("Clearing outbound messages from %s, failed to get actor: %s", sender, err)
+			ob.queue.Clear(ctx, sender)
+			continue
+		}
+
+		actorNonce, err := actor.NextNonce(fromActor)
+		if err != nil {
+			log.Errorf("Clearing outbound messages from %s, failed to get nonce: %s", sender, err)
+			ob.queue.Clear(ctx, sender)
+			continue
+		}
+
+		// Remove messages that were mined while the node was down.
+		for _, qm := range ob.queue.List(sender) {
+			if uint64(qm.Msg.Nonce) >= actorNonce {
+				break
+			}
+			if _, _, err := ob.queue.RemoveNext(ctx, sender, uint64(qm.Msg.Nonce)); err != nil {
+				return err
+			}
+		}
+
+		queued := ob.queue.List(sender)
+		valid := true
+		for _, qm := range queued {
+			if err := ob.validator.Validate(ctx, qm.Msg, fromActor); err != nil {
+				log.Errorf("Clearing outbound messages from %s, message with nonce %d is invalid: %s", sender, qm.Msg.Nonce, err)
+				valid = false
+				break
+			}
+		}
+		if !valid {
+			ob.queue.Clear(ctx, sender)
+			continue
+		}
+
+		for _, qm := range queued {
+			if err := ob.publisher.Publish(ctx, qm.Msg, height); err != nil {
+				log.Errorf("Failed to re-publish outbound message from %s with nonce %d: %s", sender, qm.Msg.Nonce, err)
+			}
+		}
+	}
+	return nil
+}
+
+// findQueued returns the queued message with the given CID.
+func (ob *Outbox) findQueued(msgCid cid.Cid) (*types.SignedMessage, error) {
+	for _, addr := range ob.queue.Queues() {
+		for _, qm := range ob.queue.List(addr) {
+			c, err := qm.Msg.Cid()
+			if err != nil {
+				return nil, err
+			}
+			if c.Equals(msgCid) {
+				return qm.Msg, nil
+			}
+		}
+	}
+	return nil, errors.Errorf("message %s not found in outbound queue", msgCid)
+}
+
+// HandleNewHead maintains the message queue in response to a new head tipset, and bumps the gas
+// price of messages stuck in it if the message pool config asks for it.
 func (ob *Outbox) HandleNewHead(ctx context.Context, oldHead, newHead types.TipSet) error {
-	return ob.policy.HandleNewHead(ctx, ob.queue, oldHead, newHead)
+	if err := ob.policy.HandleNewHead(ctx, ob.queue, oldHead, newHead); err != nil {
+		return err
+	}
+
+	height, err := newHead.Height()
+	if err != nil {
+		return err
+	}
+	ob.bumpStuck(ctx, height)
+	return nil
+}
+
+// bumpStuck replaces each message queued for AutoBumpAfterRounds or more with one paying the
+// lowest gas price the message pool accepts as a replacement. Replacing a message re-stamps it,
+// so it is bumped again after as many rounds if it is still not mined, until the price would
+// exceed AutoBumpMaxGasPrice. Messages are then left to expire.
+func (ob *Outbox) bumpStuck(ctx context.Context, height uint64) {
+	after := ob.poolCfg.AutoBumpAfterRounds
+	if after == 0 {
+		return
+	}
+
+	for _, sender := range ob.queue.Queues() {
+		for _, qm := range ob.queue.List(sender) {
+			if qm.Stamp+after > height {
+				continue
+			}
+			gasPrice := MinimumReplacementGasPrice(qm.Msg.GasPrice, ob.poolCfg.ReplacePriceBumpPercent)
+			if gasPrice.GreaterThan(ob.poolCfg.AutoBumpMaxGasPrice) {
+				continue
+			}
+			msgCid, err := qm.Msg.Cid()
+			if err != nil {
+				log.Errorf("Failed to bump gas price of outbound message from %s with nonce %d: %s", sender, qm.Msg.Nonce, err)
+				continue
+			}
+			if _, err := ob.Replace(ctx, msgCid, gasPrice, qm.Msg.GasLimit); err != nil {
+				log.Errorf("Failed to bump gas price of outbound message from %s with nonce %d: %s", sender, qm.Msg.Nonce, err)
+			}
+		}
+	}
 }
 
 // nextNonce returns the next expected nonce value for an account actor. This is the larger
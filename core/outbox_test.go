@@ -10,6 +10,7 @@ import (
 	"github.com/filecoin-project/go-filecoin/actor/builtin/account"
 	"github.com/filecoin-project/go-filecoin/actor/builtin/storagemarket"
 	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/config"
 	"github.com/filecoin-project/go-filecoin/core"
 	tf "github.com/filecoin-project/go-filecoin/testhelpers/testflags"
 	"github.com/filecoin-project/go-filecoin/types"
@@ -28,7 +29,7 @@ func TestOutbox(t *testing.T) {
 		publisher := &mockPublisher{}
 		provider := &fakeProvider{}
 
-		ob := core.NewOutbox(w, nullValidator{rejectMessages: true}, queue, publisher, nullPolicy{}, provider, provider)
+		ob := core.NewOutbox(w, nullValidator{rejectMessages: true}, queue, publisher, nullPolicy{}, provider, provider, config.NewDefaultConfig().Mpool)
 
 		cid, err := ob.Send(context.Background(), sender, sender, types.NewAttoFILFromFIL(2), types.NewGasPrice(0), types.NewGasUnits(0), "")
 		assert.Errorf(t, err, "for testing")
@@ -49,7 +50,7 @@ func TestOutbox(t *testing.T) {
 		actr.Nonce = 42
 		provider.Set(t, blk, sender, actr)
 
-		ob := core.NewOutbox(w, nullValidator{}, queue, publisher, nullPolicy{}, provider, provider)
+		ob := core.NewOutbox(w, nullValidator{}, queue, publisher, nullPolicy{}, provider, provider, config.NewDefaultConfig().Mpool)
 		require.Empty(t, queue.List(sender))
 		require.Nil(t, publisher.message)
 
@@ -79,7 +80,7 @@ func TestOutbox(t *testing.T) {
 		actr.Nonce = 42
 		provider.Set(t, blk, sender, actr)
 
-		s := core.NewOutbox(w, nullValidator{}, queue, publisher, nullPolicy{}, provider, provider)
+		s := core.NewOutbox(w, nullValidator{}, queue, publisher, nullPolicy{}, provider, provider, config.NewDefaultConfig().Mpool)
 
 		var wg sync.WaitGroup
 		addTwentyMessages := func(batch int) {
@@ -115,6 +116,346 @@ func TestOutbox(t *testing.T) {
 		}
 	})
 
+	t.Run("replace re-signs queued message and calls publish", func(t *testing.T) {
+		ctx := context.Background()
+		w, _ := types.NewMockSignersAndKeyInfo(1)
+		sender := w.Addresses[0]
+		toAddr := address.NewForTestGetter()()
+		queue := core.NewMessageQueue()
+		publisher := &mockPublisher{}
+		provider := &fakeProvider{}
+
+		blk := types.NewBlockForTest(nil, 1)
+		blk.Height = 1000
+		actr, _ := account.NewActor(types.ZeroAttoFIL)
+		actr.Nonce = 42
+		provider.Set(t, blk, sender, actr)
+
+		ob := core.NewOutbox(w, nullValidator{}, queue, publisher, nullPolicy{}, provider, provider, config.NewDefaultConfig().Mpool)
+
+		original, err := ob.Send(ctx, sender, toAddr, types.ZeroAttoFIL, types.NewGasPrice(1), types.NewGasUnits(100), "")
+		require.NoError(t, err)
+
+		replacement, err := ob.Replace(ctx, original, types.NewGasPrice(2), types.NewGasUnits(200))
+		require.NoError(t, err)
+		assert.False(t, original.Equals(replacement))
+
+		queued := queue.List(sender)
+		require.Len(t, queued, 1)
+		assert.Equal(t, actr.Nonce, queued[0].Msg.Nonce)
+		assert.Equal(t, types.NewGasPrice(2), queued[0].Msg.GasPrice)
+		assert.Equal(t, types.NewGasUnits(200), queued[0].Msg.GasLimit)
+		queuedCid, err := queued[0].Msg.Cid()
+		require.NoError(t, err)
+		assert.True(t, replacement.Equals(queuedCid))
+		assert.Equal(t, queued[0].Msg, publisher.message)
+
+		// the original is no longer queued
+		_, err = ob.Replace(ctx, original, types.NewGasPrice(3), types.NewGasUnits(200))
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "not found in outbound queue")
+	})
+
+	t.Run("replace keeps original queued if pool rejects replacement", func(t *testing.T) {
+		ctx := context.Background()
+		w, _ := types.NewMockSignersAndKeyInfo(1)
+		sender := w.Addresses[0]
+		toAddr := address.NewForTestGetter()()
+		queue := core.NewMessageQueue()
+		publisher := &mockPublisher{}
+		provider := &fakeProvider{}
+
+		blk := types.NewBlockForTest(nil, 1)
+		blk.Height = 1000
+		actr, _ := account.NewActor(types.ZeroAttoFIL)
+		provider.Set(t, blk, sender, actr)
+
+		ob := core.NewOutbox(w, nullValidator{}, queue, publisher, nullPolicy{}, provider, provider, config.NewDefaultConfig().Mpool)
+
+		original, err := ob.Send(ctx, sender, toAddr, types.ZeroAttoFIL, types.NewGasPrice(10), types.NewGasUnits(100), "")
+		require.NoError(t, err)
+
+		publisher.returnError = &core.NotPooledError{Err: errors.New("rejected for testing")}
+		_, err = ob.Replace(ctx, original, types.NewGasPrice(10), types.NewGasUnits(100))
+		assert.Error(t, err)
+
+		queued := queue.List(sender)
+		require.Len(t, queued, 1)
+		queuedCid, err := queued[0].Msg.Cid()
+		require.NoError(t, err)
+		assert.True(t, original.Equals(queuedCid))
+	})
+
+	t.Run("new head bumps gas price of stuck messages up to the max", func(t *testing.T) {
+		ctx := context.Background()
+		w, _ := types.NewMockSignersAndKeyInfo(1)
+		sender := w.Addresses[0]
+		toAddr := address.NewForTestGetter()()
+		queue := core.NewMessageQueue()
+		publisher := &mockPublisher{}
+		provider := &fakeProvider{}
+
+		blk := types.NewBlockForTest(nil, 1)
+		blk.Height = 1000
+		actr, _ := account.NewActor(types.ZeroAttoFIL)
+		provider.Set(t, blk, sender, actr)
+
+		poolCfg := config.NewDefaultConfig().Mpool
+		poolCfg.AutoBumpAfterRounds = 3
+		poolCfg.AutoBumpMaxGasPrice = types.NewGasPrice(120)
+		ob := core.NewOutbox(w, nullValidator{}, queue, publisher, nullPolicy{}, provider, provider, poolCfg)
+
+		_, err := ob.Send(ctx, sender, toAddr, types.ZeroAttoFIL, types.NewGasPrice(100), types.NewGasUnits(100), "")
+		require.NoError(t, err)
+
+		headAt := func(height uint64) types.TipSet {
+			blk := types.NewBlockForTest(nil, height)
+			blk.Height = types.Uint64(height)
+			provider.Set(t, blk, sender, actr)
+			return provider.tipset
+		}
+
+		// not stuck long enough yet
+		require.NoError(t, ob.HandleNewHead(ctx, types.UndefTipSet, headAt(1002)))
+		assert.Equal(t, types.NewGasPrice(100), queue.List(sender)[0].Msg.GasPrice)
+
+		require.NoError(t, ob.HandleNewHead(ctx, types.UndefTipSet, headAt(1003)))
+		queued := queue.List(sender)
+		require.Len(t, queued, 1)
+		assert.Equal(t, types.NewGasPrice(110), queued[0].Msg.GasPrice)
+		assert.Equal(t, uint64(1003), queued[0].Stamp)
+		assert.Equal(t, queued[0].Msg, publisher.message)
+
+		// a further bump would exceed the max price
+		require.NoError(t, ob.HandleNewHead(ctx, types.UndefTipSet, headAt(1006)))
+		assert.Equal(t, types.NewGasPrice(110), queue.List(sender)[0].Msg.GasPrice)
+	})
+
+	t.Run("send batch reserves contiguous nonces and skips invalid messages", func(t *testing.T) {
+		ctx := context.Background()
+		w, _ := types.NewMockSignersAndKeyInfo(1)
+		sender := w.Addresses[0]
+		toAddr := address.NewForTestGetter()()
+		queue := core.NewMessageQueue()
+		publisher := &mockPublisher{}
+		provider := &fakeProvider{}
+
+		blk := types.NewBlockForTest(nil, 1)
+		blk.Height = 1000
+		actr, _ := account.NewActor(types.ZeroAttoFIL)
+		actr.Nonce = 42
+		provider.Set(t, blk, sender, actr)
+
+		ob := core.NewOutbox(w, nullValidator{}, queue, publisher, nullPolicy{}, provider, provider, config.NewDefaultConfig().Mpool)
+		results, err := ob.SendBatch(ctx, sender, types.NewGasPrice(1), []core.BatchMessage{
+			{To: toAddr, Value: types.NewAttoFILFromFIL(1)},
+			{To: toAddr, Value: types.NewAttoFILFromFIL(2), Method: "foo", Params: []interface{}{struct{}{}}},
+			{To: toAddr, Value: types.NewAttoFILFromFIL(3)},
+		})
+		require.NoError(t, err)
+		require.Len(t, results, 3)
+
+		assert.NoError(t, results[0].Err)
+		assert.Error(t, results[1].Err)
+		assert.False(t, results[1].Cid.Defined())
+		assert.NoError(t, results[2].Err)
+
+		queued := queue.List(sender)
+		require.Len(t, queued, 2)
+		assert.Equal(t, types.Uint64(42), queued[0].Msg.Nonce)
+		assert.Equal(t, types.NewAttoFILFromFIL(1), queued[0].Msg.Value)
+		assert.Equal(t, types.Uint64(43), queued[1].Msg.Nonce)
+		assert.Equal(t, types.NewAttoFILFromFIL(3), queued[1].Msg.Value)
+		for i, r := range []core.BatchResult{results[0], results[2]} {
+			c, err := queued[i].Msg.Cid()
+			require.NoError(t, err)
+			assert.Equal(t, c, r.Cid)
+		}
+		assert.Equal(t, queued[1].Msg, publisher.message)
+	})
+
+	t.Run("send batch rejects batch larger than the nonce gap", func(t *testing.T) {
+		ctx := context.Background()
+		w, _ := types.NewMockSignersAndKeyInfo(1)
+		sender := w.Addresses[0]
+		toAddr := address.NewForTestGetter()()
+		queue := core.NewMessageQueue()
+		publisher := &mockPublisher{}
+		provider := &fakeProvider{}
+
+		blk := types.NewBlockForTest(nil, 1)
+		actr, _ := account.NewActor(types.ZeroAttoFIL)
+		actr.Nonce = 42
+		provider.Set(t, blk, sender, actr)
+
+		cfg := config.NewDefaultConfig().Mpool
+		cfg.MaxNonceGap = 3
+		ob := core.NewOutbox(w, nullValidator{}, queue, publisher, nullPolicy{}, provider, provider, cfg)
+
+		// one message is already queued, so only three more nonces are within the gap
+		_, err := ob.Send(ctx, sender, toAddr, types.ZeroAttoFIL, types.NewGasPrice(1), types.NewGasUnits(0), "")
+		require.NoError(t, err)
+
+		batch := make([]core.BatchMessage, 4)
+		for i := range batch {
+			batch[i] = core.BatchMessage{To: toAddr, Value: types.NewAttoFILFromFIL(1)}
+		}
+		_, err = ob.SendBatch(ctx, sender, types.NewGasPrice(1), batch)
+		assert.Error(t, err)
+		assert.Len(t, queue.List(sender), 1)
+
+		results, err := ob.SendBatch(ctx, sender, types.NewGasPrice(1), batch[:3])
+		require.NoError(t, err)
+		for _, r := range results {
+			assert.NoError(t, r.Err)
+		}
+		assert.Len(t, queue.List(sender), 4)
+	})
+
+	t.Run("send batch releases nonce of message rejected by pool", func(t *testing.T) {
+		ctx := context.Background()
+		w, _ := types.NewMockSignersAndKeyInfo(1)
+		sender := w.Addresses[0]
+		toAddr := address.NewForTestGetter()()
+		queue := core.NewMessageQueue()
+		publisher := &rejectingPublisher{rejectValue: types.NewAttoFILFromFIL(2)}
+		provider := &fakeProvider{}
+
+		blk := types.NewBlockForTest(nil, 1)
+		actr, _ := account.NewActor(types.ZeroAttoFIL)
+		actr.Nonce = 42
+		provider.Set(t, blk, sender, actr)
+
+		ob := core.NewOutbox(w, nullValidator{}, queue, publisher, nullPolicy{}, provider, provider, config.NewDefaultConfig().Mpool)
+		results, err := ob.SendBatch(ctx, sender, types.NewGasPrice(1), []core.BatchMessage{
+			{To: toAddr, Value: types.NewAttoFILFromFIL(1)},
+			{To: toAddr, Value: types.NewAttoFILFromFIL(2)},
+			{To: toAddr, Value: types.NewAttoFILFromFIL(3)},
+		})
+		require.NoError(t, err)
+		require.Len(t, results, 3)
+
+		assert.NoError(t, results[0].Err)
+		assert.Error(t, results[1].Err)
+		assert.False(t, results[1].Cid.Defined())
+		assert.NoError(t, results[2].Err)
+
+		queued := queue.List(sender)
+		require.Len(t, queued, 2)
+		assert.Equal(t, types.Uint64(42), queued[0].Msg.Nonce)
+		assert.Equal(t, types.Uint64(43), queued[1].Msg.Nonce)
+		assert.Equal(t, types.NewAttoFILFromFIL(3), queued[1].Msg.Value)
+	})
+
+	t.Run("send signed message enqueues and calls publish", func(t *testing.T) {
+		ctx := context.Background()
+		w, _ := types.NewMockSignersAndKeyInfo(1)
+		sender := w.Addresses[0]
+		toAddr := address.NewForTestGetter()()
+		queue := core.NewMessageQueue()
+		publisher := &mockPublisher{}
+		provider := &fakeProvider{}
+
+		blk := types.NewBlockForTest(nil, 1)
+		blk.Height = 1000
+		actr, _ := account.NewActor(types.ZeroAttoFIL)
+		actr.Nonce = 42
+		provider.Set(t, blk, sender, actr)
+
+		ob := core.NewOutbox(w, nullValidator{}, queue, publisher, nullPolicy{}, provider, provider, config.NewDefaultConfig().Mpool)
+		nonce, err := ob.NextNonce(ctx, sender)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(42), nonce)
+
+		msg := types.NewMessage(sender, toAddr, nonce, types.ZeroAttoFIL, "", nil)
+		signed, err := types.NewSignedMessage(*msg, w, types.NewGasPrice(1), types.NewGasUnits(0))
+		require.NoError(t, err)
+
+		c, err := ob.SendSigned(ctx, signed)
+		require.NoError(t, err)
+		expected, err := signed.Cid()
+		require.NoError(t, err)
+		assert.Equal(t, expected, c)
+		assert.Equal(t, signed, queue.List(sender)[0].Msg)
+		assert.Equal(t, uint64(1000), queue.List(sender)[0].Stamp)
+		assert.Equal(t, signed, publisher.message)
+
+		nonce, err = ob.NextNonce(ctx, sender)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(43), nonce)
+
+		// A message that doesn't follow on from the queued one is rejected.
+		publisher.message = nil
+		msg = types.NewMessage(sender, toAddr, 42, types.ZeroAttoFIL, "", nil)
+		stale, err := types.NewSignedMessage(*msg, w, types.NewGasPrice(2), types.NewGasUnits(0))
+		require.NoError(t, err)
+		_, err = ob.SendSigned(ctx, stale)
+		assert.Error(t, err)
+		assert.Len(t, queue.List(sender), 1)
+		assert.Nil(t, publisher.message)
+	})
+
+	t.Run("restore drops mined messages and re-publishes the rest", func(t *testing.T) {
+		ctx := context.Background()
+		w, _ := types.NewMockSignersAndKeyInfo(1)
+		sender := w.Addresses[0]
+		toAddr := address.NewForTestGetter()()
+		queue := core.NewMessageQueue()
+		publisher := &mockPublisher{}
+		provider := &fakeProvider{}
+
+		blk := types.NewBlockForTest(nil, 1)
+		blk.Height = 1000
+		actr, _ := account.NewActor(types.ZeroAttoFIL)
+		actr.Nonce = 42
+		provider.Set(t, blk, sender, actr)
+
+		ob := core.NewOutbox(w, nullValidator{}, queue, publisher, nullPolicy{}, provider, provider, config.NewDefaultConfig().Mpool)
+		for i := 0; i < 3; i++ {
+			_, err := ob.Send(ctx, sender, toAddr, types.ZeroAttoFIL, types.NewGasPrice(1), types.NewGasUnits(0), "")
+			require.NoError(t, err)
+		}
+		require.Len(t, queue.List(sender), 3)
+
+		// the first two messages are mined while the node is down
+		actr.Nonce = 44
+		next := types.NewBlockForTest(nil, 2)
+		next.Height = 1005
+		provider.Set(t, next, sender, actr)
+		publisher.message = nil
+
+		require.NoError(t, ob.Restore(ctx))
+		queued := queue.List(sender)
+		require.Len(t, queued, 1)
+		assert.Equal(t, types.Uint64(44), queued[0].Msg.Nonce)
+		assert.Equal(t, queued[0].Msg, publisher.message)
+		assert.Equal(t, uint64(1005), publisher.height)
+	})
+
+	t.Run("restore clears queue with invalid messages", func(t *testing.T) {
+		ctx := context.Background()
+		w, _ := types.NewMockSignersAndKeyInfo(1)
+		sender := w.Addresses[0]
+		toAddr := address.NewForTestGetter()()
+		queue := core.NewMessageQueue()
+		publisher := &mockPublisher{}
+		provider := &fakeProvider{}
+
+		blk := types.NewBlockForTest(nil, 1)
+		actr, _ := account.NewActor(types.ZeroAttoFIL)
+		provider.Set(t, blk, sender, actr)
+
+		ob := core.NewOutbox(w, nullValidator{}, queue, publisher, nullPolicy{}, provider, provider, config.NewDefaultConfig().Mpool)
+		_, err := ob.Send(ctx, sender, toAddr, types.ZeroAttoFIL, types.NewGasPrice(1), types.NewGasUnits(0), "")
+		require.NoError(t, err)
+		publisher.message = nil
+
+		ob = core.NewOutbox(w, nullValidator{rejectMessages: true}, queue, publisher, nullPolicy{}, provider, provider, config.NewDefaultConfig().Mpool)
+		require.NoError(t, ob.Restore(ctx))
+		assert.Empty(t, queue.List(sender))
+		assert.Nil(t, publisher.message)
+	})
+
 	t.Run("fails with non-account actor", func(t *testing.T) {
 		w, _ := types.NewMockSignersAndKeyInfo(1)
 		sender := w.Addresses[0]
@@ -127,7 +468,7 @@ func TestOutbox(t *testing.T) {
 		actr := storagemarket.NewActor() // Not an account actor
 		provider.Set(t, blk, sender, actr)
 
-		ob := core.NewOutbox(w, nullValidator{}, queue, publisher, nullPolicy{}, provider, provider)
+		ob := core.NewOutbox(w, nullValidator{}, queue, publisher, nullPolicy{}, provider, provider, config.NewDefaultConfig().Mpool)
 
 		_, err := ob.Send(context.Background(), sender, toAddr, types.ZeroAttoFIL, types.NewGasPrice(0), types.NewGasUnits(0), "")
 		assert.Error(t, err)
@@ -148,6 +489,18 @@ func (p *mockPublisher) Publish(ctx context.Context, message *types.SignedMessag
 	return p.returnError
 }
 
+// A publisher which behaves as if the message pool rejects messages with a particular value.
+type rejectingPublisher struct {
+	rejectValue types.AttoFIL
+}
+
+func (p *rejectingPublisher) Publish(ctx context.Context, message *types.SignedMessage, height uint64) error {
+	if message.Value.Equal(p.rejectValue) {
+		return &core.NotPooledError{Err: errors.New("rejected for testing")}
+	}
+	return nil
+}
+
 // A chain and actor provider which provides and expects values for a single message.
 type fakeProvider struct {
 	head   types.SortedCidSet // Provided by GetHead and expected by others
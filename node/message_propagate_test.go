@@ -66,13 +66,14 @@ func TestMessagePropagation(t *testing.T) {
 	require.Equal(t, 0, len(nodes[0].Inbox.Pool().Pending()))
 
 	t.Run("message propagates", func(t *testing.T) {
+		gasLimit := types.NewGasUnits(0)
 		_, err = sender.PorcelainAPI.MessageSendWithDefaultAddress(
 			ctx,
 			senderAddress,
 			address.NetworkAddress,
 			types.NewAttoFILFromFIL(1),
 			types.NewGasPrice(1),
-			types.NewGasUnits(0),
+			&gasLimit,
 			"foo",
 		)
 		require.NoError(t, err)
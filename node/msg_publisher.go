@@ -28,7 +28,7 @@ func (p *defaultMessagePublisher) Publish(ctx context.Context, message *types.Si
 	}
 
 	if _, err := p.pool.Add(ctx, message, height); err != nil {
-		return errors.Wrap(err, "failed to add message to message pool")
+		return &core.NotPooledError{Err: errors.Wrap(err, "failed to add message to message pool")}
 	}
 
 	if err = p.network.Publish(p.topic, encoded); err != nil {
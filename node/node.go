@@ -54,6 +54,8 @@ import (
 	"github.com/filecoin-project/go-filecoin/plumbing/cfg"
 	"github.com/filecoin-project/go-filecoin/plumbing/cst"
 	"github.com/filecoin-project/go-filecoin/plumbing/dag"
+	"github.com/filecoin-project/go-filecoin/plumbing/datakeys"
+	"github.com/filecoin-project/go-filecoin/plumbing/history"
 	"github.com/filecoin-project/go-filecoin/plumbing/msg"
 	"github.com/filecoin-project/go-filecoin/plumbing/strgdls"
 	"github.com/filecoin-project/go-filecoin/porcelain"
@@ -109,10 +111,11 @@ type Node struct {
 	Syncer      nodeChainSyncer
 	PowerTable  consensus.PowerTableView
 
-	BlockMiningAPI *block.MiningAPI
-	PorcelainAPI   *porcelain.API
-	RetrievalAPI   *retrieval.API
-	StorageAPI     *storage.API
+	BlockMiningAPI  *block.MiningAPI
+	PorcelainAPI    *porcelain.API
+	RetrievalAPI    *retrieval.API
+	StorageAPI      *storage.API
+	StorageMinerAPI *storage.MinerAPI
 
 	// HeavyTipSetCh is a subscription to the heaviest tipset topic on the chain.
 	HeaviestTipSetCh chan interface{}
@@ -122,6 +125,14 @@ type Node struct {
 	// https://github.com/filecoin-project/go-filecoin/issues/2309
 	HeaviestTipSetHandled func()
 
+	// History indexes the messages from and to each address, if enabled.
+	History *history.Indexer
+	// historyHeadCh is a subscription to the heaviest tipset topic feeding the History indexer.
+	historyHeadCh chan interface{}
+
+	// StorageDealManager follows the storage deals this node proposed as a client.
+	StorageDealManager *storage.DealManager
+
 	// Incoming messages for block mining.
 	Inbox *core.Inbox
 	// Messages sent and not yet mined.
@@ -426,7 +437,12 @@ func (nc *Config) Build(ctx context.Context) (*Node, error) {
 		return nil, errors.Wrap(err, "failed to set up network")
 	}
 
-	backend, err := wallet.NewDSBackend(nc.Repo.WalletDatastore())
+	var backend wallet.Backend
+	if signer := nc.Repo.Config().Wallet.RemoteSigner; signer != "" {
+		backend, err = wallet.NewRemoteBackend(signer)
+	} else {
+		backend, err = wallet.NewBackend(nc.Repo.WalletDatastore())
+	}
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to set up wallet backend")
 	}
@@ -434,23 +450,36 @@ func (nc *Config) Build(ctx context.Context) (*Node, error) {
 
 	// only the syncer gets the storage which is online connected
 	chainSyncer := chain.NewSyncer(&cstOffline, nodeConsensus, chainStore, fetcher, chain.Syncing)
-	msgPool := core.NewMessagePool(nc.Repo.Config().Mpool, consensus.NewIngestionValidator(chainState, nc.Repo.Config().Mpool))
+	msgPool := core.NewPersistentMessagePool(nc.Repo.Config().Mpool, consensus.NewIngestionValidator(chainState, nc.Repo.Config().Mpool), nc.Repo.Datastore())
 	inbox := core.NewInbox(msgPool, core.InboxMaxAgeTipsets, chainStore)
 
-	msgQueue := core.NewMessageQueue()
+	msgQueue, err := core.NewPersistentMessageQueue(nc.Repo.Datastore())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load outbound message queue")
+	}
 	outboxPolicy := core.NewMessageQueuePolicy(chainStore, core.OutboxMaxAgeRounds)
 	msgPublisher := newDefaultMessagePublisher(pubsub.NewPublisher(fsub), core.Topic, msgPool)
-	outbox := core.NewOutbox(fcWallet, consensus.NewOutboundMessageValidator(), msgQueue, msgPublisher, outboxPolicy, chainStore, chainState)
+	outbox := core.NewOutbox(fcWallet, consensus.NewOutboundMessageValidator(), msgQueue, msgPublisher, outboxPolicy, chainStore, chainState, nc.Repo.Config().Mpool)
+
+	msgPreviewer := msg.NewPreviewer(fcWallet, chainStore, &cstOffline, bs)
+
+	var historyIndexer *history.Indexer
+	if nc.Repo.Config().History.Enabled {
+		historyIndexer = history.NewIndexer(chainStore, bs, &cstOffline, nc.Repo.Datastore())
+	}
 
 	PorcelainAPI := porcelain.New(plumbing.New(&plumbing.APIDeps{
 		Bitswap:      bswap,
 		Chain:        chainState,
 		Config:       cfg.NewConfig(nc.Repo),
 		DAG:          dag.NewDAG(merkledag.NewDAGService(bservice)),
+		DataKeys:     datakeys.New(nc.Repo.Datastore()),
 		Deals:        strgdls.New(nc.Repo.DealsDatastore()),
 		Expected:     nodeConsensus,
+		GasEstimator: msg.NewGasEstimator(nc.Repo, msgPreviewer, chainStore),
+		History:      historyIndexer,
 		MsgPool:      msgPool,
-		MsgPreviewer: msg.NewPreviewer(fcWallet, chainStore, &cstOffline, bs),
+		MsgPreviewer: msgPreviewer,
 		MsgQueryer:   msg.NewQueryer(nc.Repo, fcWallet, chainStore, &cstOffline, bs),
 		MsgWaiter:    msg.NewWaiter(chainStore, bs, &cstOffline),
 		Network:      net.New(peerHost, pubsub.NewPublisher(fsub), pubsub.NewSubscriber(fsub), net.NewRouter(router), bandwidthTracker, net.NewPinger(peerHost, pingService)),
@@ -469,6 +498,7 @@ func (nc *Config) Build(ctx context.Context) (*Node, error) {
 		PorcelainAPI: PorcelainAPI,
 		Fetcher:      fetcher,
 		Exchange:     bswap,
+		History:      historyIndexer,
 		host:         peerHost,
 		Inbox:        inbox,
 		OfflineMode:  nc.OfflineMode,
@@ -513,6 +543,14 @@ func (node *Node) Start(ctx context.Context) error {
 		return err
 	}
 
+	// Restore messages persisted before the last shutdown now that the chain head is known.
+	if err = node.Inbox.Pool().Restore(ctx); err != nil {
+		return errors.Wrap(err, "failed to restore message pool")
+	}
+	if err = node.Outbox.Restore(ctx); err != nil {
+		return errors.Wrap(err, "failed to restore outbound message queue")
+	}
+
 	// Only set these up if there is a miner configured.
 	if _, err := node.miningAddress(); err == nil {
 		if err := node.setupMining(ctx); err != nil {
@@ -535,7 +573,11 @@ func (node *Node) Start(ctx context.Context) error {
 	if err != nil {
 		return errors.Wrap(err, "failed to set up protocols:")
 	}
-	node.RetrievalMiner = retrieval.NewMiner(node)
+	node.RetrievalMiner = retrieval.NewMiner(node, node.PorcelainAPI, node.Repo.Datastore())
+
+	if err := node.StorageDealManager.Start(ctx); err != nil {
+		return errors.Wrap(err, "failed to resume following storage deals")
+	}
 
 	// subscribe to block notifications
 	blkSub, err := node.PorcelainAPI.PubSubSubscribe(BlockTopic)
@@ -565,6 +607,17 @@ func (node *Node) Start(ctx context.Context) error {
 	}
 	go node.handleNewHeaviestTipSet(cctx, head)
 
+	if node.History != nil {
+		// The indexer first catches up on the chain since the node last ran, which may be all
+		// of it, so it follows the head on its own rather than in handleNewHeaviestTipSet.
+		node.historyHeadCh = node.ChainReader.HeadEvents().Sub(chain.NewHeadTopic)
+		go node.History.Run(cctx)
+		node.History.Notify(head)
+		go node.notifyHistory(cctx)
+	}
+
+	go node.RetrievalMiner.RunRedeemer(cctx)
+
 	if !node.OfflineMode {
 		node.Bootstrapper.Start(context.Background())
 	}
@@ -685,6 +738,7 @@ func (node *Node) handleNewHeaviestTipSet(ctx context.Context, head types.TipSet
 					log.Error(err)
 				}
 			}
+			node.RetrievalMiner.NotifyNewHead()
 			node.HeaviestTipSetHandled()
 		case <-ctx.Done():
 			return
@@ -692,6 +746,25 @@ func (node *Node) handleNewHeaviestTipSet(ctx context.Context, head types.TipSet
 	}
 }
 
+// notifyHistory passes each new head to the address history indexer.
+func (node *Node) notifyHistory(ctx context.Context) {
+	for {
+		select {
+		case ts, ok := <-node.historyHeadCh:
+			if !ok {
+				return
+			}
+			newHead, ok := ts.(types.TipSet)
+			if !ok || !newHead.Defined() {
+				continue
+			}
+			node.History.Notify(newHead)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 func (node *Node) cancelSubscriptions() {
 	if node.BlockSub != nil || node.MessageSub != nil {
 		node.cancelSubscriptionsCtx()
@@ -711,9 +784,15 @@ func (node *Node) cancelSubscriptions() {
 // Stop initiates the shutdown of the node.
 func (node *Node) Stop(ctx context.Context) {
 	node.ChainReader.HeadEvents().Unsub(node.HeaviestTipSetCh)
+	if node.historyHeadCh != nil {
+		node.ChainReader.HeadEvents().Unsub(node.historyHeadCh)
+	}
 	node.StopMining(ctx)
 
 	node.cancelSubscriptions()
+	if node.StorageDealManager != nil {
+		node.StorageDealManager.Stop()
+	}
 	node.ChainReader.Stop()
 
 	if node.SectorBuilder() != nil {
@@ -811,12 +890,19 @@ func (node *Node) StartMining(ctx context.Context) error {
 		go node.handleNewMiningOutput(outCh)
 	}
 
-	// initialize a storage miner
+	// initialize a storage miner, resuming the deals left in progress the first time
+	resumeDeals := node.StorageMiner == nil
 	storageMiner, err := initStorageMinerForNode(ctx, node)
 	if err != nil {
 		return errors.Wrap(err, "failed to initialize storage miner")
 	}
 	node.StorageMiner = storageMiner
+	go storageMiner.RunRedeemer(node.miningCtx)
+	if resumeDeals {
+		if err := storageMiner.ResumeDeals(ctx); err != nil {
+			return errors.Wrap(err, "failed to resume storage deals")
+		}
+	}
 
 	// loop, turning sealing-results into commitSector messages to be included
 	// in the chain
@@ -1025,13 +1111,18 @@ func (node *Node) setupProtocols() error {
 	node.BlockMiningAPI = &blockMiningAPI
 
 	// set up retrieval client and api
-	retapi := retrieval.NewAPI(retrieval.NewClient(node.host, node.PorcelainAPI))
+	retapi := retrieval.NewAPI(retrieval.NewClient(node.host, node.PorcelainAPI, node.Repo.Datastore()))
 	node.RetrievalAPI = &retapi
 
 	// set up storage client and api
 	smc := storage.NewClient(node.host, node.PorcelainAPI)
-	smcAPI := storage.NewAPI(smc)
+	node.StorageDealManager = storage.NewDealManager(smc, node.PorcelainAPI)
+	smcAPI := storage.NewAPI(smc, node.StorageDealManager)
 	node.StorageAPI = &smcAPI
+
+	// set up storage miner api
+	smAPI := storage.NewMinerAPI(func() *storage.Miner { return node.StorageMiner })
+	node.StorageMinerAPI = &smAPI
 	return nil
 }
 
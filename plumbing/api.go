@@ -16,7 +16,9 @@ import (
 	"github.com/libp2p/go-libp2p-peer"
 	pstore "github.com/libp2p/go-libp2p-peerstore"
 	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
 
+	"github.com/filecoin-project/go-filecoin/abi"
 	"github.com/filecoin-project/go-filecoin/actor"
 	"github.com/filecoin-project/go-filecoin/address"
 	"github.com/filecoin-project/go-filecoin/chain"
@@ -28,6 +30,8 @@ import (
 	"github.com/filecoin-project/go-filecoin/plumbing/cfg"
 	"github.com/filecoin-project/go-filecoin/plumbing/cst"
 	"github.com/filecoin-project/go-filecoin/plumbing/dag"
+	"github.com/filecoin-project/go-filecoin/plumbing/datakeys"
+	"github.com/filecoin-project/go-filecoin/plumbing/history"
 	"github.com/filecoin-project/go-filecoin/plumbing/msg"
 	"github.com/filecoin-project/go-filecoin/plumbing/strgdls"
 	"github.com/filecoin-project/go-filecoin/protocol/storage/storagedeal"
@@ -48,7 +52,10 @@ type API struct {
 	chain        *cst.ChainStateProvider
 	config       *cfg.Config
 	dag          *dag.DAG
+	dataKeys     *datakeys.Store
 	expected     consensus.Protocol
+	gasEstimator *msg.GasEstimator
+	history      *history.Indexer
 	msgPool      *core.MessagePool
 	msgPreviewer *msg.Previewer
 	msgQueryer   *msg.Queryer
@@ -65,8 +72,11 @@ type APIDeps struct {
 	Chain        *cst.ChainStateProvider
 	Config       *cfg.Config
 	DAG          *dag.DAG
+	DataKeys     *datakeys.Store
 	Deals        *strgdls.Store
 	Expected     consensus.Protocol
+	GasEstimator *msg.GasEstimator
+	History      *history.Indexer
 	MsgPool      *core.MessagePool
 	MsgPreviewer *msg.Previewer
 	MsgQueryer   *msg.Queryer
@@ -85,7 +95,10 @@ func New(deps *APIDeps) *API {
 		chain:        deps.Chain,
 		config:       deps.Config,
 		dag:          deps.DAG,
+		dataKeys:     deps.DataKeys,
 		expected:     deps.Expected,
+		gasEstimator: deps.GasEstimator,
+		history:      deps.History,
 		msgPool:      deps.MsgPool,
 		msgPreviewer: deps.MsgPreviewer,
 		msgQueryer:   deps.MsgQueryer,
@@ -109,6 +122,11 @@ func (api *API) ActorGetSignature(ctx context.Context, actorAddr address.Address
 	return api.chain.GetActorSignature(ctx, actorAddr, method)
 }
 
+// ActorGetState decodes the latest state of the actor at addr on the chain into out
+func (api *API) ActorGetState(ctx context.Context, addr address.Address, out interface{}) error {
+	return api.chain.GetActorState(ctx, addr, out)
+}
+
 // ActorLs returns a channel with actors from the latest state on the chain
 func (api *API) ActorLs(ctx context.Context) (<-chan state.GetAllActorsResult, error) {
 	return api.chain.LsActors(ctx)
@@ -157,16 +175,46 @@ func (api *API) ChainSampleRandomness(ctx context.Context, sampleHeight *types.B
 	return api.chain.SampleRandomness(ctx, sampleHeight)
 }
 
+// DataKeyGet returns the key of the encrypted data with the given root, or
+// datakeys.ErrKeyNotFound.
+func (api *API) DataKeyGet(root cid.Cid) ([]byte, error) {
+	return api.dataKeys.Get(root)
+}
+
+// DataKeyPut stores the key of the encrypted data with the given root.
+func (api *API) DataKeyPut(root cid.Cid, key []byte) error {
+	return api.dataKeys.Put(root, key)
+}
+
+// DataKeysLs returns the keys of all the encrypted data.
+func (api *API) DataKeysLs() ([]*datakeys.Entry, error) {
+	return api.dataKeys.Ls()
+}
+
 // DealsIterator returns an iterator to access all deals
 func (api *API) DealsIterator() (*query.Results, error) {
 	return api.storagedeals.Iterator()
 }
 
+// DealsQuery returns the deals matching the query, oldest first
+func (api *API) DealsQuery(q strgdls.Query) ([]*storagedeal.Deal, error) {
+	return api.storagedeals.Query(q)
+}
+
 // DealPut puts a given deal in the datastore
 func (api *API) DealPut(storageDeal *storagedeal.Deal) error {
 	return api.storagedeals.Put(storageDeal)
 }
 
+// AddressHistory returns the messages and value transfers from or to addr at heights from
+// since on, oldest first. It fails if the node doesn't index address history.
+func (api *API) AddressHistory(addr address.Address, since uint64) ([]*history.Entry, error) {
+	if api.history == nil {
+		return nil, errors.New("address history is not indexed; set history.enabled in the config and restart the node")
+	}
+	return api.history.History(addr, since)
+}
+
 // OutboxQueues lists addresses with non-empty outbox queues (in no particular order).
 func (api *API) OutboxQueues() []address.Address {
 	return api.outbox.Queue().Queues()
@@ -215,10 +263,97 @@ func (api *API) MessageQuery(ctx context.Context, optFrom, to address.Address, m
 // message in the msg pool and broadcasts it to the network; it does not wait for the
 // message to go on chain. Note that no default from address is provided. If you need
 // a default address, use MessageSendWithDefaultAddress instead.
+// The gas price and limit are used as given, even if zero; see MessageEstimateGasPrice and
+// MessageEstimateGasLimit to estimate them.
 func (api *API) MessageSend(ctx context.Context, from, to address.Address, value types.AttoFIL, gasPrice types.AttoFIL, gasLimit types.GasUnits, method string, params ...interface{}) (cid.Cid, error) {
 	return api.outbox.Send(ctx, from, to, value, gasPrice, gasLimit, method, params...)
 }
 
+// MessageSendBatch sends a batch of messages from one address with contiguous nonces, returning
+// a result for each message in the same order. If optGasLimit is nil, the gas limit of each
+// message is estimated, and a message whose gas limit can't be estimated fails without
+// consuming a nonce; otherwise every message is sent with optGasLimit.
+func (api *API) MessageSendBatch(ctx context.Context, from address.Address, gasPrice types.AttoFIL, optGasLimit *types.GasUnits, msgs []core.BatchMessage) ([]core.BatchResult, error) {
+	var err error
+	results := make([]core.BatchResult, len(msgs))
+	var toSend []core.BatchMessage
+	var sentIdx []int
+	for i, m := range msgs {
+		if optGasLimit != nil {
+			m.GasLimit = *optGasLimit
+		} else {
+			m.GasLimit, err = api.MessageEstimateGasLimit(ctx, from, m.To, m.Method, m.Params...)
+			if err != nil {
+				results[i].Err = err
+				continue
+			}
+		}
+		toSend = append(toSend, m)
+		sentIdx = append(sentIdx, i)
+	}
+
+	sent, err := api.outbox.SendBatch(ctx, from, gasPrice, toSend)
+	if err != nil {
+		return nil, err
+	}
+	for j, i := range sentIdx {
+		results[i] = sent[j]
+	}
+	return results, nil
+}
+
+// MessageCreate builds an unsigned message to be signed outside the node, e.g. on an air-gapped
+// machine, and later submitted with MessageSubmit. Its nonce follows on from the sender's mined
+// messages and those in the outbox. As for MessageSend, the gas price and limit are used as
+// given.
+func (api *API) MessageCreate(ctx context.Context, from, to address.Address, value types.AttoFIL, gasPrice types.AttoFIL, gasLimit types.GasUnits, method string, params ...interface{}) (*types.MeteredMessage, error) {
+	encodedParams, err := abi.ToEncodedValues(params...)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid params")
+	}
+
+	nonce, err := api.outbox.NextNonce(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := types.NewMessage(from, to, nonce, value, method, encodedParams)
+	return types.NewMeteredMessage(*msg, gasPrice, gasLimit), nil
+}
+
+// MessageSubmit sends a message signed outside the node. Like MessageSend, it enqueues the
+// message in the outbox and msg pool and broadcasts it to the network, without waiting for it
+// to go on chain.
+func (api *API) MessageSubmit(ctx context.Context, signed *types.SignedMessage) (cid.Cid, error) {
+	return api.outbox.SendSigned(ctx, signed)
+}
+
+// MessageEstimateGasPrice returns a gas price for a new message, based on the prices paid by
+// recently mined messages.
+func (api *API) MessageEstimateGasPrice(ctx context.Context) (types.AttoFIL, error) {
+	gasPrice, err := api.gasEstimator.EstimateGasPrice(ctx)
+	if err != nil {
+		return types.ZeroAttoFIL, errors.Wrap(err, "failed to estimate gas price")
+	}
+	return gasPrice, nil
+}
+
+// MessageEstimateGasLimit returns a gas limit for a new message, based on previewing it.
+func (api *API) MessageEstimateGasLimit(ctx context.Context, from, to address.Address, method string, params ...interface{}) (types.GasUnits, error) {
+	gasLimit, err := api.gasEstimator.EstimateGasLimit(ctx, from, to, method, params...)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to estimate gas limit")
+	}
+	return gasLimit, nil
+}
+
+// MessageReplace replaces a message in the outbox with a copy re-signed with a new gas price and
+// limit, and publishes it. The replacement keeps the original's nonce. The message pool only
+// accepts the replacement if its gas price sufficiently exceeds the original's.
+func (api *API) MessageReplace(ctx context.Context, msgCid cid.Cid, gasPrice types.AttoFIL, gasLimit types.GasUnits) (cid.Cid, error) {
+	return api.outbox.Replace(ctx, msgCid, gasPrice, gasLimit)
+}
+
 // MessageFind returns a message and receipt from the blockchain, if it exists.
 func (api *API) MessageFind(ctx context.Context, msgCid cid.Cid) (*msg.ChainMessage, bool, error) {
 	return api.msgWaiter.Find(ctx, msgCid)
@@ -323,6 +458,65 @@ func (api *API) WalletExport(addrs []address.Address) ([]*types.KeyInfo, error)
 	return api.wallet.Export(addrs)
 }
 
+// WalletUnlock unlocks an encrypted wallet with the given passphrase, making its keys available
+// for signing until WalletLock is called or, if timeout is not zero, until the timeout elapses.
+func (api *API) WalletUnlock(passphrase []byte, timeout time.Duration) error {
+	return api.wallet.Unlock(passphrase, timeout)
+}
+
+// WalletLock locks an encrypted wallet, so that it refuses to sign until it is unlocked again.
+func (api *API) WalletLock() error {
+	return api.wallet.Lock()
+}
+
+// WalletHDInit makes the wallet derive new addresses from the seed of mnemonic, along path. If
+// mnemonic is empty, a new random seed is generated. Returns the seed's mnemonic.
+func (api *API) WalletHDInit(mnemonic, path string) (string, error) {
+	if mnemonic == "" {
+		var err error
+		mnemonic, err = wallet.NewMnemonic()
+		if err != nil {
+			return "", err
+		}
+	}
+	if err := api.wallet.EnableHD(mnemonic, path); err != nil {
+		return "", err
+	}
+	return mnemonic, nil
+}
+
+// WalletHDMnemonic returns the mnemonic of the seed the wallet derives addresses from.
+func (api *API) WalletHDMnemonic() (string, error) {
+	hdb, err := api.wallet.HD()
+	if err != nil {
+		return "", err
+	}
+	return hdb.Mnemonic()
+}
+
+// WalletHDAddressAt returns the address of the wallet's key at index of its derivation path,
+// without adding it to the wallet.
+func (api *API) WalletHDAddressAt(index uint32) (address.Address, error) {
+	hdb, err := api.wallet.HD()
+	if err != nil {
+		return address.Undef, err
+	}
+	ki, err := hdb.KeyInfoAt(index)
+	if err != nil {
+		return address.Undef, err
+	}
+	return ki.Address()
+}
+
+// WalletHDAdd adds the key at index of the wallet's derivation path to the wallet.
+func (api *API) WalletHDAdd(index uint32) (address.Address, error) {
+	hdb, err := api.wallet.HD()
+	if err != nil {
+		return address.Undef, err
+	}
+	return hdb.AddIndex(index)
+}
+
 // DAGGetNode returns the associated DAG node for the passed in CID.
 func (api *API) DAGGetNode(ctx context.Context, ref string) (interface{}, error) {
 	return api.dag.GetNode(ctx, ref)
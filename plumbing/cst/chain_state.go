@@ -106,6 +106,19 @@ func (chn *ChainStateProvider) GetActorAt(ctx context.Context, tipKey types.Sort
 	return actr, nil
 }
 
+// GetActorState decodes the latest state of the actor at addr into out. The state is read
+// from the local store, never fetched from the network.
+func (chn *ChainStateProvider) GetActorState(ctx context.Context, addr address.Address, out interface{}) error {
+	actr, err := chn.GetActor(ctx, addr)
+	if err != nil {
+		return err
+	}
+	if !actr.Head.Defined() {
+		return errors.Errorf("actor at address %s has no state", addr)
+	}
+	return chn.cst.Get(ctx, actr.Head, out)
+}
+
 // LsActors returns a channel with actors from the latest state on the chain
 func (chn *ChainStateProvider) LsActors(ctx context.Context) (<-chan state.GetAllActorsResult, error) {
 	st, err := chain.LatestState(ctx, chn.reader, chn.cst)
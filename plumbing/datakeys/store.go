@@ -0,0 +1,111 @@
+package datakeys
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/pkg/errors"
+
+	"github.com/filecoin-project/go-filecoin/repo"
+)
+
+// DataKeyPrefix is the datastore prefix for the keys of encrypted data.
+const DataKeyPrefix = "datakeys"
+
+// ErrKeyNotFound is returned when there is no key for some data.
+var ErrKeyNotFound = errors.New("no key for the data")
+
+// Entry is the key of some encrypted data.
+type Entry struct {
+	// Root is the cid of the root of the encrypted data.
+	Root cid.Cid `json:"root"`
+	Key  []byte  `json:"key"`
+}
+
+// Store keeps the keys that encrypt client data, by the root cid of the encrypted data.
+type Store struct {
+	ds repo.Datastore
+}
+
+// New returns a new Store.
+func New(ds repo.Datastore) *Store {
+	return &Store{ds: ds}
+}
+
+// Put stores the key of the data with the given root.
+func (store *Store) Put(root cid.Cid, key []byte) error {
+	if len(key) != KeySize {
+		return fmt.Errorf("key is %d bytes, expected %d", len(key), KeySize)
+	}
+	if err := store.ds.Put(dataKey(root), key); err != nil {
+		return errors.Wrap(err, "could not save data key")
+	}
+	return nil
+}
+
+// Get returns the key of the data with the given root, or ErrKeyNotFound.
+func (store *Store) Get(root cid.Cid) ([]byte, error) {
+	key, err := store.ds.Get(dataKey(root))
+	if err == datastore.ErrNotFound {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read data key")
+	}
+	return key, nil
+}
+
+// Ls returns all the keys in the store.
+func (store *Store) Ls() ([]*Entry, error) {
+	results, err := store.ds.Query(query.Query{Prefix: "/" + DataKeyPrefix})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query data keys from datastore")
+	}
+	defer results.Close() // nolint: errcheck
+
+	var entries []*Entry
+	for result := range results.Next() {
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		root, err := cid.Decode(datastore.NewKey(result.Key).BaseNamespace())
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid data key %s", result.Key)
+		}
+		entries = append(entries, &Entry{Root: root, Key: result.Value})
+	}
+	return entries, nil
+}
+
+// MarshalJSON encodes the key in hex, like the rest of the keys a user handles.
+func (e *Entry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Root cid.Cid `json:"root"`
+		Key  string  `json:"key"`
+	}{e.Root, fmt.Sprintf("%x", e.Key)})
+}
+
+// UnmarshalJSON decodes an entry encoded by MarshalJSON.
+func (e *Entry) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		Root cid.Cid `json:"root"`
+		Key  string  `json:"key"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	key, err := ParseKey(raw.Key)
+	if err != nil {
+		return err
+	}
+	e.Root = raw.Root
+	e.Key = key
+	return nil
+}
+
+func dataKey(root cid.Cid) datastore.Key {
+	return datastore.KeyWithNamespaces([]string{DataKeyPrefix, root.String()})
+}
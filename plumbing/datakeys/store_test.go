@@ -0,0 +1,72 @@
+package datakeys_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-filecoin/plumbing/datakeys"
+	"github.com/filecoin-project/go-filecoin/repo"
+	tf "github.com/filecoin-project/go-filecoin/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/types"
+)
+
+func TestStore(t *testing.T) {
+	tf.UnitTest(t)
+
+	newCid := types.NewCidForTestGetter()
+
+	t.Run("round trips keys", func(t *testing.T) {
+		store := datakeys.New(repo.NewInMemoryRepo().Datastore())
+		root := newCid()
+		key, err := datakeys.NewKey()
+		require.NoError(t, err)
+
+		require.NoError(t, store.Put(root, key))
+
+		got, err := store.Get(root)
+		require.NoError(t, err)
+		assert.Equal(t, key, got)
+	})
+
+	t.Run("fails to get unknown keys", func(t *testing.T) {
+		store := datakeys.New(repo.NewInMemoryRepo().Datastore())
+		_, err := store.Get(newCid())
+		assert.Equal(t, datakeys.ErrKeyNotFound, err)
+	})
+
+	t.Run("rejects keys of the wrong size", func(t *testing.T) {
+		store := datakeys.New(repo.NewInMemoryRepo().Datastore())
+		assert.Error(t, store.Put(newCid(), []byte("short")))
+	})
+
+	t.Run("lists keys", func(t *testing.T) {
+		store := datakeys.New(repo.NewInMemoryRepo().Datastore())
+		root1, root2 := newCid(), newCid()
+		key1, err := datakeys.NewKey()
+		require.NoError(t, err)
+		key2, err := datakeys.NewKey()
+		require.NoError(t, err)
+		require.NoError(t, store.Put(root1, key1))
+		require.NoError(t, store.Put(root2, key2))
+
+		entries, err := store.Ls()
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []*datakeys.Entry{{Root: root1, Key: key1}, {Root: root2, Key: key2}}, entries)
+	})
+
+	t.Run("entries round trip through JSON", func(t *testing.T) {
+		key, err := datakeys.NewKey()
+		require.NoError(t, err)
+		entry := &datakeys.Entry{Root: newCid(), Key: key}
+
+		b, err := json.Marshal(entry)
+		require.NoError(t, err)
+
+		var got datakeys.Entry
+		require.NoError(t, json.Unmarshal(b, &got))
+		assert.Equal(t, entry, &got)
+	})
+}
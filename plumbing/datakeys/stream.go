@@ -0,0 +1,231 @@
+package datakeys
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// KeySize is the size in bytes of the keys that encrypt data, for AES-256.
+const KeySize = 32
+
+// segmentSize is the size of the segments of plaintext sealed one at a time, so data can be
+// encrypted and decrypted as a stream.
+const segmentSize = 64 << 10
+
+// streamVersion is the first byte of encrypted data.
+const streamVersion = 1
+
+// noncePrefixSize is the size of the random part of the segment nonces. The rest is the
+// segment counter and a byte marking the last segment, so segments can't be reordered or
+// dropped.
+const noncePrefixSize = 7
+
+// ErrCorrupt is returned when encrypted data doesn't decrypt with the key it was given.
+var ErrCorrupt = errors.New("encrypted data is corrupt or the key is wrong")
+
+// NewKey returns a new random key.
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, errors.Wrap(err, "failed to generate key")
+	}
+	return key, nil
+}
+
+// ParseKey parses a hex encoded key.
+func ParseKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "key is not hex encoded")
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key is %d bytes, expected %d", len(key), KeySize)
+	}
+	return key, nil
+}
+
+// Encrypt returns a reader of the data read from r, encrypted with key.
+func Encrypt(key []byte, r io.Reader) (io.Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 1+noncePrefixSize)
+	header[0] = streamVersion
+	if _, err := rand.Read(header[1:]); err != nil {
+		return nil, errors.Wrap(err, "failed to generate nonce")
+	}
+
+	return &encryptReader{
+		src:   bufio.NewReader(r),
+		aead:  aead,
+		nonce: newSegmentNonce(header[1:]),
+		plain: make([]byte, segmentSize),
+		out:   header,
+	}, nil
+}
+
+// Decrypt returns a reader of the data encrypted by Encrypt read from r, decrypted with key.
+func Decrypt(key []byte, r io.Reader) (io.Reader, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 1+noncePrefixSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, ErrCorrupt
+	}
+	if header[0] != streamVersion {
+		return nil, fmt.Errorf("unknown encryption version %d", header[0])
+	}
+
+	return &decryptReader{
+		src:    bufio.NewReader(r),
+		aead:   aead,
+		nonce:  newSegmentNonce(header[1:]),
+		sealed: make([]byte, segmentSize+aead.Overhead()),
+	}, nil
+}
+
+type encryptReader struct {
+	src   *bufio.Reader
+	aead  cipher.AEAD
+	nonce *segmentNonce
+	plain []byte
+	// out holds sealed bytes not read yet.
+	out  []byte
+	done bool
+}
+
+func (er *encryptReader) Read(p []byte) (int, error) {
+	for len(er.out) == 0 {
+		if er.done {
+			return 0, io.EOF
+		}
+		if err := er.sealSegment(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, er.out)
+	er.out = er.out[n:]
+	return n, nil
+}
+
+func (er *encryptReader) sealSegment() error {
+	n, last, err := readSegment(er.src, er.plain)
+	if err != nil {
+		return err
+	}
+	nonce, err := er.nonce.next(last)
+	if err != nil {
+		return err
+	}
+	er.out = er.aead.Seal(er.out[:0], nonce, er.plain[:n], nil)
+	er.done = last
+	return nil
+}
+
+type decryptReader struct {
+	src    *bufio.Reader
+	aead   cipher.AEAD
+	nonce  *segmentNonce
+	sealed []byte
+	// out holds opened bytes not read yet.
+	out  []byte
+	done bool
+}
+
+func (dr *decryptReader) Read(p []byte) (int, error) {
+	for len(dr.out) == 0 {
+		if dr.done {
+			return 0, io.EOF
+		}
+		if err := dr.openSegment(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, dr.out)
+	dr.out = dr.out[n:]
+	return n, nil
+}
+
+func (dr *decryptReader) openSegment() error {
+	n, last, err := readSegment(dr.src, dr.sealed)
+	if err != nil {
+		return err
+	}
+	nonce, err := dr.nonce.next(last)
+	if err != nil {
+		return err
+	}
+	dr.out, err = dr.aead.Open(dr.out[:0], nonce, dr.sealed[:n], nil)
+	if err != nil {
+		return ErrCorrupt
+	}
+	dr.done = last
+	return nil
+}
+
+// readSegment fills buf from r, and tells whether it read the last of r.
+func readSegment(r *bufio.Reader, buf []byte) (int, bool, error) {
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return n, true, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if _, err := r.Peek(1); err == io.EOF {
+		return n, true, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return n, false, nil
+}
+
+// segmentNonce makes the nonce of each segment: the random prefix, the segment counter and
+// whether the segment is the last.
+type segmentNonce struct {
+	nonce   []byte
+	counter uint32
+}
+
+func newSegmentNonce(prefix []byte) *segmentNonce {
+	nonce := make([]byte, noncePrefixSize+4+1)
+	copy(nonce, prefix)
+	return &segmentNonce{nonce: nonce}
+}
+
+func (sn *segmentNonce) next(last bool) ([]byte, error) {
+	if sn.counter == ^uint32(0) {
+		return nil, errors.New("data is too large to encrypt")
+	}
+	binary.BigEndian.PutUint32(sn.nonce[noncePrefixSize:], sn.counter)
+	sn.nonce[len(sn.nonce)-1] = 0
+	if last {
+		sn.nonce[len(sn.nonce)-1] = 1
+	}
+	sn.counter++
+	return sn.nonce, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("key is %d bytes, expected %d", len(key), KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,86 @@
+package datakeys
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	tf "github.com/filecoin-project/go-filecoin/testhelpers/testflags"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	tf.UnitTest(t)
+
+	key, err := NewKey()
+	require.NoError(t, err)
+
+	encrypt := func(t *testing.T, data []byte) []byte {
+		r, err := Encrypt(key, bytes.NewReader(data))
+		require.NoError(t, err)
+		sealed, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		return sealed
+	}
+
+	decrypt := func(key, sealed []byte) ([]byte, error) {
+		r, err := Decrypt(key, bytes.NewReader(sealed))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(r)
+	}
+
+	t.Run("round trips data of any size", func(t *testing.T) {
+		for _, size := range []int{0, 1, segmentSize - 1, segmentSize, segmentSize + 1, 3*segmentSize + 7} {
+			data := make([]byte, size)
+			_, err := rand.Read(data)
+			require.NoError(t, err)
+
+			sealed := encrypt(t, data)
+			assert.NotEqual(t, data, sealed)
+
+			opened, err := decrypt(key, sealed)
+			require.NoError(t, err, "size %d", size)
+			assert.True(t, bytes.Equal(data, opened), "size %d", size)
+		}
+	})
+
+	data := make([]byte, 2*segmentSize+100)
+	_, err = rand.Read(data)
+	require.NoError(t, err)
+	sealed := encrypt(t, data)
+
+	t.Run("fails with the wrong key", func(t *testing.T) {
+		other, err := NewKey()
+		require.NoError(t, err)
+		_, err = decrypt(other, sealed)
+		assert.Equal(t, ErrCorrupt, err)
+	})
+
+	t.Run("detects tampering", func(t *testing.T) {
+		tampered := append([]byte{}, sealed...)
+		tampered[len(tampered)/2] ^= 1
+		_, err := decrypt(key, tampered)
+		assert.Equal(t, ErrCorrupt, err)
+	})
+
+	t.Run("detects truncation at a segment boundary", func(t *testing.T) {
+		segment := segmentSize + 16 // GCM overhead
+		_, err := decrypt(key, sealed[:1+noncePrefixSize+segment])
+		assert.Equal(t, ErrCorrupt, err)
+	})
+
+	t.Run("parses hex keys", func(t *testing.T) {
+		parsed, err := ParseKey(fmt.Sprintf("%x", key))
+		require.NoError(t, err)
+		assert.Equal(t, key, parsed)
+
+		_, err = ParseKey("abcd")
+		assert.Error(t, err)
+	})
+}
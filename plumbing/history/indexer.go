@@ -0,0 +1,396 @@
+// Package history indexes the messages and value transfers that touch each address, so that an
+// address's transaction history can be listed without walking the chain.
+package history
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/ipfs/go-hamt-ipld"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	logging "github.com/ipfs/go-log"
+	"github.com/pkg/errors"
+
+	"github.com/filecoin-project/go-filecoin/actor/builtin"
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/chain"
+	"github.com/filecoin-project/go-filecoin/consensus"
+	"github.com/filecoin-project/go-filecoin/core"
+	"github.com/filecoin-project/go-filecoin/repo"
+	"github.com/filecoin-project/go-filecoin/sampling"
+	"github.com/filecoin-project/go-filecoin/state"
+	"github.com/filecoin-project/go-filecoin/types"
+	"github.com/filecoin-project/go-filecoin/vm"
+)
+
+var log = logging.Logger("history")
+
+func init() {
+	cbor.RegisterCborType(Entry{})
+}
+
+// Prefix is the datastore prefix under which the index is stored.
+const Prefix = "history"
+
+var (
+	headKey      = datastore.KeyWithNamespaces([]string{Prefix, "head"})
+	addrPrefix   = datastore.KeyWithNamespaces([]string{Prefix, "addr"})
+	heightPrefix = datastore.KeyWithNamespaces([]string{Prefix, "height"})
+)
+
+// Entry is a message or value transfer from or to an address.
+type Entry struct {
+	// Height is the height of the tipset the message is in.
+	Height uint64
+	// MessageCid is the cid of the message on chain.
+	MessageCid cid.Cid
+	From       address.Address
+	To         address.Address
+	Value      types.AttoFIL
+	Method     string
+	// Internal is true if the entry is a transfer made by an actor while the message executed,
+	// rather than the message itself.
+	Internal bool
+	// ExitCode is the exit code of the message's receipt.
+	ExitCode uint8
+}
+
+// indexerChainReader is the part of the chain store the indexer reads.
+type indexerChainReader interface {
+	GetBlock(context.Context, cid.Cid) (*types.Block, error)
+	GetHead() types.SortedCidSet
+	GetTipSet(tsKey types.SortedCidSet) (types.TipSet, error)
+	GetTipSetStateRoot(tsKey types.SortedCidSet) (cid.Cid, error)
+}
+
+// Indexer maintains the index of each address's history as the chain head changes. The
+// history of a tipset is found by executing its messages again on its parent state.
+// Indexing can take a long time, the first time the whole chain, so a node runs the indexer
+// in its own goroutine (see Run) rather than in its chain head handler.
+type Indexer struct {
+	chainReader indexerChainReader
+	cst         *hamt.CborIpldStore
+	bs          bstore.Blockstore
+	ds          repo.Datastore
+
+	// lk serializes updates of the index.
+	lk sync.Mutex
+
+	// heads holds the latest head passed to Notify and not yet indexed by Run.
+	heads chan types.TipSet
+}
+
+// NewIndexer returns a new Indexer storing the index in ds.
+func NewIndexer(chainReader indexerChainReader, bs bstore.Blockstore, cst *hamt.CborIpldStore, ds repo.Datastore) *Indexer {
+	return &Indexer{
+		chainReader: chainReader,
+		cst:         cst,
+		bs:          bs,
+		ds:          ds,
+		heads:       make(chan types.TipSet, 1),
+	}
+}
+
+// Notify has Run index the chain ending at newHead. It replaces any head still waiting to be
+// indexed, since indexing the newer head covers it, and never blocks.
+func (ix *Indexer) Notify(newHead types.TipSet) {
+	for {
+		select {
+		case ix.heads <- newHead:
+			return
+		default:
+		}
+		// Drop the older head, unless Run took it in the meantime.
+		select {
+		case <-ix.heads:
+		default:
+		}
+	}
+}
+
+// Run indexes the heads passed to Notify until ctx is done.
+func (ix *Indexer) Run(ctx context.Context) {
+	for {
+		select {
+		case head := <-ix.heads:
+			if err := ix.HandleNewHead(ctx, head); err != nil {
+				log.Errorf("updating address history for new tipset: %s", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// HandleNewHead updates the index so that it covers the chain ending at newHead: tipsets that
+// are no longer in the chain are removed from the index, and those not yet indexed are added.
+// The first call indexes the whole chain.
+func (ix *Indexer) HandleNewHead(ctx context.Context, newHead types.TipSet) error {
+	ix.lk.Lock()
+	defer ix.lk.Unlock()
+
+	oldHead, found, err := ix.indexedHead()
+	if err != nil {
+		return err
+	}
+
+	var oldTips, newTips []types.TipSet
+	if found {
+		oldTips, newTips, err = core.CollectTipsToCommonAncestor(ctx, ix.chainReader, oldHead, newHead)
+	} else {
+		newTips, err = chain.CollectTipSetsOfHeightAtLeast(ctx, chain.IterAncestors(ctx, ix.chainReader, newHead), types.NewBlockHeight(0))
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to collect tipsets to index")
+	}
+
+	for _, ts := range oldTips {
+		if err := ix.unindexTipSet(ts); err != nil {
+			return err
+		}
+	}
+	// Tipsets are collected from the head back; index them from the oldest up so that the
+	// indexed head is always consistent with the index.
+	for i := len(newTips) - 1; i >= 0; i-- {
+		if err := ix.indexTipSet(ctx, newTips[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// History returns the entries from or to addr at heights from since on, oldest first.
+func (ix *Indexer) History(addr address.Address, since uint64) ([]*Entry, error) {
+	results, err := ix.ds.Query(query.Query{
+		// The trailing slash keeps addresses that extend addr out of the results.
+		Prefix: addrPrefix.ChildString(addr.String()).String() + "/",
+		Orders: []query.Order{query.OrderByKey{}},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query address history")
+	}
+	defer results.Close() // nolint: errcheck
+
+	var entries []*Entry
+	for result := range results.Next() {
+		if result.Error != nil {
+			return nil, errors.Wrap(result.Error, "failed to read address history")
+		}
+		var entry Entry
+		if err := cbor.DecodeInto(result.Value, &entry); err != nil {
+			return nil, errors.Wrap(err, "failed to decode history entry")
+		}
+		if entry.Height >= since {
+			entries = append(entries, &entry)
+		}
+	}
+	return entries, nil
+}
+
+func (ix *Indexer) indexedHead() (types.TipSet, bool, error) {
+	raw, err := ix.ds.Get(headKey)
+	if err == datastore.ErrNotFound {
+		return types.UndefTipSet, false, nil
+	} else if err != nil {
+		return types.UndefTipSet, false, errors.Wrap(err, "failed to read indexed head")
+	}
+
+	var cids []cid.Cid
+	if err := cbor.DecodeInto(raw, &cids); err != nil {
+		return types.UndefTipSet, false, errors.Wrap(err, "failed to decode indexed head")
+	}
+	ts, err := ix.chainReader.GetTipSet(types.NewSortedCidSet(cids...))
+	if err != nil {
+		return types.UndefTipSet, false, errors.Wrap(err, "failed to load indexed head")
+	}
+	return ts, true, nil
+}
+
+func (ix *Indexer) putIndexedHead(ts types.TipSet) error {
+	raw, err := cbor.DumpObject(ts.ToSortedCidSet().ToSlice())
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(ix.ds.Put(headKey, raw), "failed to store indexed head")
+}
+
+// indexTipSet adds the entries of a tipset to the index and makes it the indexed head.
+func (ix *Indexer) indexTipSet(ctx context.Context, ts types.TipSet) error {
+	height, err := ts.Height()
+	if err != nil {
+		return err
+	}
+
+	entries, err := ix.tipSetEntries(ctx, ts)
+	if err != nil {
+		return errors.Wrapf(err, "failed to find history of tipset %s", ts.String())
+	}
+
+	var keys []string
+	for seq, entry := range entries {
+		raw, err := cbor.DumpObject(entry)
+		if err != nil {
+			return err
+		}
+		addrs := []address.Address{entry.From}
+		if entry.To != entry.From {
+			addrs = append(addrs, entry.To)
+		}
+		for _, addr := range addrs {
+			key := entryKey(addr, height, seq)
+			if err := ix.ds.Put(key, raw); err != nil {
+				return errors.Wrap(err, "failed to store history entry")
+			}
+			keys = append(keys, key.String())
+		}
+	}
+
+	raw, err := cbor.DumpObject(keys)
+	if err != nil {
+		return err
+	}
+	if err := ix.ds.Put(heightKey(height), raw); err != nil {
+		return errors.Wrap(err, "failed to store history keys")
+	}
+	return ix.putIndexedHead(ts)
+}
+
+// unindexTipSet removes the entries of a tipset from the index and makes its parent the
+// indexed head.
+func (ix *Indexer) unindexTipSet(ts types.TipSet) error {
+	height, err := ts.Height()
+	if err != nil {
+		return err
+	}
+
+	raw, err := ix.ds.Get(heightKey(height))
+	if err != nil && err != datastore.ErrNotFound {
+		return errors.Wrap(err, "failed to read history keys")
+	}
+	if err == nil {
+		var keys []string
+		if err := cbor.DecodeInto(raw, &keys); err != nil {
+			return errors.Wrap(err, "failed to decode history keys")
+		}
+		for _, key := range keys {
+			if err := ix.ds.Delete(datastore.NewKey(key)); err != nil {
+				return errors.Wrap(err, "failed to delete history entry")
+			}
+		}
+		if err := ix.ds.Delete(heightKey(height)); err != nil {
+			return errors.Wrap(err, "failed to delete history keys")
+		}
+	}
+
+	parents, err := ts.Parents()
+	if err != nil {
+		return err
+	}
+	parent, err := ix.chainReader.GetTipSet(parents)
+	if err != nil {
+		return err
+	}
+	return ix.putIndexedHead(parent)
+}
+
+// tipSetEntries executes the messages of a tipset on its parent state, like the message waiter
+// does to find receipts, and returns the entries of the messages that were applied.
+func (ix *Indexer) tipSetEntries(ctx context.Context, ts types.TipSet) ([]*Entry, error) {
+	parents, err := ts.Parents()
+	if err != nil {
+		return nil, err
+	}
+	if parents.Len() == 0 {
+		// The genesis tipset has no messages.
+		return nil, nil
+	}
+
+	stateCid, err := ix.chainReader.GetTipSetStateRoot(parents)
+	if err != nil {
+		return nil, err
+	}
+	st, err := state.LoadStateTree(ctx, ix.cst, stateCid, builtin.Actors)
+	if err != nil {
+		return nil, err
+	}
+
+	height, err := ts.Height()
+	if err != nil {
+		return nil, err
+	}
+	parentTs, err := ix.chainReader.GetTipSet(parents)
+	if err != nil {
+		return nil, err
+	}
+	ancestors, err := chain.GetRecentAncestors(ctx, parentTs, ix.chainReader, types.NewBlockHeight(height), types.NewBlockHeight(consensus.AncestorRoundsNeeded), sampling.LookbackParameter)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := consensus.NewDefaultProcessor().ProcessTipSet(ctx, st, vm.NewStorageMap(ix.bs), ts, ancestors)
+	if err != nil {
+		return nil, err
+	}
+
+	// Results are in the canonical message order of the tipset, without duplicates and
+	// failures.
+	var entries []*Entry
+	var seen types.SortedCidSet
+	var j int
+	for i := 0; i < ts.Len(); i++ {
+		for _, msg := range ts.At(i).Messages {
+			c, err := msg.Cid()
+			if err != nil {
+				return nil, err
+			}
+			if seen.Has(c) || res.Failures.Has(c) {
+				continue
+			}
+			(&seen).Add(c)
+			if j >= len(res.Results) {
+				return nil, fmt.Errorf("no result for message %s", c)
+			}
+			result := res.Results[j]
+			j++
+
+			entries = append(entries, &Entry{
+				Height:     height,
+				MessageCid: c,
+				From:       msg.From,
+				To:         msg.To,
+				Value:      msg.Value,
+				Method:     msg.Method,
+				ExitCode:   result.Receipt.ExitCode,
+			})
+			for _, transfer := range result.InternalTransfers {
+				entries = append(entries, &Entry{
+					Height:     height,
+					MessageCid: c,
+					From:       transfer.From,
+					To:         transfer.To,
+					Value:      transfer.Value,
+					Method:     transfer.Method,
+					Internal:   true,
+					ExitCode:   result.Receipt.ExitCode,
+				})
+			}
+		}
+	}
+	return entries, nil
+}
+
+// entryKey is the key of the seq'th entry at height, in the history of addr. Heights and
+// sequence numbers are zero padded so that keys sort in chain order.
+func entryKey(addr address.Address, height uint64, seq int) datastore.Key {
+	return addrPrefix.ChildString(addr.String()).ChildString(fmt.Sprintf("%020d", height)).ChildString(fmt.Sprintf("%010d", seq))
+}
+
+// heightKey is the key of the list of entry keys stored for the tipset at height.
+func heightKey(height uint64) datastore.Key {
+	return heightPrefix.ChildString(fmt.Sprintf("%020d", height))
+}
@@ -0,0 +1,149 @@
+package history_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	bserv "github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-hamt-ipld"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	offline "github.com/ipfs/go-ipfs-exchange-offline"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/chain"
+	"github.com/filecoin-project/go-filecoin/consensus"
+	"github.com/filecoin-project/go-filecoin/core"
+	"github.com/filecoin-project/go-filecoin/plumbing/history"
+	"github.com/filecoin-project/go-filecoin/repo"
+	th "github.com/filecoin-project/go-filecoin/testhelpers"
+	tf "github.com/filecoin-project/go-filecoin/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/types"
+)
+
+func TestIndexerHandleNewHead(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	mockSigner, _ := types.NewMockSignersAndKeyInfo(4)
+	addr1, addr2, addr3, minerAddr := mockSigner.Addresses[0], mockSigner.Addresses[1], mockSigner.Addresses[2], mockSigner.Addresses[3]
+
+	r := repo.NewInMemoryRepo()
+	bs := bstore.NewBlockstore(r.Datastore())
+	cst := &hamt.CborIpldStore{Blocks: bserv.New(bs, offline.Exchange(bs))}
+	chainStore, err := chain.Init(ctx, r, bs, cst, consensus.MakeGenesisFunc(
+		consensus.ActorAccount(addr1, types.NewAttoFILFromFIL(10000)),
+		consensus.ActorAccount(addr2, types.ZeroAttoFIL),
+		consensus.ActorAccount(addr3, types.ZeroAttoFIL),
+		consensus.MinerActor(minerAddr, addr3, th.RequireRandomPeerID(t), types.ZeroAttoFIL, types.OneKiBSectorSize),
+	))
+	require.NoError(t, err)
+
+	genesis, err := chainStore.GetTipSet(chainStore.GetHead())
+	require.NoError(t, err)
+	genesisBlock := genesis.At(0)
+
+	// mkChild puts a child of genesis with a message from addr1 to `to` in the chain store.
+	mkChild := func(to address.Address, nonce uint64) (types.TipSet, *types.SignedMessage) {
+		msg := types.NewMessage(addr1, to, 0, types.NewAttoFILFromFIL(100), "", nil)
+		smsg, err := types.NewSignedMessage(*msg, &mockSigner, types.NewGasPrice(1), types.NewGasUnits(0))
+		require.NoError(t, err)
+
+		blk := th.RequireMkFakeChild(t, th.FakeChildParams{
+			MinerAddr:   minerAddr,
+			Parent:      genesis,
+			GenesisCid:  chainStore.GenesisCid(),
+			StateRoot:   genesisBlock.StateRoot,
+			Signer:      mockSigner,
+			MinerWorker: addr3,
+			Nonce:       nonce,
+		})
+		blk.Messages = []*types.SignedMessage{smsg}
+		core.MustPut(cst, blk)
+
+		ts := th.RequireNewTipSet(t, blk)
+		require.NoError(t, chainStore.PutTipSetAndState(ctx, &chain.TipSetAndState{
+			TipSet:          ts,
+			TipSetStateRoot: genesisBlock.StateRoot,
+		}))
+		return ts, smsg
+	}
+
+	indexer := history.NewIndexer(chainStore, bs, cst, r.Datastore())
+
+	ts1, smsg1 := mkChild(addr2, 0)
+	require.NoError(t, indexer.HandleNewHead(ctx, ts1))
+
+	msgCid1, err := smsg1.Cid()
+	require.NoError(t, err)
+	assertEntry := func(entry *history.Entry) {
+		assert.Equal(t, uint64(1), entry.Height)
+		assert.Equal(t, msgCid1, entry.MessageCid)
+		assert.Equal(t, addr1, entry.From)
+		assert.Equal(t, addr2, entry.To)
+		assert.True(t, types.NewAttoFILFromFIL(100).Equal(entry.Value))
+		assert.False(t, entry.Internal)
+		assert.Equal(t, uint8(0), entry.ExitCode)
+	}
+
+	entries, err := indexer.History(addr1, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assertEntry(entries[0])
+
+	entries, err = indexer.History(addr2, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assertEntry(entries[0])
+
+	entries, err = indexer.History(addr1, 2)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	t.Run("handling the same head again changes nothing", func(t *testing.T) {
+		require.NoError(t, indexer.HandleNewHead(ctx, ts1))
+
+		entries, err := indexer.History(addr1, 0)
+		require.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("a reorg replaces the history of the abandoned tipsets", func(t *testing.T) {
+		ts2, smsg2 := mkChild(addr3, 1)
+		require.NoError(t, indexer.HandleNewHead(ctx, ts2))
+
+		entries, err := indexer.History(addr2, 0)
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+
+		msgCid2, err := smsg2.Cid()
+		require.NoError(t, err)
+		entries, err = indexer.History(addr1, 0)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		assert.Equal(t, msgCid2, entries[0].MessageCid)
+		assert.Equal(t, addr3, entries[0].To)
+	})
+
+	t.Run("the index outlives the indexer", func(t *testing.T) {
+		entries, err := history.NewIndexer(chainStore, bs, cst, r.Datastore()).History(addr3, 0)
+		require.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("run indexes the latest notified head", func(t *testing.T) {
+		runCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		ix := history.NewIndexer(chainStore, bs, cst, r.Datastore())
+		ix.Notify(ts1)
+		go ix.Run(runCtx)
+
+		require.NoError(t, th.WaitForIt(50, 10*time.Millisecond, func() (bool, error) {
+			entries, err := ix.History(addr2, 0)
+			return len(entries) == 1, err
+		}))
+	})
+}
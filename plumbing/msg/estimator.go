@@ -0,0 +1,108 @@
+package msg
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/chain"
+	"github.com/filecoin-project/go-filecoin/repo"
+	"github.com/filecoin-project/go-filecoin/types"
+)
+
+// Abstracts over a store of blockchain state.
+type estimatorChainReader interface {
+	GetHead() types.SortedCidSet
+	GetTipSet(tsKey types.SortedCidSet) (types.TipSet, error)
+}
+
+// Abstracts over running a message locally to measure its gas usage.
+type estimatorPreviewer interface {
+	Preview(ctx context.Context, optFrom, to address.Address, method string, params ...interface{}) (types.GasUnits, error)
+}
+
+// GasEstimator estimates the gas price and limit for a message when the sender doesn't specify them.
+type GasEstimator struct {
+	// For reading the estimation parameters, which may change at runtime.
+	repo repo.Repo
+	// To measure the gas a message uses against the head state.
+	previewer estimatorPreviewer
+	// To walk back over recent tipsets.
+	chainReader estimatorChainReader
+}
+
+// NewGasEstimator constructs a GasEstimator.
+func NewGasEstimator(repo repo.Repo, previewer estimatorPreviewer, chainReader estimatorChainReader) *GasEstimator {
+	return &GasEstimator{repo, previewer, chainReader}
+}
+
+// EstimateGasLimit previews a message against the head state and returns the gas it uses plus
+// the configured safety margin, capped at the block gas limit.
+func (ge *GasEstimator) EstimateGasLimit(ctx context.Context, from, to address.Address, method string, params ...interface{}) (types.GasUnits, error) {
+	used, err := ge.previewer.Preview(ctx, from, to, method, params...)
+	if err != nil {
+		return types.NewGasUnits(0), errors.Wrap(err, "failed to preview message")
+	}
+
+	margin := uint64(ge.repo.Config().GasEstimation.LimitMarginPercent)
+	limit := types.NewGasUnits(uint64(used) + (uint64(used)*margin+99)/100)
+	if limit > types.BlockGasLimit {
+		limit = types.BlockGasLimit
+	}
+	return limit, nil
+}
+
+// EstimateGasPrice returns the configured percentile of gas prices paid by messages mined in the
+// most recent tipsets, or the configured minimum gas price if that is higher or no messages are found.
+func (ge *GasEstimator) EstimateGasPrice(ctx context.Context) (types.AttoFIL, error) {
+	cfg := ge.repo.Config().GasEstimation
+
+	head, err := ge.chainReader.GetTipSet(ge.chainReader.GetHead())
+	if err != nil {
+		return types.ZeroAttoFIL, errors.Wrap(err, "failed to get head tipset")
+	}
+
+	var prices []types.AttoFIL
+	iter := chain.IterAncestors(ctx, ge.chainReader, head)
+	for i := uint(0); i < cfg.PriceLookbackTipsets && !iter.Complete(); i++ {
+		tipset := iter.Value()
+		for j := 0; j < tipset.Len(); j++ {
+			for _, msg := range tipset.At(j).Messages {
+				prices = append(prices, msg.GasPrice)
+			}
+		}
+		if err = iter.Next(); err != nil {
+			return types.ZeroAttoFIL, errors.Wrap(err, "failed to walk chain")
+		}
+	}
+
+	price := percentile(prices, cfg.PricePercentile)
+	if price.LessThan(cfg.MinGasPrice) {
+		return cfg.MinGasPrice, nil
+	}
+	return price, nil
+}
+
+// percentile returns the smallest value at or above which p percent of values lie, or zero if
+// there are no values.
+func percentile(values []types.AttoFIL, p uint) types.AttoFIL {
+	if len(values) == 0 {
+		return types.ZeroAttoFIL
+	}
+	if p > 100 {
+		p = 100
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].LessThan(values[j])
+	})
+
+	// nearest-rank method: rank = ceil(p/100 * n)
+	idx := (int(p)*len(values)+99)/100 - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return values[idx]
+}
@@ -0,0 +1,125 @@
+package msg
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/repo"
+	tf "github.com/filecoin-project/go-filecoin/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/types"
+)
+
+type fakeEstimatorPreviewer struct {
+	gasUsed types.GasUnits
+}
+
+func (p *fakeEstimatorPreviewer) Preview(ctx context.Context, optFrom, to address.Address, method string, params ...interface{}) (types.GasUnits, error) {
+	return p.gasUsed, nil
+}
+
+// A chain reader that serves a linear chain of single block tipsets.
+type fakeEstimatorChainReader struct {
+	head    types.SortedCidSet
+	tipsets map[string]types.TipSet
+}
+
+func newFakeEstimatorChainReader(t *testing.T, signer types.MockSigner, gasPrices ...[]int64) *fakeEstimatorChainReader {
+	cr := &fakeEstimatorChainReader{tipsets: make(map[string]types.TipSet)}
+	to := address.NewForTestGetter()()
+
+	var parent *types.Block
+	nonce := uint64(0)
+	for _, prices := range append([][]int64{nil}, gasPrices...) {
+		blk := types.NewBlockForTest(parent, nonce)
+		for _, price := range prices {
+			msg := types.NewMessage(signer.Addresses[0], to, nonce, types.ZeroAttoFIL, "", nil)
+			smsg, err := types.NewSignedMessage(*msg, signer, types.NewGasPrice(price), types.NewGasUnits(0))
+			require.NoError(t, err)
+			blk.Messages = append(blk.Messages, smsg)
+			nonce++
+		}
+		nonce++
+		ts := types.RequireNewTipSet(t, blk)
+		cr.head = ts.ToSortedCidSet()
+		cr.tipsets[cr.head.String()] = ts
+		parent = blk
+	}
+	return cr
+}
+
+func (cr *fakeEstimatorChainReader) GetHead() types.SortedCidSet {
+	return cr.head
+}
+
+func (cr *fakeEstimatorChainReader) GetTipSet(tsKey types.SortedCidSet) (types.TipSet, error) {
+	ts, ok := cr.tipsets[tsKey.String()]
+	if !ok {
+		return types.UndefTipSet, errors.Errorf("no such tipset %s", tsKey)
+	}
+	return ts, nil
+}
+
+func TestGasEstimator(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	signer, _ := types.NewMockSignersAndKeyInfo(1)
+	newAddr := address.NewForTestGetter()
+
+	t.Run("gas limit adds margin to previewed gas", func(t *testing.T) {
+		r := repo.NewInMemoryRepo()
+		r.Config().GasEstimation.LimitMarginPercent = 20
+		ge := NewGasEstimator(r, &fakeEstimatorPreviewer{gasUsed: types.NewGasUnits(101)}, newFakeEstimatorChainReader(t, signer))
+
+		limit, err := ge.EstimateGasLimit(ctx, newAddr(), newAddr(), "method")
+		require.NoError(t, err)
+		assert.Equal(t, types.NewGasUnits(101+21), limit)
+	})
+
+	t.Run("gas limit is capped at the block gas limit", func(t *testing.T) {
+		r := repo.NewInMemoryRepo()
+		ge := NewGasEstimator(r, &fakeEstimatorPreviewer{gasUsed: types.BlockGasLimit}, newFakeEstimatorChainReader(t, signer))
+
+		limit, err := ge.EstimateGasLimit(ctx, newAddr(), newAddr(), "method")
+		require.NoError(t, err)
+		assert.Equal(t, types.BlockGasLimit, limit)
+	})
+
+	t.Run("gas price is a percentile of recent prices", func(t *testing.T) {
+		r := repo.NewInMemoryRepo()
+		r.Config().GasEstimation.PricePercentile = 50
+		r.Config().GasEstimation.PriceLookbackTipsets = 2
+		// the oldest tipset is outside the lookback window
+		cr := newFakeEstimatorChainReader(t, signer, []int64{1000, 1000, 1000}, []int64{5, 1}, []int64{3, 4})
+		ge := NewGasEstimator(r, &fakeEstimatorPreviewer{}, cr)
+
+		price, err := ge.EstimateGasPrice(ctx)
+		require.NoError(t, err)
+		assert.True(t, types.NewGasPrice(3).Equal(price), "got %s", price)
+
+		r.Config().GasEstimation.PricePercentile = 100
+		price, err = ge.EstimateGasPrice(ctx)
+		require.NoError(t, err)
+		assert.True(t, types.NewGasPrice(5).Equal(price), "got %s", price)
+	})
+
+	t.Run("gas price defaults to the minimum", func(t *testing.T) {
+		r := repo.NewInMemoryRepo()
+		r.Config().GasEstimation.MinGasPrice = types.NewGasPrice(7)
+		ge := NewGasEstimator(r, &fakeEstimatorPreviewer{}, newFakeEstimatorChainReader(t, signer))
+
+		price, err := ge.EstimateGasPrice(ctx)
+		require.NoError(t, err)
+		assert.True(t, types.NewGasPrice(7).Equal(price), "got %s", price)
+
+		ge = NewGasEstimator(r, &fakeEstimatorPreviewer{}, newFakeEstimatorChainReader(t, signer, []int64{2, 3}))
+		price, err = ge.EstimateGasPrice(ctx)
+		require.NoError(t, err)
+		assert.True(t, types.NewGasPrice(7).Equal(price), "got %s", price)
+	})
+}
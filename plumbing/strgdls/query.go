@@ -0,0 +1,97 @@
+package strgdls
+
+import (
+	"time"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/protocol/storage/storagedeal"
+	"github.com/filecoin-project/go-filecoin/types"
+)
+
+// Query selects deals from the Store. Zero fields don't filter deals.
+type Query struct {
+	// States selects deals in any of the states.
+	States []storagedeal.State
+	// Miner selects deals made with the miner.
+	Miner address.Address
+	// ExcludeMiner selects deals not made with the miner.
+	ExcludeMiner address.Address
+	// Client selects deals paid for by the client.
+	Client address.Address
+	// PieceRef selects deals storing the piece.
+	PieceRef cid.Cid
+
+	// CreatedAfter and CreatedBefore select deals that entered their first state at or
+	// after, and before, the given times.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+
+	// ExpiresAfter and ExpiresBefore select deals ending at or after, and before, the given
+	// block heights. Deals without payments have no end and don't match either.
+	ExpiresAfter  *types.BlockHeight
+	ExpiresBefore *types.BlockHeight
+
+	// Offset is the number of matching deals to skip and Limit the maximum number of deals
+	// to return, or 0 for all of them.
+	Offset uint64
+	Limit  uint64
+}
+
+// Matches returns whether the deal satisfies every filter of the query.
+func (q Query) Matches(deal *storagedeal.Deal) bool {
+	if len(q.States) > 0 && !q.hasState(deal.Response.State) {
+		return false
+	}
+	if !q.Miner.Empty() && deal.Miner != q.Miner {
+		return false
+	}
+	if !q.ExcludeMiner.Empty() && deal.Miner == q.ExcludeMiner {
+		return false
+	}
+
+	var proposal storagedeal.Proposal
+	if deal.Proposal != nil {
+		proposal = *deal.Proposal
+	}
+	if !q.Client.Empty() && proposal.Payment.Payer != q.Client {
+		return false
+	}
+	if q.PieceRef.Defined() && !proposal.PieceRef.Equals(q.PieceRef) {
+		return false
+	}
+
+	created := deal.CreatedAt()
+	if !q.CreatedAfter.IsZero() && (created.IsZero() || created.Before(q.CreatedAfter)) {
+		return false
+	}
+	if !q.CreatedBefore.IsZero() && (created.IsZero() || !created.Before(q.CreatedBefore)) {
+		return false
+	}
+
+	end := proposal.EndHeight()
+	if q.ExpiresAfter != nil && (end == nil || end.LessThan(q.ExpiresAfter)) {
+		return false
+	}
+	if q.ExpiresBefore != nil && (end == nil || end.GreaterEqual(q.ExpiresBefore)) {
+		return false
+	}
+	return true
+}
+
+func (q Query) hasState(state storagedeal.State) bool {
+	for _, s := range q.States {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// unfiltered returns whether the query selects every deal, before Offset and Limit.
+func (q Query) unfiltered() bool {
+	return len(q.States) == 0 && q.Miner.Empty() && q.ExcludeMiner.Empty() && q.Client.Empty() &&
+		!q.PieceRef.Defined() && q.CreatedAfter.IsZero() && q.CreatedBefore.IsZero() &&
+		q.ExpiresAfter == nil && q.ExpiresBefore == nil
+}
@@ -1,6 +1,12 @@
 package strgdls
 
 import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/query"
 	cbor "github.com/ipfs/go-ipld-cbor"
@@ -8,16 +14,43 @@ import (
 
 	"github.com/filecoin-project/go-filecoin/protocol/storage/storagedeal"
 	"github.com/filecoin-project/go-filecoin/repo"
+	"github.com/filecoin-project/go-filecoin/types"
 )
 
 // Store is plumbing implementation querying deals
 type Store struct {
 	dealsDs repo.Datastore
+
+	// lk serializes updates of deals and their index entries.
+	lk      sync.Mutex
+	indexed bool
 }
 
 // StorageDealPrefix is the datastore prefix for storage deals
 const StorageDealPrefix = "storagedeals"
 
+// storageDealIndexPrefix is the datastore prefix for the index of storage deals. An index
+// entry is an empty value keyed by /dealindex/<field>/<value>/<proposal cid>. It must not
+// start with StorageDealPrefix, which would make Iterator return index entries.
+const storageDealIndexPrefix = "dealindex"
+
+// indexVersion marks the index as built from every deal of the datastore, so deals stored
+// before the index, or one of its fields, existed are indexed once.
+const indexVersion = "3"
+
+const (
+	indexState  = "state"
+	indexMiner  = "miner"
+	indexClient = "client"
+	indexPiece  = "piece"
+	// indexCreated orders all deals as Query does, to page through them and select those
+	// created in a time range.
+	indexCreated = "created"
+	// indexExpires orders the deals with payments by their end, to select those ending in a
+	// range of block heights.
+	indexExpires = "expires"
+)
+
 // New returns a new Store.
 func New(dealsDatastore repo.Datastore) *Store {
 	return &Store{dealsDs: dealsDatastore}
@@ -34,17 +67,361 @@ func (store *Store) Iterator() (*query.Results, error) {
 
 // Put puts the deal into the datastore
 func (store *Store) Put(storageDeal *storagedeal.Deal) error {
+	store.lk.Lock()
+	defer store.lk.Unlock()
+
 	proposalCid := storageDeal.Response.ProposalCid
+	previous, err := store.get(proposalCid)
+	if err != nil && err != datastore.ErrNotFound {
+		return err
+	}
+
 	datum, err := cbor.DumpObject(storageDeal)
 	if err != nil {
 		return errors.Wrap(err, "could not marshal storageDeal")
 	}
 
-	key := datastore.KeyWithNamespaces([]string{StorageDealPrefix, proposalCid.String()})
-	err = store.dealsDs.Put(key, datum)
+	// The deal and its index entries are written together, so that the index never misses
+	// a deal or points at an old version of it.
+	batch, err := store.dealsDs.Batch()
 	if err != nil {
+		return err
+	}
+
+	key := datastore.KeyWithNamespaces([]string{StorageDealPrefix, proposalCid.String()})
+	if err := batch.Put(key, datum); err != nil {
+		return errors.Wrap(err, "could not save storage deal to disk")
+	}
+	if err := reindex(batch, previous, storageDeal); err != nil {
+		return err
+	}
+	if err := batch.Commit(); err != nil {
 		return errors.Wrap(err, "could not save storage deal to disk")
 	}
+	return nil
+}
+
+// Query returns the deals matching q, oldest first. A query without filters only reads the
+// deals of the page selected by Offset and Limit. Otherwise, deals are looked up through the
+// most selective index the query uses, and every deal is read if it uses none, which only
+// happens for queries that just exclude a miner; each page of a filtered query costs as much
+// as reading all the deals looked up.
+func (store *Store) Query(q Query) ([]*storagedeal.Deal, error) {
+	store.lk.Lock()
+	defer store.lk.Unlock()
+
+	if err := store.ensureIndex(); err != nil {
+		return nil, err
+	}
 
+	if q.unfiltered() {
+		return store.page(q.Offset, q.Limit)
+	}
+
+	candidates, err := store.candidates(q)
+	if err != nil {
+		return nil, err
+	}
+
+	var deals []*storagedeal.Deal
+	seen := make(map[cid.Cid]bool)
+	for _, deal := range candidates {
+		if seen[deal.Response.ProposalCid] || !q.Matches(deal) {
+			continue
+		}
+		seen[deal.Response.ProposalCid] = true
+		deals = append(deals, deal)
+	}
+
+	sort.Slice(deals, func(i, j int) bool {
+		ti, tj := deals[i].CreatedAt(), deals[j].CreatedAt()
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return deals[i].Response.ProposalCid.String() < deals[j].Response.ProposalCid.String()
+	})
+
+	if q.Offset >= uint64(len(deals)) {
+		return []*storagedeal.Deal{}, nil
+	}
+	deals = deals[q.Offset:]
+	if q.Limit > 0 && q.Limit < uint64(len(deals)) {
+		deals = deals[:q.Limit]
+	}
+	return deals, nil
+}
+
+// candidates returns the deals indexed under the most selective field of q, or every deal.
+// Every deal a miner makes is its own, so states narrow a miner's queries more than the
+// miner does.
+func (store *Store) candidates(q Query) ([]*storagedeal.Deal, error) {
+	switch {
+	case q.PieceRef.Defined():
+		return store.lookup(indexPiece, q.PieceRef.String())
+	case !q.Client.Empty():
+		return store.lookup(indexClient, q.Client.String())
+	case len(q.States) > 0:
+		var deals []*storagedeal.Deal
+		for _, state := range q.States {
+			found, err := store.lookup(indexState, stateIndexValue(state))
+			if err != nil {
+				return nil, err
+			}
+			deals = append(deals, found...)
+		}
+		return deals, nil
+	case !q.CreatedAfter.IsZero() || !q.CreatedBefore.IsZero():
+		var from, to string
+		if !q.CreatedAfter.IsZero() {
+			from = indexNumber(q.CreatedAfter.Unix())
+		}
+		if !q.CreatedBefore.IsZero() {
+			// index values are whole seconds, Matches compares the exact times
+			to = indexNumber(q.CreatedBefore.Unix() + 1)
+		}
+		return store.scan(indexCreated, from, to)
+	case q.ExpiresAfter != nil || q.ExpiresBefore != nil:
+		var from, to string
+		if q.ExpiresAfter != nil {
+			from = heightIndexValue(q.ExpiresAfter)
+		}
+		if q.ExpiresBefore != nil {
+			to = heightIndexValue(q.ExpiresBefore)
+		}
+		return store.scan(indexExpires, from, to)
+	case !q.Miner.Empty():
+		return store.lookup(indexMiner, q.Miner.String())
+	}
+
+	results, err := store.Iterator()
+	if err != nil {
+		return nil, err
+	}
+	var deals []*storagedeal.Deal
+	for entry := range (*results).Next() {
+		if entry.Error != nil {
+			return nil, errors.Wrap(entry.Error, "failed to query deals from datastore")
+		}
+		var deal storagedeal.Deal
+		if err := cbor.DecodeInto(entry.Value, &deal); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal deals from datastore")
+		}
+		deals = append(deals, &deal)
+	}
+	return deals, nil
+}
+
+// page returns limit deals, or all of them if limit is 0, after the first offset, in the order
+// of the created index.
+func (store *Store) page(offset, limit uint64) ([]*storagedeal.Deal, error) {
+	prefix := datastore.KeyWithNamespaces([]string{storageDealIndexPrefix, indexCreated})
+	results, err := store.dealsDs.Query(query.Query{
+		Prefix:   prefix.String(),
+		KeysOnly: true,
+		Orders:   []query.Order{query.OrderByKey{}},
+		Offset:   int(offset),
+		Limit:    int(limit),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query deal index from datastore")
+	}
+	defer results.Close() // nolint: errcheck
+
+	deals := []*storagedeal.Deal{}
+	for entry := range results.Next() {
+		if entry.Error != nil {
+			return nil, errors.Wrap(entry.Error, "failed to query deal index from datastore")
+		}
+		proposalCid, err := cid.Decode(datastore.NewKey(entry.Key).BaseNamespace())
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid deal index entry %s", entry.Key)
+		}
+		deal, err := store.get(proposalCid)
+		if err != nil {
+			return nil, err
+		}
+		deals = append(deals, deal)
+	}
+	return deals, nil
+}
+
+// lookup returns the deals indexed under the value of field. The prefix may match other
+// values starting with value, which Query filters out.
+func (store *Store) lookup(field, value string) ([]*storagedeal.Deal, error) {
+	prefix := datastore.KeyWithNamespaces([]string{storageDealIndexPrefix, field, value})
+	return store.indexedDeals(prefix, func(string) bool { return true })
+}
+
+// scan returns the deals indexed under a value of field from from, included, to to,
+// excluded. An empty bound leaves the range open on its side. Only the index keys out of the
+// range are read, not their deals.
+func (store *Store) scan(field, from, to string) ([]*storagedeal.Deal, error) {
+	prefix := datastore.KeyWithNamespaces([]string{storageDealIndexPrefix, field})
+	return store.indexedDeals(prefix, func(value string) bool {
+		return (from == "" || value >= from) && (to == "" || value < to)
+	})
+}
+
+// indexedDeals returns the deals of the index entries below prefix whose value is kept.
+func (store *Store) indexedDeals(prefix datastore.Key, keep func(value string) bool) ([]*storagedeal.Deal, error) {
+	results, err := store.dealsDs.Query(query.Query{Prefix: prefix.String(), KeysOnly: true})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query deal index from datastore")
+	}
+	defer results.Close() // nolint: errcheck
+
+	var deals []*storagedeal.Deal
+	for entry := range results.Next() {
+		if entry.Error != nil {
+			return nil, errors.Wrap(entry.Error, "failed to query deal index from datastore")
+		}
+		key := datastore.NewKey(entry.Key)
+		if !keep(key.Parent().BaseNamespace()) {
+			continue
+		}
+		proposalCid, err := cid.Decode(key.BaseNamespace())
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid deal index entry %s", entry.Key)
+		}
+		deal, err := store.get(proposalCid)
+		if err == datastore.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		deals = append(deals, deal)
+	}
+	return deals, nil
+}
+
+func (store *Store) get(proposalCid cid.Cid) (*storagedeal.Deal, error) {
+	key := datastore.KeyWithNamespaces([]string{StorageDealPrefix, proposalCid.String()})
+	datum, err := store.dealsDs.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var deal storagedeal.Deal
+	if err := cbor.DecodeInto(datum, &deal); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal deal from datastore")
+	}
+	return &deal, nil
+}
+
+// ensureIndex indexes the deals stored before the index existed. lk must be held.
+func (store *Store) ensureIndex() error {
+	if store.indexed {
+		return nil
+	}
+
+	versionKey := datastore.KeyWithNamespaces([]string{storageDealIndexPrefix, "version"})
+	version, err := store.dealsDs.Get(versionKey)
+	if err != nil && err != datastore.ErrNotFound {
+		return errors.Wrap(err, "failed to read deal index version")
+	}
+
+	if string(version) != indexVersion {
+		deals, err := store.candidates(Query{})
+		if err != nil {
+			return err
+		}
+		batch, err := store.dealsDs.Batch()
+		if err != nil {
+			return err
+		}
+		for _, deal := range deals {
+			if err := reindex(batch, nil, deal); err != nil {
+				return err
+			}
+		}
+		if err := batch.Put(versionKey, []byte(indexVersion)); err != nil {
+			return errors.Wrap(err, "failed to save deal index version")
+		}
+		if err := batch.Commit(); err != nil {
+			return errors.Wrap(err, "failed to save deal index")
+		}
+	}
+
+	store.indexed = true
+	return nil
+}
+
+// reindex replaces the index entries of the previous version of a deal, if any, with the
+// entries of its current version, in batch. lk must be held.
+func reindex(batch datastore.Batch, previous, current *storagedeal.Deal) error {
+	keep := make(map[datastore.Key]bool)
+	for _, key := range indexKeys(current) {
+		keep[key] = true
+	}
+
+	if previous != nil {
+		for _, key := range indexKeys(previous) {
+			if keep[key] {
+				continue
+			}
+			if err := batch.Delete(key); err != nil && err != datastore.ErrNotFound {
+				return errors.Wrap(err, "failed to remove deal index entry")
+			}
+		}
+	}
+
+	for key := range keep {
+		if err := batch.Put(key, []byte{}); err != nil {
+			return errors.Wrap(err, "failed to save deal index entry")
+		}
+	}
 	return nil
 }
+
+// indexKeys returns the index entries of a deal.
+func indexKeys(deal *storagedeal.Deal) []datastore.Key {
+	proposalCid := deal.Response.ProposalCid.String()
+	key := func(field, value string) datastore.Key {
+		return datastore.KeyWithNamespaces([]string{storageDealIndexPrefix, field, value, proposalCid})
+	}
+
+	keys := []datastore.Key{
+		key(indexState, stateIndexValue(deal.Response.State)),
+		key(indexCreated, createdIndexValue(deal)),
+	}
+	if !deal.Miner.Empty() {
+		keys = append(keys, key(indexMiner, deal.Miner.String()))
+	}
+	if deal.Proposal != nil {
+		if !deal.Proposal.Payment.Payer.Empty() {
+			keys = append(keys, key(indexClient, deal.Proposal.Payment.Payer.String()))
+		}
+		if deal.Proposal.PieceRef.Defined() {
+			keys = append(keys, key(indexPiece, deal.Proposal.PieceRef.String()))
+		}
+		if end := deal.Proposal.EndHeight(); end != nil {
+			keys = append(keys, key(indexExpires, heightIndexValue(end)))
+		}
+	}
+	return keys
+}
+
+func stateIndexValue(state storagedeal.State) string {
+	return strconv.Itoa(int(state))
+}
+
+// createdIndexValue orders deals by the time they entered their first state, deals without
+// one first, as Query does.
+func createdIndexValue(deal *storagedeal.Deal) string {
+	var created int64
+	if len(deal.History) > 0 {
+		created = deal.History[0].Time
+	}
+	return indexNumber(created)
+}
+
+// heightIndexValue pads a block height so that index values sort as their heights do.
+func heightIndexValue(height *types.BlockHeight) string {
+	return fmt.Sprintf("%020s", height.String())
+}
+
+// indexNumber pads n so that index values sort as their numbers do.
+func indexNumber(n int64) string {
+	return fmt.Sprintf("%020d", n)
+}
@@ -1,10 +1,14 @@
 package strgdls_test
 
 import (
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
 	cbor "github.com/ipfs/go-ipld-cbor"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	"testing"
 
 	"github.com/filecoin-project/go-filecoin/address"
 	"github.com/filecoin-project/go-filecoin/plumbing/strgdls"
@@ -96,3 +100,133 @@ func TestDealStoreRoundTrip(t *testing.T) {
 	assert.Equal(t, totalPrice, retrievedDeal.Proposal.Payment.Vouchers[0].Amount)
 	assert.Equal(t, *validAt, retrievedDeal.Proposal.Payment.Vouchers[0].ValidAt)
 }
+
+func TestDealStoreQuery(t *testing.T) {
+	tf.UnitTest(t)
+
+	addressMaker := address.NewForTestGetter()
+	minerA, minerB := addressMaker(), addressMaker()
+	clientA, clientB := addressMaker(), addressMaker()
+	pieceA, err := convert.ToCid("pieceA")
+	require.NoError(t, err)
+	pieceB, err := convert.ToCid("pieceB")
+	require.NoError(t, err)
+
+	duration := uint64(0)
+	newDeal := func(miner, client address.Address, piece cid.Cid, state storagedeal.State, created int64, end uint64) *storagedeal.Deal {
+		duration++
+		proposal := &storagedeal.Proposal{
+			PieceRef:     piece,
+			Duration:     duration,
+			MinerAddress: miner,
+			Payment: storagedeal.PaymentInfo{
+				Payer:    client,
+				Vouchers: []*types.PaymentVoucher{{ValidAt: *types.NewBlockHeight(end)}},
+			},
+		}
+		proposalCid, err := convert.ToCid(proposal)
+		require.NoError(t, err)
+		return &storagedeal.Deal{
+			Miner:    miner,
+			Proposal: proposal,
+			Response: &storagedeal.Response{State: state, ProposalCid: proposalCid},
+			History:  []storagedeal.StateChange{{State: state, Time: created}},
+		}
+	}
+
+	deals := []*storagedeal.Deal{
+		newDeal(minerA, clientA, pieceA, storagedeal.Accepted, 100, 1000),
+		newDeal(minerA, clientB, pieceB, storagedeal.Complete, 200, 2000),
+		newDeal(minerB, clientA, pieceA, storagedeal.Complete, 300, 3000),
+		newDeal(minerB, clientB, pieceB, storagedeal.Failed, 400, 4000),
+	}
+
+	setup := func(t *testing.T) *strgdls.Store {
+		store := strgdls.New(repo.NewInMemoryRepo().DealsDs)
+		// stored out of order, queries return deals oldest first
+		for i := len(deals) - 1; i >= 0; i-- {
+			require.NoError(t, store.Put(deals[i]))
+		}
+		return store
+	}
+
+	requireQuery := func(t *testing.T, store *strgdls.Store, q strgdls.Query, expected ...*storagedeal.Deal) {
+		found, err := store.Query(q)
+		require.NoError(t, err)
+		require.Len(t, found, len(expected))
+		for i, deal := range expected {
+			assert.Equal(t, deal.Response.ProposalCid, found[i].Response.ProposalCid)
+		}
+	}
+
+	t.Run("without filters returns every deal oldest first", func(t *testing.T) {
+		requireQuery(t, setup(t), strgdls.Query{}, deals...)
+	})
+
+	t.Run("filters by indexed fields", func(t *testing.T) {
+		store := setup(t)
+		requireQuery(t, store, strgdls.Query{States: []storagedeal.State{storagedeal.Complete}}, deals[1], deals[2])
+		requireQuery(t, store, strgdls.Query{States: []storagedeal.State{storagedeal.Accepted, storagedeal.Failed}}, deals[0], deals[3])
+		requireQuery(t, store, strgdls.Query{Miner: minerB}, deals[2], deals[3])
+		requireQuery(t, store, strgdls.Query{ExcludeMiner: minerB}, deals[0], deals[1])
+		requireQuery(t, store, strgdls.Query{Client: clientB}, deals[1], deals[3])
+		requireQuery(t, store, strgdls.Query{PieceRef: pieceA}, deals[0], deals[2])
+		requireQuery(t, store, strgdls.Query{PieceRef: pieceA, Miner: minerB, States: []storagedeal.State{storagedeal.Complete}}, deals[2])
+		requireQuery(t, store, strgdls.Query{Miner: minerA, States: []storagedeal.State{storagedeal.Complete, storagedeal.Failed}}, deals[1])
+	})
+
+	t.Run("filters by creation time and expiry", func(t *testing.T) {
+		store := setup(t)
+		requireQuery(t, store, strgdls.Query{CreatedAfter: time.Unix(200, 0), CreatedBefore: time.Unix(400, 0)}, deals[1], deals[2])
+		requireQuery(t, store, strgdls.Query{ExpiresAfter: types.NewBlockHeight(3000)}, deals[2], deals[3])
+		requireQuery(t, store, strgdls.Query{ExpiresBefore: types.NewBlockHeight(3000)}, deals[0], deals[1])
+		requireQuery(t, store, strgdls.Query{CreatedAfter: time.Unix(200, 1), CreatedBefore: time.Unix(400, 1)}, deals[2], deals[3])
+		requireQuery(t, store, strgdls.Query{ExpiresAfter: types.NewBlockHeight(999), ExpiresBefore: types.NewBlockHeight(2001)}, deals[0], deals[1])
+		requireQuery(t, store, strgdls.Query{CreatedAfter: time.Unix(200, 0), ExpiresBefore: types.NewBlockHeight(4000), Miner: minerB}, deals[2])
+	})
+
+	t.Run("pages through the results", func(t *testing.T) {
+		store := setup(t)
+		requireQuery(t, store, strgdls.Query{Limit: 2}, deals[0], deals[1])
+		requireQuery(t, store, strgdls.Query{Offset: 2, Limit: 2}, deals[2], deals[3])
+		requireQuery(t, store, strgdls.Query{Offset: 3, Limit: 2}, deals[3])
+		requireQuery(t, store, strgdls.Query{Offset: 4})
+	})
+
+	t.Run("updates the index when a deal changes", func(t *testing.T) {
+		store := setup(t)
+
+		updated := *deals[0]
+		updated.Response = &storagedeal.Response{State: storagedeal.Complete, ProposalCid: deals[0].Response.ProposalCid}
+		require.NoError(t, store.Put(&updated))
+
+		requireQuery(t, store, strgdls.Query{States: []storagedeal.State{storagedeal.Accepted}})
+		requireQuery(t, store, strgdls.Query{States: []storagedeal.State{storagedeal.Complete}}, deals[0], deals[1], deals[2])
+	})
+
+	t.Run("indexes deals stored before the index existed", func(t *testing.T) {
+		dealsDs := repo.NewInMemoryRepo().DealsDs
+		for _, deal := range deals {
+			datum, err := cbor.DumpObject(deal)
+			require.NoError(t, err)
+			key := datastore.KeyWithNamespaces([]string{strgdls.StorageDealPrefix, deal.Response.ProposalCid.String()})
+			require.NoError(t, dealsDs.Put(key, datum))
+		}
+
+		store := strgdls.New(dealsDs)
+		requireQuery(t, store, strgdls.Query{Miner: minerA}, deals[0], deals[1])
+		requireQuery(t, store, strgdls.Query{Offset: 1, Limit: 2}, deals[1], deals[2])
+	})
+
+	t.Run("iterator skips index entries", func(t *testing.T) {
+		store := setup(t)
+		_, err := store.Query(strgdls.Query{})
+		require.NoError(t, err)
+
+		results, err := store.Iterator()
+		require.NoError(t, err)
+		entries, err := (*results).Rest()
+		require.NoError(t, err)
+		assert.Len(t, entries, len(deals))
+	})
+}
@@ -2,10 +2,12 @@ package porcelain
 
 import (
 	"context"
+	"io"
 	"math/big"
 	"time"
 
 	"github.com/ipfs/go-cid"
+	ipld "github.com/ipfs/go-ipld-format"
 	"github.com/libp2p/go-libp2p-peer"
 
 	minerActor "github.com/filecoin-project/go-filecoin/actor/builtin/miner"
@@ -61,8 +63,8 @@ func (a *API) DealGet(ctx context.Context, proposalCid cid.Cid) (*storagedeal.De
 
 // DealRedeem redeems a voucher for the deal with the given cid and returns
 // either the cid of the created redeem message or an error
-func (a *API) DealRedeem(ctx context.Context, fromAddr address.Address, dealCid cid.Cid, gasPrice types.AttoFIL, gasLimit types.GasUnits) (cid.Cid, error) {
-	return DealRedeem(ctx, a, fromAddr, dealCid, gasPrice, gasLimit)
+func (a *API) DealRedeem(ctx context.Context, fromAddr address.Address, dealCid cid.Cid, gasPrice types.AttoFIL, optGasLimit *types.GasUnits) (cid.Cid, error) {
+	return DealRedeem(ctx, a, fromAddr, dealCid, gasPrice, optGasLimit)
 }
 
 // DealRedeemPreview previews the redeem method for a deal and returns the
@@ -83,14 +85,14 @@ func (a *API) MessagePoolWait(ctx context.Context, messageCount uint) ([]*types.
 }
 
 // MessageSendWithDefaultAddress calls MessageSend but with a default from
-// address if none is provided
+// address if none is provided, and an estimated gas limit if none is given
 func (a *API) MessageSendWithDefaultAddress(
 	ctx context.Context,
 	from,
 	to address.Address,
 	value types.AttoFIL,
 	gasPrice types.AttoFIL,
-	gasLimit types.GasUnits,
+	optGasLimit *types.GasUnits,
 	method string,
 	params ...interface{},
 ) (cid.Cid, error) {
@@ -101,23 +103,29 @@ func (a *API) MessageSendWithDefaultAddress(
 		to,
 		value,
 		gasPrice,
-		gasLimit,
+		optGasLimit,
 		method,
 		params...,
 	)
 }
 
+// MessageReplaceWithDefaultGas replaces a stuck outbox message with a higher priced copy,
+// picking the minimum acceptable gas price and keeping the gas limit when they are not given
+func (a *API) MessageReplaceWithDefaultGas(ctx context.Context, msgCid cid.Cid, optGasPrice types.AttoFIL, optGasLimit types.GasUnits) (cid.Cid, error) {
+	return MessageReplaceWithDefaultGas(ctx, a, msgCid, optGasPrice, optGasLimit)
+}
+
 // MinerCreate creates a miner
 func (a *API) MinerCreate(
 	ctx context.Context,
 	accountAddr address.Address,
 	gasPrice types.AttoFIL,
-	gasLimit types.GasUnits,
+	optGasLimit *types.GasUnits,
 	sectorSize *types.BytesAmount,
 	pid peer.ID,
 	collateral types.AttoFIL,
 ) (_ *address.Address, err error) {
-	return MinerCreate(ctx, a, accountAddr, gasPrice, gasLimit, sectorSize, pid, collateral)
+	return MinerCreate(ctx, a, accountAddr, gasPrice, optGasLimit, sectorSize, pid, collateral)
 }
 
 // MinerPreviewCreate previews the Gas cost of creating a miner
@@ -161,8 +169,23 @@ func (a *API) MinerGetPeerID(ctx context.Context, minerAddr address.Address) (pe
 }
 
 // MinerSetPrice configures the price of storage. See implementation for details.
-func (a *API) MinerSetPrice(ctx context.Context, from address.Address, miner address.Address, gasPrice types.AttoFIL, gasLimit types.GasUnits, price types.AttoFIL, expiry *big.Int) (MinerSetPriceResponse, error) {
-	return MinerSetPrice(ctx, a, from, miner, gasPrice, gasLimit, price, expiry)
+func (a *API) MinerSetPrice(ctx context.Context, from address.Address, miner address.Address, gasPrice types.AttoFIL, optGasLimit *types.GasUnits, price types.AttoFIL, expiry *big.Int) (MinerSetPriceResponse, error) {
+	return MinerSetPrice(ctx, a, from, miner, gasPrice, optGasLimit, price, expiry)
+}
+
+// MinerListAsks queries for the asks of the given miner
+func (a *API) MinerListAsks(ctx context.Context, minerAddr address.Address, includeExpired bool) ([]minerActor.Ask, error) {
+	return MinerListAsks(ctx, a, minerAddr, includeExpired)
+}
+
+// MinerCancelAsk withdraws an ask of the given miner
+func (a *API) MinerCancelAsk(ctx context.Context, from address.Address, minerAddr address.Address, gasPrice types.AttoFIL, optGasLimit *types.GasUnits, askID uint64) (cid.Cid, error) {
+	return MinerCancelAsk(ctx, a, from, minerAddr, gasPrice, optGasLimit, askID)
+}
+
+// MinerPruneExpiredAsks removes the expired asks of the given miner
+func (a *API) MinerPruneExpiredAsks(ctx context.Context, from address.Address, minerAddr address.Address, gasPrice types.AttoFIL, optGasLimit *types.GasUnits) (uint64, error) {
+	return MinerPruneExpiredAsks(ctx, a, from, minerAddr, gasPrice, optGasLimit)
 }
 
 // MinerPreviewSetPrice calculates the amount of Gas needed for a call to MinerSetPrice.
@@ -193,6 +216,12 @@ func (a *API) WalletDefaultAddress() (address.Address, error) {
 	return WalletDefaultAddress(a)
 }
 
+// WalletHDRescan adds the addresses derived from the wallet's seed that have been used on chain
+// to the wallet, stopping after gap consecutive unused addresses.
+func (a *API) WalletHDRescan(ctx context.Context, gap uint32) ([]address.Address, error) {
+	return WalletHDRescan(ctx, a, gap)
+}
+
 // PaymentChannelLs lists payment channels for a given payer
 func (a *API) PaymentChannelLs(
 	ctx context.Context,
@@ -214,11 +243,33 @@ func (a *API) PaymentChannelVoucher(
 	return PaymentChannelVoucher(ctx, a, fromAddr, channel, amount, validAt, condition)
 }
 
-// ClientListAsks returns a channel with asks from the latest chain state
+// ClientListAsks returns a channel with the asks that haven't expired from the latest chain state
 func (a *API) ClientListAsks(ctx context.Context) <-chan Ask {
 	return ClientListAsks(ctx, a)
 }
 
+// ClientListAllAsks returns a channel with asks from the latest chain state, expired or not
+func (a *API) ClientListAllAsks(ctx context.Context) <-chan Ask {
+	return ClientListAllAsks(ctx, a)
+}
+
+// ClientImportEncrypted encrypts data with key, or a new key if key is nil, imports the
+// encrypted data and keeps the key by the root of the imported data.
+func (a *API) ClientImportEncrypted(ctx context.Context, data io.Reader, key []byte) (ipld.Node, error) {
+	return ClientImportEncrypted(ctx, a, data, key)
+}
+
+// ClientDecrypt returns a reader of the data with the given root read from r, decrypted if
+// the data was encrypted with a known key.
+func (a *API) ClientDecrypt(root cid.Cid, r io.Reader) (io.Reader, error) {
+	return ClientDecrypt(a, root, r)
+}
+
+// ClientVerifyDeal verifies that the data of a complete storage deal is still proven
+func (a *API) ClientVerifyDeal(ctx context.Context, proposalCid cid.Cid) (*DealHealth, error) {
+	return ClientVerifyDeal(ctx, a, proposalCid)
+}
+
 // PingMinerWithTimeout pings a storage or retrieval miner, waiting the given
 // timeout and returning desciptive errors.
 func (a *API) PingMinerWithTimeout(
@@ -2,14 +2,24 @@ package porcelain
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"math/big"
+	"strconv"
 
 	"github.com/filecoin-project/go-filecoin/actor/builtin/miner"
 	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/exec"
+	"github.com/filecoin-project/go-filecoin/plumbing/datakeys"
+	"github.com/filecoin-project/go-filecoin/protocol/storage/storagedeal"
 	"github.com/filecoin-project/go-filecoin/state"
 	"github.com/filecoin-project/go-filecoin/types"
+	vmErrors "github.com/filecoin-project/go-filecoin/vm/errors"
 
+	"github.com/ipfs/go-cid"
 	cbor "github.com/ipfs/go-ipld-cbor"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/pkg/errors"
 )
 
 // Ask is a result of querying for an ask, it may contain an error
@@ -24,11 +34,21 @@ type Ask struct {
 
 type claPlubming interface {
 	ActorLs(ctx context.Context) (<-chan state.GetAllActorsResult, error)
+	ChainBlockHeight() (*types.BlockHeight, error)
 	MessageQuery(ctx context.Context, optFrom, to address.Address, method string, params ...interface{}) ([][]byte, error)
 }
 
-// ClientListAsks returns a channel with asks from the latest chain state
+// ClientListAsks returns a channel with the asks that haven't expired from the latest chain state
 func ClientListAsks(ctx context.Context, plumbing claPlubming) <-chan Ask {
+	return listAsks(ctx, plumbing, false)
+}
+
+// ClientListAllAsks returns a channel with asks from the latest chain state, expired or not
+func ClientListAllAsks(ctx context.Context, plumbing claPlubming) <-chan Ask {
+	return listAsks(ctx, plumbing, true)
+}
+
+func listAsks(ctx context.Context, plumbing claPlubming, includeExpired bool) <-chan Ask {
 	out := make(chan Ask)
 
 	go func() {
@@ -41,8 +61,20 @@ func ClientListAsks(ctx context.Context, plumbing claPlubming) <-chan Ask {
 			return
 		}
 
+		// asks expire once the chain reaches their expiry
+		var height *types.BlockHeight
+		if !includeExpired {
+			height, err = plumbing.ChainBlockHeight()
+			if err != nil {
+				out <- Ask{
+					Error: err,
+				}
+				return
+			}
+		}
+
 		for actorResult := range actorCh {
-			err := listAsksFromActorResult(ctx, plumbing, actorResult, out)
+			err := listAsksFromActorResult(ctx, plumbing, actorResult, height, out)
 			if err != nil {
 				out <- Ask{
 					Error: err,
@@ -55,7 +87,9 @@ func ClientListAsks(ctx context.Context, plumbing claPlubming) <-chan Ask {
 	return out
 }
 
-func listAsksFromActorResult(ctx context.Context, plumbing claPlubming, actorResult state.GetAllActorsResult, out chan Ask) error {
+// listAsksFromActorResult sends the asks of a miner actor to out, skipping the asks expired at
+// height unless height is nil.
+func listAsksFromActorResult(ctx context.Context, plumbing claPlubming, actorResult state.GetAllActorsResult, height *types.BlockHeight, out chan Ask) error {
 	if actorResult.Error != nil {
 		return actorResult.Error
 	}
@@ -84,6 +118,9 @@ func listAsksFromActorResult(ctx context.Context, plumbing claPlubming, actorRes
 		if err != nil {
 			return err
 		}
+		if height != nil && ask.Expiry != nil && height.GreaterEqual(ask.Expiry) {
+			continue
+		}
 
 		out <- ask
 	}
@@ -109,3 +146,174 @@ func getAskByID(ctx context.Context, plumbing claPlubming, addr address.Address,
 		Miner:  addr,
 	}, nil
 }
+
+type ciePlumbing interface {
+	DAGImportData(ctx context.Context, data io.Reader) (ipld.Node, error)
+	DataKeyPut(root cid.Cid, key []byte) error
+}
+
+// ClientImportEncrypted encrypts data with key, or a new key if key is nil, imports the
+// encrypted data and keeps the key by the root of the imported data.
+func ClientImportEncrypted(ctx context.Context, plumbing ciePlumbing, data io.Reader, key []byte) (ipld.Node, error) {
+	if key == nil {
+		var err error
+		if key, err = datakeys.NewKey(); err != nil {
+			return nil, err
+		}
+	}
+
+	encrypted, err := datakeys.Encrypt(key, data)
+	if err != nil {
+		return nil, err
+	}
+	nd, err := plumbing.DAGImportData(ctx, encrypted)
+	if err != nil {
+		return nil, err
+	}
+	if err := plumbing.DataKeyPut(nd.Cid(), key); err != nil {
+		return nil, err
+	}
+	return nd, nil
+}
+
+type cdPlumbing interface {
+	DataKeyGet(root cid.Cid) ([]byte, error)
+}
+
+// ClientDecrypt returns a reader of the data with the given root read from r, decrypted if
+// the data was encrypted with a known key.
+func ClientDecrypt(plumbing cdPlumbing, root cid.Cid, r io.Reader) (io.Reader, error) {
+	key, err := plumbing.DataKeyGet(root)
+	if err == datakeys.ErrKeyNotFound {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return datakeys.Decrypt(key, r)
+}
+
+// DealVerdict is the verdict of verifying that the data of a storage deal is still proven.
+type DealVerdict string
+
+const (
+	// DealHealthy is a deal whose data is proven on chain.
+	DealHealthy = DealVerdict("healthy")
+	// DealPending is a deal whose data can't be proven on chain until the miner's next PoSt,
+	// e.g. because the miner hasn't submitted one since the deal completed.
+	DealPending = DealVerdict("pending")
+	// DealAtRisk is a deal whose data is proven, but whose miner is late with its PoSt or
+	// whose term is over.
+	DealAtRisk = DealVerdict("at-risk")
+	// DealLost is a deal whose data is no longer proven on chain.
+	DealLost = DealVerdict("lost")
+)
+
+// DealHealth is the outcome of verifying a complete storage deal.
+type DealHealth struct {
+	ProposalCid cid.Cid         `json:"proposalCid"`
+	Miner       address.Address `json:"miner"`
+	SectorID    uint64          `json:"sectorId"`
+	Verdict     DealVerdict     `json:"verdict"`
+	// Problems explains a verdict other than healthy.
+	Problems []string `json:"problems"`
+}
+
+type cvdPlumbing interface {
+	ActorGetSignature(ctx context.Context, actorAddr address.Address, method string) (*exec.FunctionSignature, error)
+	ChainBlockHeight() (*types.BlockHeight, error)
+	DealGet(context.Context, cid.Cid) (*storagedeal.Deal, error)
+	MessageQuery(ctx context.Context, optFrom, to address.Address, method string, params ...interface{}) ([][]byte, error)
+}
+
+// ClientVerifyDeal verifies that the data of a complete storage deal is still proven: that
+// the sector holding it is committed on chain, that the miner is up to date with its PoSts
+// and that the piece inclusion proof the miner gave verifies against the sector.
+func ClientVerifyDeal(ctx context.Context, plumbing cvdPlumbing, proposalCid cid.Cid) (*DealHealth, error) {
+	deal, err := plumbing.DealGet(ctx, proposalCid)
+	if err != nil {
+		return nil, err
+	}
+	if deal.Response.State != storagedeal.Complete {
+		return nil, fmt.Errorf("deal is %s, only complete deals can be verified", deal.Response.State)
+	}
+	proofInfo := deal.Response.ProofInfo
+	if proofInfo == nil {
+		return nil, errors.New("deal has no proof info to verify")
+	}
+
+	height, err := plumbing.ChainBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	health := &DealHealth{
+		ProposalCid: proposalCid,
+		Miner:       deal.Miner,
+		SectorID:    proofInfo.SectorID,
+		Verdict:     DealHealthy,
+	}
+	atRisk := func(format string, args ...interface{}) {
+		if health.Verdict == DealHealthy {
+			health.Verdict = DealAtRisk
+		}
+		health.Problems = append(health.Problems, fmt.Sprintf(format, args...))
+	}
+	lost := func(format string, args ...interface{}) {
+		health.Verdict = DealLost
+		health.Problems = append(health.Problems, fmt.Sprintf(format, args...))
+	}
+
+	if end := deal.Proposal.EndHeight(); end != nil && height.GreaterThan(end) {
+		atRisk("deal ended at block %s", end)
+	}
+
+	commitmentsVal, err := queryAndDeserialize(ctx, plumbing, deal.Miner, "getSectorCommitments")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get sector commitments")
+	}
+	commitments, ok := commitmentsVal.Val.(map[string]types.Commitments)
+	if !ok {
+		return nil, errors.New("failed to convert returned ABI value")
+	}
+	if _, ok := commitments[strconv.FormatUint(proofInfo.SectorID, 10)]; !ok {
+		lost("sector %d is not committed", proofInfo.SectorID)
+	}
+
+	res, err := plumbing.MessageQuery(ctx, address.Undef, deal.Miner, "getProvingPeriod")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get proving period")
+	}
+	periodEnd := types.NewBlockHeightFromBytes(res[1])
+	sectorSize, err := MinerGetSectorSize(ctx, plumbing, deal.Miner)
+	if err != nil {
+		return nil, err
+	}
+	if grace := miner.GenerationAttackTime(sectorSize); height.GreaterThan(periodEnd.Add(grace)) {
+		lost("miner missed the PoSt of the proving period ending at block %s", periodEnd)
+	} else if height.GreaterThan(periodEnd) {
+		atRisk("miner's PoSt is %s blocks late", height.Sub(periodEnd))
+	}
+
+	// TODO This is fake. CommP should be the merkle root of data, rather than its CID (issue #2792)
+	var commP types.CommP
+	copy(commP[:], deal.Proposal.PieceRef.Bytes())
+	_, err = plumbing.MessageQuery(ctx, address.Undef, deal.Miner, "verifyPieceInclusion", commP[:], proofInfo.SectorID, proofInfo.PieceInclusionProof)
+	switch vmErrors.CodeError(errors.Cause(err)) {
+	case 0:
+	case miner.ErrProofsOutOfDate:
+		// The miner actor verifies no proofs before the miner's first PoSt, nor once its last
+		// PoSt is older than the piece inclusion grace period. Whether the miner is late with
+		// its PoSt was checked above.
+		if health.Verdict == DealHealthy {
+			health.Verdict = DealPending
+		}
+		health.Problems = append(health.Problems, "piece inclusion can't be verified until the miner's next PoSt")
+	case miner.ErrSectorNotCommitted, miner.ErrInvalidInclusionProof:
+		lost("piece inclusion proof does not verify: %s", err)
+	default:
+		return nil, errors.Wrap(err, "failed to verify piece inclusion proof")
+	}
+
+	return health, nil
+}
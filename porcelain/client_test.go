@@ -1,31 +1,52 @@
 package porcelain_test
 
 import (
+	"bytes"
 	"context"
+	"io"
+	"io/ioutil"
 	"math/big"
 	"testing"
 
+	"github.com/filecoin-project/go-filecoin/abi"
 	"github.com/filecoin-project/go-filecoin/actor"
+	"github.com/filecoin-project/go-filecoin/actor/builtin"
 	"github.com/filecoin-project/go-filecoin/actor/builtin/miner"
 	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/exec"
+	"github.com/filecoin-project/go-filecoin/plumbing/datakeys"
 	"github.com/filecoin-project/go-filecoin/porcelain"
+	"github.com/filecoin-project/go-filecoin/protocol/storage/storagedeal"
 	"github.com/filecoin-project/go-filecoin/state"
 	"github.com/filecoin-project/go-filecoin/types"
+	vmErrors "github.com/filecoin-project/go-filecoin/vm/errors"
 
 	tf "github.com/filecoin-project/go-filecoin/testhelpers/testflags"
+	"github.com/ipfs/go-cid"
 	cbor "github.com/ipfs/go-ipld-cbor"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/ipfs/go-merkledag"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type claPlumbing struct {
 	actorFail   bool
 	actorChFail bool
 	messageFail bool
+	height      *types.BlockHeight
 
 	MinerAddress address.Address
 }
 
+func (cla *claPlumbing) ChainBlockHeight() (*types.BlockHeight, error) {
+	if cla.height == nil {
+		return types.NewBlockHeight(0), nil
+	}
+	return cla.height, nil
+}
+
 func (cla *claPlumbing) ActorLs(ctx context.Context) (<-chan state.GetAllActorsResult, error) {
 	out := make(chan state.GetAllActorsResult)
 
@@ -93,6 +114,24 @@ func TestClientListAsks(t *testing.T) {
 		assert.Equal(t, expectedResult, result)
 	})
 
+	t.Run("skips expired asks", func(t *testing.T) {
+		ctx := context.Background()
+		plumbing := &claPlumbing{height: types.NewBlockHeight(1)}
+
+		var asks []porcelain.Ask
+		for ask := range porcelain.ClientListAsks(ctx, plumbing) {
+			asks = append(asks, ask)
+		}
+		assert.Empty(t, asks)
+
+		asks = nil
+		for ask := range porcelain.ClientListAllAsks(ctx, plumbing) {
+			require.NoError(t, ask.Error)
+			asks = append(asks, ask)
+		}
+		assert.Len(t, asks, 42)
+	})
+
 	t.Run("failed actor ls", func(t *testing.T) {
 		ctx := context.Background()
 		plumbing := &claPlumbing{
@@ -129,3 +168,261 @@ func TestClientListAsks(t *testing.T) {
 		assert.Error(t, result.Error, "MESSAGE FAILURE")
 	})
 }
+
+type cdPlumbing struct {
+	keys map[cid.Cid][]byte
+	// imported holds the data imported, by root
+	imported map[cid.Cid][]byte
+}
+
+func newCDPlumbing() *cdPlumbing {
+	return &cdPlumbing{
+		keys:     make(map[cid.Cid][]byte),
+		imported: make(map[cid.Cid][]byte),
+	}
+}
+
+func (cdp *cdPlumbing) DAGImportData(ctx context.Context, data io.Reader) (ipld.Node, error) {
+	b, err := ioutil.ReadAll(data)
+	if err != nil {
+		return nil, err
+	}
+	nd := merkledag.NewRawNode(b)
+	cdp.imported[nd.Cid()] = b
+	return nd, nil
+}
+
+func (cdp *cdPlumbing) DataKeyPut(root cid.Cid, key []byte) error {
+	cdp.keys[root] = key
+	return nil
+}
+
+func (cdp *cdPlumbing) DataKeyGet(root cid.Cid) ([]byte, error) {
+	key, ok := cdp.keys[root]
+	if !ok {
+		return nil, datakeys.ErrKeyNotFound
+	}
+	return key, nil
+}
+
+func TestClientImportEncrypted(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	data := []byte("some data that should not be readable by the miner")
+
+	t.Run("imports encrypted data and keeps a new key", func(t *testing.T) {
+		plumbing := newCDPlumbing()
+
+		nd, err := porcelain.ClientImportEncrypted(ctx, plumbing, bytes.NewReader(data), nil)
+		require.NoError(t, err)
+
+		assert.NotContains(t, string(plumbing.imported[nd.Cid()]), string(data))
+		assert.Len(t, plumbing.keys[nd.Cid()], datakeys.KeySize)
+
+		r, err := porcelain.ClientDecrypt(plumbing, nd.Cid(), bytes.NewReader(plumbing.imported[nd.Cid()]))
+		require.NoError(t, err)
+		decrypted, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, data, decrypted)
+	})
+
+	t.Run("uses the key given", func(t *testing.T) {
+		plumbing := newCDPlumbing()
+		key, err := datakeys.NewKey()
+		require.NoError(t, err)
+
+		nd, err := porcelain.ClientImportEncrypted(ctx, plumbing, bytes.NewReader(data), key)
+		require.NoError(t, err)
+		assert.Equal(t, key, plumbing.keys[nd.Cid()])
+	})
+}
+
+func TestClientDecrypt(t *testing.T) {
+	tf.UnitTest(t)
+
+	t.Run("passes through data without a key", func(t *testing.T) {
+		plumbing := newCDPlumbing()
+
+		r, err := porcelain.ClientDecrypt(plumbing, types.SomeCid(), bytes.NewReader([]byte("plain")))
+		require.NoError(t, err)
+		out, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("plain"), out)
+	})
+}
+
+type cvdPlumbing struct {
+	t           *testing.T
+	height      *types.BlockHeight
+	deal        *storagedeal.Deal
+	sectors     map[string]types.Commitments
+	periodEnd   *types.BlockHeight
+	verifyError error
+}
+
+func newCVDPlumbing(t *testing.T) *cvdPlumbing {
+	newCid := types.NewCidForTestGetter()
+	return &cvdPlumbing{
+		t:      t,
+		height: types.NewBlockHeight(500),
+		deal: &storagedeal.Deal{
+			Miner: address.NewForTestGetter()(),
+			Proposal: &storagedeal.Proposal{
+				PieceRef: newCid(),
+				Payment: storagedeal.PaymentInfo{
+					Vouchers: []*types.PaymentVoucher{{ValidAt: *types.NewBlockHeight(1000)}},
+				},
+			},
+			Response: &storagedeal.Response{
+				State:       storagedeal.Complete,
+				ProposalCid: newCid(),
+				ProofInfo:   &storagedeal.ProofInfo{SectorID: 42, PieceInclusionProof: []byte{1, 2, 3}},
+			},
+		},
+		sectors:   map[string]types.Commitments{"42": {}},
+		periodEnd: types.NewBlockHeight(600),
+	}
+}
+
+func (cvd *cvdPlumbing) ActorGetSignature(ctx context.Context, actorAddr address.Address, method string) (*exec.FunctionSignature, error) {
+	return builtin.Actors[types.MinerActorCodeCid].Exports()[method], nil
+}
+
+func (cvd *cvdPlumbing) ChainBlockHeight() (*types.BlockHeight, error) {
+	return cvd.height, nil
+}
+
+func (cvd *cvdPlumbing) DealGet(ctx context.Context, proposalCid cid.Cid) (*storagedeal.Deal, error) {
+	if proposalCid != cvd.deal.Response.ProposalCid {
+		return nil, porcelain.ErrDealNotFound
+	}
+	return cvd.deal, nil
+}
+
+func (cvd *cvdPlumbing) MessageQuery(ctx context.Context, optFrom, to address.Address, method string, params ...interface{}) ([][]byte, error) {
+	switch method {
+	case "getSectorCommitments":
+		values, err := abi.ToValues([]interface{}{cvd.sectors})
+		require.NoError(cvd.t, err)
+		encoded, err := values[0].Serialize()
+		require.NoError(cvd.t, err)
+		return [][]byte{encoded}, nil
+	case "getProvingPeriod":
+		return [][]byte{types.NewBlockHeight(0).Bytes(), cvd.periodEnd.Bytes()}, nil
+	case "getSectorSize":
+		return [][]byte{types.OneKiBSectorSize.Bytes()}, nil
+	case "verifyPieceInclusion":
+		assert.Equal(cvd.t, uint64(42), params[1])
+		assert.Equal(cvd.t, []byte{1, 2, 3}, params[2])
+		return nil, cvd.verifyError
+	}
+	return nil, errors.Errorf("unexpected query %s", method)
+}
+
+func TestClientVerifyDeal(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+
+	t.Run("healthy deal", func(t *testing.T) {
+		plumbing := newCVDPlumbing(t)
+
+		health, err := porcelain.ClientVerifyDeal(ctx, plumbing, plumbing.deal.Response.ProposalCid)
+		require.NoError(t, err)
+		assert.Equal(t, porcelain.DealHealthy, health.Verdict)
+		assert.Equal(t, uint64(42), health.SectorID)
+		assert.Empty(t, health.Problems)
+	})
+
+	t.Run("at risk when the PoSt is late", func(t *testing.T) {
+		plumbing := newCVDPlumbing(t)
+		plumbing.height = types.NewBlockHeight(650)
+
+		health, err := porcelain.ClientVerifyDeal(ctx, plumbing, plumbing.deal.Response.ProposalCid)
+		require.NoError(t, err)
+		assert.Equal(t, porcelain.DealAtRisk, health.Verdict)
+		require.Len(t, health.Problems, 1)
+		assert.Contains(t, health.Problems[0], "50 blocks late")
+	})
+
+	t.Run("at risk when the deal ended", func(t *testing.T) {
+		plumbing := newCVDPlumbing(t)
+		plumbing.height = types.NewBlockHeight(1500)
+		plumbing.periodEnd = types.NewBlockHeight(1600)
+
+		health, err := porcelain.ClientVerifyDeal(ctx, plumbing, plumbing.deal.Response.ProposalCid)
+		require.NoError(t, err)
+		assert.Equal(t, porcelain.DealAtRisk, health.Verdict)
+		assert.Contains(t, health.Problems[0], "deal ended")
+	})
+
+	t.Run("lost when the PoSt was missed", func(t *testing.T) {
+		plumbing := newCVDPlumbing(t)
+		plumbing.height = types.NewBlockHeight(800)
+
+		health, err := porcelain.ClientVerifyDeal(ctx, plumbing, plumbing.deal.Response.ProposalCid)
+		require.NoError(t, err)
+		assert.Equal(t, porcelain.DealLost, health.Verdict)
+		assert.Contains(t, health.Problems[0], "missed the PoSt")
+	})
+
+	t.Run("lost when the sector is gone and the proof fails", func(t *testing.T) {
+		plumbing := newCVDPlumbing(t)
+		plumbing.sectors = map[string]types.Commitments{"7": {}}
+		plumbing.verifyError = errors.Wrap(miner.Errors[miner.ErrSectorNotCommitted], "querymethod returned an error")
+
+		health, err := porcelain.ClientVerifyDeal(ctx, plumbing, plumbing.deal.Response.ProposalCid)
+		require.NoError(t, err)
+		assert.Equal(t, porcelain.DealLost, health.Verdict)
+		require.Len(t, health.Problems, 2)
+		assert.Contains(t, health.Problems[0], "sector 42 is not committed")
+		assert.Contains(t, health.Problems[1], "does not verify")
+	})
+
+	t.Run("pending when completed before the miner's first PoSt", func(t *testing.T) {
+		plumbing := newCVDPlumbing(t)
+		plumbing.verifyError = errors.Wrap(miner.Errors[miner.ErrProofsOutOfDate], "querymethod returned an error")
+
+		health, err := porcelain.ClientVerifyDeal(ctx, plumbing, plumbing.deal.Response.ProposalCid)
+		require.NoError(t, err)
+		assert.Equal(t, porcelain.DealPending, health.Verdict)
+		require.Len(t, health.Problems, 1)
+		assert.Contains(t, health.Problems[0], "next PoSt")
+	})
+
+	t.Run("stays at risk when proofs are out of date and the PoSt is late", func(t *testing.T) {
+		plumbing := newCVDPlumbing(t)
+		plumbing.height = types.NewBlockHeight(650)
+		plumbing.verifyError = errors.Wrap(miner.Errors[miner.ErrProofsOutOfDate], "querymethod returned an error")
+
+		health, err := porcelain.ClientVerifyDeal(ctx, plumbing, plumbing.deal.Response.ProposalCid)
+		require.NoError(t, err)
+		assert.Equal(t, porcelain.DealAtRisk, health.Verdict)
+	})
+
+	t.Run("fails when the proof can't be checked", func(t *testing.T) {
+		plumbing := newCVDPlumbing(t)
+		plumbing.verifyError = errors.New("connection refused")
+
+		_, err := porcelain.ClientVerifyDeal(ctx, plumbing, plumbing.deal.Response.ProposalCid)
+		assert.Error(t, err)
+	})
+
+	t.Run("fails on other reverts of the miner actor", func(t *testing.T) {
+		plumbing := newCVDPlumbing(t)
+		plumbing.verifyError = errors.Wrap(vmErrors.NewRevertError("proofs out of date"), "querymethod returned an error")
+
+		_, err := porcelain.ClientVerifyDeal(ctx, plumbing, plumbing.deal.Response.ProposalCid)
+		assert.Error(t, err)
+	})
+
+	t.Run("fails for deals that aren't complete", func(t *testing.T) {
+		plumbing := newCVDPlumbing(t)
+		plumbing.deal.Response.State = storagedeal.Staged
+
+		_, err := porcelain.ClientVerifyDeal(ctx, plumbing, plumbing.deal.Response.ProposalCid)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "only complete deals")
+	})
+}
@@ -2,10 +2,13 @@ package porcelain
 
 import (
 	"context"
+
 	"github.com/ipfs/go-cid"
 	logging "github.com/ipfs/go-log"
+	"github.com/pkg/errors"
 
 	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/core"
 	"github.com/filecoin-project/go-filecoin/types"
 )
 
@@ -13,12 +16,14 @@ var log = logging.Logger("porcelain") // nolint: deadcode
 
 // mswdaAPI is the subset of the plumbing.API that MessageSendWithDefaultAddress uses.
 type mswdaAPI interface {
+	MessageEstimateGasLimit(ctx context.Context, from, to address.Address, method string, params ...interface{}) (types.GasUnits, error)
 	MessageSend(ctx context.Context, from, to address.Address, value types.AttoFIL, gasPrice types.AttoFIL, gasLimit types.GasUnits, method string, params ...interface{}) (cid.Cid, error)
 	WalletDefaultAddress() (address.Address, error)
 }
 
 // MessageSendWithDefaultAddress calls MessageSend but with a default from
-// address if none is provided. If you don't need a default address provided,
+// address if none is provided, and a gas limit estimated by previewing the
+// message if optGasLimit is nil. If you don't need a default address provided,
 // use MessageSend instead.
 func MessageSendWithDefaultAddress(
 	ctx context.Context,
@@ -27,7 +32,7 @@ func MessageSendWithDefaultAddress(
 	to address.Address,
 	value types.AttoFIL,
 	gasPrice types.AttoFIL,
-	gasLimit types.GasUnits,
+	optGasLimit *types.GasUnits,
 	method string,
 	params ...interface{},
 ) (cid.Cid, error) {
@@ -40,5 +45,72 @@ func MessageSendWithDefaultAddress(
 		from = ret
 	}
 
+	var gasLimit types.GasUnits
+	if optGasLimit != nil {
+		gasLimit = *optGasLimit
+	} else {
+		var err error
+		gasLimit, err = plumbing.MessageEstimateGasLimit(ctx, from, to, method, params...)
+		if err != nil {
+			return cid.Undef, err
+		}
+	}
+
 	return plumbing.MessageSend(ctx, from, to, value, gasPrice, gasLimit, method, params...)
 }
+
+// mrAPI is the subset of the plumbing.API that MessageReplaceWithDefaultGas uses.
+type mrAPI interface {
+	ConfigGet(dottedPath string) (interface{}, error)
+	MessageReplace(ctx context.Context, msgCid cid.Cid, gasPrice types.AttoFIL, gasLimit types.GasUnits) (cid.Cid, error)
+	OutboxQueues() []address.Address
+	OutboxQueueLs(sender address.Address) []*core.QueuedMessage
+}
+
+// MessageReplaceWithDefaultGas replaces a stuck message in the outbox with a copy re-signed
+// with a higher gas price. If optGasPrice is zero, the lowest price the message pool will accept
+// as a replacement is used. If optGasLimit is zero, the original message's gas limit is kept.
+func MessageReplaceWithDefaultGas(
+	ctx context.Context,
+	plumbing mrAPI,
+	msgCid cid.Cid,
+	optGasPrice types.AttoFIL,
+	optGasLimit types.GasUnits,
+) (cid.Cid, error) {
+	original, err := findOutboxMessage(plumbing, msgCid)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	gasPrice := optGasPrice
+	if gasPrice.IsZero() {
+		bumpPercent, err := plumbing.ConfigGet("mpool.replacePriceBumpPercent")
+		if err != nil {
+			return cid.Undef, errors.Wrap(err, "failed to read replacement price bump from config")
+		}
+		gasPrice = core.MinimumReplacementGasPrice(original.GasPrice, bumpPercent.(uint))
+	}
+
+	gasLimit := optGasLimit
+	if gasLimit == 0 {
+		gasLimit = original.GasLimit
+	}
+
+	return plumbing.MessageReplace(ctx, msgCid, gasPrice, gasLimit)
+}
+
+// findOutboxMessage returns the message with the given CID from any outbox queue.
+func findOutboxMessage(plumbing mrAPI, msgCid cid.Cid) (*types.SignedMessage, error) {
+	for _, addr := range plumbing.OutboxQueues() {
+		for _, qm := range plumbing.OutboxQueueLs(addr) {
+			c, err := qm.Msg.Cid()
+			if err != nil {
+				return nil, err
+			}
+			if c.Equals(msgCid) {
+				return qm.Msg, nil
+			}
+		}
+	}
+	return nil, errors.Errorf("message %s not found in outbox", msgCid)
+}
@@ -0,0 +1,130 @@
+package porcelain_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/config"
+	"github.com/filecoin-project/go-filecoin/core"
+	"github.com/filecoin-project/go-filecoin/porcelain"
+	tf "github.com/filecoin-project/go-filecoin/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/types"
+)
+
+type mrTestPlumbing struct {
+	config *config.Config
+	queued *types.SignedMessage
+
+	replacedCid cid.Cid
+	gasPrice    types.AttoFIL
+	gasLimit    types.GasUnits
+}
+
+func (mrtp *mrTestPlumbing) ConfigGet(dottedPath string) (interface{}, error) {
+	return mrtp.config.Get(dottedPath)
+}
+
+func (mrtp *mrTestPlumbing) MessageReplace(ctx context.Context, msgCid cid.Cid, gasPrice types.AttoFIL, gasLimit types.GasUnits) (cid.Cid, error) {
+	mrtp.replacedCid = msgCid
+	mrtp.gasPrice = gasPrice
+	mrtp.gasLimit = gasLimit
+	return types.NewCidForTestGetter()(), nil
+}
+
+func (mrtp *mrTestPlumbing) OutboxQueues() []address.Address {
+	return []address.Address{mrtp.queued.From}
+}
+
+func (mrtp *mrTestPlumbing) OutboxQueueLs(sender address.Address) []*core.QueuedMessage {
+	return []*core.QueuedMessage{{Msg: mrtp.queued, Stamp: 0}}
+}
+
+func TestMessageReplaceWithDefaultGas(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	signer, _ := types.NewMockSignersAndKeyInfo(1)
+	msg := types.NewMessage(signer.Addresses[0], address.NewForTestGetter()(), 3, types.ZeroAttoFIL, "", nil)
+	queued, err := types.NewSignedMessage(*msg, signer, types.NewGasPrice(100), types.NewGasUnits(300))
+	require.NoError(t, err)
+	queuedCid, err := queued.Cid()
+	require.NoError(t, err)
+
+	t.Run("defaults to the minimum replacement price and original limit", func(t *testing.T) {
+		plumbing := &mrTestPlumbing{config: config.NewDefaultConfig(), queued: queued}
+		plumbing.config.Mpool.ReplacePriceBumpPercent = 25
+
+		_, err := porcelain.MessageReplaceWithDefaultGas(ctx, plumbing, queuedCid, types.ZeroAttoFIL, types.NewGasUnits(0))
+		require.NoError(t, err)
+		assert.Equal(t, queuedCid, plumbing.replacedCid)
+		assert.True(t, types.NewGasPrice(125).Equal(plumbing.gasPrice))
+		assert.Equal(t, types.NewGasUnits(300), plumbing.gasLimit)
+	})
+
+	t.Run("uses given price and limit", func(t *testing.T) {
+		plumbing := &mrTestPlumbing{config: config.NewDefaultConfig(), queued: queued}
+
+		_, err := porcelain.MessageReplaceWithDefaultGas(ctx, plumbing, queuedCid, types.NewGasPrice(500), types.NewGasUnits(400))
+		require.NoError(t, err)
+		assert.True(t, types.NewGasPrice(500).Equal(plumbing.gasPrice))
+		assert.Equal(t, types.NewGasUnits(400), plumbing.gasLimit)
+	})
+
+	t.Run("fails for a message not in the outbox", func(t *testing.T) {
+		plumbing := &mrTestPlumbing{config: config.NewDefaultConfig(), queued: queued}
+
+		_, err := porcelain.MessageReplaceWithDefaultGas(ctx, plumbing, types.NewCidForTestGetter()(), types.ZeroAttoFIL, types.NewGasUnits(0))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "not found in outbox")
+	})
+}
+
+type mswdaTestPlumbing struct {
+	estimated bool
+	gasLimit  types.GasUnits
+}
+
+func (mtp *mswdaTestPlumbing) MessageEstimateGasLimit(ctx context.Context, from, to address.Address, method string, params ...interface{}) (types.GasUnits, error) {
+	mtp.estimated = true
+	return types.NewGasUnits(250), nil
+}
+
+func (mtp *mswdaTestPlumbing) MessageSend(ctx context.Context, from, to address.Address, value types.AttoFIL, gasPrice types.AttoFIL, gasLimit types.GasUnits, method string, params ...interface{}) (cid.Cid, error) {
+	mtp.gasLimit = gasLimit
+	return types.NewCidForTestGetter()(), nil
+}
+
+func (mtp *mswdaTestPlumbing) WalletDefaultAddress() (address.Address, error) {
+	return address.TestAddress, nil
+}
+
+func TestMessageSendWithDefaultAddress(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	to := address.NewForTestGetter()()
+
+	t.Run("estimates an omitted gas limit", func(t *testing.T) {
+		plumbing := &mswdaTestPlumbing{}
+
+		_, err := porcelain.MessageSendWithDefaultAddress(ctx, plumbing, address.Undef, to, types.ZeroAttoFIL, types.NewGasPrice(1), nil, "foo")
+		require.NoError(t, err)
+		assert.True(t, plumbing.estimated)
+		assert.Equal(t, types.NewGasUnits(250), plumbing.gasLimit)
+	})
+
+	t.Run("uses a given gas limit, even zero", func(t *testing.T) {
+		plumbing := &mswdaTestPlumbing{}
+		gasLimit := types.NewGasUnits(0)
+
+		_, err := porcelain.MessageSendWithDefaultAddress(ctx, plumbing, address.Undef, to, types.ZeroAttoFIL, types.NewGasPrice(1), &gasLimit, "foo")
+		require.NoError(t, err)
+		assert.False(t, plumbing.estimated)
+		assert.Equal(t, types.NewGasUnits(0), plumbing.gasLimit)
+	})
+}
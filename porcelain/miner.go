@@ -24,7 +24,7 @@ import (
 type mcAPI interface {
 	ConfigGet(dottedPath string) (interface{}, error)
 	ConfigSet(dottedPath string, paramJSON string) error
-	MessageSendWithDefaultAddress(ctx context.Context, from, to address.Address, value types.AttoFIL, gasPrice types.AttoFIL, gasLimit types.GasUnits, method string, params ...interface{}) (cid.Cid, error)
+	MessageSendWithDefaultAddress(ctx context.Context, from, to address.Address, value types.AttoFIL, gasPrice types.AttoFIL, optGasLimit *types.GasUnits, method string, params ...interface{}) (cid.Cid, error)
 	MessageWait(ctx context.Context, msgCid cid.Cid, cb func(*types.Block, *types.SignedMessage, *types.MessageReceipt) error) error
 	WalletDefaultAddress() (address.Address, error)
 }
@@ -38,7 +38,7 @@ func MinerCreate(
 	plumbing mcAPI,
 	minerOwnerAddr address.Address,
 	gasPrice types.AttoFIL,
-	gasLimit types.GasUnits,
+	optGasLimit *types.GasUnits,
 	sectorSize *types.BytesAmount,
 	pid peer.ID,
 	collateral types.AttoFIL,
@@ -69,7 +69,7 @@ func MinerCreate(
 		address.StorageMarketAddress,
 		collateral,
 		gasPrice,
-		gasLimit,
+		optGasLimit,
 		"createStorageMiner",
 		sectorSize,
 		pid,
@@ -152,7 +152,7 @@ func MinerPreviewCreate(
 type mspAPI interface {
 	ConfigGet(dottedPath string) (interface{}, error)
 	ConfigSet(dottedKey string, jsonString string) error
-	MessageSendWithDefaultAddress(ctx context.Context, from, to address.Address, value types.AttoFIL, gasPrice types.AttoFIL, gasLimit types.GasUnits, method string, params ...interface{}) (cid.Cid, error)
+	MessageSendWithDefaultAddress(ctx context.Context, from, to address.Address, value types.AttoFIL, gasPrice types.AttoFIL, optGasLimit *types.GasUnits, method string, params ...interface{}) (cid.Cid, error)
 	MessageWait(ctx context.Context, msgCid cid.Cid, cb func(*types.Block, *types.SignedMessage, *types.MessageReceipt) error) error
 }
 
@@ -167,7 +167,7 @@ type MinerSetPriceResponse struct {
 // MinerSetPrice configures the price of storage, then sends an ask advertising that price and waits for it to be mined.
 // If minerAddr is empty, the default miner will be used.
 // This method is non-transactional in the sense that it will set the price whether or not it creates the ask successfully.
-func MinerSetPrice(ctx context.Context, plumbing mspAPI, from address.Address, miner address.Address, gasPrice types.AttoFIL, gasLimit types.GasUnits, price types.AttoFIL, expiry *big.Int) (MinerSetPriceResponse, error) {
+func MinerSetPrice(ctx context.Context, plumbing mspAPI, from address.Address, miner address.Address, gasPrice types.AttoFIL, optGasLimit *types.GasUnits, price types.AttoFIL, expiry *big.Int) (MinerSetPriceResponse, error) {
 	res := MinerSetPriceResponse{
 		Price: price,
 	}
@@ -196,7 +196,7 @@ func MinerSetPrice(ctx context.Context, plumbing mspAPI, from address.Address, m
 	}
 
 	// create ask
-	res.AddAskCid, err = plumbing.MessageSendWithDefaultAddress(ctx, from, res.MinerAddr, types.ZeroAttoFIL, gasPrice, gasLimit, "addAsk", price, expiry)
+	res.AddAskCid, err = plumbing.MessageSendWithDefaultAddress(ctx, from, res.MinerAddr, types.ZeroAttoFIL, gasPrice, optGasLimit, "addAsk", price, expiry)
 	if err != nil {
 		return res, errors.Wrap(err, "couldn't send message")
 	}
@@ -379,3 +379,120 @@ func MinerGetPeerID(ctx context.Context, plumbing mgpidAPI, minerAddr address.Ad
 	}
 	return pid, nil
 }
+
+// mlaAPI is the subset of the plumbing.API that MinerListAsks uses.
+type mlaAPI interface {
+	ChainBlockHeight() (*types.BlockHeight, error)
+	MessageQuery(ctx context.Context, optFrom, to address.Address, method string, params ...interface{}) ([][]byte, error)
+}
+
+// MinerListAsks queries for the asks of the given miner, skipping the expired asks unless
+// includeExpired is true.
+func MinerListAsks(ctx context.Context, plumbing mlaAPI, minerAddr address.Address, includeExpired bool) ([]minerActor.Ask, error) {
+	ret, err := plumbing.MessageQuery(ctx, address.Undef, minerAddr, "getAsks")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query asks")
+	}
+
+	var askIDs []uint64
+	if err := cbor.DecodeInto(ret[0], &askIDs); err != nil {
+		return nil, err
+	}
+
+	height, err := plumbing.ChainBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	asks := []minerActor.Ask{}
+	for _, id := range askIDs {
+		ask, err := MinerGetAsk(ctx, plumbing, minerAddr, id)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get ask %d", id)
+		}
+		if !includeExpired && height.GreaterEqual(ask.Expiry) {
+			continue
+		}
+		asks = append(asks, ask)
+	}
+	return asks, nil
+}
+
+// mcaAPI is the subset of the plumbing.API that MinerCancelAsk and MinerPruneExpiredAsks use.
+type mcaAPI interface {
+	ConfigGet(dottedPath string) (interface{}, error)
+	MessageSendWithDefaultAddress(ctx context.Context, from, to address.Address, value types.AttoFIL, gasPrice types.AttoFIL, optGasLimit *types.GasUnits, method string, params ...interface{}) (cid.Cid, error)
+	MessageWait(ctx context.Context, msgCid cid.Cid, cb func(*types.Block, *types.SignedMessage, *types.MessageReceipt) error) error
+}
+
+// MinerCancelAsk withdraws an ask of the given miner and waits for the withdrawal to be mined.
+// If minerAddr is empty, the default miner will be used.
+func MinerCancelAsk(ctx context.Context, plumbing mcaAPI, from address.Address, minerAddr address.Address, gasPrice types.AttoFIL, optGasLimit *types.GasUnits, askID uint64) (cid.Cid, error) {
+	minerAddr, err := minerAddressOrDefault(plumbing, minerAddr)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	msgCid, err := plumbing.MessageSendWithDefaultAddress(ctx, from, minerAddr, types.ZeroAttoFIL, gasPrice, optGasLimit, "cancelAsk", big.NewInt(int64(askID)))
+	if err != nil {
+		return cid.Undef, errors.Wrap(err, "couldn't send message")
+	}
+
+	err = plumbing.MessageWait(ctx, msgCid, func(blk *types.Block, smsg *types.SignedMessage, receipt *types.MessageReceipt) error {
+		if receipt.ExitCode != uint8(0) {
+			return vmErrors.VMExitCodeToError(receipt.ExitCode, minerActor.Errors)
+		}
+		return nil
+	})
+	return msgCid, err
+}
+
+// MinerPruneExpiredAsks removes the expired asks of the given miner, waits for the removal to
+// be mined and returns how many asks were removed.
+// If minerAddr is empty, the default miner will be used.
+func MinerPruneExpiredAsks(ctx context.Context, plumbing mcaAPI, from address.Address, minerAddr address.Address, gasPrice types.AttoFIL, optGasLimit *types.GasUnits) (uint64, error) {
+	minerAddr, err := minerAddressOrDefault(plumbing, minerAddr)
+	if err != nil {
+		return 0, err
+	}
+
+	msgCid, err := plumbing.MessageSendWithDefaultAddress(ctx, from, minerAddr, types.ZeroAttoFIL, gasPrice, optGasLimit, "pruneExpiredAsks")
+	if err != nil {
+		return 0, errors.Wrap(err, "couldn't send message")
+	}
+
+	var pruned uint64
+	err = plumbing.MessageWait(ctx, msgCid, func(blk *types.Block, smsg *types.SignedMessage, receipt *types.MessageReceipt) error {
+		if receipt.ExitCode != uint8(0) {
+			return vmErrors.VMExitCodeToError(receipt.ExitCode, minerActor.Errors)
+		}
+		abiVal, err := abi.Deserialize(receipt.Return[0], abi.Integer)
+		if err != nil {
+			return errors.Wrap(err, "failed to deserialize returned value")
+		}
+		count, ok := abiVal.Val.(*big.Int)
+		if !ok {
+			return errors.New("failed to convert returned ABI value")
+		}
+		pruned = count.Uint64()
+		return nil
+	})
+	return pruned, err
+}
+
+// minerAddressOrDefault returns minerAddr, or the configured miner address if minerAddr is empty.
+func minerAddressOrDefault(plumbing mcaAPI, minerAddr address.Address) (address.Address, error) {
+	if !minerAddr.Empty() {
+		return minerAddr, nil
+	}
+
+	minerValue, err := plumbing.ConfigGet("mining.minerAddress")
+	if err != nil {
+		return address.Undef, errors.Wrap(err, "Could not get miner address in config")
+	}
+	configured, ok := minerValue.(address.Address)
+	if !ok || configured.Empty() {
+		return address.Undef, errors.New("Configured miner is not an address")
+	}
+	return configured, nil
+}
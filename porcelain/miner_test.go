@@ -56,7 +56,7 @@ func (mpc *minerCreate) ConfigSet(dottedPath string, paramJSON string) error {
 	return mpc.config.Set(dottedPath, paramJSON)
 }
 
-func (mpc *minerCreate) MessageSendWithDefaultAddress(ctx context.Context, from, to address.Address, value types.AttoFIL, gasPrice types.AttoFIL, gasLimit types.GasUnits, method string, params ...interface{}) (cid.Cid, error) {
+func (mpc *minerCreate) MessageSendWithDefaultAddress(ctx context.Context, from, to address.Address, value types.AttoFIL, gasPrice types.AttoFIL, gasLimit *types.GasUnits, method string, params ...interface{}) (cid.Cid, error) {
 	if mpc.msgFail {
 		return cid.Cid{}, errors.New("Test Error")
 	}
@@ -86,12 +86,13 @@ func TestMinerCreate(t *testing.T) {
 		plumbing := newMinerCreate(t, false, expectedAddress)
 		collateral := types.NewAttoFILFromFIL(1)
 
+		gasLimit := types.NewGasUnits(100)
 		addr, err := MinerCreate(
 			ctx,
 			plumbing,
 			address.Address{},
 			types.NewGasPrice(0),
-			types.NewGasUnits(100),
+			&gasLimit,
 			types.OneKiBSectorSize,
 			"",
 			collateral,
@@ -105,12 +106,13 @@ func TestMinerCreate(t *testing.T) {
 		plumbing := newMinerCreate(t, true, address.Address{})
 		collateral := types.NewAttoFILFromFIL(1)
 
+		gasLimit := types.NewGasUnits(100)
 		_, err := MinerCreate(
 			ctx,
 			plumbing,
 			address.Address{},
 			types.NewGasPrice(0),
-			types.NewGasUnits(100),
+			&gasLimit,
 			types.OneKiBSectorSize,
 			"",
 			collateral,
@@ -174,7 +176,7 @@ type minerSetPricePlumbing struct {
 	failSend bool
 	failWait bool
 
-	messageSend func(ctx context.Context, from, to address.Address, value types.AttoFIL, gasPrice types.AttoFIL, gasLimit types.GasUnits, method string, params ...interface{}) (cid.Cid, error)
+	messageSend func(ctx context.Context, from, to address.Address, value types.AttoFIL, gasPrice types.AttoFIL, gasLimit *types.GasUnits, method string, params ...interface{}) (cid.Cid, error)
 }
 
 func newMinerSetPricePlumbing(t *testing.T) *minerSetPricePlumbing {
@@ -184,7 +186,7 @@ func newMinerSetPricePlumbing(t *testing.T) *minerSetPricePlumbing {
 	}
 }
 
-func (mtp *minerSetPricePlumbing) MessageSendWithDefaultAddress(ctx context.Context, from, to address.Address, value types.AttoFIL, gasPrice types.AttoFIL, gasLimit types.GasUnits, method string, params ...interface{}) (cid.Cid, error) {
+func (mtp *minerSetPricePlumbing) MessageSendWithDefaultAddress(ctx context.Context, from, to address.Address, value types.AttoFIL, gasPrice types.AttoFIL, gasLimit *types.GasUnits, method string, params ...interface{}) (cid.Cid, error) {
 	if mtp.failSend {
 		return cid.Cid{}, errors.New("Test error in MessageSend")
 	}
@@ -241,7 +243,7 @@ func TestMinerSetPrice(t *testing.T) {
 
 		ctx := context.Background()
 		price := types.NewAttoFILFromFIL(50)
-		_, err := MinerSetPrice(ctx, plumbing, address.Undef, address.Undef, types.NewGasPrice(0), types.NewGasUnits(0), price, big.NewInt(0))
+		_, err := MinerSetPrice(ctx, plumbing, address.Undef, address.Undef, types.NewGasPrice(0), nil, price, big.NewInt(0))
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "Test error in ConfigGet")
 	})
@@ -252,7 +254,7 @@ func TestMinerSetPrice(t *testing.T) {
 
 		ctx := context.Background()
 		price := types.NewAttoFILFromFIL(50)
-		_, err := MinerSetPrice(ctx, plumbing, address.Undef, address.Undef, types.NewGasPrice(0), types.NewGasUnits(0), price, big.NewInt(0))
+		_, err := MinerSetPrice(ctx, plumbing, address.Undef, address.Undef, types.NewGasPrice(0), nil, price, big.NewInt(0))
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "Test error in ConfigSet")
 	})
@@ -262,7 +264,7 @@ func TestMinerSetPrice(t *testing.T) {
 
 		ctx := context.Background()
 		price := types.NewAttoFILFromFIL(50)
-		_, err := MinerSetPrice(ctx, plumbing, address.Undef, address.Undef, types.NewGasPrice(0), types.NewGasUnits(0), price, big.NewInt(0))
+		_, err := MinerSetPrice(ctx, plumbing, address.Undef, address.Undef, types.NewGasPrice(0), nil, price, big.NewInt(0))
 		require.NoError(t, err)
 
 		configPrice, err := plumbing.config.Get("mining.storagePrice")
@@ -277,7 +279,7 @@ func TestMinerSetPrice(t *testing.T) {
 
 		ctx := context.Background()
 		price := types.NewAttoFILFromFIL(50)
-		_, err := MinerSetPrice(ctx, plumbing, address.Undef, address.Undef, types.NewGasPrice(0), types.NewGasUnits(0), price, big.NewInt(0))
+		_, err := MinerSetPrice(ctx, plumbing, address.Undef, address.Undef, types.NewGasPrice(0), nil, price, big.NewInt(0))
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "Test error in MessageSend")
 
@@ -294,12 +296,12 @@ func TestMinerSetPrice(t *testing.T) {
 		price := types.NewAttoFILFromFIL(50)
 		minerAddr := address.NewForTestGetter()()
 
-		plumbing.messageSend = func(ctx context.Context, from, to address.Address, value types.AttoFIL, gasPrice types.AttoFIL, gasLimit types.GasUnits, method string, params ...interface{}) (cid.Cid, error) {
+		plumbing.messageSend = func(ctx context.Context, from, to address.Address, value types.AttoFIL, gasPrice types.AttoFIL, gasLimit *types.GasUnits, method string, params ...interface{}) (cid.Cid, error) {
 			assert.Equal(t, minerAddr, to)
 			return types.NewCidForTestGetter()(), nil
 		}
 
-		_, err := MinerSetPrice(ctx, plumbing, address.Undef, minerAddr, types.NewGasPrice(0), types.NewGasUnits(0), price, big.NewInt(0))
+		_, err := MinerSetPrice(ctx, plumbing, address.Undef, minerAddr, types.NewGasPrice(0), nil, price, big.NewInt(0))
 		require.NoError(t, err)
 	})
 
@@ -312,12 +314,12 @@ func TestMinerSetPrice(t *testing.T) {
 		ctx := context.Background()
 		price := types.NewAttoFILFromFIL(50)
 
-		plumbing.messageSend = func(ctx context.Context, from, to address.Address, value types.AttoFIL, gasPrice types.AttoFIL, gasLimit types.GasUnits, method string, params ...interface{}) (cid.Cid, error) {
+		plumbing.messageSend = func(ctx context.Context, from, to address.Address, value types.AttoFIL, gasPrice types.AttoFIL, gasLimit *types.GasUnits, method string, params ...interface{}) (cid.Cid, error) {
 			assert.Equal(t, minerAddr, to)
 			return types.NewCidForTestGetter()(), nil
 		}
 
-		_, err := MinerSetPrice(ctx, plumbing, address.Undef, address.Undef, types.NewGasPrice(0), types.NewGasUnits(0), price, big.NewInt(0))
+		_, err := MinerSetPrice(ctx, plumbing, address.Undef, address.Undef, types.NewGasPrice(0), nil, price, big.NewInt(0))
 		require.NoError(t, err)
 	})
 
@@ -328,14 +330,14 @@ func TestMinerSetPrice(t *testing.T) {
 		price := types.NewAttoFILFromFIL(50)
 		expiry := big.NewInt(24)
 
-		plumbing.messageSend = func(ctx context.Context, from, to address.Address, value types.AttoFIL, gasPrice types.AttoFIL, gasLimit types.GasUnits, method string, params ...interface{}) (cid.Cid, error) {
+		plumbing.messageSend = func(ctx context.Context, from, to address.Address, value types.AttoFIL, gasPrice types.AttoFIL, gasLimit *types.GasUnits, method string, params ...interface{}) (cid.Cid, error) {
 			assert.Equal(t, "addAsk", method)
 			assert.Equal(t, price, params[0])
 			assert.Equal(t, expiry, params[1])
 			return types.NewCidForTestGetter()(), nil
 		}
 
-		_, err := MinerSetPrice(ctx, plumbing, address.Undef, address.Undef, types.NewGasPrice(0), types.NewGasUnits(0), price, expiry)
+		_, err := MinerSetPrice(ctx, plumbing, address.Undef, address.Undef, types.NewGasPrice(0), nil, price, expiry)
 		require.NoError(t, err)
 	})
 
@@ -346,7 +348,7 @@ func TestMinerSetPrice(t *testing.T) {
 		ctx := context.Background()
 		price := types.NewAttoFILFromFIL(50)
 
-		_, err := MinerSetPrice(ctx, plumbing, address.Undef, address.Undef, types.NewGasPrice(0), types.NewGasUnits(0), price, big.NewInt(0))
+		_, err := MinerSetPrice(ctx, plumbing, address.Undef, address.Undef, types.NewGasPrice(0), nil, price, big.NewInt(0))
 		require.Error(t, err)
 		assert.Contains(t, err.Error(), "Test error in MessageWait")
 	})
@@ -361,11 +363,11 @@ func TestMinerSetPrice(t *testing.T) {
 
 		messageCid := types.NewCidForTestGetter()()
 
-		plumbing.messageSend = func(ctx context.Context, from, to address.Address, value types.AttoFIL, gasPrice types.AttoFIL, gasLimit types.GasUnits, method string, params ...interface{}) (cid.Cid, error) {
+		plumbing.messageSend = func(ctx context.Context, from, to address.Address, value types.AttoFIL, gasPrice types.AttoFIL, gasLimit *types.GasUnits, method string, params ...interface{}) (cid.Cid, error) {
 			return messageCid, nil
 		}
 
-		res, err := MinerSetPrice(ctx, plumbing, address.Undef, minerAddr, types.NewGasPrice(0), types.NewGasUnits(0), price, expiry)
+		res, err := MinerSetPrice(ctx, plumbing, address.Undef, minerAddr, types.NewGasPrice(0), nil, price, expiry)
 		require.NoError(t, err)
 
 		assert.Equal(t, price, res.Price)
@@ -530,3 +532,93 @@ func TestMinerGetLastCommittedSectorID(t *testing.T) {
 
 	assert.Equal(t, int(lastCommittedSectorID), 5432)
 }
+
+type minerListAsksPlumbing struct {
+	height *types.BlockHeight
+}
+
+func (mlap *minerListAsksPlumbing) ChainBlockHeight() (*types.BlockHeight, error) {
+	return mlap.height, nil
+}
+
+func (mlap *minerListAsksPlumbing) MessageQuery(ctx context.Context, optFrom, to address.Address, method string, params ...interface{}) ([][]byte, error) {
+	if method == "getAsks" {
+		out, err := cbor.DumpObject([]uint64{0, 1})
+		if err != nil {
+			panic("Could not encode ask ids")
+		}
+		return [][]byte{out}, nil
+	}
+
+	id := params[0].(*big.Int)
+	out, err := cbor.DumpObject(miner.Ask{
+		Price:  types.NewAttoFILFromFIL(32),
+		Expiry: types.NewBlockHeight(10 * (id.Uint64() + 1)),
+		ID:     id,
+	})
+	if err != nil {
+		panic("Could not encode ask")
+	}
+	return [][]byte{out}, nil
+}
+
+func TestMinerListAsks(t *testing.T) {
+	tf.UnitTest(t)
+
+	t.Run("lists asks that haven't expired", func(t *testing.T) {
+		plumbing := &minerListAsksPlumbing{height: types.NewBlockHeight(10)}
+
+		asks, err := MinerListAsks(context.Background(), plumbing, address.TestAddress2, false)
+		require.NoError(t, err)
+		require.Len(t, asks, 1)
+		assert.Equal(t, big.NewInt(1), asks[0].ID)
+	})
+
+	t.Run("lists expired asks when asked to", func(t *testing.T) {
+		plumbing := &minerListAsksPlumbing{height: types.NewBlockHeight(10)}
+
+		asks, err := MinerListAsks(context.Background(), plumbing, address.TestAddress2, true)
+		require.NoError(t, err)
+		require.Len(t, asks, 2)
+		assert.Equal(t, big.NewInt(0), asks[0].ID)
+		assert.Equal(t, big.NewInt(1), asks[1].ID)
+	})
+}
+
+func TestMinerCancelAsk(t *testing.T) {
+	tf.UnitTest(t)
+
+	t.Run("sends cancelAsk to the configured miner", func(t *testing.T) {
+		plumbing := newMinerSetPricePlumbing(t)
+		minerAddr := address.NewForTestGetter()()
+		require.NoError(t, plumbing.config.Set("mining.minerAddress", fmt.Sprintf("\"%s\"", minerAddr.String())))
+
+		plumbing.messageSend = func(ctx context.Context, from, to address.Address, value types.AttoFIL, gasPrice types.AttoFIL, gasLimit *types.GasUnits, method string, params ...interface{}) (cid.Cid, error) {
+			assert.Equal(t, minerAddr, to)
+			assert.Equal(t, "cancelAsk", method)
+			assert.Equal(t, big.NewInt(3), params[0])
+			return types.NewCidForTestGetter()(), nil
+		}
+
+		msgCid, err := MinerCancelAsk(context.Background(), plumbing, address.Undef, address.Undef, types.NewGasPrice(0), nil, 3)
+		require.NoError(t, err)
+		assert.Equal(t, plumbing.msgCid, msgCid)
+	})
+
+	t.Run("reports error when the miner isn't configured", func(t *testing.T) {
+		plumbing := newMinerSetPricePlumbing(t)
+
+		_, err := MinerCancelAsk(context.Background(), plumbing, address.Undef, address.Undef, types.NewGasPrice(0), nil, 3)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Configured miner is not an address")
+	})
+
+	t.Run("reports error when the message fails", func(t *testing.T) {
+		plumbing := newMinerSetPricePlumbing(t)
+		plumbing.failWait = true
+
+		_, err := MinerCancelAsk(context.Background(), plumbing, address.Undef, address.TestAddress2, types.NewGasPrice(0), nil, 3)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "Test error in MessageWait")
+	})
+}
@@ -90,12 +90,12 @@ type dealRedeemPlumbing interface {
 	ChainBlockHeight() (*types.BlockHeight, error)
 	DealGet(context.Context, cid.Cid) (*storagedeal.Deal, error)
 	MessagePreview(context.Context, address.Address, address.Address, string, ...interface{}) (types.GasUnits, error)
-	MessageSendWithDefaultAddress(context.Context, address.Address, address.Address, types.AttoFIL, types.AttoFIL, types.GasUnits, string, ...interface{}) (cid.Cid, error)
+	MessageSendWithDefaultAddress(context.Context, address.Address, address.Address, types.AttoFIL, types.AttoFIL, *types.GasUnits, string, ...interface{}) (cid.Cid, error)
 }
 
 // DealRedeem redeems a voucher for the deal with the given cid and returns
 // either the cid of the created redeem message or an error
-func DealRedeem(ctx context.Context, plumbing dealRedeemPlumbing, fromAddr address.Address, dealCid cid.Cid, gasPrice types.AttoFIL, gasLimit types.GasUnits) (cid.Cid, error) {
+func DealRedeem(ctx context.Context, plumbing dealRedeemPlumbing, fromAddr address.Address, dealCid cid.Cid, gasPrice types.AttoFIL, optGasLimit *types.GasUnits) (cid.Cid, error) {
 	params, err := buildDealRedeemParams(ctx, plumbing, dealCid)
 	if err != nil {
 		return cid.Undef, err
@@ -107,7 +107,7 @@ func DealRedeem(ctx context.Context, plumbing dealRedeemPlumbing, fromAddr addre
 		address.PaymentBrokerAddress,
 		types.NewAttoFILFromFIL(0),
 		gasPrice,
-		gasLimit,
+		optGasLimit,
 		"redeem",
 		params...,
 	)
@@ -126,7 +126,7 @@ func (trp *testRedeemPlumbing) MessagePreview(_ context.Context, fromAddr addres
 	return trp.gasPrice, nil
 }
 
-func (trp *testRedeemPlumbing) MessageSendWithDefaultAddress(_ context.Context, fromAddr address.Address, actorAddr address.Address, _ types.AttoFIL, _ types.AttoFIL, _ types.GasUnits, method string, params ...interface{}) (cid.Cid, error) {
+func (trp *testRedeemPlumbing) MessageSendWithDefaultAddress(_ context.Context, fromAddr address.Address, actorAddr address.Address, _ types.AttoFIL, _ types.AttoFIL, _ *types.GasUnits, method string, params ...interface{}) (cid.Cid, error) {
 	trp.ResultingFromAddr = fromAddr
 	trp.ResultingActorAddr = actorAddr
 	trp.ResultingMethod = method
@@ -178,7 +178,7 @@ func TestDealRedeem(t *testing.T) {
 		vouchers:    vouchers,
 	}
 
-	resultCid, err := porcelain.DealRedeem(context.Background(), plumbing, fromAddr, dealCid, types.NewAttoFILFromFIL(0), types.NewGasUnits(0))
+	resultCid, err := porcelain.DealRedeem(context.Background(), plumbing, fromAddr, dealCid, types.NewAttoFILFromFIL(0), nil)
 	require.NoError(t, err)
 
 	assert.Equal(t, fromAddr, plumbing.ResultingFromAddr)
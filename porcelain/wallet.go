@@ -61,3 +61,38 @@ func WalletDefaultAddress(plumbing wdaPlumbing) (address.Address, error) {
 
 	return address.Undef, ErrNoDefaultFromAddress
 }
+
+type wrhdPlumbing interface {
+	ActorGet(ctx context.Context, addr address.Address) (*actor.Actor, error)
+	WalletHDAddressAt(index uint32) (address.Address, error)
+	WalletHDAdd(index uint32) (address.Address, error)
+}
+
+// WalletHDRescan finds the addresses derived from the wallet's seed that have been used on
+// chain, and adds them to the wallet. It derives addresses in order until gap consecutive
+// addresses have no actor on chain, which is how a restored wallet recovers its addresses.
+// Returns the used addresses.
+func WalletHDRescan(ctx context.Context, plumbing wrhdPlumbing, gap uint32) ([]address.Address, error) {
+	var used []address.Address
+	for index, unused := uint32(0), uint32(0); unused < gap; index++ {
+		addr, err := plumbing.WalletHDAddressAt(index)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to derive address %d", index)
+		}
+
+		if _, err := plumbing.ActorGet(ctx, addr); err != nil {
+			if state.IsActorNotFoundError(err) {
+				unused++
+				continue
+			}
+			return nil, errors.Wrapf(err, "failed to get actor for %s", addr)
+		}
+		unused = 0
+
+		if _, err := plumbing.WalletHDAdd(index); err != nil {
+			return nil, errors.Wrapf(err, "failed to add address %s", addr)
+		}
+		used = append(used, addr)
+	}
+	return used, nil
+}
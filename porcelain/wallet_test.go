@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/ipfs/go-cid"
+	"github.com/pkg/errors"
 
 	"github.com/filecoin-project/go-filecoin/actor"
 	"github.com/filecoin-project/go-filecoin/address"
@@ -14,6 +15,7 @@ import (
 	tf "github.com/filecoin-project/go-filecoin/testhelpers/testflags"
 	"github.com/filecoin-project/go-filecoin/types"
 	"github.com/filecoin-project/go-filecoin/wallet"
+	"github.com/filecoin-project/go-filecoin/wallet/hd"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -119,3 +121,95 @@ func isInList(needle address.Address, haystack []address.Address) bool {
 	}
 	return false
 }
+
+type actorNotFound struct{}
+
+func (actorNotFound) Error() string       { return "actor not found" }
+func (actorNotFound) ActorNotFound() bool { return true }
+
+type wrhdTestPlumbing struct {
+	hd       *wallet.HDBackend
+	onChain  map[address.Address]bool
+	added    []uint32
+	actorErr error
+}
+
+func (p *wrhdTestPlumbing) ActorGet(ctx context.Context, addr address.Address) (*actor.Actor, error) {
+	if p.actorErr != nil {
+		return nil, p.actorErr
+	}
+	if !p.onChain[addr] {
+		return nil, actorNotFound{}
+	}
+	return actor.NewActor(cid.Undef, types.NewAttoFILFromFIL(1)), nil
+}
+
+func (p *wrhdTestPlumbing) WalletHDAddressAt(index uint32) (address.Address, error) {
+	ki, err := p.hd.KeyInfoAt(index)
+	if err != nil {
+		return address.Undef, err
+	}
+	return ki.Address()
+}
+
+func (p *wrhdTestPlumbing) WalletHDAdd(index uint32) (address.Address, error) {
+	p.added = append(p.added, index)
+	return p.hd.AddIndex(index)
+}
+
+func TestWalletHDRescan(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+
+	newPlumbing := func(t *testing.T, usedIndices ...uint32) *wrhdTestPlumbing {
+		mnemonic, err := wallet.NewMnemonic()
+		require.NoError(t, err)
+		ds := repo.NewInMemoryRepo().WalletDatastore()
+		require.NoError(t, wallet.InitHD(ds, mnemonic, hd.DefaultAccountPath))
+		backend, err := wallet.NewHDBackend(ds)
+		require.NoError(t, err)
+
+		p := &wrhdTestPlumbing{hd: backend, onChain: make(map[address.Address]bool)}
+		for _, index := range usedIndices {
+			addr, err := p.WalletHDAddressAt(index)
+			require.NoError(t, err)
+			p.onChain[addr] = true
+		}
+		return p
+	}
+
+	t.Run("adds used addresses within the gap", func(t *testing.T) {
+		p := newPlumbing(t, 0, 2, 5)
+
+		used, err := porcelain.WalletHDRescan(ctx, p, 3)
+		require.NoError(t, err)
+		assert.Len(t, used, 3)
+		assert.Equal(t, []uint32{0, 2, 5}, p.added)
+		assert.ElementsMatch(t, used, p.hd.Addresses())
+
+		t.Log("new addresses continue after the last used one")
+		next, err := p.hd.NewAddress()
+		require.NoError(t, err)
+		expected, err := p.WalletHDAddressAt(6)
+		require.NoError(t, err)
+		assert.Equal(t, expected, next)
+	})
+
+	t.Run("stops at the gap", func(t *testing.T) {
+		p := newPlumbing(t, 0, 4)
+
+		used, err := porcelain.WalletHDRescan(ctx, p, 3)
+		require.NoError(t, err)
+		assert.Len(t, used, 1)
+		assert.Equal(t, []uint32{0}, p.added)
+	})
+
+	t.Run("fails on chain errors", func(t *testing.T) {
+		p := newPlumbing(t)
+		p.actorErr = errors.New("boom")
+
+		_, err := porcelain.WalletHDRescan(ctx, p, 3)
+		assert.Error(t, err)
+	})
+}
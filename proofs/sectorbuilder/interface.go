@@ -30,6 +30,14 @@ type SectorBuilder interface {
 	// piece-bytes from a sealed sector.
 	ReadPieceFromSealedSector(pieceCid cid.Cid) (io.Reader, error)
 
+	// SealedPieceInfo returns the metadata of a piece in a sealed sector, or
+	// nil if no sealed sector holds the piece.
+	SealedPieceInfo(pieceCid cid.Cid) (*PieceInfo, error)
+
+	// PieceSectorID returns the id of a staged or sealed sector holding the
+	// piece, and whether one does.
+	PieceSectorID(pieceCid cid.Cid) (sectorID uint64, found bool, err error)
+
 	// SealAllStagedSectors seals any non-empty staged sectors.
 	SealAllStagedSectors(ctx context.Context) error
 
@@ -6,6 +6,7 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"sync"
 	"time"
 	"unsafe"
 
@@ -35,6 +36,14 @@ const MaxNumStagedSectors = 1
 // miner.
 type stagedSectorMetadata struct {
 	sectorID uint64
+	pieces   []*PieceInfo
+}
+
+// sealedPiece is an entry of the sealed piece index: a piece and the sealed
+// sector holding it.
+type sealedPiece struct {
+	sectorID uint64
+	info     *PieceInfo
 }
 
 func elapsed(what string) func() {
@@ -57,6 +66,14 @@ type RustSectorBuilder struct {
 	// knows about.
 	sealStatusPoller *sealStatusPoller
 
+	// sealedPiecesLk protects sealedPieces.
+	sealedPiecesLk sync.Mutex
+
+	// sealedPieces indexes the pieces of sealed sectors by their CID. It is
+	// filled when the sector builder starts and as sectors finish sealing. A
+	// piece in several sectors maps to the most recent.
+	sealedPieces map[string]sealedPiece
+
 	// SectorClass configures behavior of sector_builder_ffi, including sector
 	// packing, sector sizes, sealing and PoSt generation performance.
 	SectorClass types.SectorClass
@@ -118,6 +135,7 @@ func NewRustSectorBuilder(cfg RustSectorBuilderConfig) (*RustSectorBuilder, erro
 		blockService:      cfg.BlockService,
 		ptr:               unsafe.Pointer(resPtr.sector_builder),
 		sectorSealResults: make(chan SectorSealResult),
+		sealedPieces:      make(map[string]sealedPiece),
 		SectorClass:       cfg.SectorClass,
 	}
 
@@ -128,11 +146,26 @@ func NewRustSectorBuilder(cfg RustSectorBuilderConfig) (*RustSectorBuilder, erro
 	}
 
 	stagedSectorIDs := make([]uint64, len(metadata))
+	staged := make(map[uint64]bool)
 	for idx, m := range metadata {
 		stagedSectorIDs[idx] = m.sectorID
+		staged[m.sectorID] = true
 	}
 
-	sb.sealStatusPoller = newSealStatusPoller(stagedSectorIDs, sb.sectorSealResults, sb.findSealedSectorMetadata)
+	// index the pieces of the sectors sealed before now
+	for sectorID := uint64(1); sectorID <= cfg.LastUsedSectorID; sectorID++ {
+		if staged[sectorID] {
+			continue
+		}
+		meta, err := sb.findSealedSectorMetadata(sectorID)
+		if err != nil || meta == nil {
+			// the sector failed to seal
+			continue
+		}
+		sb.indexSealedPieces(meta)
+	}
+
+	sb.sealStatusPoller = newSealStatusPoller(stagedSectorIDs, sb.sectorSealResults, sb.findAndIndexSealedSectorMetadata)
 
 	return sb, nil
 }
@@ -231,6 +264,7 @@ func (sb *RustSectorBuilder) AddPiece(ctx context.Context, pieceRef cid.Cid, pie
 		return 0, errors.Wrap(err, errStr)
 	case sectorID := <-sectorIDCh:
 		go sb.sealStatusPoller.addSectorID(sectorID)
+
 		log.Infof("add piece complete (pieceRef=%s, sectorID=%d, sinkPath=%s)", pieceRef.String(), sectorID, sink.ID())
 
 		return sectorID, nil
@@ -312,6 +346,59 @@ func (sb *RustSectorBuilder) ReadPieceFromSealedSector(pieceCid cid.Cid) (io.Rea
 	return bytes.NewReader(goBytes(resPtr.data_ptr, resPtr.data_len)), nil
 }
 
+// SealedPieceInfo returns the metadata of a piece in a sealed sector, or nil if
+// no sealed sector holds the piece. If several do, the most recent is used.
+func (sb *RustSectorBuilder) SealedPieceInfo(pieceCid cid.Cid) (*PieceInfo, error) {
+	sb.sealedPiecesLk.Lock()
+	defer sb.sealedPiecesLk.Unlock()
+
+	return sb.sealedPieces[pieceCid.KeyString()].info, nil
+}
+
+// PieceSectorID returns the id of a staged or sealed sector holding the piece,
+// and whether one does. Staged sectors are more recent, so they are looked
+// through first.
+func (sb *RustSectorBuilder) PieceSectorID(pieceCid cid.Cid) (uint64, bool, error) {
+	staged, err := sb.stagedSectors()
+	if err != nil {
+		return 0, false, errors.Wrap(err, "failed to load staged sectors")
+	}
+	for _, sector := range staged {
+		for _, piece := range sector.pieces {
+			if piece.Ref.Equals(pieceCid) {
+				return sector.sectorID, true, nil
+			}
+		}
+	}
+
+	sb.sealedPiecesLk.Lock()
+	defer sb.sealedPiecesLk.Unlock()
+
+	piece, ok := sb.sealedPieces[pieceCid.KeyString()]
+	return piece.sectorID, ok, nil
+}
+
+// findAndIndexSealedSectorMetadata is findSealedSectorMetadata, which also
+// indexes the pieces of the sector once it is sealed.
+func (sb *RustSectorBuilder) findAndIndexSealedSectorMetadata(sectorID uint64) (*SealedSectorMetadata, error) {
+	meta, err := sb.findSealedSectorMetadata(sectorID)
+	if err == nil && meta != nil {
+		sb.indexSealedPieces(meta)
+	}
+	return meta, err
+}
+
+// indexSealedPieces adds the pieces of a sealed sector to the index used by
+// SealedPieceInfo.
+func (sb *RustSectorBuilder) indexSealedPieces(meta *SealedSectorMetadata) {
+	sb.sealedPiecesLk.Lock()
+	defer sb.sealedPiecesLk.Unlock()
+
+	for _, piece := range meta.Pieces {
+		sb.sealedPieces[piece.Ref.KeyString()] = sealedPiece{sectorID: meta.SectorID, info: piece}
+	}
+}
+
 // SealAllStagedSectors schedules sealing of all staged sectors.
 func (sb *RustSectorBuilder) SealAllStagedSectors(ctx context.Context) error {
 	resPtr := (*C.sector_builder_ffi_SealAllStagedSectorsResponse)(unsafe.Pointer(C.sector_builder_ffi_seal_all_staged_sectors((*C.sector_builder_ffi_SectorBuilder)(sb.ptr))))
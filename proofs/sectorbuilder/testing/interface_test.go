@@ -227,6 +227,37 @@ func TestSectorBuilder(t *testing.T) {
 		require.Equal(t, hex.EncodeToString(inputBytes), hex.EncodeToString(outputBytes))
 	})
 
+	t.Run("sealed piece info is found once the piece's sector is sealed", func(t *testing.T) {
+		h := NewBuilder(t).Build()
+		defer h.Close()
+
+		inputBytes := RequireRandomBytes(t, h.MaxBytesPerSector.Uint64())
+		ref, size, reader, err := h.CreateAddPieceArgs(inputBytes)
+		require.NoError(t, err)
+
+		info, err := h.SectorBuilder.SealedPieceInfo(ref)
+		require.NoError(t, err)
+		require.Nil(t, info)
+
+		sectorID, err := h.SectorBuilder.AddPiece(context.Background(), ref, size, reader)
+		require.NoError(t, err)
+
+		timeout := time.After(MaxTimeToSealASector)
+		select {
+		case val := <-h.SectorBuilder.SectorSealResults():
+			require.NoError(t, val.SealingErr)
+			require.Equal(t, sectorID, val.SealingResult.SectorID)
+		case <-timeout:
+			t.Fatalf("timed out waiting for seal to complete")
+		}
+
+		info, err = h.SectorBuilder.SealedPieceInfo(ref)
+		require.NoError(t, err)
+		require.NotNil(t, info)
+		require.True(t, ref.Equals(info.Ref))
+		require.Equal(t, size, info.Size)
+	})
+
 	t.Run("sector builder resumes polling for staged sectors even after a restart", func(t *testing.T) {
 		stagingDir, err := ioutil.TempDir("", "staging")
 		if err != nil {
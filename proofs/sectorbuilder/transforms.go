@@ -5,6 +5,7 @@ import (
 
 	"github.com/filecoin-project/go-filecoin/types"
 	"github.com/ipfs/go-cid"
+	"github.com/pkg/errors"
 )
 
 // #cgo LDFLAGS: -L${SRCDIR}/../lib -lsector_builder_ffi
@@ -51,8 +52,14 @@ func goStagedSectorMetadata(src *C.sector_builder_ffi_FFIStagedSectorMetadata, s
 
 	sectorPtrs := (*[1 << 30]C.sector_builder_ffi_FFIStagedSectorMetadata)(unsafe.Pointer(src))[:size:size]
 	for i := 0; i < int(size); i++ {
+		ps, err := goPieceInfos(sectorPtrs[i].pieces_ptr, sectorPtrs[i].pieces_len)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal from string to cid")
+		}
+
 		sectors[i] = &stagedSectorMetadata{
 			sectorID: uint64(sectorPtrs[i].sector_id),
+			pieces:   ps,
 		}
 	}
 
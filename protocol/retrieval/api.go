@@ -8,6 +8,7 @@ import (
 	"github.com/libp2p/go-libp2p-peer"
 
 	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/types"
 )
 
 // API here is the API for a retrieval client.
@@ -20,7 +21,15 @@ func NewAPI(rc *Client) API {
 	return API{rc: rc}
 }
 
-// RetrievePiece retrieves bytes referenced by CID pieceCID
-func (a *API) RetrievePiece(ctx context.Context, pieceCID cid.Cid, mpid peer.ID, minerAddr address.Address) (io.ReadCloser, error) {
-	return a.rc.RetrievePiece(ctx, mpid, pieceCID)
+// RetrievePiece retrieves the part selected by pr of the bytes referenced by CID pieceCID. If
+// the miner charges for retrievals, payer (or the wallet's default address, if empty) pays up
+// to maxPrice.
+func (a *API) RetrievePiece(ctx context.Context, pieceCID cid.Cid, pr PieceRange, mpid peer.ID, minerAddr address.Address, payer address.Address, maxPrice types.AttoFIL) (io.ReadCloser, error) {
+	return a.rc.RetrievePiece(ctx, mpid, pieceCID, pr, payer, maxPrice)
+}
+
+// QueryPiece asks the miner with peer id mpid whether it serves the piece referenced by pieceCID,
+// and on what terms.
+func (a *API) QueryPiece(ctx context.Context, pieceCID cid.Cid, mpid peer.ID) (*RetrievalQueryResponse, error) {
+	return a.rc.QueryPiece(ctx, mpid, pieceCID)
 }
@@ -3,6 +3,7 @@ package retrieval
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"time"
@@ -14,8 +15,13 @@ import (
 	"github.com/libp2p/go-libp2p-peer"
 	"github.com/pkg/errors"
 
+	"github.com/filecoin-project/go-filecoin/actor/builtin/paymentbroker"
+	"github.com/filecoin-project/go-filecoin/address"
 	cbu "github.com/filecoin-project/go-filecoin/cborutil"
 	"github.com/filecoin-project/go-filecoin/net"
+	"github.com/filecoin-project/go-filecoin/proofs"
+	"github.com/filecoin-project/go-filecoin/repo"
+	"github.com/filecoin-project/go-filecoin/types"
 )
 
 // RetrievePieceChunkSize defines the size of piece-chunks to be sent from miner to client. The maximum size of readable
@@ -24,7 +30,13 @@ import (
 const RetrievePieceChunkSize = 256 << 8
 
 type clientPorcelainAPI interface {
+	ChainBlockHeight() (*types.BlockHeight, error)
+	MessageSend(ctx context.Context, from, to address.Address, value types.AttoFIL, gasPrice types.AttoFIL, gasLimit types.GasUnits, method string, params ...interface{}) (cid.Cid, error)
+	MessageWait(ctx context.Context, msgCid cid.Cid, cb func(*types.Block, *types.SignedMessage, *types.MessageReceipt) error) error
+	PaymentChannelLs(ctx context.Context, fromAddr address.Address, payerAddr address.Address) (map[string]*paymentbroker.PaymentChannel, error)
+	PaymentChannelVoucher(ctx context.Context, fromAddr address.Address, channel *types.ChannelID, amount types.AttoFIL, validAt *types.BlockHeight, condition *types.Predicate) (*types.PaymentVoucher, error)
 	PingMinerWithTimeout(ctx context.Context, p peer.ID, to time.Duration) error
+	WalletDefaultAddress() (address.Address, error)
 }
 
 // Client is a client interface to the retrieval market protocols.
@@ -32,19 +44,25 @@ type Client struct {
 	api  clientPorcelainAPI
 	host host.Host
 	log  logging.EventLogger
+
+	// vouchers holds the last voucher given on each payment channel created for retrievals.
+	vouchers *voucherStore
 }
 
 // NewClient produces a new Client.
-func NewClient(host host.Host, api clientPorcelainAPI) *Client {
+func NewClient(host host.Host, api clientPorcelainAPI, ds repo.Datastore) *Client {
 	return &Client{
-		api:  api,
-		host: host,
-		log:  logging.Logger("retrieval/client"),
+		api:      api,
+		host:     host,
+		log:      logging.Logger("retrieval/client"),
+		vouchers: &voucherStore{ds: ds, prefix: ClientVouchersPrefix},
 	}
 }
 
-// RetrievePiece connects to a miner and transfers a piece of content.
-func (sc *Client) RetrievePiece(ctx context.Context, minerPeerID peer.ID, pieceCID cid.Cid) (io.ReadCloser, error) {
+// RetrievePiece connects to a miner and transfers the part of a piece of content selected by pr.
+// If the miner charges for it, payer pays it, up to maxPrice, from a payment channel to the
+// miner: an existing one with enough funds left, or a new one.
+func (sc *Client) RetrievePiece(ctx context.Context, minerPeerID peer.ID, pieceCID cid.Cid, pr PieceRange, payer address.Address, maxPrice types.AttoFIL) (io.ReadCloser, error) {
 	err := sc.api.PingMinerWithTimeout(ctx, minerPeerID, 15*time.Second)
 	if err == net.ErrPingSelf {
 		return nil, errors.New("attempting to retrieve piece from self. This is currently unsupported.  Please use a separate go-filecoin node as client")
@@ -52,23 +70,35 @@ func (sc *Client) RetrievePiece(ctx context.Context, minerPeerID peer.ID, pieceC
 	if err != nil {
 		return nil, err
 	}
-	s, err := sc.host.NewStream(ctx, minerPeerID, retrievalFreeProtocol)
+
+	if payer.Empty() {
+		payer, err = sc.api.WalletDefaultAddress()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s, err := sc.host.NewStream(ctx, minerPeerID, retrievalPaidProtocol)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create stream to retrieval miner")
 	}
 	defer sc.safeCloseStream(s)
 
 	streamReader := cbu.NewMsgReader(s)
+	streamWriter := cbu.NewMsgWriter(s)
 
-	req := RetrievePieceRequest{
+	req := RetrievePaidPieceRequest{
 		PieceRef: pieceCID,
+		Payer:    payer,
+		Offset:   pr.Offset,
+		Length:   pr.Length,
 	}
 
-	if err := cbu.NewMsgWriter(s).WriteMsg(&req); err != nil {
+	if err := streamWriter.WriteMsg(&req); err != nil {
 		return nil, errors.Wrap(err, "failed to write request message to stream")
 	}
 
-	var res RetrievePieceResponse
+	var res RetrievePaidPieceResponse
 	if err := streamReader.ReadMsg(&res); err != nil {
 		return nil, errors.Wrap(err, "failed to read response message from stream")
 	}
@@ -77,18 +107,72 @@ func (sc *Client) RetrievePiece(ctx context.Context, minerPeerID peer.ID, pieceC
 		return nil, errors.Errorf("could not retrieve piece - error from miner: %s", res.ErrorMessage)
 	}
 
+	// The size is the miner's word, so bound it before allocating or paying for anything.
+	maxSize := proofs.GetMaxUserBytesPerStagedSector(types.TwoHundredFiftySixMiBSectorSize).Uint64()
+	if pr.Length > 0 && pr.Length < maxSize {
+		maxSize = pr.Length
+	}
+	if res.Size > maxSize {
+		return nil, errors.Errorf("miner offers %d bytes, more than the %d bytes requested or held by a sector", res.Size, maxSize)
+	}
+
+	totalPrice := priceOf(res.PricePerByte, res.Size)
+	if totalPrice.GreaterThan(maxPrice) {
+		return nil, errors.Errorf("miner asks %s for the piece, more than the maximum price of %s", totalPrice, maxPrice)
+	}
+
+	var pay *clientPayment
+	if res.PricePerByte.IsPositive() {
+		if res.PaymentInterval == 0 {
+			return nil, errors.New("miner asked for payment without a payment interval")
+		}
+
+		pay, err = sc.setupPayment(ctx, payer, res.Target, totalPrice)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to set up payment")
+		}
+
+		setup := RetrievalPaymentSetup{
+			Channel: pay.channel,
+			Base:    pay.base,
+		}
+		if err := streamWriter.WriteMsg(&setup); err != nil {
+			return nil, errors.Wrap(err, "failed to write payment setup to stream")
+		}
+
+		var setupRes RetrievePieceResponse
+		if err := streamReader.ReadMsg(&setupRes); err != nil {
+			return nil, errors.Wrap(err, "failed to read payment setup response from stream")
+		}
+		if setupRes.Status != Success {
+			return nil, errors.Errorf("miner refused payment: %s", setupRes.ErrorMessage)
+		}
+	}
+
 	var buf []byte
-	for {
+	var paidFor uint64
+	for uint64(len(buf)) < res.Size {
 		var chunk RetrievePieceChunk
 		if err := streamReader.ReadMsg(&chunk); err != nil {
 			if err == io.EOF {
-				break
+				return nil, errors.Errorf("miner stopped sending after %d of %d bytes", len(buf), res.Size)
 			}
 
 			return nil, errors.Errorf("could not read chunk from stream: %s", err.Error())
 		}
+		if uint64(len(buf)+len(chunk.Data)) > res.Size {
+			return nil, errors.New("miner sent more bytes than are in the piece")
+		}
 
 		buf = append(buf, chunk.Data...)
+
+		received := uint64(len(buf))
+		if pay != nil && (received-paidFor >= res.PaymentInterval || received == res.Size) {
+			if err := sc.sendVoucher(ctx, streamWriter, pay, priceOf(res.PricePerByte, received)); err != nil {
+				return nil, err
+			}
+			paidFor = received
+		}
 	}
 
 	// TODO: Figure out how to stream piece-bytes w/out having to buffer.
@@ -97,6 +181,143 @@ func (sc *Client) RetrievePiece(ctx context.Context, minerPeerID peer.ID, pieceC
 	return buffered, nil
 }
 
+// QueryPiece asks a miner whether it serves a piece, and on what terms.
+func (sc *Client) QueryPiece(ctx context.Context, minerPeerID peer.ID, pieceCID cid.Cid) (*RetrievalQueryResponse, error) {
+	s, err := sc.host.NewStream(ctx, minerPeerID, retrievalQueryProtocol)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create stream to retrieval miner")
+	}
+	defer sc.safeCloseStream(s)
+
+	if err := cbu.NewMsgWriter(s).WriteMsg(&RetrievalQueryRequest{PieceRef: pieceCID}); err != nil {
+		return nil, errors.Wrap(err, "failed to write query to stream")
+	}
+
+	var res RetrievalQueryResponse
+	if err := cbu.NewMsgReader(s).ReadMsg(&res); err != nil {
+		return nil, errors.Wrap(err, "failed to read query response from stream")
+	}
+
+	return &res, nil
+}
+
+// clientPayment is the payment channel a retrieval is paid from.
+type clientPayment struct {
+	payer   address.Address
+	target  address.Address
+	channel *types.ChannelID
+	// base is the amount of the channel promised before the retrieval.
+	base types.AttoFIL
+}
+
+// setupPayment finds a payment channel from payer to target, created for retrievals, that can
+// pay total on top of what it has already promised, or creates one.
+func (sc *Client) setupPayment(ctx context.Context, payer, target address.Address, total types.AttoFIL) (*clientPayment, error) {
+	height, err := sc.api.ChainBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	channels, err := sc.api.PaymentChannelLs(ctx, payer, payer)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list payment channels")
+	}
+
+	// Leave the miner time to redeem before the channel closes.
+	minEol := height.Add(types.NewBlockHeight(2 * MinChannelRemaining))
+	for key, channel := range channels {
+		if channel.Target != target || channel.Eol.LessThan(minEol) {
+			continue
+		}
+		id, ok := types.NewChannelIDFromString(key, 10)
+		if !ok {
+			continue
+		}
+
+		// Only channels created for retrievals are reused, since the promises made on
+		// others, such as storage deal payments, aren't known here.
+		last, err := sc.vouchers.get(payer, id)
+		if err != nil {
+			return nil, err
+		}
+		if last == nil {
+			continue
+		}
+
+		base := last.Amount
+		if base.LessThan(channel.AmountRedeemed) {
+			base = channel.AmountRedeemed
+		}
+		if channel.Amount.LessThan(base.Add(total)) {
+			continue
+		}
+		return &clientPayment{payer: payer, target: target, channel: id, base: base}, nil
+	}
+
+	return sc.createChannel(ctx, payer, target, total, height)
+}
+
+// createChannel creates a payment channel from payer to target holding value.
+func (sc *Client) createChannel(ctx context.Context, payer, target address.Address, value types.AttoFIL, height *types.BlockHeight) (*clientPayment, error) {
+	eol := height.Add(types.NewBlockHeight(ChannelLifetime))
+	msgCid, err := sc.api.MessageSend(ctx,
+		payer,
+		address.PaymentBrokerAddress,
+		value,
+		types.NewGasPrice(CreateChannelGasPrice),
+		types.NewGasUnits(CreateChannelGasLimit),
+		"createChannel",
+		target,
+		eol)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create payment channel")
+	}
+
+	var channel *types.ChannelID
+	err = sc.api.MessageWait(ctx, msgCid, func(block *types.Block, message *types.SignedMessage, receipt *types.MessageReceipt) error {
+		if receipt.ExitCode != 0 {
+			return fmt.Errorf("createChannel failed %d", receipt.ExitCode)
+		}
+		channel = types.NewChannelIDFromBytes(receipt.Return[0])
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Record the channel as one created for retrievals, with nothing promised yet.
+	err = sc.vouchers.put(&types.PaymentVoucher{
+		Channel: *channel,
+		Payer:   payer,
+		Target:  target,
+		Amount:  types.ZeroAttoFIL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &clientPayment{payer: payer, target: target, channel: channel, base: types.ZeroAttoFIL}, nil
+}
+
+// sendVoucher sends the miner a voucher paying owed on top of the payment's base. The voucher is
+// recorded before it is sent, so that the amount promised on the channel is never underestimated.
+func (sc *Client) sendVoucher(ctx context.Context, w *cbu.MsgWriter, pay *clientPayment, owed types.AttoFIL) error {
+	height, err := sc.api.ChainBlockHeight()
+	if err != nil {
+		return err
+	}
+
+	voucher, err := sc.api.PaymentChannelVoucher(ctx, pay.payer, pay.channel, pay.base.Add(owed), height, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to create voucher")
+	}
+	if err := sc.vouchers.put(voucher); err != nil {
+		return err
+	}
+
+	return errors.Wrap(w.WriteMsg(voucher), "failed to write voucher to stream")
+}
+
 func (sc *Client) safeCloseStream(stream inet.Stream) {
 	if err := stream.Close(); err != nil {
 		log.Errorf("error closing stream: %s", err)
@@ -1,8 +1,18 @@
-// Package retrieval implements a very simple retrieval protocol that works on high level like this:
+// Package retrieval implements a simple retrieval protocol, in which clients pay miners for pieces with payment
+// channel vouchers. On a high level it works like this:
 //
-// 1. CLIENT opens /fil/retrieval/free/0.0.0 stream to MINER
-// 2. CLIENT sends MINER a RetrievePieceRequest
-// 3. MINER sends CLIENT a RetrievePieceResponse with Status set to Success if it has PieceRef in a sealed sector
-// 4. MINER sends CLIENT RetrievePieceChunks until all data associated with PieceRef has been sent
-// 5. CLIENT reads RetrievePieceChunk from stream until EOF and then closes stream
+// 1. CLIENT opens /fil/retrieval/paid/0.0.0 stream to MINER
+// 2. CLIENT sends MINER a RetrievePaidPieceRequest
+// 3. MINER sends CLIENT a RetrievePaidPieceResponse with the piece's size, price per byte and payment interval
+// 4. If the price is not zero, CLIENT sends MINER a RetrievalPaymentSetup naming a payment channel to Target
+// 5. MINER acknowledges the setup with a RetrievePieceResponse
+// 6. MINER sends CLIENT RetrievePieceChunks, waiting for a PaymentVoucher every PaymentInterval bytes
+// 7. CLIENT sends a final PaymentVoucher once it has read all the data, and closes the stream
+//
+// Miners keep the best voucher received on each channel, to redeem it. Miners that don't charge for retrievals
+// also serve the older /fil/retrieval/free/0.0.0 protocol, in which MINER sends the chunks right after a
+// RetrievePieceResponse.
+//
+// Before retrieving, a CLIENT may open a /fil/retrieval/qry/0.0.0 stream and send a RetrievalQueryRequest, to which
+// MINER answers with a RetrievalQueryResponse telling whether it has the piece, its size and its price.
 package retrieval
@@ -1,39 +1,81 @@
 package retrieval
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"time"
 
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
 	logging "github.com/ipfs/go-log"
 	host "github.com/libp2p/go-libp2p-host"
 	inet "github.com/libp2p/go-libp2p-net"
 	"github.com/libp2p/go-libp2p-protocol"
+	"github.com/pkg/errors"
 
+	"github.com/filecoin-project/go-filecoin/actor/builtin/paymentbroker"
+	"github.com/filecoin-project/go-filecoin/address"
 	cbu "github.com/filecoin-project/go-filecoin/cborutil"
 	"github.com/filecoin-project/go-filecoin/proofs/sectorbuilder"
+	"github.com/filecoin-project/go-filecoin/repo"
+	"github.com/filecoin-project/go-filecoin/types"
 )
 
 var log = logging.Logger("/fil/retrieval")
 
 const retrievalFreeProtocol = protocol.ID("/fil/retrieval/free/0.0.0")
 
+const retrievalPaidProtocol = protocol.ID("/fil/retrieval/paid/0.0.0")
+
+const retrievalQueryProtocol = protocol.ID("/fil/retrieval/qry/0.0.0")
+
+// voucherTimeout is how long a retrieval miner waits for a voucher before it stops serving.
+const voucherTimeout = time.Minute
+
 // TODO: better name
 type minerNode interface {
 	Host() host.Host
 	SectorBuilder() sectorbuilder.SectorBuilder
 }
 
+// minerPorcelain is the subset of the porcelain API that retrieval.Miner needs.
+type minerPorcelain interface {
+	ChainBlockHeight() (*types.BlockHeight, error)
+	ConfigGet(dottedPath string) (interface{}, error)
+	MessageQuery(ctx context.Context, optFrom, to address.Address, method string, params ...interface{}) ([][]byte, error)
+	MessageSend(ctx context.Context, from, to address.Address, value types.AttoFIL, gasPrice types.AttoFIL, gasLimit types.GasUnits, method string, params ...interface{}) (cid.Cid, error)
+	MessageWait(ctx context.Context, msgCid cid.Cid, cb func(*types.Block, *types.SignedMessage, *types.MessageReceipt) error) error
+	MinerGetOwnerAddress(ctx context.Context, minerAddr address.Address) (address.Address, error)
+}
+
 // Miner serves requests for pieces from RetrievalClients.
 type Miner struct {
-	node minerNode
+	node         minerNode
+	porcelainAPI minerPorcelain
+
+	// vouchers holds the best voucher received on each payment channel, for redemption.
+	vouchers *voucherStore
+	// redeems holds the last voucher sent to be redeemed on each payment channel.
+	redeems *voucherStore
+	// redeemCh wakes the redeemer goroutine.
+	redeemCh chan struct{}
 }
 
 // NewMiner is used to create a Miner and bind a handling function to the piece retrieval protocol.
-func NewMiner(nd minerNode) *Miner {
+func NewMiner(nd minerNode, porcelainAPI minerPorcelain, ds repo.Datastore) *Miner {
 	rm := &Miner{
-		node: nd,
+		node:         nd,
+		porcelainAPI: porcelainAPI,
+		vouchers:     &voucherStore{ds: ds, prefix: MinerVouchersPrefix},
+		redeems:      &voucherStore{ds: ds, prefix: MinerRedeemsPrefix},
+		redeemCh:     make(chan struct{}, 1),
 	}
 
 	nd.Host().SetStreamHandler(retrievalFreeProtocol, rm.handleRetrievePieceForFree)
+	nd.Host().SetStreamHandler(retrievalPaidProtocol, rm.handleRetrievePiece)
+	nd.Host().SetStreamHandler(retrievalQueryProtocol, rm.handleQuery)
 
 	return rm
 }
@@ -47,10 +89,7 @@ func (rm *Miner) handleRetrievePieceForFree(s inet.Stream) {
 		return
 	}
 
-	reader, err := rm.node.SectorBuilder().ReadPieceFromSealedSector(req.PieceRef)
-	if err != nil {
-		log.Warningf("failed to obtain a reader for piece with CID %s: %s", req.PieceRef.String(), err)
-
+	writeFailure := func(err error) {
 		resp := RetrievePieceResponse{
 			Status:       Failure,
 			ErrorMessage: err.Error(),
@@ -59,13 +98,22 @@ func (rm *Miner) handleRetrievePieceForFree(s inet.Stream) {
 		if err := cbu.NewMsgWriter(s).WriteMsg(&resp); err != nil {
 			log.Warningf("failed to write response for piece with CID %s: %s", req.PieceRef.String(), err)
 		}
+	}
 
+	price, err := rm.getRetrievalPrice()
+	if err != nil {
+		writeFailure(err)
+		return
+	}
+	if price.IsPositive() {
+		writeFailure(fmt.Errorf("miner charges %s per byte for retrievals", price))
 		return
 	}
 
-	bs, err := ioutil.ReadAll(reader)
+	piece, size, err := rm.readPiece(req.PieceRef, PieceRange{Offset: req.Offset, Length: req.Length})
 	if err != nil {
-		log.Errorf("failed to read all bytes: %s", err)
+		writeFailure(err)
+		return
 	}
 
 	resp := RetrievePieceResponse{
@@ -77,20 +125,386 @@ func (rm *Miner) handleRetrievePieceForFree(s inet.Stream) {
 		return
 	}
 
-	for i := 0; i < len(bs); i += RetrievePieceChunkSize {
-		end := i + RetrievePieceChunkSize
+	buf := make([]byte, RetrievePieceChunkSize)
+	for sent := uint64(0); sent < size; {
+		n := size - sent
+		if n > RetrievePieceChunkSize {
+			n = RetrievePieceChunkSize
+		}
 
-		if end > len(bs) {
-			end = len(bs)
+		if _, err := io.ReadFull(piece, buf[:n]); err != nil {
+			log.Errorf("failed to read piece with CID %s: %s", req.PieceRef.String(), err)
+			return
 		}
 
 		chunk := RetrievePieceChunk{
-			Data: bs[i:end],
+			Data: buf[:n],
 		}
 
 		if err := cbu.NewMsgWriter(s).WriteMsg(&chunk); err != nil {
 			log.Warningf("failed to write chunk for CID %s: %s", req.PieceRef.String(), err)
 			return
 		}
+		sent += n
+	}
+}
+
+// handleRetrievePiece serves a piece for payment. It sends at most PaymentInterval bytes that
+// haven't been paid for, and stops serving if the client doesn't pay for them.
+func (rm *Miner) handleRetrievePiece(s inet.Stream) {
+	defer s.Close() // nolint: errcheck
+
+	ctx := context.Background()
+	reader := cbu.NewMsgReader(s)
+	writer := cbu.NewMsgWriter(s)
+
+	var req RetrievePaidPieceRequest
+	if err := reader.ReadMsg(&req); err != nil {
+		log.Errorf("failed to read paid piece retrieval request: %s", err)
+		return
+	}
+
+	writeFailure := func(err error) {
+		resp := RetrievePaidPieceResponse{
+			Status:       Failure,
+			ErrorMessage: err.Error(),
+		}
+		if err := writer.WriteMsg(&resp); err != nil {
+			log.Warningf("failed to write response for piece with CID %s: %s", req.PieceRef.String(), err)
+		}
+	}
+
+	price, err := rm.getRetrievalPrice()
+	if err != nil {
+		writeFailure(err)
+		return
+	}
+	target, err := rm.getPaymentTarget(ctx)
+	if err != nil {
+		writeFailure(err)
+		return
+	}
+
+	piece, size, err := rm.readPiece(req.PieceRef, PieceRange{Offset: req.Offset, Length: req.Length})
+	if err != nil {
+		writeFailure(err)
+		return
+	}
+
+	resp := RetrievePaidPieceResponse{
+		Status:          Success,
+		Size:            size,
+		PricePerByte:    price,
+		PaymentInterval: PaymentInterval,
+		Target:          target,
+	}
+	if err := writer.WriteMsg(&resp); err != nil {
+		log.Warningf("failed to write response for piece with CID %s: %s", req.PieceRef.String(), err)
+		return
+	}
+
+	var pay *payment
+	if price.IsPositive() {
+		var setup RetrievalPaymentSetup
+		if err := reader.ReadMsg(&setup); err != nil {
+			log.Warningf("failed to read payment setup for piece with CID %s: %s", req.PieceRef.String(), err)
+			return
+		}
+
+		// The setup is acknowledged with a RetrievePieceResponse.
+		setupResp := RetrievePieceResponse{Status: Success}
+		pay, err = rm.startPayment(ctx, req.Payer, target, &setup, priceOf(price, size))
+		if err != nil {
+			log.Warningf("refusing payment for piece with CID %s: %s", req.PieceRef.String(), err)
+			setupResp = RetrievePieceResponse{Status: Failure, ErrorMessage: err.Error()}
+		}
+		if err := writer.WriteMsg(&setupResp); err != nil {
+			log.Warningf("failed to write response for piece with CID %s: %s", req.PieceRef.String(), err)
+			return
+		}
+		if pay == nil {
+			return
+		}
+	}
+
+	buf := make([]byte, RetrievePieceChunkSize)
+	var sent, paidFor uint64
+	for sent < size {
+		if pay != nil && sent == paidFor+PaymentInterval {
+			if err := rm.receiveVoucher(ctx, s, reader, pay, priceOf(price, sent)); err != nil {
+				log.Warningf("stopped serving piece with CID %s after %d bytes: %s", req.PieceRef.String(), sent, err)
+				return
+			}
+			paidFor = sent
+		}
+
+		end := sent + RetrievePieceChunkSize
+		if end > size {
+			end = size
+		}
+		if pay != nil && end > paidFor+PaymentInterval {
+			end = paidFor + PaymentInterval
+		}
+
+		data := buf[:end-sent]
+		if _, err := io.ReadFull(piece, data); err != nil {
+			log.Errorf("failed to read piece with CID %s: %s", req.PieceRef.String(), err)
+			return
+		}
+		if err := writer.WriteMsg(&RetrievePieceChunk{Data: data}); err != nil {
+			log.Warningf("failed to write chunk for CID %s: %s", req.PieceRef.String(), err)
+			return
+		}
+		sent = end
+	}
+
+	if pay != nil && paidFor < size {
+		if err := rm.receiveVoucher(ctx, s, reader, pay, priceOf(price, size)); err != nil {
+			log.Warningf("piece with CID %s was not paid for in full: %s", req.PieceRef.String(), err)
+		}
+	}
+}
+
+// handleQuery tells a client whether a piece is available, and on what terms, from the sector
+// builder's metadata, without reading the piece.
+func (rm *Miner) handleQuery(s inet.Stream) {
+	defer s.Close() // nolint: errcheck
+
+	var req RetrievalQueryRequest
+	if err := cbu.NewMsgReader(s).ReadMsg(&req); err != nil {
+		log.Errorf("failed to read retrieval query: %s", err)
+		return
+	}
+
+	resp := rm.query(req.PieceRef)
+	if err := cbu.NewMsgWriter(s).WriteMsg(&resp); err != nil {
+		log.Warningf("failed to write query response for piece with CID %s: %s", req.PieceRef.String(), err)
+	}
+}
+
+func (rm *Miner) query(pieceRef cid.Cid) RetrievalQueryResponse {
+	unavailable := func(err error) RetrievalQueryResponse {
+		return RetrievalQueryResponse{ErrorMessage: err.Error()}
+	}
+
+	sb := rm.node.SectorBuilder()
+	if sb == nil {
+		return unavailable(errors.New("mining disabled, no pieces to retrieve"))
+	}
+
+	piece, err := sb.SealedPieceInfo(pieceRef)
+	if err != nil {
+		return unavailable(err)
+	}
+	if piece == nil {
+		return unavailable(fmt.Errorf("no sealed sector holds piece %s", pieceRef.String()))
+	}
+
+	price, err := rm.getRetrievalPrice()
+	if err != nil {
+		return unavailable(err)
+	}
+
+	return RetrievalQueryResponse{
+		Available:       true,
+		Size:            piece.Size,
+		PricePerByte:    price,
+		PaymentInterval: PaymentInterval,
+	}
+}
+
+// payment is the state of payment for a retrieval.
+type payment struct {
+	payer   address.Address
+	channel *paymentbroker.PaymentChannel
+	setup   *RetrievalPaymentSetup
+	// promised is the amount of the channel promised by the retrieval's latest voucher, or
+	// its base before the first.
+	promised types.AttoFIL
+}
+
+// startPayment checks that the payment channel in setup can pay total on top of what has
+// already been promised from it.
+func (rm *Miner) startPayment(ctx context.Context, payer, target address.Address, setup *RetrievalPaymentSetup, total types.AttoFIL) (*payment, error) {
+	if setup.Channel == nil {
+		return nil, errors.New("no payment channel given")
+	}
+
+	channel, err := rm.paymentChannel(ctx, payer, setup.Channel)
+	if err != nil {
+		return nil, err
+	}
+
+	if channel.Target != target {
+		return nil, fmt.Errorf("payment channel pays %s, not %s", channel.Target, target)
+	}
+
+	height, err := rm.porcelainAPI.ChainBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+	if channel.Eol.LessThan(height.Add(types.NewBlockHeight(MinChannelRemaining))) {
+		return nil, fmt.Errorf("payment channel closes at %s, too soon to redeem payment", channel.Eol)
+	}
+
+	promised, err := rm.vouchers.promised(payer, setup.Channel)
+	if err != nil {
+		return nil, err
+	}
+	if setup.Base.LessThan(promised) || setup.Base.LessThan(channel.AmountRedeemed) {
+		return nil, fmt.Errorf("base %s is less than the amount already promised from the channel", setup.Base)
+	}
+	if channel.Amount.LessThan(setup.Base.Add(total)) {
+		return nil, fmt.Errorf("payment channel holds %s, not enough to pay %s on top of %s", channel.Amount, total, setup.Base)
+	}
+
+	return &payment{payer: payer, channel: channel, setup: setup, promised: setup.Base}, nil
+}
+
+// paymentChannel returns a payment channel of payer.
+func (rm *Miner) paymentChannel(ctx context.Context, payer address.Address, id *types.ChannelID) (*paymentbroker.PaymentChannel, error) {
+	ret, err := rm.porcelainAPI.MessageQuery(ctx, address.Undef, address.PaymentBrokerAddress, "ls", payer)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get payment channels of payer")
+	}
+	var channels map[string]*paymentbroker.PaymentChannel
+	if err := cbor.DecodeInto(ret[0], &channels); err != nil {
+		return nil, errors.Wrap(err, "failed to decode payment channels of payer")
+	}
+	channel, ok := channels[id.KeyString()]
+	if !ok {
+		return nil, fmt.Errorf("payer %s has no payment channel %s", payer, id)
+	}
+	return channel, nil
+}
+
+// receiveVoucher reads a voucher from the client and checks that it pays owed on top of the
+// payment's base. The voucher is stored, so that it may be redeemed, as long as no other
+// retrieval was paid on the channel since the payment's last voucher.
+func (rm *Miner) receiveVoucher(ctx context.Context, s inet.Stream, reader *cbu.MsgReader, pay *payment, owed types.AttoFIL) error {
+	if err := s.SetReadDeadline(time.Now().Add(voucherTimeout)); err != nil {
+		return err
+	}
+	defer s.SetReadDeadline(time.Time{}) // nolint: errcheck
+
+	var voucher types.PaymentVoucher
+	if err := reader.ReadMsg(&voucher); err != nil {
+		return errors.Wrap(err, "failed to read voucher")
+	}
+
+	if !voucher.Channel.Equal(pay.setup.Channel) {
+		return fmt.Errorf("voucher is for channel %s, not %s", &voucher.Channel, pay.setup.Channel)
+	}
+	if voucher.Payer != pay.payer {
+		return fmt.Errorf("voucher is from %s, not %s", voucher.Payer, pay.payer)
+	}
+	if voucher.Condition != nil {
+		return errors.New("voucher has a condition")
+	}
+	if !paymentbroker.VerifyVoucherSignature(pay.payer, &voucher.Channel, voucher.Amount, &voucher.ValidAt, voucher.Condition, voucher.Signature) {
+		return errors.New("invalid voucher signature")
+	}
+	if voucher.Amount.LessThan(pay.setup.Base.Add(owed)) {
+		return fmt.Errorf("voucher pays %s, less than the %s owed", voucher.Amount, pay.setup.Base.Add(owed))
+	}
+	if voucher.Amount.GreaterThan(pay.channel.Amount) {
+		return fmt.Errorf("voucher pays %s, more than the channel holds", voucher.Amount)
+	}
+	if !voucher.ValidAt.LessThan(pay.channel.Eol) {
+		return fmt.Errorf("voucher is valid at %s, after the channel closes", &voucher.ValidAt)
+	}
+
+	if err := rm.vouchers.advance(&voucher, pay.promised); err != nil {
+		return err
+	}
+	pay.promised = voucher.Amount
+	return nil
+}
+
+// readPiece returns a reader of the part of a piece selected by pr, and its size. The piece is
+// read from its sealed sector, and only the selected bytes are read from the returned reader.
+func (rm *Miner) readPiece(pieceRef cid.Cid, pr PieceRange) (io.Reader, uint64, error) {
+	sb := rm.node.SectorBuilder()
+	if sb == nil {
+		return nil, 0, errors.New("mining disabled, no pieces to retrieve")
+	}
+
+	piece, err := sb.SealedPieceInfo(pieceRef)
+	if err != nil {
+		return nil, 0, err
+	}
+	if piece == nil {
+		return nil, 0, fmt.Errorf("no sealed sector holds piece %s", pieceRef.String())
+	}
+
+	reader, err := sb.ReadPieceFromSealedSector(pieceRef)
+	if err != nil {
+		log.Warningf("failed to obtain a reader for piece with CID %s: %s", pieceRef.String(), err)
+		return nil, 0, err
+	}
+	size := piece.Size
+
+	if pr.Offset > size {
+		return nil, 0, fmt.Errorf("offset %d is beyond the end of the piece", pr.Offset)
+	}
+	if err := skip(reader, pr.Offset); err != nil {
+		return nil, 0, err
+	}
+	size -= pr.Offset
+	if pr.Length > 0 && pr.Length < size {
+		size = pr.Length
+	}
+	return reader, size, nil
+}
+
+// skip advances reader by n bytes, seeking if it can.
+func skip(reader io.Reader, n uint64) error {
+	if n == 0 {
+		return nil
+	}
+
+	if seeker, ok := reader.(io.Seeker); ok {
+		end, err := seeker.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		if uint64(end) < n {
+			return fmt.Errorf("offset %d is beyond the end of the piece", n)
+		}
+		_, err = seeker.Seek(int64(n), io.SeekStart)
+		return err
+	}
+
+	if _, err := io.CopyN(ioutil.Discard, reader, int64(n)); err != nil {
+		if err == io.EOF {
+			return fmt.Errorf("offset %d is beyond the end of the piece", n)
+		}
+		return err
+	}
+	return nil
+}
+
+func (rm *Miner) getRetrievalPrice() (types.AttoFIL, error) {
+	retrievalPrice, err := rm.porcelainAPI.ConfigGet("mining.retrievalPrice")
+	if err != nil {
+		return types.ZeroAttoFIL, err
+	}
+	retrievalPriceAF, ok := retrievalPrice.(types.AttoFIL)
+	if !ok {
+		return types.ZeroAttoFIL, errors.New("Could not retrieve retrievalPrice from config")
+	}
+	return retrievalPriceAF, nil
+}
+
+// getPaymentTarget returns the owner of the node's miner, to whom retrievals are paid.
+func (rm *Miner) getPaymentTarget(ctx context.Context) (address.Address, error) {
+	minerAddr, err := rm.porcelainAPI.ConfigGet("mining.minerAddress")
+	if err != nil {
+		return address.Undef, err
+	}
+	minerAddrA, ok := minerAddr.(address.Address)
+	if !ok || minerAddrA.Empty() {
+		return address.Undef, errors.New("node has no miner")
 	}
+	return rm.porcelainAPI.MinerGetOwnerAddress(ctx, minerAddrA)
 }
@@ -0,0 +1,121 @@
+package retrieval
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	host "github.com/libp2p/go-libp2p-host"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-filecoin/proofs/sectorbuilder"
+	tf "github.com/filecoin-project/go-filecoin/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/types"
+)
+
+// onlyReader hides the Seek method of a reader.
+type onlyReader struct {
+	r *bytes.Reader
+}
+
+func (or *onlyReader) Read(p []byte) (int, error) {
+	return or.r.Read(p)
+}
+
+func TestSkip(t *testing.T) {
+	tf.UnitTest(t)
+
+	data := []byte("0123456789")
+
+	t.Run("seeks readers that can seek", func(t *testing.T) {
+		r := bytes.NewReader(data)
+		require.NoError(t, skip(r, 4))
+
+		rest, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("456789"), rest)
+	})
+
+	t.Run("discards from readers that can't seek", func(t *testing.T) {
+		r := &onlyReader{bytes.NewReader(data)}
+		require.NoError(t, skip(r, 4))
+
+		rest, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("456789"), rest)
+	})
+
+	t.Run("fails beyond the end", func(t *testing.T) {
+		assert.Error(t, skip(bytes.NewReader(data), 11))
+		assert.Error(t, skip(&onlyReader{bytes.NewReader(data)}, 11))
+	})
+}
+
+func TestReadPiece(t *testing.T) {
+	tf.UnitTest(t)
+
+	data := []byte("0123456789")
+
+	newCid := types.NewCidForTestGetter()
+	pieceRef := newCid()
+
+	rm := &Miner{node: &testNode{sectorBuilder: &testSectorBuilder{pieces: map[cid.Cid][]byte{pieceRef: data}}}}
+
+	read := func(t *testing.T, pr PieceRange) []byte {
+		reader, size, err := rm.readPiece(pieceRef, pr)
+		require.NoError(t, err)
+
+		bs := make([]byte, size)
+		_, err = io.ReadFull(reader, bs)
+		require.NoError(t, err)
+		return bs
+	}
+
+	t.Run("reads the whole piece", func(t *testing.T) {
+		assert.Equal(t, data, read(t, PieceRange{}))
+	})
+
+	t.Run("reads a range of the piece", func(t *testing.T) {
+		assert.Equal(t, []byte("3456"), read(t, PieceRange{Offset: 3, Length: 4}))
+		assert.Equal(t, []byte("789"), read(t, PieceRange{Offset: 7}))
+		assert.Equal(t, []byte("89"), read(t, PieceRange{Offset: 8, Length: 100}))
+	})
+
+	t.Run("fails beyond the end of the piece", func(t *testing.T) {
+		_, _, err := rm.readPiece(pieceRef, PieceRange{Offset: 11})
+		assert.Error(t, err)
+	})
+
+	t.Run("fails for a piece in no sealed sector", func(t *testing.T) {
+		_, _, err := rm.readPiece(newCid(), PieceRange{})
+		assert.Error(t, err)
+	})
+}
+
+type testNode struct {
+	sectorBuilder sectorbuilder.SectorBuilder
+}
+
+func (tn *testNode) Host() host.Host                            { return nil }
+func (tn *testNode) SectorBuilder() sectorbuilder.SectorBuilder { return tn.sectorBuilder }
+
+// testSectorBuilder holds the bytes of sealed pieces.
+type testSectorBuilder struct {
+	sectorbuilder.SectorBuilder
+	pieces map[cid.Cid][]byte
+}
+
+func (tsb *testSectorBuilder) ReadPieceFromSealedSector(pieceCid cid.Cid) (io.Reader, error) {
+	return bytes.NewReader(tsb.pieces[pieceCid]), nil
+}
+
+func (tsb *testSectorBuilder) SealedPieceInfo(pieceCid cid.Cid) (*sectorbuilder.PieceInfo, error) {
+	data, ok := tsb.pieces[pieceCid]
+	if !ok {
+		return nil, nil
+	}
+	return &sectorbuilder.PieceInfo{Ref: pieceCid, Size: uint64(len(data))}, nil
+}
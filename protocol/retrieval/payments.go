@@ -0,0 +1,164 @@
+package retrieval
+
+import (
+	"math/big"
+	"sync"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/pkg/errors"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/repo"
+	"github.com/filecoin-project/go-filecoin/types"
+)
+
+const (
+	// PaymentInterval is the number of bytes a retrieval miner sends before it waits for a
+	// voucher paying for them.
+	PaymentInterval = 16 * RetrievePieceChunkSize
+
+	// ChannelLifetime is the number of blocks a payment channel created for retrievals stays
+	// open.
+	ChannelLifetime = 2000
+
+	// MinChannelRemaining is the number of blocks a payment channel must stay open for a
+	// retrieval miner to accept payment on it, so that it has time to redeem its vouchers.
+	MinChannelRemaining = 200
+
+	// CreateChannelGasPrice is the gas price of the message used to create the payment channel
+	CreateChannelGasPrice = 1
+
+	// CreateChannelGasLimit is the gas limit of the message used to create the payment channel
+	CreateChannelGasLimit = 300
+)
+
+// ClientVouchersPrefix is the datastore prefix of the last voucher a retrieval client gave on
+// each payment channel.
+const ClientVouchersPrefix = "retrieval/client/vouchers"
+
+// MinerVouchersPrefix is the datastore prefix of the best voucher a retrieval miner received on
+// each payment channel.
+const MinerVouchersPrefix = "retrieval/miner/vouchers"
+
+// MinerRedeemsPrefix is the datastore prefix of the last voucher a retrieval miner sent to be
+// redeemed on each payment channel.
+const MinerRedeemsPrefix = "retrieval/miner/redeems"
+
+// voucherStore keeps one voucher per payment channel. Vouchers on a channel are for cumulative
+// amounts, so the highest one supersedes the others.
+type voucherStore struct {
+	ds     repo.Datastore
+	prefix string
+
+	// lk makes checking the stored voucher and replacing it atomic.
+	lk sync.Mutex
+}
+
+func (vs *voucherStore) key(payer address.Address, channel *types.ChannelID) datastore.Key {
+	return datastore.KeyWithNamespaces([]string{vs.prefix, payer.String(), channel.KeyString()})
+}
+
+// get returns the voucher stored for a channel, or nil if there is none.
+func (vs *voucherStore) get(payer address.Address, channel *types.ChannelID) (*types.PaymentVoucher, error) {
+	raw, err := vs.ds.Get(vs.key(payer, channel))
+	if err == datastore.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to read voucher")
+	}
+
+	var voucher types.PaymentVoucher
+	if err := cbor.DecodeInto(raw, &voucher); err != nil {
+		return nil, errors.Wrap(err, "failed to decode voucher")
+	}
+	return &voucher, nil
+}
+
+// put stores a voucher, unless a voucher for at least as much is already stored for its channel.
+func (vs *voucherStore) put(voucher *types.PaymentVoucher) error {
+	vs.lk.Lock()
+	defer vs.lk.Unlock()
+
+	stored, err := vs.get(voucher.Payer, &voucher.Channel)
+	if err != nil {
+		return err
+	}
+	if stored != nil && voucher.Amount.LessEqual(stored.Amount) {
+		return nil
+	}
+	return vs.store(voucher)
+}
+
+// advance stores a voucher paying more than from, the amount its payer has promised so far
+// in a retrieval. It fails if the stored voucher promises more than from, which means another
+// retrieval was paid on the channel in the meantime with the same promised amounts.
+func (vs *voucherStore) advance(voucher *types.PaymentVoucher, from types.AttoFIL) error {
+	vs.lk.Lock()
+	defer vs.lk.Unlock()
+
+	stored, err := vs.get(voucher.Payer, &voucher.Channel)
+	if err != nil {
+		return err
+	}
+	if stored != nil && stored.Amount.GreaterThan(from) {
+		return errors.Errorf("payment channel %s was paid by another retrieval at the same time", &voucher.Channel)
+	}
+	if !voucher.Amount.GreaterThan(from) {
+		return errors.Errorf("voucher pays %s, no more than the %s already promised", voucher.Amount, from)
+	}
+	return vs.store(voucher)
+}
+
+// delete forgets the voucher stored for a channel.
+func (vs *voucherStore) delete(payer address.Address, channel *types.ChannelID) error {
+	vs.lk.Lock()
+	defer vs.lk.Unlock()
+	return errors.Wrap(vs.ds.Delete(vs.key(payer, channel)), "failed to delete voucher")
+}
+
+// list returns the vouchers stored for all channels.
+func (vs *voucherStore) list() ([]*types.PaymentVoucher, error) {
+	results, err := vs.ds.Query(query.Query{Prefix: datastore.NewKey(vs.prefix).String()})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query vouchers")
+	}
+	defer results.Close() // nolint: errcheck
+
+	var vouchers []*types.PaymentVoucher
+	for result := range results.Next() {
+		if result.Error != nil {
+			return nil, errors.Wrap(result.Error, "failed to read voucher")
+		}
+		var voucher types.PaymentVoucher
+		if err := cbor.DecodeInto(result.Value, &voucher); err != nil {
+			return nil, errors.Wrap(err, "failed to decode voucher")
+		}
+		vouchers = append(vouchers, &voucher)
+	}
+	return vouchers, nil
+}
+
+// store writes a voucher. lk must be held.
+func (vs *voucherStore) store(voucher *types.PaymentVoucher) error {
+	raw, err := cbor.DumpObject(voucher)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode voucher")
+	}
+	return errors.Wrap(vs.ds.Put(vs.key(voucher.Payer, &voucher.Channel), raw), "failed to store voucher")
+}
+
+// promised returns the amount of a channel promised by the stored voucher, or zero.
+func (vs *voucherStore) promised(payer address.Address, channel *types.ChannelID) (types.AttoFIL, error) {
+	voucher, err := vs.get(payer, channel)
+	if err != nil || voucher == nil {
+		return types.ZeroAttoFIL, err
+	}
+	return voucher.Amount, nil
+}
+
+// priceOf returns the price of n bytes at pricePerByte.
+func priceOf(pricePerByte types.AttoFIL, n uint64) types.AttoFIL {
+	return pricePerByte.MulBigInt(new(big.Int).SetUint64(n))
+}
@@ -0,0 +1,62 @@
+package retrieval
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/repo"
+	tf "github.com/filecoin-project/go-filecoin/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/types"
+)
+
+func TestVoucherStore(t *testing.T) {
+	tf.UnitTest(t)
+
+	voucher := func(amount uint64) *types.PaymentVoucher {
+		return &types.PaymentVoucher{
+			Channel: *types.NewChannelID(1),
+			Payer:   address.TestAddress,
+			Amount:  types.NewAttoFILFromFIL(amount),
+		}
+	}
+	newStore := func() *voucherStore {
+		return &voucherStore{ds: repo.NewInMemoryRepo().Datastore(), prefix: MinerVouchersPrefix}
+	}
+
+	t.Run("put keeps the highest voucher", func(t *testing.T) {
+		vs := newStore()
+		require.NoError(t, vs.put(voucher(2)))
+		require.NoError(t, vs.put(voucher(1)))
+
+		promised, err := vs.promised(address.TestAddress, types.NewChannelID(1))
+		require.NoError(t, err)
+		assert.Equal(t, types.NewAttoFILFromFIL(2), promised)
+
+		require.NoError(t, vs.put(voucher(3)))
+		vouchers, err := vs.list()
+		require.NoError(t, err)
+		require.Len(t, vouchers, 1)
+		assert.Equal(t, types.NewAttoFILFromFIL(3), vouchers[0].Amount)
+	})
+
+	t.Run("advance rejects a voucher paid from an amount already promised again", func(t *testing.T) {
+		vs := newStore()
+		require.NoError(t, vs.advance(voucher(2), types.NewAttoFILFromFIL(1)))
+
+		// A second retrieval started from the same base.
+		assert.Error(t, vs.advance(voucher(3), types.NewAttoFILFromFIL(1)))
+
+		require.NoError(t, vs.advance(voucher(3), types.NewAttoFILFromFIL(2)))
+		promised, err := vs.promised(address.TestAddress, types.NewChannelID(1))
+		require.NoError(t, err)
+		assert.Equal(t, types.NewAttoFILFromFIL(3), promised)
+	})
+
+	t.Run("advance rejects a voucher paying nothing more", func(t *testing.T) {
+		vs := newStore()
+		assert.Error(t, vs.advance(voucher(1), types.NewAttoFILFromFIL(1)))
+	})
+}
@@ -0,0 +1,147 @@
+package retrieval
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/config"
+	"github.com/filecoin-project/go-filecoin/types"
+)
+
+// NotifyNewHead wakes the goroutine redeeming the miner's vouchers (see RunRedeemer) to check
+// for vouchers to redeem at the new head. It never blocks.
+func (rm *Miner) NotifyNewHead() {
+	select {
+	case rm.redeemCh <- struct{}{}:
+	default:
+	}
+}
+
+// RunRedeemer redeems the miner's vouchers each time it is notified of a new head, until ctx
+// is done.
+func (rm *Miner) RunRedeemer(ctx context.Context) {
+	for {
+		select {
+		case <-rm.redeemCh:
+			if err := rm.redeemVouchers(ctx); err != nil {
+				log.Errorf("failed to redeem retrieval vouchers: %s", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// redeemVouchers redeems the best voucher received on each payment channel once the channel's
+// eol is within the margin of the vouchers config. No retrieval starts on a channel that
+// close to its eol (see MinChannelRemaining), so one message redeems the payments of all the
+// retrievals made with it. A channel is only redeemed again if a retrieval still running then
+// pays more. Vouchers of channels past their eol are forgotten.
+func (rm *Miner) redeemVouchers(ctx context.Context) error {
+	policy, err := rm.getVoucherPolicy()
+	if err != nil {
+		return err
+	}
+	if !policy.AutoRedeem {
+		return nil
+	}
+
+	height, err := rm.porcelainAPI.ChainBlockHeight()
+	if err != nil {
+		return err
+	}
+
+	vouchers, err := rm.vouchers.list()
+	if err != nil {
+		return err
+	}
+	for _, v := range vouchers {
+		if err := rm.redeemVoucher(ctx, v, height, policy); err != nil {
+			log.Errorf("failed to redeem voucher of payment channel %s from %s: %s", &v.Channel, v.Payer, err)
+		}
+	}
+	return nil
+}
+
+func (rm *Miner) redeemVoucher(ctx context.Context, v *types.PaymentVoucher, height *types.BlockHeight, policy *config.VoucherConfig) error {
+	channel, err := rm.paymentChannel(ctx, v.Payer, &v.Channel)
+	if err != nil {
+		return err
+	}
+
+	if height.GreaterEqual(channel.Eol) {
+		// Nothing more can be redeemed from the channel.
+		if err := rm.redeems.delete(v.Payer, &v.Channel); err != nil {
+			return err
+		}
+		return rm.vouchers.delete(v.Payer, &v.Channel)
+	}
+	if height.Add(types.NewBlockHeight(policy.EolMargin)).LessThan(channel.Eol) || height.LessThan(&v.ValidAt) {
+		return nil
+	}
+	if v.Amount.LessEqual(channel.AmountRedeemed) {
+		return nil
+	}
+	sent, err := rm.redeems.promised(v.Payer, &v.Channel)
+	if err != nil {
+		return err
+	}
+	if v.Amount.LessEqual(sent) {
+		// Being redeemed already.
+		return nil
+	}
+
+	msgCid, err := rm.porcelainAPI.MessageSend(
+		ctx,
+		channel.Target,
+		address.PaymentBrokerAddress,
+		types.ZeroAttoFIL,
+		policy.GasPrice,
+		policy.GasLimit,
+		"redeem",
+		v.Payer,
+		&v.Channel,
+		v.Amount,
+		&v.ValidAt,
+		v.Condition,
+		[]byte(v.Signature),
+		[]interface{}{},
+	)
+	if err != nil {
+		return err
+	}
+	if err := rm.redeems.put(v); err != nil {
+		return err
+	}
+
+	go func() {
+		err := rm.porcelainAPI.MessageWait(ctx, msgCid, func(blk *types.Block, smsg *types.SignedMessage, receipt *types.MessageReceipt) error {
+			if receipt.ExitCode != 0 {
+				return errors.Errorf("redeem message %s failed with exit code %d", msgCid, receipt.ExitCode)
+			}
+			return nil
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Errorf("failed to redeem voucher of payment channel %s from %s: %s", &v.Channel, v.Payer, err)
+			// Let the voucher be redeemed again.
+			if err := rm.redeems.delete(v.Payer, &v.Channel); err != nil {
+				log.Errorf("failed to forget redeem of payment channel %s from %s: %s", &v.Channel, v.Payer, err)
+			}
+		}
+	}()
+	return nil
+}
+
+func (rm *Miner) getVoucherPolicy() (*config.VoucherConfig, error) {
+	policy, err := rm.porcelainAPI.ConfigGet("vouchers")
+	if err != nil {
+		return nil, err
+	}
+	policyConfig, ok := policy.(*config.VoucherConfig)
+	if !ok || policyConfig == nil {
+		return nil, errors.New("Could not retrieve vouchers from config")
+	}
+	return policyConfig, nil
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p-peer"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/filecoin-project/go-filecoin/address"
@@ -39,8 +40,27 @@ func TestRetrievalProtocolPieceNotFound(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestRetrievalQueryPieceNotFound(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+
+	minerNode, clientNode, minerAddr, _ := configureMinerAndClient(t)
+
+	require.NoError(t, minerNode.StartMining(ctx))
+	defer minerNode.StopMining(ctx)
+
+	minerPID, err := clientNode.PorcelainAPI.MinerGetPeerID(ctx, minerAddr)
+	require.NoError(t, err)
+
+	res, err := clientNode.RetrievalAPI.QueryPiece(ctx, types.NewCidForTestGetter()(), minerPID)
+	require.NoError(t, err)
+	assert.False(t, res.Available)
+	assert.NotEmpty(t, res.ErrorMessage)
+}
+
 func retrievePieceBytes(ctx context.Context, retrievalAPI *retrieval.API, data cid.Cid, minerPID peer.ID, addr address.Address) ([]byte, error) {
-	r, err := retrievalAPI.RetrievePiece(ctx, data, minerPID, addr)
+	r, err := retrievalAPI.RetrievePiece(ctx, data, retrieval.PieceRange{}, minerPID, addr, address.Undef, types.ZeroAttoFIL)
 	if err != nil {
 		return nil, err
 	}
@@ -3,12 +3,20 @@ package retrieval
 import (
 	"github.com/ipfs/go-cid"
 	cbor "github.com/ipfs/go-ipld-cbor"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/types"
 )
 
 func init() {
 	cbor.RegisterCborType(RetrievePieceRequest{})
 	cbor.RegisterCborType(RetrievePieceResponse{})
 	cbor.RegisterCborType(RetrievePieceChunk{})
+	cbor.RegisterCborType(RetrievePaidPieceRequest{})
+	cbor.RegisterCborType(RetrievePaidPieceResponse{})
+	cbor.RegisterCborType(RetrievalPaymentSetup{})
+	cbor.RegisterCborType(RetrievalQueryRequest{})
+	cbor.RegisterCborType(RetrievalQueryResponse{})
 }
 
 // RetrievePieceStatus communicates a successful (or failed) piece retrieval
@@ -25,9 +33,19 @@ const (
 	Success
 )
 
+// PieceRange selects the part of a piece to retrieve. Its zero value selects the whole piece.
+type PieceRange struct {
+	// Offset is the number of bytes to skip.
+	Offset uint64
+	// Length is the number of bytes to retrieve, or zero for all those after Offset.
+	Length uint64
+}
+
 // RetrievePieceRequest represents a retrieval miner's request for content.
 type RetrievePieceRequest struct {
 	PieceRef cid.Cid
+	Offset   uint64
+	Length   uint64
 }
 
 // RetrievePieceResponse contains the requested content.
@@ -40,3 +58,58 @@ type RetrievePieceResponse struct {
 type RetrievePieceChunk struct {
 	Data []byte
 }
+
+// RetrievePaidPieceRequest asks a retrieval miner for the terms on which it serves a piece.
+type RetrievePaidPieceRequest struct {
+	PieceRef cid.Cid
+	// Payer is the address that pays for the piece.
+	Payer address.Address
+	// Offset and Length select the part of the piece to retrieve, as in PieceRange.
+	Offset uint64
+	Length uint64
+}
+
+// RetrievePaidPieceResponse gives the terms on which a retrieval miner serves a piece.
+type RetrievePaidPieceResponse struct {
+	Status       RetrievePieceStatus
+	ErrorMessage string
+	// Size is the number of bytes in the requested part of the piece.
+	Size uint64
+	// PricePerByte is the price of each byte of the piece. If it is zero, the miner streams the
+	// piece right away. Otherwise it waits for a RetrievalPaymentSetup.
+	PricePerByte types.AttoFIL
+	// PaymentInterval is the number of bytes the miner sends before waiting for a voucher that
+	// pays for them.
+	PaymentInterval uint64
+	// Target is the address payments must be made to.
+	Target address.Address
+}
+
+// RetrievalPaymentSetup tells a retrieval miner which payment channel the client pays from.
+// Vouchers the client sends on the channel are for Base plus the price of the bytes received
+// so far.
+type RetrievalPaymentSetup struct {
+	Channel *types.ChannelID
+	// Base is the amount of the channel already promised to the miner by earlier vouchers.
+	Base types.AttoFIL
+}
+
+// RetrievalQueryRequest asks a retrieval miner whether it serves a piece, and on what terms.
+type RetrievalQueryRequest struct {
+	PieceRef cid.Cid
+}
+
+// RetrievalQueryResponse tells whether a retrieval miner serves a piece, and on what terms.
+type RetrievalQueryResponse struct {
+	// Available is true if the miner holds the piece in a sealed sector.
+	Available bool
+	// ErrorMessage tells why the piece isn't available.
+	ErrorMessage string
+	// Size is the number of bytes in the piece.
+	Size uint64
+	// PricePerByte is the price of each byte of the piece.
+	PricePerByte types.AttoFIL
+	// PaymentInterval is the number of bytes the miner sends before waiting for a voucher that
+	// pays for them.
+	PaymentInterval uint64
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/ipfs/go-cid"
 
 	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/porcelain"
 	"github.com/filecoin-project/go-filecoin/protocol/storage/storagedeal"
 	"github.com/filecoin-project/go-filecoin/types"
 )
@@ -13,18 +14,49 @@ import (
 // API here is the API for a storage client.
 type API struct {
 	sc *Client
+	dm *DealManager
 }
 
 // NewAPI creates a new API for a storage client.
-func NewAPI(storageClient *Client) API {
-	return API{sc: storageClient}
+func NewAPI(storageClient *Client, dealManager *DealManager) API {
+	return API{sc: storageClient, dm: dealManager}
 }
 
-// ProposeStorageDeal calls the storage client ProposeDeal function
+// ProposeStorageDeal calls the deal manager ProposeDeal function
 func (a *API) ProposeStorageDeal(ctx context.Context, data cid.Cid, miner address.Address,
-	askid uint64, duration uint64, allowDuplicates bool) (*storagedeal.Response, error) {
+	askid uint64, duration uint64, allowDuplicates bool, offline bool, retry RetryPolicy) (*storagedeal.Response, error) {
 
-	return a.sc.ProposeDeal(ctx, miner, data, askid, duration, allowDuplicates)
+	return a.dm.ProposeDeal(ctx, miner, data, askid, duration, allowDuplicates, offline, retry)
+}
+
+// Replicate calls the deal manager Replicate function
+func (a *API) Replicate(ctx context.Context, data cid.Cid, replicas uint, duration uint64, maxPrice types.AttoFIL, budget *types.AttoFIL) ([]*ReplicaStatus, error) {
+	return a.dm.Replicate(ctx, data, replicas, duration, maxPrice, budget)
+}
+
+// RenewDeal calls the deal manager RenewDeal function
+func (a *API) RenewDeal(ctx context.Context, proposalCid cid.Cid, duration uint64, retry RetryPolicy) (*storagedeal.Response, error) {
+	return a.dm.RenewDeal(ctx, proposalCid, duration, retry)
+}
+
+// SetRenewPolicy calls the deal manager SetRenewPolicy function
+func (a *API) SetRenewPolicy(ctx context.Context, proposalCid cid.Cid, policy *storagedeal.RenewPolicy) error {
+	return a.dm.SetRenewPolicy(ctx, proposalCid, policy)
+}
+
+// VerifyCompleteDeals calls the deal manager VerifyCompleteDeals function
+func (a *API) VerifyCompleteDeals(ctx context.Context) ([]*porcelain.DealHealth, error) {
+	return a.dm.VerifyCompleteDeals(ctx)
+}
+
+// TrackedDeals calls the deal manager Tracked function
+func (a *API) TrackedDeals(ctx context.Context) ([]*DealUpdate, error) {
+	return a.dm.Tracked(ctx)
+}
+
+// WatchDeals calls the deal manager Subscribe function
+func (a *API) WatchDeals() (<-chan DealUpdate, func()) {
+	return a.dm.Subscribe()
 }
 
 // QueryStorageDeal calls the storage client QueryDeal function
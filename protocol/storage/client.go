@@ -36,6 +36,17 @@ var Errors = map[uint8]error{
 	ErrDuplicateDeal: errors.New("proposal is a duplicate of existing deal; if you would like to create a duplicate, add the --allow-duplicates flag"),
 }
 
+// MinerError is returned by ProposeDeal when the miner rejected the proposal or couldn't be
+// reached, as opposed to the proposal failing before it was sent, in which case proposing it to
+// another miner would fail the same way.
+type MinerError struct {
+	Err error
+}
+
+func (e *MinerError) Error() string {
+	return e.Err.Error()
+}
+
 const (
 	// VoucherInterval defines how many block pass before creating a new voucher
 	VoucherInterval = 1000
@@ -89,13 +100,25 @@ func NewClient(host host.Host, api clientPorcelainAPI) *Client {
 
 // ProposeDeal proposes a storage deal to a miner.  Pass allowDuplicates = true to
 // allow duplicate proposals without error.
-func (smc *Client) ProposeDeal(ctx context.Context, miner address.Address, data cid.Cid, askID uint64, duration uint64, allowDuplicates bool) (*storagedeal.Response, error) {
+func (smc *Client) ProposeDeal(ctx context.Context, miner address.Address, data cid.Cid, askID uint64, duration uint64, allowDuplicates bool, offline bool) (*storagedeal.Response, error) {
+	return smc.proposeDeal(ctx, miner, data, askID, duration, allowDuplicates, offline, nil)
+}
+
+// RenewDeal proposes to the miner of a deal to store its piece for duration more blocks,
+// counted from now, at the given ask. The client needn't hold the data: a miner still
+// holding it in a sector completes the renewal without fetching it.
+func (smc *Client) RenewDeal(ctx context.Context, deal *storagedeal.Deal, askID uint64, duration uint64) (*storagedeal.Response, error) {
+	return smc.proposeDeal(ctx, deal.Miner, deal.Proposal.PieceRef, askID, duration, true, false, deal)
+}
+
+// proposeDeal proposes a storage deal to a miner, renewing the deal renewed if it isn't nil.
+func (smc *Client) proposeDeal(ctx context.Context, miner address.Address, data cid.Cid, askID uint64, duration uint64, allowDuplicates bool, offline bool, renewed *storagedeal.Deal) (*storagedeal.Response, error) {
 	ctxSetup, cancel := context.WithTimeout(ctx, 5*smc.api.BlockTime())
 	defer cancel()
 
 	pid, err := smc.api.MinerGetPeerID(ctxSetup, miner)
 	if err != nil {
-		return nil, err
+		return nil, &MinerError{err}
 	}
 
 	minerAlive := make(chan error, 1)
@@ -104,9 +127,14 @@ func (smc *Client) ProposeDeal(ctx context.Context, miner address.Address, data
 		minerAlive <- smc.api.PingMinerWithTimeout(ctxSetup, pid, 15*time.Second)
 	}()
 
-	size, err := smc.api.DAGGetFileSize(ctxSetup, data)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to determine the size of the data")
+	var size uint64
+	if renewed != nil {
+		size = renewed.Proposal.Size.Uint64()
+	} else {
+		size, err = smc.api.DAGGetFileSize(ctxSetup, data)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to determine the size of the data")
+		}
 	}
 
 	// TODO This is fake. CommP should be the merkle root of data, rather than its CID (issue #2792)
@@ -147,11 +175,15 @@ func (smc *Client) ProposeDeal(ctx context.Context, miner address.Address, data
 	totalPrice := price.MulBigInt(big.NewInt(int64(size * duration)))
 
 	proposal := &storagedeal.Proposal{
-		PieceRef:     data,
-		Size:         types.NewBytesAmount(size),
-		TotalPrice:   totalPrice,
-		Duration:     duration,
-		MinerAddress: miner,
+		PieceRef:        data,
+		Size:            types.NewBytesAmount(size),
+		TotalPrice:      totalPrice,
+		Duration:        duration,
+		MinerAddress:    miner,
+		OfflineTransfer: offline,
+	}
+	if renewed != nil {
+		proposal.RenewalOf = &renewed.Response.ProposalCid
 	}
 
 	if smc.isMaybeDupDeal(ctx, proposal) && !allowDuplicates {
@@ -165,10 +197,13 @@ func (smc *Client) ProposeDeal(ctx context.Context, miner address.Address, data
 			return nil, errors.New("attempting to make storage deal with self. This is currently unsupported.  Please use a separate go-filecoin node as client")
 		}
 		if err != nil {
-			return nil, err
+			return nil, &MinerError{err}
 		}
 	case <-ctxSetup.Done():
-		return nil, ctxSetup.Err()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &MinerError{errors.Wrap(ctxSetup.Err(), "miner didn't answer ping")}
 	}
 
 	// create payment information
@@ -205,11 +240,14 @@ func (smc *Client) ProposeDeal(ctx context.Context, miner address.Address, data
 	// to complete.
 	err = smc.ProtocolRequestFunc(ctx, makeDealProtocol, pid, smc.host, signedProposal, &response)
 	if err != nil {
-		return nil, errors.Wrap(err, "error sending proposal")
+		if ctx.Err() != nil {
+			return nil, errors.Wrap(err, "error sending proposal")
+		}
+		return nil, errors.Wrap(&MinerError{err}, "error sending proposal")
 	}
 
 	if err := smc.checkDealResponse(ctx, &response); err != nil {
-		return nil, errors.Wrap(err, "response check failed")
+		return nil, errors.Wrap(&MinerError{err}, "response check failed")
 	}
 
 	// Note: currently the miner requests the data out of band
@@ -3,6 +3,7 @@ package storage_test
 import (
 	"context"
 	"math/big"
+	"sync"
 	"testing"
 	"time"
 
@@ -56,7 +57,7 @@ func TestProposeDeal(t *testing.T) {
 	minerAddr := addressCreator()
 	askID := uint64(67)
 	duration := uint64(10000)
-	dealResponse, err := client.ProposeDeal(ctx, minerAddr, dataCid, askID, duration, false)
+	dealResponse, err := client.ProposeDeal(ctx, minerAddr, dataCid, askID, duration, false, false)
 	require.NoError(t, err)
 
 	// TODO This is fake. CommP should be the merkle root of data, rather than its CID (issue #2792)
@@ -146,9 +147,9 @@ func TestProposeDealFailsWhenADealAlreadyExists(t *testing.T) {
 	minerAddr := addressCreator()
 	askID := uint64(67)
 	duration := uint64(10000)
-	_, err := client.ProposeDeal(ctx, minerAddr, dataCid, askID, duration, false)
+	_, err := client.ProposeDeal(ctx, minerAddr, dataCid, askID, duration, false, false)
 	require.NoError(t, err)
-	_, err = client.ProposeDeal(ctx, minerAddr, dataCid, askID, duration, false)
+	_, err = client.ProposeDeal(ctx, minerAddr, dataCid, askID, duration, false, false)
 	assert.Error(t, err)
 }
 
@@ -160,7 +161,9 @@ type clientTestAPI struct {
 	target      address.Address
 	perPayment  types.AttoFIL
 	testing     *testing.T
-	deals       map[cid.Cid]*storagedeal.Deal
+
+	dealsLk sync.Mutex
+	deals   map[cid.Cid]*storagedeal.Deal
 }
 
 func newTestClientAPI(t *testing.T) *clientTestAPI {
@@ -277,11 +280,18 @@ func (tcn *testClientNode) MakeTestProtocolRequest(ctx context.Context, protocol
 }
 
 func (ctp *clientTestAPI) DealsLs(_ context.Context) (<-chan *porcelain.StorageDealLsResult, error) {
+	ctp.dealsLk.Lock()
+	var deals []storagedeal.Deal
+	for _, deal := range ctp.deals {
+		deals = append(deals, copyDeal(deal))
+	}
+	ctp.dealsLk.Unlock()
+
 	results := make(chan *porcelain.StorageDealLsResult)
 	go func() {
-		for _, deal := range ctp.deals {
+		for _, deal := range deals {
 			results <- &porcelain.StorageDealLsResult{
-				Deal: *deal,
+				Deal: deal,
 			}
 		}
 		close(results)
@@ -290,18 +300,35 @@ func (ctp *clientTestAPI) DealsLs(_ context.Context) (<-chan *porcelain.StorageD
 }
 
 func (ctp *clientTestAPI) DealGet(_ context.Context, dealCid cid.Cid) (*storagedeal.Deal, error) {
+	ctp.dealsLk.Lock()
+	defer ctp.dealsLk.Unlock()
 	deal, ok := ctp.deals[dealCid]
 	if ok {
-		return deal, nil
+		d := copyDeal(deal)
+		return &d, nil
 	}
 	return nil, porcelain.ErrDealNotFound
 }
 
 func (ctp *clientTestAPI) DealPut(storageDeal *storagedeal.Deal) error {
-	ctp.deals[storageDeal.Response.ProposalCid] = storageDeal
+	ctp.dealsLk.Lock()
+	defer ctp.dealsLk.Unlock()
+	d := copyDeal(storageDeal)
+	ctp.deals[storageDeal.Response.ProposalCid] = &d
 	return nil
 }
 
+// copyDeal copies a deal and its response, so callers can't change stored deals, as with a
+// real datastore.
+func copyDeal(deal *storagedeal.Deal) storagedeal.Deal {
+	d := *deal
+	if deal.Response != nil {
+		resp := *deal.Response
+		d.Response = &resp
+	}
+	return d
+}
+
 func (ctp *clientTestAPI) MessageQuery(ctx context.Context, optFrom, to address.Address, method string, params ...interface{}) ([][]byte, error) {
 	return [][]byte{{byte(types.TestProofsMode)}}, nil
 }
@@ -0,0 +1,422 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-peer"
+	"github.com/pkg/errors"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/porcelain"
+	"github.com/filecoin-project/go-filecoin/protocol/storage/storagedeal"
+	"github.com/filecoin-project/go-filecoin/types"
+)
+
+// RetryPolicy tells a DealManager whether to propose a deal to another miner when it is
+// rejected or fails.
+type RetryPolicy struct {
+	// Retries is the number of times the deal may be proposed to another miner.
+	Retries uint
+	// MaxPrice is the highest ask price, per byte per block, the deal may be proposed at.
+	MaxPrice types.AttoFIL
+}
+
+// DealUpdate is a change in the state of a deal tracked by a DealManager.
+type DealUpdate struct {
+	ProposalCid cid.Cid           `json:"proposalCid"`
+	Miner       address.Address   `json:"miner"`
+	State       storagedeal.State `json:"state"`
+	Message     string            `json:"message"`
+	// Replacement is the proposal of the deal to another miner, if the deal failed and was
+	// proposed again.
+	Replacement cid.Cid `json:"replacement,omitempty"`
+}
+
+type dealManagerPorcelainAPI interface {
+	BlockTime() time.Duration
+	ChainBlockHeight() (*types.BlockHeight, error)
+	ClientListAsks(ctx context.Context) <-chan porcelain.Ask
+	ClientVerifyDeal(ctx context.Context, proposalCid cid.Cid) (*porcelain.DealHealth, error)
+	ConfigGet(dottedPath string) (interface{}, error)
+	DAGGetFileSize(context.Context, cid.Cid) (uint64, error)
+	DealGet(context.Context, cid.Cid) (*storagedeal.Deal, error)
+	DealPut(*storagedeal.Deal) error
+	DealsLs(context.Context) (<-chan *porcelain.StorageDealLsResult, error)
+	MinerGetPeerID(ctx context.Context, minerAddr address.Address) (peer.ID, error)
+	PingMinerWithTimeout(ctx context.Context, p peer.ID, to time.Duration) error
+}
+
+// DealManager follows the deals a Client proposes until they complete, recording their state
+// in the deals datastore, proposes deals that are rejected or fail to other miners, renews
+// deals with a renew policy before they end and alerts when complete deals are at risk.
+// Retry policies are kept in memory: deals resumed after a restart are followed but not
+// proposed again.
+type DealManager struct {
+	client *Client
+	api    dealManagerPorcelainAPI
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	lk   sync.Mutex
+	subs map[chan DealUpdate]struct{}
+	// tracked holds the deals being followed, by proposal cid.
+	tracked map[cid.Cid]*trackedDeal
+	// verdicts holds the last verdict of the complete deals verified, by proposal cid.
+	verdicts map[cid.Cid]porcelain.DealVerdict
+
+	// dealsLk serializes the updates of deal records, each of which reads a deal, changes it
+	// and writes it back, so that concurrent updates of a deal aren't lost.
+	dealsLk sync.Mutex
+}
+
+// trackedDeal is what a DealManager needs to propose a deal again.
+type trackedDeal struct {
+	retry RetryPolicy
+	// tried holds the miners the deal was proposed to.
+	tried []address.Address
+}
+
+// NewDealManager creates a DealManager for the deals of client.
+func NewDealManager(client *Client, api dealManagerPorcelainAPI) *DealManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &DealManager{
+		client:   client,
+		api:      api,
+		ctx:      ctx,
+		cancel:   cancel,
+		subs:     make(map[chan DealUpdate]struct{}),
+		tracked:  make(map[cid.Cid]*trackedDeal),
+		verdicts: make(map[cid.Cid]porcelain.DealVerdict),
+	}
+}
+
+// Start resumes following the client deals that were in progress when the node stopped, and
+// starts renewing and verifying deals.
+func (dm *DealManager) Start(ctx context.Context) error {
+	minerAddr, err := dm.api.ConfigGet("mining.minerAddress")
+	if err != nil {
+		return err
+	}
+
+	deals, err := dm.api.DealsLs(ctx)
+	if err != nil {
+		return err
+	}
+	for deal := range deals {
+		if deal.Err != nil {
+			return deal.Err
+		}
+		// deals made with the node's own miner are the miner's to follow
+		if deal.Deal.Miner == minerAddr || deal.Deal.Response == nil {
+			continue
+		}
+		if inProgress(deal.Deal.Response.State) {
+			dm.track(deal.Deal.Response.ProposalCid, &trackedDeal{tried: []address.Address{deal.Deal.Miner}})
+		}
+	}
+
+	dm.wg.Add(1)
+	go func() {
+		defer dm.wg.Done()
+		dm.renewDeals()
+	}()
+
+	dm.wg.Add(1)
+	go func() {
+		defer dm.wg.Done()
+		dm.verifyDeals()
+	}()
+	return nil
+}
+
+// Stop stops following deals.
+func (dm *DealManager) Stop() {
+	dm.cancel()
+	dm.wg.Wait()
+}
+
+// ProposeDeal proposes a deal with the client, and follows it. If the miner rejects it, or
+// the deal fails later, it is proposed to other miners as retry allows.
+func (dm *DealManager) ProposeDeal(ctx context.Context, miner address.Address, data cid.Cid, askID uint64, duration uint64, allowDuplicates bool, offline bool, retry RetryPolicy) (*storagedeal.Response, error) {
+	td := &trackedDeal{retry: retry, tried: []address.Address{miner}}
+
+	resp, err := dm.client.ProposeDeal(ctx, miner, data, askID, duration, allowDuplicates, offline)
+	if err != nil {
+		if !isMinerError(err) {
+			return nil, err
+		}
+		log.Infof("proposal of %s to %s failed: %s", data, miner, err)
+
+		var retryErr error
+		resp, retryErr = dm.proposeElsewhere(ctx, data, duration, offline, td)
+		if retryErr != nil {
+			return nil, err
+		}
+	}
+
+	dm.track(resp.ProposalCid, td)
+	return resp, nil
+}
+
+// Tracked returns the latest state of the deals being followed.
+func (dm *DealManager) Tracked(ctx context.Context) ([]*DealUpdate, error) {
+	dm.lk.Lock()
+	proposals := make([]cid.Cid, 0, len(dm.tracked))
+	for proposalCid := range dm.tracked {
+		proposals = append(proposals, proposalCid)
+	}
+	dm.lk.Unlock()
+
+	var updates []*DealUpdate
+	for _, proposalCid := range proposals {
+		deal, err := dm.api.DealGet(ctx, proposalCid)
+		if err != nil {
+			return nil, err
+		}
+		updates = append(updates, &DealUpdate{
+			ProposalCid: proposalCid,
+			Miner:       deal.Miner,
+			State:       deal.Response.State,
+			Message:     deal.Response.Message,
+		})
+	}
+	return updates, nil
+}
+
+// Subscribe returns a channel of the changes in the state of the deals being followed and of
+// the alerts on complete deals at risk, and a function to call when done with it.
+func (dm *DealManager) Subscribe() (<-chan DealUpdate, func()) {
+	ch := make(chan DealUpdate, 16)
+
+	dm.lk.Lock()
+	dm.subs[ch] = struct{}{}
+	dm.lk.Unlock()
+
+	return ch, func() {
+		dm.lk.Lock()
+		defer dm.lk.Unlock()
+		if _, ok := dm.subs[ch]; ok {
+			delete(dm.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (dm *DealManager) publish(update DealUpdate) {
+	dm.lk.Lock()
+	defer dm.lk.Unlock()
+	for ch := range dm.subs {
+		select {
+		case ch <- update:
+		default:
+			log.Warningf("dropped update of deal %s for a slow subscriber", update.ProposalCid)
+		}
+	}
+}
+
+func (dm *DealManager) track(proposalCid cid.Cid, td *trackedDeal) {
+	dm.lk.Lock()
+	defer dm.lk.Unlock()
+	if _, ok := dm.tracked[proposalCid]; ok {
+		return
+	}
+	dm.tracked[proposalCid] = td
+
+	dm.wg.Add(1)
+	go func() {
+		defer dm.wg.Done()
+		dm.follow(proposalCid, td)
+	}()
+}
+
+func (dm *DealManager) untrack(proposalCid cid.Cid) {
+	dm.lk.Lock()
+	defer dm.lk.Unlock()
+	delete(dm.tracked, proposalCid)
+}
+
+// follow queries the miner for the state of a deal every block until the deal is complete or
+// failed, recording each change.
+func (dm *DealManager) follow(proposalCid cid.Cid, td *trackedDeal) {
+	defer dm.untrack(proposalCid)
+
+	ticker := time.NewTicker(dm.api.BlockTime())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-dm.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		resp, err := dm.client.QueryDeal(dm.ctx, proposalCid)
+		if err != nil {
+			log.Warningf("failed to query deal %s: %s", proposalCid, err)
+			continue
+		}
+
+		deal, err := dm.recordResponse(proposalCid, resp)
+		if err != nil {
+			log.Errorf("failed to record state of deal %s: %s", proposalCid, err)
+			continue
+		}
+		if deal == nil || inProgress(resp.State) {
+			continue
+		}
+
+		if resp.State == storagedeal.Failed || resp.State == storagedeal.Rejected {
+			dm.replace(deal, td)
+		}
+		return
+	}
+}
+
+// recordResponse stores resp if it changes the state of a deal, and publishes the change.
+// It returns the updated deal, or nil if nothing changed.
+func (dm *DealManager) recordResponse(proposalCid cid.Cid, resp *storagedeal.Response) (*storagedeal.Deal, error) {
+	dm.dealsLk.Lock()
+	defer dm.dealsLk.Unlock()
+
+	deal, err := dm.api.DealGet(dm.ctx, proposalCid)
+	if err != nil {
+		return nil, err
+	}
+	if deal.Response.State == resp.State && deal.Response.Message == resp.Message {
+		return nil, nil
+	}
+
+	deal.Response.State = resp.State
+	deal.Response.Message = resp.Message
+	if resp.ProofInfo != nil {
+		deal.Response.ProofInfo = resp.ProofInfo
+	}
+	deal.RecordState(time.Now())
+	if err := dm.api.DealPut(deal); err != nil {
+		return nil, err
+	}
+
+	dm.publish(DealUpdate{
+		ProposalCid: proposalCid,
+		Miner:       deal.Miner,
+		State:       resp.State,
+		Message:     resp.Message,
+	})
+	return deal, nil
+}
+
+// replace proposes a failed deal to another miner, if its retry policy allows, and hands the
+// deal's renewal over to the replacement.
+func (dm *DealManager) replace(deal *storagedeal.Deal, td *trackedDeal) {
+	var resp *storagedeal.Response
+	tried := len(td.tried)
+	if td.retry.Retries > 0 {
+		var err error
+		resp, err = dm.proposeElsewhere(dm.ctx, deal.Proposal.PieceRef, deal.Proposal.Duration, deal.Proposal.OfflineTransfer, td)
+		if err != nil {
+			log.Warningf("could not propose failed deal %s again: %s", deal.Response.ProposalCid, err)
+			resp = nil
+		}
+	}
+
+	if err := dm.handOver(dm.ctx, deal.Response.ProposalCid, resp, len(td.tried)-tried); err != nil {
+		log.Errorf("failed to hand the renewal of deal %s over: %s", deal.Response.ProposalCid, err)
+	}
+	if resp == nil {
+		return
+	}
+
+	dm.publish(DealUpdate{
+		ProposalCid: deal.Response.ProposalCid,
+		Miner:       deal.Miner,
+		State:       deal.Response.State,
+		Message:     deal.Response.Message,
+		Replacement: resp.ProposalCid,
+	})
+	dm.track(resp.ProposalCid, td)
+}
+
+// proposeElsewhere proposes data to miners not tried yet, cheapest ask first, until one
+// accepts or the retries are used up.
+func (dm *DealManager) proposeElsewhere(ctx context.Context, data cid.Cid, duration uint64, offline bool, td *trackedDeal) (*storagedeal.Response, error) {
+	for td.retry.Retries > 0 {
+		ask, err := dm.nextAsk(ctx, td)
+		if err != nil {
+			return nil, err
+		}
+		td.retry.Retries--
+		td.tried = append(td.tried, ask.Miner)
+
+		resp, err := dm.client.ProposeDeal(ctx, ask.Miner, data, ask.ID, duration, false, offline)
+		if err != nil {
+			if !isMinerError(err) {
+				return nil, err
+			}
+			log.Infof("proposal of %s to %s failed: %s", data, ask.Miner, err)
+			continue
+		}
+		return resp, nil
+	}
+	return nil, errors.New("no retries left")
+}
+
+// isMinerError returns whether a proposal failed because of its miner, so that it may be
+// proposed to another.
+func isMinerError(err error) bool {
+	_, ok := errors.Cause(err).(*MinerError)
+	return ok
+}
+
+// nextAsk returns the cheapest ask, at most td's maximum price, of a miner td wasn't
+// proposed to.
+func (dm *DealManager) nextAsk(ctx context.Context, td *trackedDeal) (*porcelain.Ask, error) {
+	height, err := dm.api.ChainBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	var best *porcelain.Ask
+	for ask := range dm.api.ClientListAsks(ctx) {
+		if ask.Error != nil {
+			return nil, ask.Error
+		}
+		if ask.Expiry != nil && ask.Expiry.LessEqual(height) {
+			continue
+		}
+		if ask.Price.GreaterThan(td.retry.MaxPrice) || wasTried(td, ask.Miner) {
+			continue
+		}
+		if best == nil || ask.Price.LessThan(best.Price) {
+			a := ask
+			best = &a
+		}
+	}
+	if best == nil {
+		return nil, errors.New("no other miner asks for a low enough price")
+	}
+	return best, nil
+}
+
+func wasTried(td *trackedDeal, miner address.Address) bool {
+	for _, m := range td.tried {
+		if m == miner {
+			return true
+		}
+	}
+	return false
+}
+
+// inProgress returns whether a deal in state s may still change state.
+func inProgress(s storagedeal.State) bool {
+	switch s {
+	case storagedeal.Accepted, storagedeal.Started, storagedeal.Staged:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,294 @@
+package storage_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-peer"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/porcelain"
+	. "github.com/filecoin-project/go-filecoin/protocol/storage"
+	"github.com/filecoin-project/go-filecoin/protocol/storage/storagedeal"
+	th "github.com/filecoin-project/go-filecoin/testhelpers"
+	tf "github.com/filecoin-project/go-filecoin/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/types"
+	"github.com/filecoin-project/go-filecoin/util/convert"
+)
+
+func TestDealManager(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	addressCreator := address.NewForTestGetter()
+	minerA, minerB, minerC := addressCreator(), addressCreator(), addressCreator()
+
+	asks := []porcelain.Ask{
+		{Miner: minerA, ID: 0, Price: types.NewAttoFILFromFIL(2), Expiry: types.NewBlockHeight(1000)},
+		{Miner: minerB, ID: 1, Price: types.NewAttoFILFromFIL(3), Expiry: types.NewBlockHeight(1000)},
+		{Miner: minerC, ID: 2, Price: types.NewAttoFILFromFIL(1), Expiry: types.NewBlockHeight(1000)},
+	}
+	retry := RetryPolicy{Retries: 1, MaxPrice: types.NewAttoFILFromFIL(5)}
+
+	t.Run("records the state of deals as they progress", func(t *testing.T) {
+		miners := newTestDealMiners(t)
+		miners.states[minerA] = []storagedeal.State{storagedeal.Staged, storagedeal.Complete}
+		testAPI, dm := newTestDealManager(t, miners, asks)
+		defer dm.Stop()
+
+		updates, unsubscribe := dm.Subscribe()
+		defer unsubscribe()
+
+		resp, err := dm.ProposeDeal(ctx, minerA, types.SomeCid(), 0, 100, false, false, RetryPolicy{})
+		require.NoError(t, err)
+
+		assertNextUpdate(t, updates, resp.ProposalCid, storagedeal.Staged)
+		assertNextUpdate(t, updates, resp.ProposalCid, storagedeal.Complete)
+
+		deal, err := testAPI.DealGet(ctx, resp.ProposalCid)
+		require.NoError(t, err)
+		assert.Equal(t, storagedeal.Complete, deal.Response.State)
+	})
+
+	t.Run("proposes rejected deals to the cheapest other miner", func(t *testing.T) {
+		miners := newTestDealMiners(t)
+		miners.rejects[minerA] = true
+		_, dm := newTestDealManager(t, miners, asks)
+		defer dm.Stop()
+
+		_, err := dm.ProposeDeal(ctx, minerA, types.SomeCid(), 0, 100, false, false, retry)
+		require.NoError(t, err)
+		assert.Equal(t, []address.Address{minerA, minerC}, miners.proposedTo())
+	})
+
+	t.Run("ignores miners asking too much", func(t *testing.T) {
+		miners := newTestDealMiners(t)
+		miners.rejects[minerA] = true
+		miners.rejects[minerC] = true
+		_, dm := newTestDealManager(t, miners, asks)
+		defer dm.Stop()
+
+		cheap := RetryPolicy{Retries: 2, MaxPrice: types.NewAttoFILFromFIL(2)}
+		_, err := dm.ProposeDeal(ctx, minerA, types.SomeCid(), 0, 100, false, false, cheap)
+		assert.Error(t, err)
+		assert.Equal(t, []address.Address{minerA, minerC}, miners.proposedTo())
+	})
+
+	t.Run("gives up when the retries are used", func(t *testing.T) {
+		miners := newTestDealMiners(t)
+		miners.rejects[minerA] = true
+		miners.rejects[minerC] = true
+		_, dm := newTestDealManager(t, miners, asks)
+		defer dm.Stop()
+
+		_, err := dm.ProposeDeal(ctx, minerA, types.SomeCid(), 0, 100, false, false, retry)
+		assert.Error(t, err)
+		assert.Equal(t, []address.Address{minerA, minerC}, miners.proposedTo())
+	})
+
+	t.Run("doesn't propose elsewhere when the proposal fails before reaching the miner", func(t *testing.T) {
+		miners := newTestDealMiners(t)
+		_, dm := newTestDealManager(t, miners, asks)
+		defer dm.Stop()
+
+		data := types.SomeCid()
+		_, err := dm.ProposeDeal(ctx, minerA, data, 0, 100, false, false, RetryPolicy{})
+		require.NoError(t, err)
+
+		_, err = dm.ProposeDeal(ctx, minerA, data, 0, 100, false, false, retry)
+		assert.Equal(t, Errors[ErrDuplicateDeal], err)
+		assert.Equal(t, []address.Address{minerA}, miners.proposedTo())
+	})
+
+	t.Run("skips asks expiring at the current height", func(t *testing.T) {
+		miners := newTestDealMiners(t)
+		miners.rejects[minerA] = true
+		testAPI, dm := newTestDealManager(t, miners, asks)
+		defer dm.Stop()
+		testAPI.asks = []porcelain.Ask{
+			asks[0],
+			asks[1],
+			{Miner: minerC, ID: 2, Price: types.NewAttoFILFromFIL(1), Expiry: types.NewBlockHeight(773)},
+		}
+
+		_, err := dm.ProposeDeal(ctx, minerA, types.SomeCid(), 0, 100, false, false, retry)
+		require.NoError(t, err)
+		assert.Equal(t, []address.Address{minerA, minerB}, miners.proposedTo())
+	})
+
+	t.Run("proposes failed deals to another miner", func(t *testing.T) {
+		miners := newTestDealMiners(t)
+		miners.states[minerA] = []storagedeal.State{storagedeal.Failed}
+		_, dm := newTestDealManager(t, miners, asks)
+		defer dm.Stop()
+
+		updates, unsubscribe := dm.Subscribe()
+		defer unsubscribe()
+
+		resp, err := dm.ProposeDeal(ctx, minerA, types.SomeCid(), 0, 100, false, false, retry)
+		require.NoError(t, err)
+
+		assertNextUpdate(t, updates, resp.ProposalCid, storagedeal.Failed)
+		replaced := assertNextUpdate(t, updates, resp.ProposalCid, storagedeal.Failed)
+		assert.True(t, replaced.Replacement.Defined())
+		assert.Equal(t, []address.Address{minerA, minerC}, miners.proposedTo())
+	})
+
+	t.Run("resumes following client deals in progress", func(t *testing.T) {
+		miners := newTestDealMiners(t)
+		miners.states[minerA] = []storagedeal.State{storagedeal.Complete}
+		testAPI, dm := newTestDealManager(t, miners, asks)
+
+		proposalCid := types.NewCidForTestGetter()()
+		miners.deals[proposalCid] = minerA
+		require.NoError(t, testAPI.DealPut(&storagedeal.Deal{
+			Miner:    minerA,
+			Proposal: &storagedeal.Proposal{PieceRef: types.SomeCid()},
+			Response: &storagedeal.Response{State: storagedeal.Staged, ProposalCid: proposalCid},
+		}))
+
+		updates, unsubscribe := dm.Subscribe()
+		defer unsubscribe()
+
+		require.NoError(t, dm.Start(ctx))
+		defer dm.Stop()
+
+		assertNextUpdate(t, updates, proposalCid, storagedeal.Complete)
+	})
+}
+
+func assertNextUpdate(t *testing.T, updates <-chan DealUpdate, proposalCid cid.Cid, state storagedeal.State) DealUpdate {
+	select {
+	case u := <-updates:
+		assert.Equal(t, proposalCid, u.ProposalCid)
+		assert.Equal(t, state, u.State)
+		return u
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for deal %s to be %s", proposalCid, state)
+		return DealUpdate{}
+	}
+}
+
+// testDealMiners answers proposals and queries for several miners.
+type testDealMiners struct {
+	t *testing.T
+
+	lk sync.Mutex
+	// rejects holds the miners rejecting proposals
+	rejects map[address.Address]bool
+	// states holds the states each miner gives its deals, one per query
+	states   map[address.Address][]storagedeal.State
+	proposed []address.Address
+	deals    map[cid.Cid]address.Address
+}
+
+func newTestDealMiners(t *testing.T) *testDealMiners {
+	return &testDealMiners{
+		t:       t,
+		rejects: make(map[address.Address]bool),
+		states:  make(map[address.Address][]storagedeal.State),
+		deals:   make(map[cid.Cid]address.Address),
+	}
+}
+
+func (tdm *testDealMiners) respond(request interface{}) (interface{}, error) {
+	tdm.lk.Lock()
+	defer tdm.lk.Unlock()
+
+	switch r := request.(type) {
+	case *storagedeal.SignedDealProposal:
+		pcid, err := convert.ToCid(r.Proposal)
+		require.NoError(tdm.t, err)
+
+		miner := r.MinerAddress
+		tdm.proposed = append(tdm.proposed, miner)
+		if tdm.rejects[miner] {
+			return &storagedeal.Response{State: storagedeal.Rejected, Message: "no", ProposalCid: pcid}, nil
+		}
+		tdm.deals[pcid] = miner
+		return &storagedeal.Response{State: storagedeal.Accepted, Message: "OK", ProposalCid: pcid}, nil
+	case storagedeal.QueryRequest:
+		miner := tdm.deals[r.Cid]
+		state := storagedeal.Accepted
+		if states := tdm.states[miner]; len(states) > 0 {
+			state = states[0]
+			tdm.states[miner] = states[1:]
+			if len(tdm.states[miner]) == 0 {
+				// stay in the last state
+				tdm.states[miner] = states[:1]
+			}
+		}
+		return &storagedeal.Response{State: state, ProposalCid: r.Cid}, nil
+	}
+	tdm.t.Fatalf("unexpected request %T", request)
+	return nil, nil
+}
+
+func (tdm *testDealMiners) proposedTo() []address.Address {
+	tdm.lk.Lock()
+	defer tdm.lk.Unlock()
+	return append([]address.Address{}, tdm.proposed...)
+}
+
+type dealManagerTestAPI struct {
+	*clientTestAPI
+	asks []porcelain.Ask
+	// unreachable holds the miners that can't be reached
+	unreachable map[address.Address]bool
+	// health holds the verdicts of verifying deals, healthy if missing
+	health map[cid.Cid]*porcelain.DealHealth
+	// paymentsErr fails creating payments if not nil
+	paymentsErr error
+}
+
+func newTestDealManager(t *testing.T, miners *testDealMiners, asks []porcelain.Ask) (*dealManagerTestAPI, *DealManager) {
+	testAPI := &dealManagerTestAPI{
+		clientTestAPI: newTestClientAPI(t),
+		asks:          asks,
+		unreachable:   make(map[address.Address]bool),
+		health:        make(map[cid.Cid]*porcelain.DealHealth),
+	}
+	client := NewClient(th.NewFakeHost(), testAPI)
+	client.ProtocolRequestFunc = newTestClientNode(miners.respond).MakeTestProtocolRequest
+	return testAPI, NewDealManager(client, testAPI)
+}
+
+func (dmp *dealManagerTestAPI) ClientListAsks(ctx context.Context) <-chan porcelain.Ask {
+	out := make(chan porcelain.Ask, len(dmp.asks))
+	for _, ask := range dmp.asks {
+		out <- ask
+	}
+	close(out)
+	return out
+}
+
+func (dmp *dealManagerTestAPI) ClientVerifyDeal(ctx context.Context, proposalCid cid.Cid) (*porcelain.DealHealth, error) {
+	if health, ok := dmp.health[proposalCid]; ok {
+		return health, nil
+	}
+	return &porcelain.DealHealth{ProposalCid: proposalCid, Verdict: porcelain.DealHealthy}, nil
+}
+
+func (dmp *dealManagerTestAPI) CreatePayments(ctx context.Context, config porcelain.CreatePaymentsParams) (*porcelain.CreatePaymentsReturn, error) {
+	if dmp.paymentsErr != nil {
+		return nil, dmp.paymentsErr
+	}
+	return dmp.clientTestAPI.CreatePayments(ctx, config)
+}
+
+func (dmp *dealManagerTestAPI) MinerGetPeerID(ctx context.Context, minerAddr address.Address) (peer.ID, error) {
+	if dmp.unreachable[minerAddr] {
+		return "", errors.New("no route to miner")
+	}
+	return dmp.clientTestAPI.MinerGetPeerID(ctx, minerAddr)
+}
+
+func (dmp *dealManagerTestAPI) ConfigGet(dottedPath string) (interface{}, error) {
+	return address.Undef, nil
+}
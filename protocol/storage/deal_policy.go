@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os/exec"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/filecoin-project/go-filecoin/config"
+	"github.com/filecoin-project/go-filecoin/plumbing/strgdls"
+	"github.com/filecoin-project/go-filecoin/protocol/storage/storagedeal"
+)
+
+// dealPolicyHookTimeout is how long the deal policy's decision hook has to answer.
+const dealPolicyHookTimeout = 10 * time.Second
+
+// dealPolicyHookAnswer is what the deal policy's decision hook writes on stdout.
+type dealPolicyHookAnswer struct {
+	Accept bool   `json:"accept"`
+	Reason string `json:"reason"`
+}
+
+// checkDealPolicy returns an error telling why a proposal breaks the miner's deal policy, or
+// nil if the miner may accept it.
+func (sm *Miner) checkDealPolicy(ctx context.Context, p *storagedeal.Proposal) error {
+	policy, err := sm.getDealPolicy()
+	if err != nil {
+		return err
+	}
+
+	size := p.Size.Uint64()
+	if policy.MinPieceSize > 0 && size < policy.MinPieceSize {
+		return fmt.Errorf("piece is %d bytes but the miner stores pieces of at least %d bytes", size, policy.MinPieceSize)
+	}
+	if policy.MaxPieceSize > 0 && size > policy.MaxPieceSize {
+		return fmt.Errorf("piece is %d bytes but the miner stores pieces of at most %d bytes", size, policy.MaxPieceSize)
+	}
+
+	if policy.MaxDuration > 0 && p.Duration > policy.MaxDuration {
+		return fmt.Errorf("deal lasts %d blocks but the miner makes deals of at most %d blocks", p.Duration, policy.MaxDuration)
+	}
+
+	if policy.MinPrice.IsPositive() {
+		minTotal := policy.MinPrice.MulBigInt(new(big.Int).SetUint64(size)).MulBigInt(new(big.Int).SetUint64(p.Duration))
+		if p.TotalPrice.LessThan(minTotal) {
+			return fmt.Errorf("proposed price (%s) is less than the miner's minimum (%s) given a minimum price of %s", p.TotalPrice, minTotal, policy.MinPrice)
+		}
+	}
+
+	client := p.Payment.Payer
+	for _, denied := range policy.DeniedClients {
+		if client == denied {
+			return fmt.Errorf("miner doesn't make deals with client %s", client)
+		}
+	}
+	if len(policy.AllowedClients) > 0 {
+		allowed := false
+		for _, a := range policy.AllowedClients {
+			allowed = allowed || client == a
+		}
+		if !allowed {
+			return fmt.Errorf("miner doesn't make deals with client %s", client)
+		}
+	}
+
+	if policy.DecisionHook != "" {
+		return runDealPolicyHook(ctx, policy.DecisionHook, p)
+	}
+
+	return nil
+}
+
+// checkDealCapacity returns an error if the miner has no room left for a proposal under its
+// deal policy. Callers hold capacityLk until the deal is recorded, so that proposals accepted
+// at once can't take more room than the policy leaves.
+func (sm *Miner) checkDealCapacity(p *storagedeal.Proposal) error {
+	policy, err := sm.getDealPolicy()
+	if err != nil {
+		return err
+	}
+	if policy.MaxStagedBytes == 0 && policy.MaxConcurrentDeals == 0 {
+		return nil
+	}
+
+	count, stagedBytes, err := sm.unsealedDeals()
+	if err != nil {
+		return err
+	}
+	if policy.MaxConcurrentDeals > 0 && count >= policy.MaxConcurrentDeals {
+		return fmt.Errorf("miner has %d deals in progress, the most it takes at once", count)
+	}
+	if policy.MaxStagedBytes > 0 && stagedBytes+p.Size.Uint64() > policy.MaxStagedBytes {
+		return fmt.Errorf("miner has %d bytes waiting to be sealed and takes at most %d", stagedBytes, policy.MaxStagedBytes)
+	}
+	return nil
+}
+
+// unsealedDeals returns the number and total piece size of the deals the miner accepted but
+// hasn't sealed yet.
+func (sm *Miner) unsealedDeals() (uint, uint64, error) {
+	deals, err := sm.porcelainAPI.DealsQuery(strgdls.Query{
+		States: []storagedeal.State{storagedeal.Accepted, storagedeal.Started, storagedeal.Staged},
+		Miner:  sm.minerAddr,
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var size uint64
+	for _, deal := range deals {
+		if deal.Proposal != nil && deal.Proposal.Size != nil {
+			size += deal.Proposal.Size.Uint64()
+		}
+	}
+	return uint(len(deals)), size, nil
+}
+
+// runDealPolicyHook asks the executable at path whether to accept a proposal.
+func runDealPolicyHook(ctx context.Context, path string, p *storagedeal.Proposal) error {
+	input, err := json.Marshal(p)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode proposal for the deal policy hook")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dealPolicyHookTimeout)
+	defer cancel()
+
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		log.Errorf("deal policy hook %s failed: %s", path, err)
+		return errors.New("miner failed to decide on the proposal")
+	}
+
+	var answer dealPolicyHookAnswer
+	if err := json.Unmarshal(stdout.Bytes(), &answer); err != nil {
+		log.Errorf("deal policy hook %s gave an invalid answer: %s", path, err)
+		return errors.New("miner failed to decide on the proposal")
+	}
+	if !answer.Accept {
+		if answer.Reason == "" {
+			return errors.New("proposal rejected by the miner's deal policy")
+		}
+		return fmt.Errorf("proposal rejected by the miner's deal policy: %s", answer.Reason)
+	}
+	return nil
+}
+
+func (sm *Miner) getDealPolicy() (*config.DealPolicyConfig, error) {
+	policy, err := sm.porcelainAPI.ConfigGet("dealPolicy")
+	if err != nil {
+		return nil, err
+	}
+	policyConfig, ok := policy.(*config.DealPolicyConfig)
+	if !ok || policyConfig == nil {
+		return nil, errors.New("Could not retrieve dealPolicy from config")
+	}
+	return policyConfig, nil
+}
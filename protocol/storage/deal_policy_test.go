@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/protocol/storage/storagedeal"
+	tf "github.com/filecoin-project/go-filecoin/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/types"
+)
+
+func TestDealPolicy(t *testing.T) {
+	tf.UnitTest(t)
+
+	t.Run("accepts proposals by default", func(t *testing.T) {
+		_, miner, proposal := defaultMinerTestSetup(t, VoucherInterval, defaultAmountInc)
+
+		res, err := miner.receiveStorageProposal(context.Background(), proposal)
+		require.NoError(t, err)
+		assert.Equal(t, storagedeal.Accepted, res.State)
+	})
+
+	rejects := func(t *testing.T, key, value, message string) {
+		porcelainAPI, miner, proposal := defaultMinerTestSetup(t, VoucherInterval, defaultAmountInc)
+		require.NoError(t, porcelainAPI.config.Set(key, value))
+
+		res, err := miner.receiveStorageProposal(context.Background(), proposal)
+		require.NoError(t, err)
+		assert.Equal(t, storagedeal.Rejected, res.State)
+		assert.Contains(t, res.Message, message)
+	}
+
+	t.Run("rejects pieces that are too small", func(t *testing.T) {
+		rejects(t, "dealPolicy.minPieceSize", "2000", "stores pieces of at least 2000 bytes")
+	})
+
+	t.Run("rejects pieces that are too large", func(t *testing.T) {
+		rejects(t, "dealPolicy.maxPieceSize", "500", "stores pieces of at most 500 bytes")
+	})
+
+	t.Run("rejects deals that are too long", func(t *testing.T) {
+		rejects(t, "dealPolicy.maxDuration", "100", "makes deals of at most 100 blocks")
+	})
+
+	t.Run("rejects prices below the minimum", func(t *testing.T) {
+		rejects(t, "dealPolicy.minPrice", `".0005"`, "less than the miner's minimum")
+	})
+
+	t.Run("rejects denied clients", func(t *testing.T) {
+		porcelainAPI, miner, proposal := defaultMinerTestSetup(t, VoucherInterval, defaultAmountInc)
+		value := fmt.Sprintf("[%q]", porcelainAPI.payerAddress.String())
+		require.NoError(t, porcelainAPI.config.Set("dealPolicy.deniedClients", value))
+
+		res, err := miner.receiveStorageProposal(context.Background(), proposal)
+		require.NoError(t, err)
+		assert.Equal(t, storagedeal.Rejected, res.State)
+		assert.Contains(t, res.Message, "doesn't make deals with client")
+	})
+
+	t.Run("rejects clients that aren't allowed", func(t *testing.T) {
+		value := fmt.Sprintf("[%q]", address.TestAddress.String())
+		rejects(t, "dealPolicy.allowedClients", value, "doesn't make deals with client")
+	})
+
+	t.Run("accepts allowed clients", func(t *testing.T) {
+		porcelainAPI, miner, proposal := defaultMinerTestSetup(t, VoucherInterval, defaultAmountInc)
+		value := fmt.Sprintf("[%q]", porcelainAPI.payerAddress.String())
+		require.NoError(t, porcelainAPI.config.Set("dealPolicy.allowedClients", value))
+
+		res, err := miner.receiveStorageProposal(context.Background(), proposal)
+		require.NoError(t, err)
+		assert.Equal(t, storagedeal.Accepted, res.State)
+	})
+
+	withUnsealedDeal := func(t *testing.T) (*minerTestPorcelain, *Miner, *storagedeal.SignedDealProposal) {
+		porcelainAPI, miner, proposal := defaultMinerTestSetup(t, VoucherInterval, defaultAmountInc)
+		require.NoError(t, porcelainAPI.DealPut(&storagedeal.Deal{
+			Miner:    miner.minerAddr,
+			Proposal: &storagedeal.Proposal{Size: types.NewBytesAmount(600)},
+			Response: &storagedeal.Response{State: storagedeal.Staged, ProposalCid: types.NewCidForTestGetter()()},
+		}))
+		return porcelainAPI, miner, proposal
+	}
+
+	t.Run("caps the number of deals in progress", func(t *testing.T) {
+		porcelainAPI, miner, proposal := withUnsealedDeal(t)
+		require.NoError(t, porcelainAPI.config.Set("dealPolicy.maxConcurrentDeals", "1"))
+
+		res, err := miner.receiveStorageProposal(context.Background(), proposal)
+		require.NoError(t, err)
+		assert.Equal(t, storagedeal.Rejected, res.State)
+		assert.Contains(t, res.Message, "1 deals in progress")
+	})
+
+	t.Run("caps the bytes waiting to be sealed", func(t *testing.T) {
+		porcelainAPI, miner, proposal := withUnsealedDeal(t)
+		require.NoError(t, porcelainAPI.config.Set("dealPolicy.maxStagedBytes", "1500"))
+
+		res, err := miner.receiveStorageProposal(context.Background(), proposal)
+		require.NoError(t, err)
+		assert.Equal(t, storagedeal.Rejected, res.State)
+		assert.Contains(t, res.Message, "600 bytes waiting to be sealed")
+	})
+
+	t.Run("reserves room for proposals received at once", func(t *testing.T) {
+		porcelainAPI, miner, proposal := defaultMinerTestSetup(t, VoucherInterval, defaultAmountInc)
+		require.NoError(t, porcelainAPI.config.Set("dealPolicy.maxConcurrentDeals", "1"))
+		miner.dealProcessor = func(m *Miner, proposalCid cid.Cid) {}
+
+		results := make(chan *storagedeal.Response, 2)
+		for i := 0; i < 2; i++ {
+			go func() {
+				res, err := miner.receiveStorageProposal(context.Background(), proposal)
+				assert.NoError(t, err)
+				results <- res
+			}()
+		}
+
+		states := []storagedeal.State{(<-results).State, (<-results).State}
+		assert.ElementsMatch(t, []storagedeal.State{storagedeal.Accepted, storagedeal.Rejected}, states)
+	})
+
+	dir, err := ioutil.TempDir("", "dealpolicy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+
+	hooks := 0
+	hook := func(t *testing.T, answer string) string {
+		hooks++
+		path := filepath.Join(dir, fmt.Sprintf("hook%d.sh", hooks))
+		script := fmt.Sprintf("#!/bin/sh\ncat > /dev/null\necho '%s'\n", answer)
+		require.NoError(t, ioutil.WriteFile(path, []byte(script), 0700))
+		return fmt.Sprintf("%q", path)
+	}
+
+	t.Run("rejects proposals the decision hook rejects", func(t *testing.T) {
+		rejects(t, "dealPolicy.decisionHook", hook(t, `{"accept": false, "reason": "no room"}`), "deal policy: no room")
+	})
+
+	t.Run("accepts proposals the decision hook accepts", func(t *testing.T) {
+		porcelainAPI, miner, proposal := defaultMinerTestSetup(t, VoucherInterval, defaultAmountInc)
+		require.NoError(t, porcelainAPI.config.Set("dealPolicy.decisionHook", hook(t, `{"accept": true}`)))
+
+		res, err := miner.receiveStorageProposal(context.Background(), proposal)
+		require.NoError(t, err)
+		assert.Equal(t, storagedeal.Accepted, res.State)
+	})
+
+	t.Run("rejects proposals when the decision hook fails", func(t *testing.T) {
+		rejects(t, "dealPolicy.decisionHook", hook(t, "not json"), "failed to decide")
+	})
+}
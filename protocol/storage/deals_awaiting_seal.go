@@ -122,6 +122,21 @@ func (dealsAwaitingSeal *dealsAwaitingSeal) onSealFail(ctx context.Context, sect
 	delete(dealsAwaitingSeal.SectorsToDeals, sectorID)
 }
 
+// hasDeal returns whether a deal is attached to a sector awaiting seal.
+func (dealsAwaitingSeal *dealsAwaitingSeal) hasDeal(dealCid cid.Cid) bool {
+	dealsAwaitingSeal.l.Lock()
+	defer dealsAwaitingSeal.l.Unlock()
+
+	for _, deals := range dealsAwaitingSeal.SectorsToDeals {
+		for _, c := range deals {
+			if c.Equals(dealCid) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (dealsAwaitingSeal *dealsAwaitingSeal) commitMessageCid(sectorID uint64) (cid.Cid, bool) {
 	sectorData, ok := dealsAwaitingSeal.SealedSectors[sectorID]
 	if !ok {
@@ -4,15 +4,19 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"math/big"
 	"strconv"
 	"sync"
 	"time"
 
 	bserv "github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-car"
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-ipfs-exchange-offline"
 	cbor "github.com/ipfs/go-ipld-cbor"
+	ipld "github.com/ipfs/go-ipld-format"
 	logging "github.com/ipfs/go-log"
 	dag "github.com/ipfs/go-merkledag"
 	uio "github.com/ipfs/go-unixfs/io"
@@ -26,6 +30,7 @@ import (
 	"github.com/filecoin-project/go-filecoin/address"
 	cbu "github.com/filecoin-project/go-filecoin/cborutil"
 	"github.com/filecoin-project/go-filecoin/exec"
+	"github.com/filecoin-project/go-filecoin/plumbing/strgdls"
 	"github.com/filecoin-project/go-filecoin/porcelain"
 	"github.com/filecoin-project/go-filecoin/proofs"
 	"github.com/filecoin-project/go-filecoin/proofs/sectorbuilder"
@@ -53,29 +58,52 @@ type Miner struct {
 	minerOwnerAddr address.Address
 
 	dealsAwaitingSealDs repo.Datastore
+	postsDs             repo.Datastore
 
-	postInProcessLk sync.Mutex
-	postInProcess   *types.BlockHeight
+	// postsLk guards posts, generatingPoSt and the PoSt being generated's deadline and cancel func
+	postsLk        sync.Mutex
+	posts          []*PoStPeriod
+	generatingPoSt bool
+	postDeadline   *types.BlockHeight
+	cancelPoSt     context.CancelFunc
 
 	dealsAwaitingSeal *dealsAwaitingSeal
 
+	// capacityLk serializes checking the deal policy's capacity and accepting proposals
+	capacityLk sync.Mutex
+
+	// redeeming holds the redeem messages not mined yet, and settled the final state of the
+	// payment channels nothing more can be redeemed from, by deal proposal cid
+	redeemingLk sync.Mutex
+	redeeming   map[cid.Cid]*redeemMessage
+	settled     map[cid.Cid]*settledPayment
+	redeemsDs   repo.Datastore
+	// redeemCh wakes the redeemer goroutine
+	redeemCh chan struct{}
+
 	porcelainAPI minerPorcelain
 	node         node
 
 	proposalAcceptor func(m *Miner, p *storagedeal.Proposal) (*storagedeal.Response, error)
 	proposalRejector func(m *Miner, p *storagedeal.Proposal, reason string) (*storagedeal.Response, error)
+	dealProcessor    func(m *Miner, proposalCid cid.Cid)
 }
 
 // minerPorcelain is the subset of the porcelain API that storage.Miner needs.
 type minerPorcelain interface {
 	ActorGetSignature(context.Context, address.Address, string) (*exec.FunctionSignature, error)
+	ActorGetState(ctx context.Context, addr address.Address, out interface{}) error
 
 	ChainBlockHeight() (*types.BlockHeight, error)
 	ChainSampleRandomness(ctx context.Context, sampleHeight *types.BlockHeight) ([]byte, error)
 	ConfigGet(dottedPath string) (interface{}, error)
 
+	DAGImportData(context.Context, io.Reader) (ipld.Node, error)
+
 	DealGet(context.Context, cid.Cid) (*storagedeal.Deal, error)
 	DealPut(*storagedeal.Deal) error
+	DealsLs(context.Context) (<-chan *porcelain.StorageDealLsResult, error)
+	DealsQuery(q strgdls.Query) ([]*storagedeal.Deal, error)
 
 	MessageSend(ctx context.Context, from, to address.Address, value types.AttoFIL, gasPrice types.AttoFIL, gasLimit types.GasUnits, method string, params ...interface{}) (cid.Cid, error)
 	MessageQuery(ctx context.Context, optFrom, to address.Address, method string, params ...interface{}) ([][]byte, error)
@@ -104,9 +132,13 @@ func NewMiner(minerAddr, minerOwnerAddr address.Address, nd node, dealsDs repo.D
 		minerOwnerAddr:      minerOwnerAddr,
 		porcelainAPI:        porcelainAPI,
 		dealsAwaitingSealDs: dealsDs,
+		postsDs:             dealsDs,
+		redeemsDs:           dealsDs,
+		redeemCh:            make(chan struct{}, 1),
 		node:                nd,
 		proposalAcceptor:    acceptProposal,
 		proposalRejector:    rejectProposal,
+		dealProcessor:       (*Miner).processStorageDeal,
 	}
 
 	if err := sm.loadDealsAwaitingSeal(); err != nil {
@@ -115,6 +147,14 @@ func NewMiner(minerAddr, minerOwnerAddr address.Address, nd node, dealsDs repo.D
 	sm.dealsAwaitingSeal.onSuccess = sm.onCommitSuccess
 	sm.dealsAwaitingSeal.onFail = sm.onCommitFail
 
+	if err := sm.loadPoSts(); err != nil {
+		return nil, errors.Wrap(err, "failed to load PoSt history when creating miner")
+	}
+
+	if err := sm.loadRedeems(); err != nil {
+		return nil, errors.Wrap(err, "failed to load redeem messages when creating miner")
+	}
+
 	nd.Host().SetStreamHandler(makeDealProtocol, sm.handleMakeDeal)
 	nd.Host().SetStreamHandler(queryDealProtocol, sm.handleQueryDeal)
 
@@ -169,6 +209,19 @@ func (sm *Miner) receiveStorageProposal(ctx context.Context, sp *storagedeal.Sig
 		return sm.proposalRejector(sm, p, fmt.Sprintf("piece is %s bytes but sector size is %s bytes", sp.Size.String(), maxUserBytes))
 	}
 
+	if err := sm.checkDealPolicy(ctx, p); err != nil {
+		return sm.proposalRejector(sm, p, err.Error())
+	}
+
+	// Hold the lock until the deal is recorded, so its room is reserved before the next
+	// proposal is checked.
+	sm.capacityLk.Lock()
+	defer sm.capacityLk.Unlock()
+
+	if err := sm.checkDealCapacity(p); err != nil {
+		return sm.proposalRejector(sm, p, err.Error())
+	}
+
 	// Payment is valid, everything else checks out, let's accept this proposal
 	return sm.proposalAcceptor(sm, p)
 }
@@ -294,8 +347,27 @@ func (sm *Miner) getPaymentChannel(ctx context.Context, p *storagedeal.Proposal)
 		return nil, err
 	}
 
+	return sm.paymentChannel(ctx, p)
+}
+
+// paymentChannel returns the payment channel of a deal as it is on chain.
+func (sm *Miner) paymentChannel(ctx context.Context, p *storagedeal.Proposal) (*paymentbroker.PaymentChannel, error) {
 	payer := p.Payment.Payer
 
+	channels, err := sm.paymentChannels(ctx, payer)
+	if err != nil {
+		return nil, err
+	}
+	channel, ok := channels[p.Payment.Channel.KeyString()]
+	if !ok {
+		return nil, fmt.Errorf("could not find payment channel for payer %s and id %s", payer.String(), p.Payment.Channel.KeyString())
+	}
+	return channel, nil
+}
+
+// paymentChannels returns the payment channels of a payer as they are on chain, by channel id
+// key. Reclaimed and closed channels are gone from the payment broker.
+func (sm *Miner) paymentChannels(ctx context.Context, payer address.Address) (map[string]*paymentbroker.PaymentChannel, error) {
 	ret, err := sm.porcelainAPI.MessageQuery(ctx, address.Undef, address.PaymentBrokerAddress, "ls", payer)
 	if err != nil {
 		return nil, errors.Wrap(err, "Error getting payment channel for payer")
@@ -305,11 +377,7 @@ func (sm *Miner) getPaymentChannel(ctx context.Context, p *storagedeal.Proposal)
 	if err := cbor.DecodeInto(ret[0], &channels); err != nil {
 		return nil, errors.Wrap(err, "Could not decode payment channels for payer")
 	}
-	channel, ok := channels[p.Payment.Channel.KeyString()]
-	if !ok {
-		return nil, fmt.Errorf("could not find payment channel for payer %s and id %s", payer.String(), p.Payment.Channel.KeyString())
-	}
-	return channel, nil
+	return channels, nil
 }
 
 func acceptProposal(sm *Miner, p *storagedeal.Proposal) (*storagedeal.Response, error) {
@@ -333,13 +401,14 @@ func acceptProposal(sm *Miner, p *storagedeal.Proposal) (*storagedeal.Response,
 		Proposal: p,
 		Response: resp,
 	}
+	storageDeal.RecordState(time.Now())
 
 	if err := sm.porcelainAPI.DealPut(storageDeal); err != nil {
 		return nil, errors.Wrap(err, "Could not persist miner deal")
 	}
 
 	// TODO: use some sort of nicer scheduler
-	go sm.processStorageDeal(proposalCid)
+	go sm.dealProcessor(sm, proposalCid)
 
 	return resp, nil
 }
@@ -362,6 +431,7 @@ func rejectProposal(sm *Miner, p *storagedeal.Proposal, reason string) (*storage
 		Proposal: p,
 		Response: resp,
 	}
+	storageDeal.RecordState(time.Now())
 	if err := sm.porcelainAPI.DealPut(storageDeal); err != nil {
 		return nil, errors.Wrap(err, "failed to save miner deal")
 	}
@@ -375,6 +445,7 @@ func (sm *Miner) updateDealResponse(ctx context.Context, proposalCid cid.Cid, f
 		return errors.Wrapf(err, "failed to get retrive deal with proposal CID %s", proposalCid.String())
 	}
 	f(storageDeal.Response)
+	storageDeal.RecordState(time.Now())
 	err = sm.porcelainAPI.DealPut(storageDeal)
 	if err != nil {
 		return errors.Wrap(err, "failed to store updated deal response in datastore")
@@ -392,10 +463,34 @@ func (sm *Miner) processStorageDeal(proposalCid cid.Cid) {
 	d, err := sm.porcelainAPI.DealGet(ctx, proposalCid)
 	if err != nil {
 		log.Errorf("could not retrieve deal with proposal CID %s: %s", proposalCid.String(), err)
+		return
 	}
-	if d.Response.State != storagedeal.Accepted {
-		// TODO: handle resumption of deal processing across miner restarts
-		log.Error("attempted to process an already started deal")
+	switch d.Response.State {
+	case storagedeal.Accepted:
+		if sm.renewInSector(ctx, proposalCid, d.Proposal) {
+			return
+		}
+		if d.Proposal.OfflineTransfer {
+			// the data arrives through ImportDealData
+			err := sm.updateDealResponse(ctx, proposalCid, func(resp *storagedeal.Response) {
+				resp.Message = "waiting for the data to be imported"
+			})
+			if err != nil {
+				log.Errorf("could not update deal awaiting import: %s", err)
+			}
+			return
+		}
+		err := sm.updateDealResponse(ctx, proposalCid, func(resp *storagedeal.Response) {
+			resp.State = storagedeal.Started
+		})
+		if err != nil {
+			log.Errorf("could not update deal to 'Started' state: %s", err)
+			return
+		}
+	case storagedeal.Started:
+		// the transfer was interrupted by a restart, so start it over
+	default:
+		log.Errorf("attempted to process deal %s in state %s", proposalCid.String(), d.Response.State)
 		return
 	}
 
@@ -416,7 +511,57 @@ func (sm *Miner) processStorageDeal(proposalCid cid.Cid) {
 		return
 	}
 
-	fail := func(message, logerr string) {
+	sm.stageDeal(ctx, proposalCid, d.Proposal) // nolint: errcheck
+}
+
+// renewInSector completes a deal renewing an earlier deal of the same client whose piece is
+// still in a committed sector, without sealing the piece again. It returns whether it did.
+func (sm *Miner) renewInSector(ctx context.Context, proposalCid cid.Cid, p *storagedeal.Proposal) bool {
+	if p.RenewalOf == nil {
+		return false
+	}
+
+	renewed, err := sm.porcelainAPI.DealGet(ctx, *p.RenewalOf)
+	if err != nil {
+		log.Infof("deal %s renews unknown deal %s, sealing its piece again", proposalCid, p.RenewalOf)
+		return false
+	}
+	proofInfo := renewed.Response.ProofInfo
+	if renewed.Response.State != storagedeal.Complete || proofInfo == nil {
+		log.Infof("deal %s renews deal %s which is %s, sealing its piece again", proposalCid, p.RenewalOf, renewed.Response.State)
+		return false
+	}
+	if !renewed.Proposal.PieceRef.Equals(p.PieceRef) || renewed.Proposal.Payment.Payer != p.Payment.Payer {
+		log.Infof("deal %s renews deal %s of another piece or client, sealing its piece again", proposalCid, p.RenewalOf)
+		return false
+	}
+
+	commitments, err := sm.getActorSectorCommitments(ctx)
+	if err != nil {
+		log.Errorf("could not get sector commitments to renew deal %s: %s", proposalCid, err)
+		return false
+	}
+	if _, ok := commitments[strconv.FormatUint(proofInfo.SectorID, 10)]; !ok {
+		log.Infof("sector %d of deal %s is not committed, sealing its piece again", proofInfo.SectorID, p.RenewalOf)
+		return false
+	}
+
+	err = sm.updateDealResponse(ctx, proposalCid, func(resp *storagedeal.Response) {
+		resp.State = storagedeal.Complete
+		resp.Message = fmt.Sprintf("renewed in sector %d", proofInfo.SectorID)
+		resp.ProofInfo = proofInfo
+	})
+	if err != nil {
+		log.Errorf("could not update renewed deal to 'Complete' state: %s", err)
+		return false
+	}
+	return true
+}
+
+// stageDeal adds the piece of a deal, whose data is in the node's blockstore, to a sector.
+// If it fails, the deal fails and the error is returned.
+func (sm *Miner) stageDeal(ctx context.Context, proposalCid cid.Cid, p *storagedeal.Proposal) error {
+	fail := func(message, logerr string) error {
 		log.Errorf(logerr)
 		err := sm.updateDealResponse(ctx, proposalCid, func(resp *storagedeal.Response) {
 			resp.Message = message
@@ -425,20 +570,19 @@ func (sm *Miner) processStorageDeal(proposalCid cid.Cid) {
 		if err != nil {
 			log.Errorf("could not update to deal to 'Failed' state in fail callback: %s", err)
 		}
+		return errors.New(logerr)
 	}
 
 	dagService := dag.NewDAGService(sm.node.BlockService())
 
-	rootIpldNode, err := dagService.Get(ctx, d.Proposal.PieceRef)
+	rootIpldNode, err := dagService.Get(ctx, p.PieceRef)
 	if err != nil {
-		fail("internal error", fmt.Sprintf("failed to add piece: %s", err))
-		return
+		return fail("internal error", fmt.Sprintf("failed to add piece: %s", err))
 	}
 
 	r, err := uio.NewDagReader(ctx, rootIpldNode, dagService)
 	if err != nil {
-		fail("internal error", fmt.Sprintf("failed to add piece: %s", err))
-		return
+		return fail("internal error", fmt.Sprintf("failed to add piece: %s", err))
 	}
 
 	// There is a race here that requires us to use dealsAwaitingSeal below. If the
@@ -450,10 +594,9 @@ func (sm *Miner) processStorageDeal(proposalCid cid.Cid) {
 	//
 	// Also, this pattern of not being able to set up book-keeping ahead of
 	// the call is inelegant.
-	sectorID, err := sm.node.SectorBuilder().AddPiece(ctx, d.Proposal.PieceRef, d.Proposal.Size.Uint64(), r)
+	sectorID, err := sm.node.SectorBuilder().AddPiece(ctx, p.PieceRef, p.Size.Uint64(), r)
 	if err != nil {
-		fail("failed to submit seal proof", fmt.Sprintf("failed to add piece: %s", err))
-		return
+		return fail("failed to submit seal proof", fmt.Sprintf("failed to add piece: %s", err))
 	}
 
 	err = sm.updateDealResponse(ctx, proposalCid, func(resp *storagedeal.Response) {
@@ -469,6 +612,125 @@ func (sm *Miner) processStorageDeal(proposalCid cid.Cid) {
 	if err := sm.saveDealsAwaitingSeal(); err != nil {
 		log.Errorf("could not save deal awaiting seal: %s", err)
 	}
+	return nil
+}
+
+// ImportDealData takes the data of an offline transfer deal, from a file or a CAR, and stages
+// it once it is checked against the deal's piece.
+func (sm *Miner) ImportDealData(ctx context.Context, proposalCid cid.Cid, data io.Reader, isCar bool) error {
+	d, err := sm.porcelainAPI.DealGet(ctx, proposalCid)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get deal %s", proposalCid.String())
+	}
+	if d.Miner != sm.minerAddr {
+		return fmt.Errorf("deal %s is not with miner %s", proposalCid.String(), sm.minerAddr)
+	}
+	if !d.Proposal.OfflineTransfer {
+		return fmt.Errorf("deal %s does not transfer its data offline", proposalCid.String())
+	}
+	if d.Response.State != storagedeal.Accepted {
+		return fmt.Errorf("deal %s is %s, not waiting for data", proposalCid.String(), d.Response.State)
+	}
+
+	var root cid.Cid
+	if isCar {
+		header, err := car.LoadCar(sm.node.BlockService().Blockstore(), data)
+		if err != nil {
+			return errors.Wrap(err, "failed to load car")
+		}
+		if len(header.Roots) != 1 {
+			return fmt.Errorf("expected car with a single root, got %d", len(header.Roots))
+		}
+		root = header.Roots[0]
+	} else {
+		nd, err := sm.porcelainAPI.DAGImportData(ctx, data)
+		if err != nil {
+			return errors.Wrap(err, "failed to import data")
+		}
+		root = nd.Cid()
+	}
+	if !root.Equals(d.Proposal.PieceRef) {
+		return fmt.Errorf("data has root %s but the deal is for piece %s", root.String(), d.Proposal.PieceRef.String())
+	}
+
+	// make sure all of the piece is there, without asking the network for what's missing
+	bs := sm.node.BlockService().Blockstore()
+	offlineDAG := dag.NewDAGService(bserv.New(bs, offline.Exchange(bs)))
+	if err := dag.FetchGraph(ctx, root, offlineDAG); err != nil {
+		return errors.Wrap(err, "data is incomplete")
+	}
+
+	err = sm.updateDealResponse(ctx, proposalCid, func(resp *storagedeal.Response) {
+		resp.State = storagedeal.Started
+		resp.Message = ""
+	})
+	if err != nil {
+		return err
+	}
+
+	return sm.stageDeal(ctx, proposalCid, d.Proposal)
+}
+
+// ResumeDeals carries on processing the deals the miner accepted but hadn't staged when the
+// node stopped. Staged deals recorded in dealsAwaitingSeal need nothing more: they complete
+// when their sector is sealed. A staged deal the node stopped before recording is attached to
+// the sector holding its piece, or staged again if no sector does.
+func (sm *Miner) ResumeDeals(ctx context.Context) error {
+	deals, err := sm.porcelainAPI.DealsLs(ctx)
+	if err != nil {
+		return err
+	}
+
+	var resumed, restaged []cid.Cid
+	for deal := range deals {
+		if deal.Err != nil {
+			return deal.Err
+		}
+		if deal.Deal.Miner != sm.minerAddr || deal.Deal.Response == nil {
+			continue
+		}
+
+		proposalCid := deal.Deal.Response.ProposalCid
+		switch deal.Deal.Response.State {
+		case storagedeal.Accepted, storagedeal.Started:
+			resumed = append(resumed, proposalCid)
+		case storagedeal.Staged:
+			if sm.dealsAwaitingSeal.hasDeal(proposalCid) {
+				continue
+			}
+			// The node stopped between staging the piece and recording its sector, so the deal
+			// would never complete. Record the sector if the piece made it into one, otherwise
+			// stage the piece again.
+			sectorID, found, err := sm.node.SectorBuilder().PieceSectorID(deal.Deal.Proposal.PieceRef)
+			if err != nil {
+				return errors.Wrapf(err, "failed to find the sector of deal %s", proposalCid.String())
+			}
+			if !found {
+				restaged = append(restaged, proposalCid)
+				continue
+			}
+			sm.dealsAwaitingSeal.attachDealToSector(ctx, sectorID, proposalCid)
+			if err := sm.saveDealsAwaitingSeal(); err != nil {
+				return errors.Wrap(err, "could not save deal awaiting seal")
+			}
+		}
+	}
+
+	for _, proposalCid := range restaged {
+		err := sm.updateDealResponse(ctx, proposalCid, func(resp *storagedeal.Response) {
+			resp.State = storagedeal.Started
+			resp.Message = "staging again after restart"
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, proposalCid := range append(resumed, restaged...) {
+		log.Infof("resuming storage deal %s", proposalCid.String())
+		go sm.dealProcessor(sm, proposalCid)
+	}
+	return nil
 }
 
 func (sm *Miner) loadDealsAwaitingSeal() error {
@@ -668,11 +930,13 @@ func (sm *Miner) handleQueryDeal(s inet.Stream) {
 }
 
 // OnNewHeaviestTipSet is a callback called by node, every time the the latest
-// head is updated. It is used to check if we are in a new proving period and
-// need to trigger PoSt submission.
+// head is updated. It is used to trigger redeeming the vouchers that became valid, and to
+// schedule the PoSt of the current proving period.
 func (sm *Miner) OnNewHeaviestTipSet(ts types.TipSet) error {
 	ctx := context.Background()
 
+	sm.notifyRedeemer()
+
 	isBootstrapMinerActor, err := sm.isBootstrapMinerActor(ctx)
 	if err != nil {
 		return errors.Errorf("could not determine if actor created for bootstrapping: %s", err)
@@ -714,35 +978,12 @@ func (sm *Miner) OnNewHeaviestTipSet(ts types.TipSet) error {
 		return errors.Errorf("failed to get proving period: %s", err)
 	}
 
-	sm.postInProcessLk.Lock()
-	defer sm.postInProcessLk.Unlock()
-
-	if sm.postInProcess != nil && sm.postInProcess.Equal(provingPeriodEnd) {
-		// post is already being generated for this period, nothing to do
-		return nil
-	}
-
 	height, err := ts.Height()
 	if err != nil {
 		return errors.Errorf("failed to get block height: %s", err)
 	}
 
-	// the block height of the new heaviest tipset
-	h := types.NewBlockHeight(height)
-
-	if h.GreaterEqual(provingPeriodStart) {
-		if h.LessEqual(provingPeriodEnd) {
-			// we are in a new proving period, lets get this post going
-			sm.postInProcess = provingPeriodEnd
-
-			go sm.submitPoSt(ctx, provingPeriodStart, provingPeriodEnd, inputs)
-		} else {
-			// we are too late
-			// TODO: figure out faults and payments here
-			return errors.Errorf("too late start=%s  end=%s current=%s", provingPeriodStart, provingPeriodEnd, h)
-		}
-	}
-	return nil
+	return sm.schedulePoSt(ctx, provingPeriodStart, provingPeriodEnd, types.NewBlockHeight(height), inputs)
 }
 
 func (sm *Miner) getProvingPeriod() (*types.BlockHeight, *types.BlockHeight, error) {
@@ -759,24 +1000,6 @@ func (sm *Miner) getProvingPeriod() (*types.BlockHeight, *types.BlockHeight, err
 	return types.NewBlockHeightFromBytes(res[0]), types.NewBlockHeightFromBytes(res[1]), nil
 }
 
-func (sm *Miner) submitPoSt(ctx context.Context, start, end *types.BlockHeight, inputs []PoStInputs) {
-	prover := NewProver(sm.minerAddr, sm.minerOwnerAddr, sm, sm)
-	submission, err := prover.CalculatePoSt(ctx, start, end, inputs)
-	if err != nil {
-		log.Errorf("failed to calculate PoSt: %s", err)
-		return
-	}
-
-	gasPrice := types.NewGasPrice(submitPostGasPrice)
-	_, err = sm.porcelainAPI.MessageSend(ctx, sm.minerOwnerAddr, sm.minerAddr, submission.Fee, gasPrice, submission.GasLimit, "submitPoSt", submission.Proofs)
-	if err != nil {
-		log.Errorf("failed to submit PoSt: %s", err)
-		return
-	}
-
-	log.Info("submitted PoSt")
-}
-
 //
 // Implementation of ProofReader.
 //
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"context"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/pkg/errors"
+)
+
+// MinerAPI here is the API for a storage miner.
+type MinerAPI struct {
+	miner func() *Miner
+}
+
+// NewMinerAPI creates a new API for the storage miner returned by miner, which is nil while
+// the node isn't mining.
+func NewMinerAPI(miner func() *Miner) MinerAPI {
+	return MinerAPI{miner: miner}
+}
+
+// ImportDealData calls the storage miner ImportDealData function
+func (a *MinerAPI) ImportDealData(ctx context.Context, proposalCid cid.Cid, data io.Reader, isCar bool) error {
+	sm, err := a.storageMiner()
+	if err != nil {
+		return err
+	}
+	return sm.ImportDealData(ctx, proposalCid, data, isCar)
+}
+
+// Vouchers calls the storage miner Vouchers function
+func (a *MinerAPI) Vouchers(ctx context.Context) ([]*VoucherStatus, error) {
+	sm, err := a.storageMiner()
+	if err != nil {
+		return nil, err
+	}
+	return sm.Vouchers(ctx)
+}
+
+// PoStStatus calls the storage miner PoStStatus function
+func (a *MinerAPI) PoStStatus() ([]*PoStPeriod, error) {
+	sm, err := a.storageMiner()
+	if err != nil {
+		return nil, err
+	}
+	return sm.PoStStatus(), nil
+}
+
+func (a *MinerAPI) storageMiner() (*Miner, error) {
+	sm := a.miner()
+	if sm == nil {
+		return nil, errors.New("node is not running a storage miner, start mining first")
+	}
+	return sm, nil
+}
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"errors"
@@ -11,7 +12,16 @@ import (
 	"time"
 
 	bserv "github.com/ipfs/go-blockservice"
+	"github.com/ipfs/go-car"
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/go-ipfs-blockstore"
+	chunk "github.com/ipfs/go-ipfs-chunker"
+	"github.com/ipfs/go-ipfs-exchange-offline"
+	ipld "github.com/ipfs/go-ipld-format"
+	dag "github.com/ipfs/go-merkledag"
+	imp "github.com/ipfs/go-unixfs/importer"
 	"github.com/libp2p/go-libp2p-host"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -19,10 +29,12 @@ import (
 	"github.com/filecoin-project/go-filecoin/abi"
 	"github.com/filecoin-project/go-filecoin/actor"
 	"github.com/filecoin-project/go-filecoin/actor/builtin"
+	minerActor "github.com/filecoin-project/go-filecoin/actor/builtin/miner"
 	"github.com/filecoin-project/go-filecoin/actor/builtin/paymentbroker"
 	"github.com/filecoin-project/go-filecoin/address"
 	"github.com/filecoin-project/go-filecoin/exec"
 	"github.com/filecoin-project/go-filecoin/plumbing/cfg"
+	"github.com/filecoin-project/go-filecoin/plumbing/strgdls"
 	"github.com/filecoin-project/go-filecoin/porcelain"
 	"github.com/filecoin-project/go-filecoin/proofs/sectorbuilder"
 	"github.com/filecoin-project/go-filecoin/protocol/storage/storagedeal"
@@ -505,13 +517,24 @@ func successMessageHandlers(t *testing.T) messageHandlerMap {
 	return handlers
 }
 
-type testNode struct{}
+type testNode struct {
+	blockService  bserv.BlockService
+	sectorBuilder *testSectorBuilder
+}
 
-func (tn *testNode) BlockService() bserv.BlockService           { return nil }
-func (tn *testNode) Host() host.Host                            { return nil }
-func (tn *testNode) SectorBuilder() sectorbuilder.SectorBuilder { return &testSectorBuilder{} }
+func (tn *testNode) BlockService() bserv.BlockService { return tn.blockService }
+func (tn *testNode) Host() host.Host                  { return nil }
+func (tn *testNode) SectorBuilder() sectorbuilder.SectorBuilder {
+	if tn.sectorBuilder != nil {
+		return tn.sectorBuilder
+	}
+	return &testSectorBuilder{}
+}
 
-type testSectorBuilder struct{}
+type testSectorBuilder struct {
+	// pieceSectors maps the pieces in the sector builder to their sector ids.
+	pieceSectors map[cid.Cid]uint64
+}
 
 func (tsb *testSectorBuilder) AddPiece(ctx context.Context, pieceRef cid.Cid, pieceSize uint64, pieceReader io.Reader) (sectorID uint64, err error) {
 	return 0, nil
@@ -519,6 +542,13 @@ func (tsb *testSectorBuilder) AddPiece(ctx context.Context, pieceRef cid.Cid, pi
 func (tsb *testSectorBuilder) ReadPieceFromSealedSector(pieceCid cid.Cid) (io.Reader, error) {
 	return nil, nil
 }
+func (tsb *testSectorBuilder) SealedPieceInfo(pieceCid cid.Cid) (*sectorbuilder.PieceInfo, error) {
+	return nil, nil
+}
+func (tsb *testSectorBuilder) PieceSectorID(pieceCid cid.Cid) (uint64, bool, error) {
+	sectorID, ok := tsb.pieceSectors[pieceCid]
+	return sectorID, ok, nil
+}
 func (tsb *testSectorBuilder) SealAllStagedSectors(ctx context.Context) error {
 	return nil
 }
@@ -557,10 +587,14 @@ type minerTestPorcelain struct {
 	noChannels      bool
 	blockHeight     *types.BlockHeight
 	channelEol      *types.BlockHeight
+	amountRedeemed  types.AttoFIL
 	paymentStart    *types.BlockHeight
 	randError       bool
 	deals           map[cid.Cid]*storagedeal.Deal
+	dagService      ipld.DAGService
 	messageHandlers map[string]func(address.Address, types.AttoFIL, ...interface{}) ([][]byte, error)
+	// blockMessageWait makes MessageWait wait until its context is done, as if the message is never mined
+	blockMessageWait bool
 
 	testing *testing.T
 }
@@ -607,6 +641,7 @@ func newMinerTestPorcelain(t *testing.T) *minerTestPorcelain {
 		signer:          mockSigner,
 		noChannels:      false,
 		channelEol:      types.NewBlockHeight(13773),
+		amountRedeemed:  types.ZeroAttoFIL,
 		blockHeight:     blockHeight,
 		paymentStart:    blockHeight,
 		messageHandlers: messageHandlerMap{},
@@ -615,6 +650,15 @@ func newMinerTestPorcelain(t *testing.T) *minerTestPorcelain {
 	}
 }
 
+func (mtp *minerTestPorcelain) ActorGetState(ctx context.Context, addr address.Address, out interface{}) error {
+	state, ok := out.(*minerActor.State)
+	if !ok {
+		return fmt.Errorf("no test state for %T", out)
+	}
+	state.ActiveCollateral = types.NewAttoFILFromFIL(1000)
+	return nil
+}
+
 func (mtp *minerTestPorcelain) ActorGetSignature(ctx context.Context, actorAddr address.Address, method string) (_ *exec.FunctionSignature, err error) {
 	return builtin.Actors[types.MinerActorCodeCid].Exports()[method], nil
 }
@@ -651,7 +695,7 @@ func (mtp *minerTestPorcelain) messageQueryPaymentBrokerLs() ([][]byte, error) {
 		channels[id] = &paymentbroker.PaymentChannel{
 			Target:         mtp.targetAddress,
 			Amount:         types.NewAttoFILFromFIL(100000),
-			AmountRedeemed: types.NewAttoFILFromFIL(0),
+			AmountRedeemed: mtp.amountRedeemed,
 			AgreedEol:      mtp.channelEol,
 			Eol:            mtp.channelEol,
 		}
@@ -662,6 +706,13 @@ func (mtp *minerTestPorcelain) messageQueryPaymentBrokerLs() ([][]byte, error) {
 	return [][]byte{channelsBytes}, nil
 }
 
+func (mtp *minerTestPorcelain) DAGImportData(ctx context.Context, data io.Reader) (ipld.Node, error) {
+	if mtp.dagService == nil {
+		return nil, errors.New("no dag service")
+	}
+	return imp.BuildDagFromReader(mtp.dagService, chunk.DefaultSplitter(data))
+}
+
 func (mtp *minerTestPorcelain) ConfigGet(dottedPath string) (interface{}, error) {
 	return mtp.config.Get(dottedPath)
 }
@@ -670,7 +721,272 @@ func (mtp *minerTestPorcelain) ChainBlockHeight() (*types.BlockHeight, error) {
 	return mtp.blockHeight, nil
 }
 
+func TestResumeDeals(t *testing.T) {
+	tf.UnitTest(t)
+
+	porcelainAPI, miner, proposal := defaultMinerTestSetup(t, VoucherInterval, defaultAmountInc)
+	miner.dealsAwaitingSeal = newDealsAwaitingSeal()
+
+	processed := make(chan cid.Cid, 10)
+	miner.dealProcessor = func(m *Miner, proposalCid cid.Cid) {
+		processed <- proposalCid
+	}
+
+	newCid := types.NewCidForTestGetter()
+	putDealOfPiece := func(minerAddr address.Address, state storagedeal.State, pieceRef cid.Cid) cid.Cid {
+		p := proposal.Proposal
+		p.PieceRef = pieceRef
+		proposalCid := newCid()
+		require.NoError(t, porcelainAPI.DealPut(&storagedeal.Deal{
+			Miner:    minerAddr,
+			Proposal: &p,
+			Response: &storagedeal.Response{State: state, ProposalCid: proposalCid},
+		}))
+		return proposalCid
+	}
+	putDeal := func(minerAddr address.Address, state storagedeal.State) cid.Cid {
+		return putDealOfPiece(minerAddr, state, proposal.Proposal.PieceRef)
+	}
+
+	accepted := putDeal(miner.minerAddr, storagedeal.Accepted)
+	started := putDeal(miner.minerAddr, storagedeal.Started)
+	staged := putDeal(miner.minerAddr, storagedeal.Staged)
+	unattached := putDeal(miner.minerAddr, storagedeal.Staged)
+	putDeal(miner.minerAddr, storagedeal.Complete)
+	putDeal(miner.minerAddr, storagedeal.Failed)
+	putDeal(address.TestAddress, storagedeal.Accepted)
+
+	// The node stopped after this deal's piece was added to sector 7, but before recording it.
+	pieceInSector := newCid()
+	inSector := putDealOfPiece(miner.minerAddr, storagedeal.Staged, pieceInSector)
+	miner.node = &testNode{sectorBuilder: &testSectorBuilder{
+		pieceSectors: map[cid.Cid]uint64{pieceInSector: 7},
+	}}
+
+	miner.dealsAwaitingSeal.attachDealToSector(context.Background(), 42, staged)
+
+	require.NoError(t, miner.ResumeDeals(context.Background()))
+
+	var resumed []cid.Cid
+	for i := 0; i < 3; i++ {
+		select {
+		case c := <-processed:
+			resumed = append(resumed, c)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for deals to resume")
+		}
+	}
+	assert.ElementsMatch(t, []cid.Cid{accepted, started, unattached}, resumed)
+
+	deal, err := porcelainAPI.DealGet(context.Background(), unattached)
+	require.NoError(t, err)
+	assert.Equal(t, storagedeal.Started, deal.Response.State)
+	require.Len(t, deal.History, 1)
+	assert.Equal(t, storagedeal.Started, deal.History[0].State)
+
+	assert.True(t, miner.dealsAwaitingSeal.hasDeal(inSector))
+	deal, err = porcelainAPI.DealGet(context.Background(), inSector)
+	require.NoError(t, err)
+	assert.Equal(t, storagedeal.Staged, deal.Response.State)
+
+	select {
+	case c := <-processed:
+		t.Fatalf("unexpectedly resumed deal %s", c)
+	default:
+	}
+}
+
+func TestDealStateHistory(t *testing.T) {
+	tf.UnitTest(t)
+
+	porcelainAPI, miner, proposal := defaultMinerTestSetup(t, VoucherInterval, defaultAmountInc)
+	miner.node = &testNode{}
+	// don't process the deal, so it only changes state below
+	miner.dealProcessor = func(m *Miner, proposalCid cid.Cid) {}
+
+	resp, err := acceptProposal(miner, &proposal.Proposal)
+	require.NoError(t, err)
+
+	require.NoError(t, miner.updateDealResponse(context.Background(), resp.ProposalCid, func(r *storagedeal.Response) {
+		r.State = storagedeal.Staged
+	}))
+	require.NoError(t, miner.updateDealResponse(context.Background(), resp.ProposalCid, func(r *storagedeal.Response) {
+		r.State = storagedeal.Staged
+	}))
+
+	deal, err := porcelainAPI.DealGet(context.Background(), resp.ProposalCid)
+	require.NoError(t, err)
+	require.Len(t, deal.History, 2)
+	assert.Equal(t, storagedeal.Accepted, deal.History[0].State)
+	assert.Equal(t, storagedeal.Staged, deal.History[1].State)
+	assert.True(t, deal.History[0].Time > 0)
+	assert.True(t, deal.History[0].Time <= deal.History[1].Time)
+}
+
+func TestImportDealData(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	data := []byte("the data of a deal too big to send over the network")
+
+	// the client's copy of the data
+	_, clientDAG := newTestDAG()
+	root, err := imp.BuildDagFromReader(clientDAG, chunk.DefaultSplitter(bytes.NewReader(data)))
+	require.NoError(t, err)
+
+	setup := func(t *testing.T, offlineTransfer bool) (*minerTestPorcelain, *Miner, cid.Cid) {
+		porcelainAPI, miner, proposal := defaultMinerTestSetup(t, VoucherInterval, defaultAmountInc)
+
+		blockService, dagService := newTestDAG()
+		miner.node = &testNode{blockService: blockService}
+		porcelainAPI.dagService = dagService
+		miner.dealsAwaitingSeal = newDealsAwaitingSeal()
+		miner.dealsAwaitingSealDs = repo.NewInMemoryRepo().DealsDatastore()
+
+		proposal.PieceRef = root.Cid()
+		proposal.Size = types.NewBytesAmount(uint64(len(data)))
+		proposal.OfflineTransfer = offlineTransfer
+
+		proposalCid := types.NewCidForTestGetter()()
+		require.NoError(t, porcelainAPI.DealPut(&storagedeal.Deal{
+			Miner:    miner.minerAddr,
+			Proposal: &proposal.Proposal,
+			Response: &storagedeal.Response{State: storagedeal.Accepted, ProposalCid: proposalCid},
+		}))
+		return porcelainAPI, miner, proposalCid
+	}
+
+	assertState := func(t *testing.T, porcelainAPI *minerTestPorcelain, proposalCid cid.Cid, state storagedeal.State) {
+		deal, err := porcelainAPI.DealGet(ctx, proposalCid)
+		require.NoError(t, err)
+		assert.Equal(t, state, deal.Response.State)
+	}
+
+	t.Run("waits for the data of offline deals", func(t *testing.T) {
+		porcelainAPI, miner, proposalCid := setup(t, true)
+
+		miner.processStorageDeal(proposalCid)
+
+		assertState(t, porcelainAPI, proposalCid, storagedeal.Accepted)
+		deal, err := porcelainAPI.DealGet(ctx, proposalCid)
+		require.NoError(t, err)
+		assert.Contains(t, deal.Response.Message, "waiting for the data")
+	})
+
+	t.Run("stages imported files", func(t *testing.T) {
+		porcelainAPI, miner, proposalCid := setup(t, true)
+
+		require.NoError(t, miner.ImportDealData(ctx, proposalCid, bytes.NewReader(data), false))
+		assertState(t, porcelainAPI, proposalCid, storagedeal.Staged)
+		assert.True(t, miner.dealsAwaitingSeal.hasDeal(proposalCid))
+	})
+
+	t.Run("stages imported cars", func(t *testing.T) {
+		porcelainAPI, miner, proposalCid := setup(t, true)
+
+		var carData bytes.Buffer
+		require.NoError(t, car.WriteCar(ctx, clientDAG, []cid.Cid{root.Cid()}, &carData))
+
+		require.NoError(t, miner.ImportDealData(ctx, proposalCid, &carData, true))
+		assertState(t, porcelainAPI, proposalCid, storagedeal.Staged)
+	})
+
+	t.Run("rejects data that isn't the deal's piece", func(t *testing.T) {
+		porcelainAPI, miner, proposalCid := setup(t, true)
+
+		err := miner.ImportDealData(ctx, proposalCid, bytes.NewReader([]byte("something else")), false)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "but the deal is for piece")
+		assertState(t, porcelainAPI, proposalCid, storagedeal.Accepted)
+	})
+
+	t.Run("rejects deals transferred online", func(t *testing.T) {
+		_, miner, proposalCid := setup(t, false)
+
+		err := miner.ImportDealData(ctx, proposalCid, bytes.NewReader(data), false)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "does not transfer its data offline")
+	})
+}
+
+func TestRenewInSector(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	newCid := types.NewCidForTestGetter()
+
+	setup := func(sectorID uint64) (*minerTestPorcelain, *Miner, cid.Cid) {
+		porcelainAPI, miner, proposal := defaultMinerTestSetup(t, VoucherInterval, defaultAmountInc)
+		// sector 42 is committed
+		porcelainAPI.messageHandlers = successMessageHandlers(t)
+
+		renewedCid := newCid()
+		require.NoError(t, porcelainAPI.DealPut(&storagedeal.Deal{
+			Miner:    miner.minerAddr,
+			Proposal: &proposal.Proposal,
+			Response: &storagedeal.Response{
+				State:       storagedeal.Complete,
+				ProposalCid: renewedCid,
+				ProofInfo:   &storagedeal.ProofInfo{SectorID: sectorID, PieceInclusionProof: []byte{3, 3, 3}},
+			},
+		}))
+
+		renewal := proposal.Proposal
+		renewal.Duration = 20000
+		renewal.RenewalOf = &renewedCid
+		renewalCid := newCid()
+		require.NoError(t, porcelainAPI.DealPut(&storagedeal.Deal{
+			Miner:    miner.minerAddr,
+			Proposal: &renewal,
+			Response: &storagedeal.Response{State: storagedeal.Accepted, ProposalCid: renewalCid},
+		}))
+		return porcelainAPI, miner, renewalCid
+	}
+
+	t.Run("completes renewals of pieces in committed sectors", func(t *testing.T) {
+		porcelainAPI, miner, renewalCid := setup(42)
+
+		miner.processStorageDeal(renewalCid)
+
+		deal, err := porcelainAPI.DealGet(ctx, renewalCid)
+		require.NoError(t, err)
+		assert.Equal(t, storagedeal.Complete, deal.Response.State)
+		assert.Equal(t, "renewed in sector 42", deal.Response.Message)
+		require.NotNil(t, deal.Response.ProofInfo)
+		assert.Equal(t, uint64(42), deal.Response.ProofInfo.SectorID)
+		assert.Equal(t, []byte{3, 3, 3}, deal.Response.ProofInfo.PieceInclusionProof)
+	})
+
+	t.Run("seals pieces not in a committed sector again", func(t *testing.T) {
+		porcelainAPI, miner, renewalCid := setup(7)
+
+		deal, err := porcelainAPI.DealGet(ctx, renewalCid)
+		require.NoError(t, err)
+		assert.False(t, miner.renewInSector(ctx, renewalCid, deal.Proposal))
+		assert.Equal(t, storagedeal.Accepted, deal.Response.State)
+	})
+
+	t.Run("seals pieces of other clients again", func(t *testing.T) {
+		porcelainAPI, miner, renewalCid := setup(42)
+
+		deal, err := porcelainAPI.DealGet(ctx, renewalCid)
+		require.NoError(t, err)
+		deal.Proposal.Payment.Payer = address.TestAddress
+		assert.False(t, miner.renewInSector(ctx, renewalCid, deal.Proposal))
+	})
+}
+
+func newTestDAG() (bserv.BlockService, ipld.DAGService) {
+	bs := blockstore.NewBlockstore(dssync.MutexWrap(datastore.NewMapDatastore()))
+	blockService := bserv.New(bs, offline.Exchange(bs))
+	return blockService, dag.NewDAGService(blockService)
+}
+
 func (mtp *minerTestPorcelain) MessageWait(ctx context.Context, msgCid cid.Cid, cb func(*types.Block, *types.SignedMessage, *types.MessageReceipt) error) error {
+	if mtp.blockMessageWait {
+		<-ctx.Done()
+		return ctx.Err()
+	}
 	return nil
 }
 
@@ -701,8 +1017,9 @@ func minerWithAcceptedDealTestSetup(t *testing.T, proposalCid cid.Cid, sectorID
 	// give miner a test node that will return a test sector builder
 	miner.node = &testNode{}
 
-	// give the miner some place to store the deal
+	// give the miner some place to store the deal and its PoSts
 	miner.dealsAwaitingSealDs = repo.NewInMemoryRepo().DealsDs
+	miner.postsDs = miner.dealsAwaitingSealDs
 
 	// create the dealsAwaitingSeal to manage the deal prior to sealing
 	err := miner.loadDealsAwaitingSeal()
@@ -809,3 +1126,22 @@ func (mtp *minerTestPorcelain) DealPut(storageDeal *storagedeal.Deal) error {
 	mtp.deals[storageDeal.Response.ProposalCid] = storageDeal
 	return nil
 }
+
+func (mtp *minerTestPorcelain) DealsQuery(q strgdls.Query) ([]*storagedeal.Deal, error) {
+	var deals []*storagedeal.Deal
+	for _, storageDeal := range mtp.deals {
+		if q.Matches(storageDeal) {
+			deals = append(deals, storageDeal)
+		}
+	}
+	return deals, nil
+}
+
+func (mtp *minerTestPorcelain) DealsLs(_ context.Context) (<-chan *porcelain.StorageDealLsResult, error) {
+	out := make(chan *porcelain.StorageDealLsResult, len(mtp.deals))
+	for _, storageDeal := range mtp.deals {
+		out <- &porcelain.StorageDealLsResult{Deal: *storageDeal}
+	}
+	close(out)
+	return out, nil
+}
@@ -0,0 +1,289 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/pkg/errors"
+
+	minerActor "github.com/filecoin-project/go-filecoin/actor/builtin/miner"
+	"github.com/filecoin-project/go-filecoin/config"
+	"github.com/filecoin-project/go-filecoin/types"
+)
+
+const postsDatastorePrefix = "posts"
+
+// postHistoryLength is how many proving periods the PoSt history keeps.
+const postHistoryLength = 100
+
+// PoStState is the state of the PoSt of a proving period.
+type PoStState string
+
+const (
+	// PoStGenerating is a PoSt being generated or submitted.
+	PoStGenerating = PoStState("generating")
+	// PoStRetrying is a PoSt whose last attempt failed, retried at the next block.
+	PoStRetrying = PoStState("retrying")
+	// PoStSubmitted is a PoSt whose message was mined.
+	PoStSubmitted = PoStState("submitted")
+	// PoStFailed is a PoSt that failed as many times as retries allow.
+	PoStFailed = PoStState("failed")
+	// PoStMissed is a PoSt too late for the miner actor to accept it.
+	PoStMissed = PoStState("missed")
+)
+
+// PoStAttempt is an attempt at generating and submitting the PoSt of a proving period.
+type PoStAttempt struct {
+	Height *types.BlockHeight `json:"height"`
+	Error  string             `json:"error,omitempty"`
+}
+
+// PoStPeriod is the PoSt history of a proving period.
+type PoStPeriod struct {
+	Start    *types.BlockHeight `json:"start"`
+	End      *types.BlockHeight `json:"end"`
+	State    PoStState          `json:"state"`
+	Attempts []PoStAttempt      `json:"attempts"`
+	// Fee is the late fee paid with the submitted PoSt.
+	Fee        types.AttoFIL `json:"fee"`
+	MessageCid *cid.Cid      `json:"messageCid,omitempty"`
+}
+
+// PoStStatus returns the PoSt history of the miner's proving periods, oldest first.
+func (sm *Miner) PoStStatus() []*PoStPeriod {
+	sm.postsLk.Lock()
+	defer sm.postsLk.Unlock()
+
+	periods := make([]*PoStPeriod, len(sm.posts))
+	for i, p := range sm.posts {
+		period := *p
+		period.Attempts = append([]PoStAttempt(nil), p.Attempts...)
+		periods[i] = &period
+	}
+	return periods
+}
+
+// schedulePoSt starts generating the PoSt of the proving period from start to end at height,
+// unless it is being generated or is over. A PoSt expected to be mined after the end of the
+// period is submitted late with the late fee, which is less than the collateral a missed
+// PoSt forfeits, as long as the miner actor accepts it. A PoSt whose message isn't mined by
+// the end of the grace period, e.g. because it was dropped from the message pool, is given
+// up on so that later proving periods can be scheduled.
+func (sm *Miner) schedulePoSt(ctx context.Context, start, end, height *types.BlockHeight, inputs []PoStInputs) error {
+	sm.postsLk.Lock()
+	defer sm.postsLk.Unlock()
+
+	if sm.generatingPoSt {
+		if height.GreaterEqual(sm.postDeadline) {
+			sm.cancelPoSt()
+		}
+		return nil
+	}
+
+	if height.LessThan(start) {
+		return nil
+	}
+
+	period := sm.postPeriod(start, end)
+	if period.State == PoStSubmitted || period.State == PoStFailed || period.State == PoStMissed {
+		return nil
+	}
+
+	policy, err := sm.getPoStPolicy()
+	if err != nil {
+		return err
+	}
+
+	if uint(len(period.Attempts)) > policy.PoStRetries {
+		period.State = PoStFailed
+		if err := sm.savePoSts(); err != nil {
+			return err
+		}
+		return errors.Errorf("PoSt of proving period ending at %s failed %d times", end, len(period.Attempts))
+	}
+
+	grace, err := sm.postGracePeriod(ctx)
+	if err != nil {
+		return err
+	}
+	if height.Add(types.NewBlockHeight(policy.PoStSafetyMargin)).GreaterEqual(end.Add(grace)) {
+		period.State = PoStMissed
+		if err := sm.savePoSts(); err != nil {
+			return err
+		}
+		// TODO: figure out faults and payments here
+		return errors.Errorf("too late start=%s  end=%s current=%s", start, end, height)
+	}
+
+	period.State = PoStGenerating
+	period.Attempts = append(period.Attempts, PoStAttempt{Height: height})
+	if err := sm.savePoSts(); err != nil {
+		return err
+	}
+
+	postCtx, cancel := context.WithCancel(ctx)
+	sm.generatingPoSt = true
+	sm.postDeadline = end.Add(grace)
+	sm.cancelPoSt = cancel
+	go sm.submitPoSt(postCtx, start, end, inputs)
+	return nil
+}
+
+// submitPoSt generates and submits the PoSt of a proving period, waits for it to be mined
+// and records the outcome of the attempt.
+func (sm *Miner) submitPoSt(ctx context.Context, start, end *types.BlockHeight, inputs []PoStInputs) {
+	submission, msgCid, err := sm.generatePoSt(ctx, start, end, inputs)
+
+	sm.postsLk.Lock()
+	defer sm.postsLk.Unlock()
+
+	sm.cancelPoSt()
+	sm.generatingPoSt = false
+	sm.postDeadline = nil
+	sm.cancelPoSt = nil
+	period := sm.postPeriod(start, end)
+	if err != nil {
+		log.Errorf("failed to submit PoSt: %s", err)
+		period.State = PoStRetrying
+		period.Attempts[len(period.Attempts)-1].Error = err.Error()
+	} else {
+		log.Info("submitted PoSt")
+		period.State = PoStSubmitted
+		period.Fee = submission.Fee
+		period.MessageCid = &msgCid
+	}
+
+	if err := sm.savePoSts(); err != nil {
+		log.Errorf("failed persisting PoSt history: %s", err)
+	}
+}
+
+func (sm *Miner) generatePoSt(ctx context.Context, start, end *types.BlockHeight, inputs []PoStInputs) (*PoStSubmission, cid.Cid, error) {
+	prover := NewProver(sm.minerAddr, sm.minerOwnerAddr, sm, sm)
+	submission, err := prover.CalculatePoSt(ctx, start, end, inputs)
+	if err != nil {
+		return nil, cid.Undef, errors.Wrap(err, "failed to calculate PoSt")
+	}
+
+	gasPrice := types.NewGasPrice(submitPostGasPrice)
+	msgCid, err := sm.porcelainAPI.MessageSend(ctx, sm.minerOwnerAddr, sm.minerAddr, submission.Fee, gasPrice, submission.GasLimit, "submitPoSt", submission.Proofs)
+	if err != nil {
+		return nil, cid.Undef, errors.Wrap(err, "failed to send PoSt")
+	}
+
+	err = sm.porcelainAPI.MessageWait(ctx, msgCid, func(blk *types.Block, smsg *types.SignedMessage, receipt *types.MessageReceipt) error {
+		if receipt.ExitCode != 0 {
+			return errors.Errorf("PoSt message %s failed with exit code %d", msgCid, receipt.ExitCode)
+		}
+		return nil
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, cid.Undef, errors.Errorf("PoSt message %s was not mined by the end of the grace period", msgCid)
+		}
+		return nil, cid.Undef, err
+	}
+	return submission, msgCid, nil
+}
+
+// postPeriod returns the PoSt history of the proving period ending at end, recording a new
+// one if there is none. postsLk must be held.
+func (sm *Miner) postPeriod(start, end *types.BlockHeight) *PoStPeriod {
+	for _, p := range sm.posts {
+		if p.End.Equal(end) {
+			return p
+		}
+	}
+
+	period := &PoStPeriod{Start: start, End: end, State: PoStGenerating, Fee: types.ZeroAttoFIL}
+	sm.posts = append(sm.posts, period)
+	if len(sm.posts) > postHistoryLength {
+		sm.posts = sm.posts[len(sm.posts)-postHistoryLength:]
+	}
+	return period
+}
+
+// LatePoStFee provides the Prover with the fee for submitting the PoSt of the proving
+// period ending at periodEnd now, expecting it to be mined within the safety margin.
+func (sm *Miner) LatePoStFee(ctx context.Context, periodEnd *types.BlockHeight) (types.AttoFIL, error) {
+	policy, err := sm.getPoStPolicy()
+	if err != nil {
+		return types.ZeroAttoFIL, err
+	}
+
+	height, err := sm.porcelainAPI.ChainBlockHeight()
+	if err != nil {
+		return types.ZeroAttoFIL, err
+	}
+	minedAt := height.Add(types.NewBlockHeight(policy.PoStSafetyMargin))
+	if minedAt.LessEqual(periodEnd) {
+		return types.ZeroAttoFIL, nil
+	}
+
+	collateral, err := sm.getActiveCollateral(ctx)
+	if err != nil {
+		return types.ZeroAttoFIL, errors.Wrap(err, "failed to get miner collateral")
+	}
+	grace, err := sm.postGracePeriod(ctx)
+	if err != nil {
+		return types.ZeroAttoFIL, err
+	}
+	return minerActor.LatePoStFee(collateral, periodEnd, minedAt, grace), nil
+}
+
+// postGracePeriod returns how many blocks after the end of a proving period the miner actor
+// accepts a late PoSt.
+func (sm *Miner) postGracePeriod(ctx context.Context) (*types.BlockHeight, error) {
+	sectorSize, err := sm.porcelainAPI.MinerGetSectorSize(ctx, sm.minerAddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get sector size")
+	}
+	return minerActor.GenerationAttackTime(sectorSize), nil
+}
+
+// getActiveCollateral reads the collateral committed to the miner's sectors, from which the
+// late fee is charged, from the miner actor's state.
+func (sm *Miner) getActiveCollateral(ctx context.Context) (types.AttoFIL, error) {
+	var state minerActor.State
+	if err := sm.porcelainAPI.ActorGetState(ctx, sm.minerAddr, &state); err != nil {
+		return types.ZeroAttoFIL, err
+	}
+	return state.ActiveCollateral, nil
+}
+
+func (sm *Miner) getPoStPolicy() (*config.MiningConfig, error) {
+	policy, err := sm.porcelainAPI.ConfigGet("mining")
+	if err != nil {
+		return nil, err
+	}
+	policyConfig, ok := policy.(*config.MiningConfig)
+	if !ok || policyConfig == nil {
+		return nil, errors.New("Could not retrieve mining from config")
+	}
+	return policyConfig, nil
+}
+
+func (sm *Miner) loadPoSts() error {
+	key := datastore.KeyWithNamespaces([]string{postsDatastorePrefix})
+	result, notFound := sm.postsDs.Get(key)
+	if notFound == nil {
+		if err := json.Unmarshal(result, &sm.posts); err != nil {
+			return errors.Wrap(err, "failed to unmarshal PoSt history from datastore")
+		}
+	}
+	return nil
+}
+
+func (sm *Miner) savePoSts() error {
+	marshalledPoSts, err := json.Marshal(sm.posts)
+	if err != nil {
+		return errors.Wrap(err, "Could not marshal PoSt history")
+	}
+	key := datastore.KeyWithNamespaces([]string{postsDatastorePrefix})
+	if err := sm.postsDs.Put(key, marshalledPoSts); err != nil {
+		return errors.Wrap(err, "could not save PoSt history to disk")
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	tf "github.com/filecoin-project/go-filecoin/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/types"
+)
+
+func TestPoStScheduler(t *testing.T) {
+	tf.UnitTest(t)
+
+	proposalCid := types.NewCidForTestGetter()()
+	sector := testSectorMetadata(proposalCid)
+
+	// the proving period of successMessageHandlers
+	start := types.NewBlockHeight(20003)
+	end := types.NewBlockHeight(40003)
+
+	// setup returns a miner whose submitPoSt messages fail failures times, and the values of
+	// the submitPoSt messages sent.
+	setup := func(t *testing.T, failures int) (*minerTestPorcelain, *Miner, *[]types.AttoFIL) {
+		api, miner, _ := minerWithAcceptedDealTestSetup(t, proposalCid, sector.SectorID)
+
+		var sent []types.AttoFIL
+		handlers := successMessageHandlers(t)
+		handlers["submitPoSt"] = func(a address.Address, v types.AttoFIL, p ...interface{}) ([][]byte, error) {
+			sent = append(sent, v)
+			if len(sent) <= failures {
+				return nil, errors.New("test error")
+			}
+			return [][]byte{}, nil
+		}
+		api.messageHandlers = handlers
+		return api, miner, &sent
+	}
+
+	newHead := func(t *testing.T, api *minerTestPorcelain, miner *Miner, height uint64) error {
+		api.blockHeight = types.NewBlockHeight(height)
+		ts, err := types.NewTipSet(&types.Block{Height: types.Uint64(height)})
+		require.NoError(t, err)
+		return miner.OnNewHeaviestTipSet(ts)
+	}
+
+	t.Run("records the submitted PoSt", func(t *testing.T) {
+		api, miner, sent := setup(t, 0)
+
+		require.NoError(t, newHead(t, api, miner, 20500))
+		period := waitForPoSt(t, miner, PoStSubmitted)
+
+		assert.Equal(t, start, period.Start)
+		assert.Equal(t, end, period.End)
+		require.Len(t, period.Attempts, 1)
+		assert.Equal(t, types.NewBlockHeight(20500), period.Attempts[0].Height)
+		assert.Equal(t, types.ZeroAttoFIL, period.Fee)
+		assert.NotNil(t, period.MessageCid)
+
+		// the PoSt is not submitted again in the same proving period
+		require.NoError(t, newHead(t, api, miner, 20501))
+		assert.Len(t, *sent, 1)
+	})
+
+	t.Run("remembers the PoSt after a restart", func(t *testing.T) {
+		api, miner, sent := setup(t, 0)
+
+		require.NoError(t, newHead(t, api, miner, 20500))
+		waitForPoSt(t, miner, PoStSubmitted)
+
+		restarted := &Miner{porcelainAPI: api, postsDs: miner.postsDs}
+		require.NoError(t, restarted.loadPoSts())
+		require.NoError(t, restarted.schedulePoSt(context.Background(), start, end, types.NewBlockHeight(20501), nil))
+
+		assert.Len(t, *sent, 1)
+		require.Len(t, restarted.PoStStatus(), 1)
+		assert.Equal(t, PoStSubmitted, restarted.PoStStatus()[0].State)
+	})
+
+	t.Run("retries a failed PoSt", func(t *testing.T) {
+		api, miner, sent := setup(t, 1)
+
+		require.NoError(t, newHead(t, api, miner, 20500))
+		waitForPoSt(t, miner, PoStRetrying)
+
+		require.NoError(t, newHead(t, api, miner, 20501))
+		period := waitForPoSt(t, miner, PoStSubmitted)
+
+		assert.Len(t, *sent, 2)
+		require.Len(t, period.Attempts, 2)
+		assert.Contains(t, period.Attempts[0].Error, "test error")
+		assert.Empty(t, period.Attempts[1].Error)
+	})
+
+	t.Run("fails once retries are exhausted", func(t *testing.T) {
+		api, miner, sent := setup(t, 2)
+		require.NoError(t, api.config.Set("mining.postRetries", "1"))
+
+		require.NoError(t, newHead(t, api, miner, 20500))
+		waitForPoSt(t, miner, PoStRetrying)
+		require.NoError(t, newHead(t, api, miner, 20501))
+		waitForPoSt(t, miner, PoStRetrying)
+
+		err := newHead(t, api, miner, 20502)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed 2 times")
+		assert.Equal(t, PoStFailed, miner.PoStStatus()[0].State)
+
+		require.NoError(t, newHead(t, api, miner, 20503))
+		assert.Len(t, *sent, 2)
+	})
+
+	t.Run("submits late PoSt with the late fee", func(t *testing.T) {
+		api, miner, sent := setup(t, 0)
+
+		// expected to be mined 50 blocks late of a 100 block grace period, with 1000 FIL of
+		// collateral
+		require.NoError(t, newHead(t, api, miner, 40033))
+		period := waitForPoSt(t, miner, PoStSubmitted)
+
+		assert.Equal(t, []types.AttoFIL{types.NewAttoFILFromFIL(500)}, *sent)
+		assert.Equal(t, types.NewAttoFILFromFIL(500), period.Fee)
+	})
+
+	t.Run("gives up waiting for a PoSt not mined by the end of the grace period", func(t *testing.T) {
+		api, miner, sent := setup(t, 0)
+		api.blockMessageWait = true
+
+		require.NoError(t, newHead(t, api, miner, 20500))
+		waitForPoSt(t, miner, PoStGenerating)
+
+		// still waiting within the grace period
+		require.NoError(t, newHead(t, api, miner, 40102))
+		assert.Equal(t, PoStGenerating, miner.PoStStatus()[0].State)
+
+		require.NoError(t, newHead(t, api, miner, 40103))
+		period := waitForPoSt(t, miner, PoStRetrying)
+		require.Len(t, period.Attempts, 1)
+		assert.Contains(t, period.Attempts[0].Error, "not mined by the end of the grace period")
+
+		// the next head is no longer blocked by the abandoned PoSt
+		err := newHead(t, api, miner, 40104)
+		require.Error(t, err)
+		assert.Equal(t, PoStMissed, miner.PoStStatus()[0].State)
+		assert.Len(t, *sent, 1)
+	})
+
+	t.Run("misses PoSt past the grace period", func(t *testing.T) {
+		api, miner, sent := setup(t, 0)
+
+		err := newHead(t, api, miner, 40090)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "too late")
+
+		assert.Equal(t, PoStMissed, miner.PoStStatus()[0].State)
+		assert.Empty(t, *sent)
+	})
+}
+
+// waitForPoSt waits for the latest PoSt of miner to be in state, and returns it.
+func waitForPoSt(t *testing.T, miner *Miner, state PoStState) *PoStPeriod {
+	for i := 0; i < 100; i++ {
+		periods := miner.PoStStatus()
+		if len(periods) > 0 && periods[len(periods)-1].State == state {
+			return periods[len(periods)-1]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.FailNow(t, "PoSt never reached state", state)
+	return nil
+}
@@ -19,6 +19,9 @@ type ProofReader interface {
 	ChainHeight() (*types.BlockHeight, error)
 	// ChallengeSeed returns the PoSt challenge seed for a proving period.
 	ChallengeSeed(ctx context.Context, periodStart *types.BlockHeight) (types.PoStChallengeSeed, error)
+	// LatePoStFee returns the fee for submitting a PoSt for a proving period now, which is
+	// zero unless the PoSt is late.
+	LatePoStFee(ctx context.Context, periodEnd *types.BlockHeight) (types.AttoFIL, error)
 }
 
 // ProofCalculator creates the proof-of-spacetime bytes.
@@ -96,14 +99,17 @@ func (sm *Prover) CalculatePoSt(ctx context.Context, start, end *types.BlockHeig
 
 	}
 
+	fee, err := sm.chain.LatePoStFee(ctx, end)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to compute late PoSt fee")
+	}
 	if height.GreaterEqual(end) {
-		// TODO: pay late fees https://github.com/filecoin-project/go-filecoin/issues/2942
-		return nil, errors.Errorf("PoSt generation was too slow height=%s end=%s", height, end)
+		log.Warningf("PoSt generation was too slow height=%s end=%s, paying late fee %s", height, end, fee)
 	}
 
 	return &PoStSubmission{
 		Proofs:   proofs,
-		Fee:      types.ZeroAttoFIL,
+		Fee:      fee,
 		GasLimit: types.NewGasUnits(submitPostGasLimit),
 	}, nil
 }
@@ -40,6 +40,23 @@ func TestProver(t *testing.T) {
 		submission, e := prover.CalculatePoSt(ctx, start, end, fakeInputs)
 		require.NoError(t, e)
 		assert.Equal(t, fake.proofs, submission.Proofs)
+		assert.Equal(t, types.ZeroAttoFIL, submission.Fee)
+	})
+
+	t.Run("pays late fee", func(t *testing.T) {
+		fake := &fakeProverDeps{
+			seed:   fakeSeed,
+			height: end.Add(types.NewBlockHeight(10)),
+			fee:    types.NewAttoFILFromFIL(7),
+			proofs: []types.PoStProof{{1, 2, 3, 4}},
+			faults: []uint64{},
+		}
+		prover := storage.NewProver(actorAddress, ownerAddress, fake, fake)
+
+		submission, e := prover.CalculatePoSt(ctx, start, end, fakeInputs)
+		require.NoError(t, e)
+		assert.Equal(t, fake.proofs, submission.Proofs)
+		assert.Equal(t, types.NewAttoFILFromFIL(7), submission.Fee)
 	})
 
 	t.Run("fails without chain height", func(t *testing.T) {
@@ -72,6 +89,7 @@ func TestProver(t *testing.T) {
 type fakeProverDeps struct {
 	seed   types.PoStChallengeSeed
 	height *types.BlockHeight
+	fee    types.AttoFIL
 	proofs []types.PoStProof
 	faults []uint64
 }
@@ -90,6 +108,13 @@ func (f *fakeProverDeps) ChallengeSeed(ctx context.Context, periodStart *types.B
 	return zeroSeed, errors.New("no seed")
 }
 
+func (f *fakeProverDeps) LatePoStFee(ctx context.Context, periodEnd *types.BlockHeight) (types.AttoFIL, error) {
+	if f.height.GreaterEqual(periodEnd) {
+		return f.fee, nil
+	}
+	return types.ZeroAttoFIL, nil
+}
+
 func (f *fakeProverDeps) CalculatePost(sortedCommRs proofs.SortedCommRs, seed types.PoStChallengeSeed) ([]types.PoStProof, []uint64, error) {
 	return f.proofs, f.faults, nil
 }
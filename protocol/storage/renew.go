@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/pkg/errors"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/porcelain"
+	"github.com/filecoin-project/go-filecoin/protocol/storage/storagedeal"
+	"github.com/filecoin-project/go-filecoin/types"
+)
+
+// RenewDeal proposes to the miner of a complete deal to store its piece for duration more
+// blocks, counted from now, at the miner's cheapest ask. If the miner rejects the renewal,
+// the piece is proposed to other miners as retry allows, which needs the data to be held
+// locally. The renewal inherits the deal's renew policy, and the proposals made are counted
+// in the deal's RenewAttempts.
+func (dm *DealManager) RenewDeal(ctx context.Context, proposalCid cid.Cid, duration uint64, retry RetryPolicy) (*storagedeal.Response, error) {
+	deal, err := dm.api.DealGet(ctx, proposalCid)
+	if err != nil {
+		return nil, err
+	}
+	if deal.Response.State != storagedeal.Complete {
+		return nil, fmt.Errorf("deal is %s, only complete deals can be renewed", deal.Response.State)
+	}
+	if deal.RenewedBy != nil {
+		return nil, fmt.Errorf("deal was already renewed by %s", deal.RenewedBy)
+	}
+
+	// td.tried holds the miners proposed to, counted as renewal attempts, and the deal's own
+	// miner even if it has no ask.
+	td := &trackedDeal{retry: retry, tried: []address.Address{deal.Miner}}
+	unasked := 0
+
+	ask, err := dm.cheapestAsk(ctx, deal.Miner)
+	if err != nil {
+		unasked = 1
+	} else {
+		var resp *storagedeal.Response
+		resp, err = dm.client.RenewDeal(ctx, deal, ask.ID, duration)
+		if err == nil {
+			return dm.recordRenewal(ctx, deal, resp, td, len(td.tried))
+		}
+		if !isMinerError(err) {
+			return nil, err
+		}
+	}
+	log.Infof("renewal of %s with %s failed: %s", proposalCid, deal.Miner, err)
+
+	resp, retryErr := dm.proposeElsewhere(ctx, deal.Proposal.PieceRef, duration, false, td)
+	if retryErr != nil {
+		if err := dm.countRenewAttempts(ctx, proposalCid, len(td.tried)-unasked); err != nil {
+			log.Errorf("failed to record renewal attempts of deal %s: %s", proposalCid, err)
+		}
+		return nil, err
+	}
+	return dm.recordRenewal(ctx, deal, resp, td, len(td.tried)-unasked)
+}
+
+// SetRenewPolicy sets the policy to renew a deal before it ends, or clears it if policy is
+// nil.
+func (dm *DealManager) SetRenewPolicy(ctx context.Context, proposalCid cid.Cid, policy *storagedeal.RenewPolicy) error {
+	dm.dealsLk.Lock()
+	defer dm.dealsLk.Unlock()
+
+	deal, err := dm.api.DealGet(ctx, proposalCid)
+	if err != nil {
+		return err
+	}
+	deal.Renew = policy
+	return dm.api.DealPut(deal)
+}
+
+// recordRenewal links a deal to the deal renewing it, passes on its renew policy, counts the
+// proposals made to renew it and follows the renewal.
+func (dm *DealManager) recordRenewal(ctx context.Context, deal *storagedeal.Deal, resp *storagedeal.Response, td *trackedDeal, proposals int) (*storagedeal.Response, error) {
+	if err := dm.linkRenewal(ctx, deal.Response.ProposalCid, resp.ProposalCid, proposals); err != nil {
+		return nil, err
+	}
+
+	dm.track(resp.ProposalCid, td)
+	return resp, nil
+}
+
+// linkRenewal records that the deal renewalCid renews the deal proposalCid after the given
+// number of proposals, and passes on the renewed deal's renew policy. Both deals are read
+// again, as they may have changed while the renewal was proposed.
+func (dm *DealManager) linkRenewal(ctx context.Context, proposalCid, renewalCid cid.Cid, proposals int) error {
+	dm.dealsLk.Lock()
+	defer dm.dealsLk.Unlock()
+
+	deal, err := dm.api.DealGet(ctx, proposalCid)
+	if err != nil {
+		return err
+	}
+	renewal, err := dm.api.DealGet(ctx, renewalCid)
+	if err != nil {
+		return err
+	}
+
+	renewal.Renew = deal.Renew
+	renewal.Renews = &proposalCid
+	if err := dm.api.DealPut(renewal); err != nil {
+		return err
+	}
+
+	deal.RenewedBy = &renewalCid
+	deal.RenewAttempts += uint64(proposals)
+	return dm.api.DealPut(deal)
+}
+
+// countRenewAttempts adds the number of proposals made to renew a deal to its attempts.
+func (dm *DealManager) countRenewAttempts(ctx context.Context, proposalCid cid.Cid, proposals int) error {
+	dm.dealsLk.Lock()
+	defer dm.dealsLk.Unlock()
+
+	deal, err := dm.api.DealGet(ctx, proposalCid)
+	if err != nil {
+		return err
+	}
+	deal.RenewAttempts += uint64(proposals)
+	return dm.api.DealPut(deal)
+}
+
+// handOver passes the renew policy of a failed deal on to the deal replacing it, if any,
+// found after the given number of proposals. If the failed deal renews another, that deal
+// counts the proposals and is linked to the replacement instead, or unlinked if there is none
+// so that it may be renewed again.
+func (dm *DealManager) handOver(ctx context.Context, failedCid cid.Cid, replacement *storagedeal.Response, proposals int) error {
+	dm.dealsLk.Lock()
+	defer dm.dealsLk.Unlock()
+
+	failed, err := dm.api.DealGet(ctx, failedCid)
+	if err != nil {
+		return err
+	}
+
+	if replacement != nil {
+		deal, err := dm.api.DealGet(ctx, replacement.ProposalCid)
+		if err != nil {
+			return err
+		}
+		deal.Renew = failed.Renew
+		deal.Renews = failed.Renews
+		if err := dm.api.DealPut(deal); err != nil {
+			return err
+		}
+	}
+
+	if failed.Renews == nil {
+		return nil
+	}
+	renewed, err := dm.api.DealGet(ctx, *failed.Renews)
+	if err != nil {
+		return err
+	}
+	if renewed.RenewedBy == nil || !renewed.RenewedBy.Equals(failedCid) {
+		return nil
+	}
+	renewed.RenewedBy = nil
+	renewed.RenewAttempts += uint64(proposals)
+	if replacement != nil {
+		renewed.RenewedBy = &replacement.ProposalCid
+	}
+	return dm.api.DealPut(renewed)
+}
+
+// renewDeals renews, every block, the deals with a renew policy that end soon.
+func (dm *DealManager) renewDeals() {
+	ticker := time.NewTicker(dm.api.BlockTime())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-dm.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := dm.renewEndingDeals(dm.ctx); err != nil {
+			log.Warningf("failed to renew deals: %s", err)
+		}
+	}
+}
+
+func (dm *DealManager) renewEndingDeals(ctx context.Context) error {
+	height, err := dm.api.ChainBlockHeight()
+	if err != nil {
+		return err
+	}
+
+	deals, err := dm.api.DealsLs(ctx)
+	if err != nil {
+		return err
+	}
+
+	var ending []*storagedeal.Deal
+	for result := range deals {
+		if result.Err != nil {
+			return result.Err
+		}
+		d := result.Deal
+		if d.Renew == nil || d.RenewedBy != nil || d.Response == nil || d.Response.State != storagedeal.Complete {
+			continue
+		}
+		end := d.Proposal.EndHeight()
+		if end == nil || height.Add(types.NewBlockHeight(d.Renew.Before)).LessThan(end) {
+			continue
+		}
+		ending = append(ending, d)
+	}
+
+	for _, d := range ending {
+		if d.RenewAttempts > d.Renew.Retries {
+			// each attempt funds a payment channel, so don't try forever
+			continue
+		}
+		retry := RetryPolicy{Retries: uint(d.Renew.Retries - d.RenewAttempts), MaxPrice: d.Renew.MaxPrice}
+		resp, err := dm.RenewDeal(ctx, d.Response.ProposalCid, d.Renew.Duration, retry)
+		if err != nil {
+			log.Warningf("could not renew deal %s: %s", d.Response.ProposalCid, err)
+			continue
+		}
+		log.Infof("renewed deal %s with %s", d.Response.ProposalCid, resp.ProposalCid)
+	}
+	return nil
+}
+
+// cheapestAsk returns the cheapest ask of miner that hasn't expired.
+func (dm *DealManager) cheapestAsk(ctx context.Context, miner address.Address) (*porcelain.Ask, error) {
+	height, err := dm.api.ChainBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	var best *porcelain.Ask
+	for ask := range dm.api.ClientListAsks(ctx) {
+		if ask.Error != nil {
+			return nil, ask.Error
+		}
+		if ask.Miner != miner || (ask.Expiry != nil && ask.Expiry.LessEqual(height)) {
+			continue
+		}
+		if best == nil || ask.Price.LessThan(best.Price) {
+			a := ask
+			best = &a
+		}
+	}
+	if best == nil {
+		return nil, errors.Errorf("miner %s has no ask", miner)
+	}
+	return best, nil
+}
@@ -0,0 +1,259 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/porcelain"
+	. "github.com/filecoin-project/go-filecoin/protocol/storage"
+	"github.com/filecoin-project/go-filecoin/protocol/storage/storagedeal"
+	tf "github.com/filecoin-project/go-filecoin/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/types"
+)
+
+func TestRenewDeal(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	addressCreator := address.NewForTestGetter()
+	minerA, minerB := addressCreator(), addressCreator()
+	newCid := types.NewCidForTestGetter()
+
+	asks := []porcelain.Ask{
+		{Miner: minerA, ID: 0, Price: types.NewAttoFILFromFIL(2), Expiry: types.NewBlockHeight(1000)},
+		{Miner: minerB, ID: 1, Price: types.NewAttoFILFromFIL(3), Expiry: types.NewBlockHeight(1000)},
+	}
+
+	t.Run("proposes a renewal to the deal's miner", func(t *testing.T) {
+		miners := newTestDealMiners(t)
+		testAPI, dm := newTestDealManager(t, miners, asks)
+		defer dm.Stop()
+
+		policy := &storagedeal.RenewPolicy{Duration: 100, Before: 10}
+		proposalCid := newCid()
+		putCompleteDeal(t, testAPI, miners, proposalCid, minerA, policy)
+
+		resp, err := dm.RenewDeal(ctx, proposalCid, 200, RetryPolicy{})
+		require.NoError(t, err)
+		assert.Equal(t, []address.Address{minerA}, miners.proposedTo())
+
+		renewal, err := testAPI.DealGet(ctx, resp.ProposalCid)
+		require.NoError(t, err)
+		require.NotNil(t, renewal.Proposal.RenewalOf)
+		assert.Equal(t, proposalCid, *renewal.Proposal.RenewalOf)
+		assert.Equal(t, uint64(200), renewal.Proposal.Duration)
+		assert.Equal(t, policy, renewal.Renew)
+
+		deal, err := testAPI.DealGet(ctx, proposalCid)
+		require.NoError(t, err)
+		require.NotNil(t, deal.RenewedBy)
+		assert.Equal(t, resp.ProposalCid, *deal.RenewedBy)
+
+		_, err = dm.RenewDeal(ctx, proposalCid, 200, RetryPolicy{})
+		assert.Error(t, err)
+	})
+
+	t.Run("proposes rejected renewals to other miners", func(t *testing.T) {
+		miners := newTestDealMiners(t)
+		miners.rejects[minerA] = true
+		testAPI, dm := newTestDealManager(t, miners, asks)
+		defer dm.Stop()
+
+		proposalCid := newCid()
+		putCompleteDeal(t, testAPI, miners, proposalCid, minerA, nil)
+
+		retry := RetryPolicy{Retries: 1, MaxPrice: types.NewAttoFILFromFIL(5)}
+		resp, err := dm.RenewDeal(ctx, proposalCid, 200, retry)
+		require.NoError(t, err)
+		assert.Equal(t, []address.Address{minerA, minerB}, miners.proposedTo())
+
+		renewal, err := testAPI.DealGet(ctx, resp.ProposalCid)
+		require.NoError(t, err)
+		assert.Equal(t, minerB, renewal.Miner)
+	})
+
+	t.Run("only renews complete deals", func(t *testing.T) {
+		miners := newTestDealMiners(t)
+		testAPI, dm := newTestDealManager(t, miners, asks)
+		defer dm.Stop()
+
+		proposalCid := newCid()
+		require.NoError(t, testAPI.DealPut(&storagedeal.Deal{
+			Miner:    minerA,
+			Proposal: &storagedeal.Proposal{PieceRef: types.SomeCid(), Size: types.NewBytesAmount(10)},
+			Response: &storagedeal.Response{State: storagedeal.Staged, ProposalCid: proposalCid},
+		}))
+
+		_, err := dm.RenewDeal(ctx, proposalCid, 200, RetryPolicy{})
+		assert.Error(t, err)
+		assert.Empty(t, miners.proposedTo())
+	})
+
+	t.Run("keeps a renew policy set while the deal is followed", func(t *testing.T) {
+		miners := newTestDealMiners(t)
+		miners.states[minerA] = []storagedeal.State{storagedeal.Staged, storagedeal.Complete}
+		testAPI, dm := newTestDealManager(t, miners, asks)
+		defer dm.Stop()
+
+		updates, unsubscribe := dm.Subscribe()
+		defer unsubscribe()
+
+		resp, err := dm.ProposeDeal(ctx, minerA, types.SomeCid(), 0, 100, false, false, RetryPolicy{})
+		require.NoError(t, err)
+
+		policy := &storagedeal.RenewPolicy{Duration: 100, Before: 10}
+		require.NoError(t, dm.SetRenewPolicy(ctx, resp.ProposalCid, policy))
+
+		assertNextUpdate(t, updates, resp.ProposalCid, storagedeal.Staged)
+		assertNextUpdate(t, updates, resp.ProposalCid, storagedeal.Complete)
+
+		deal, err := testAPI.DealGet(ctx, resp.ProposalCid)
+		require.NoError(t, err)
+		assert.Equal(t, storagedeal.Complete, deal.Response.State)
+		assert.Equal(t, policy, deal.Renew)
+	})
+
+	t.Run("links the deal to the replacement of a failed renewal", func(t *testing.T) {
+		miners := newTestDealMiners(t)
+		miners.states[minerA] = []storagedeal.State{storagedeal.Failed}
+		testAPI, dm := newTestDealManager(t, miners, asks)
+		defer dm.Stop()
+
+		policy := &storagedeal.RenewPolicy{Duration: 100, Before: 10}
+		proposalCid := newCid()
+		putCompleteDeal(t, testAPI, miners, proposalCid, minerA, policy)
+
+		retry := RetryPolicy{Retries: 1, MaxPrice: types.NewAttoFILFromFIL(5)}
+		resp, err := dm.RenewDeal(ctx, proposalCid, 200, retry)
+		require.NoError(t, err)
+
+		deal := waitForDeal(t, testAPI, proposalCid, func(d *storagedeal.Deal) bool {
+			return d.RenewedBy != nil && !d.RenewedBy.Equals(resp.ProposalCid)
+		})
+		replacement, err := testAPI.DealGet(ctx, *deal.RenewedBy)
+		require.NoError(t, err)
+		assert.Equal(t, minerB, replacement.Miner)
+		assert.Equal(t, policy, replacement.Renew)
+		require.NotNil(t, replacement.Renews)
+		assert.Equal(t, proposalCid, *replacement.Renews)
+	})
+
+	t.Run("unlinks a failed renewal that isn't replaced", func(t *testing.T) {
+		miners := newTestDealMiners(t)
+		miners.states[minerA] = []storagedeal.State{storagedeal.Failed}
+		testAPI, dm := newTestDealManager(t, miners, asks)
+		defer dm.Stop()
+
+		proposalCid := newCid()
+		putCompleteDeal(t, testAPI, miners, proposalCid, minerA, nil)
+
+		_, err := dm.RenewDeal(ctx, proposalCid, 200, RetryPolicy{})
+		require.NoError(t, err)
+
+		waitForDeal(t, testAPI, proposalCid, func(d *storagedeal.Deal) bool {
+			return d.RenewedBy == nil
+		})
+		_, err = dm.RenewDeal(ctx, proposalCid, 200, RetryPolicy{})
+		assert.NoError(t, err)
+	})
+
+	t.Run("stops renewing a deal once its retries are used up", func(t *testing.T) {
+		miners := newTestDealMiners(t)
+		miners.rejects[minerA] = true
+		miners.rejects[minerB] = true
+		testAPI, dm := newTestDealManager(t, miners, asks)
+
+		height, err := testAPI.ChainBlockHeight()
+		require.NoError(t, err)
+
+		proposalCid := newCid()
+		policy := &storagedeal.RenewPolicy{Duration: 100, Before: 10, Retries: 2, MaxPrice: types.NewAttoFILFromFIL(5)}
+		putCompleteDeal(t, testAPI, miners, proposalCid, minerA, policy)
+		setDealEnd(t, testAPI, proposalCid, height.Add(types.NewBlockHeight(5)))
+
+		require.NoError(t, dm.Start(ctx))
+		defer dm.Stop()
+
+		waitForDeal(t, testAPI, proposalCid, func(d *storagedeal.Deal) bool {
+			return d.RenewAttempts == 3
+		})
+		time.Sleep(3 * testAPI.BlockTime())
+		assert.Equal(t, []address.Address{minerA, minerB, minerA}, miners.proposedTo())
+
+		deal, err := testAPI.DealGet(ctx, proposalCid)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(3), deal.RenewAttempts)
+		assert.Nil(t, deal.RenewedBy)
+	})
+
+	t.Run("renews deals with a renew policy when they end soon", func(t *testing.T) {
+		miners := newTestDealMiners(t)
+		testAPI, dm := newTestDealManager(t, miners, asks)
+
+		height, err := testAPI.ChainBlockHeight()
+		require.NoError(t, err)
+
+		soon, later := newCid(), newCid()
+		putCompleteDeal(t, testAPI, miners, soon, minerA, &storagedeal.RenewPolicy{Duration: 100, Before: 10})
+		putCompleteDeal(t, testAPI, miners, later, minerB, &storagedeal.RenewPolicy{Duration: 100, Before: 1})
+		setDealEnd(t, testAPI, soon, height.Add(types.NewBlockHeight(5)))
+		setDealEnd(t, testAPI, later, height.Add(types.NewBlockHeight(5)))
+
+		require.NoError(t, dm.Start(ctx))
+		defer dm.Stop()
+
+		waitForDeal(t, testAPI, soon, func(d *storagedeal.Deal) bool {
+			return d.RenewedBy != nil
+		})
+
+		deal, err := testAPI.DealGet(ctx, later)
+		require.NoError(t, err)
+		assert.Nil(t, deal.RenewedBy)
+	})
+}
+
+// putCompleteDeal stores a complete deal with miner, known to miners.
+func putCompleteDeal(t *testing.T, testAPI *dealManagerTestAPI, miners *testDealMiners, proposalCid cid.Cid, miner address.Address, policy *storagedeal.RenewPolicy) {
+	miners.lk.Lock()
+	miners.deals[proposalCid] = miner
+	miners.lk.Unlock()
+
+	require.NoError(t, testAPI.DealPut(&storagedeal.Deal{
+		Miner:    miner,
+		Proposal: &storagedeal.Proposal{PieceRef: types.SomeCid(), Size: types.NewBytesAmount(10), Duration: 100},
+		Response: &storagedeal.Response{State: storagedeal.Complete, ProposalCid: proposalCid},
+		Renew:    policy,
+	}))
+}
+
+// setDealEnd makes a deal end at height, by giving it a last payment valid then.
+func setDealEnd(t *testing.T, testAPI *dealManagerTestAPI, proposalCid cid.Cid, height *types.BlockHeight) {
+	deal, err := testAPI.DealGet(context.Background(), proposalCid)
+	require.NoError(t, err)
+	proposal := *deal.Proposal
+	proposal.Payment.Vouchers = []*types.PaymentVoucher{{ValidAt: *height}}
+	deal.Proposal = &proposal
+	require.NoError(t, testAPI.DealPut(deal))
+}
+
+// waitForDeal waits for a deal to satisfy cond, and returns it.
+func waitForDeal(t *testing.T, testAPI *dealManagerTestAPI, proposalCid cid.Cid, cond func(*storagedeal.Deal) bool) *storagedeal.Deal {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deal, err := testAPI.DealGet(context.Background(), proposalCid)
+		require.NoError(t, err)
+		if cond(deal) {
+			return deal
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for deal %s", proposalCid)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
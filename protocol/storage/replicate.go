@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/pkg/errors"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/porcelain"
+	"github.com/filecoin-project/go-filecoin/protocol/storage/storagedeal"
+	"github.com/filecoin-project/go-filecoin/types"
+)
+
+// replicaPingTimeout is how long a miner has to answer a ping to be proposed a replica.
+const replicaPingTimeout = 10 * time.Second
+
+// ReplicaStatus is the state of a deal proposed to replicate data.
+type ReplicaStatus struct {
+	Miner address.Address `json:"miner"`
+	// ProposalCid is undefined if the deal could not be proposed.
+	ProposalCid cid.Cid           `json:"proposalCid,omitempty"`
+	State       storagedeal.State `json:"state"`
+	Message     string            `json:"message"`
+}
+
+// Replicate stores data with replicas distinct miners, proposing it to the cheapest reachable
+// miners asking at most maxPrice, and waits until replicas deals are complete, which takes at
+// least as long as sealing the data. When a deal is rejected or fails, the data is proposed to
+// the next miner. If budget is not nil, no deal is proposed that would bring the total price of
+// the deals proposed above it; the payment channel of a deal is funded before its miner
+// answers, so rejected deals count as well as those accepted. It returns the status of every
+// deal proposed, and an error if it runs out of miners or budget before enough deals complete,
+// or if a proposal fails for another reason than its miner.
+func (dm *DealManager) Replicate(ctx context.Context, data cid.Cid, replicas uint, duration uint64, maxPrice types.AttoFIL, budget *types.AttoFIL) ([]*ReplicaStatus, error) {
+	if replicas == 0 {
+		return nil, errors.New("at least one replica is needed")
+	}
+
+	candidates, err := dm.replicaCandidates(ctx, maxPrice)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := dm.api.DAGGetFileSize(ctx, data)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to determine the size of the data")
+	}
+
+	var statuses []*ReplicaStatus
+	// active holds the deals in progress, by proposal cid
+	active := make(map[cid.Cid]*ReplicaStatus)
+	var complete uint
+	spent := types.ZeroAttoFIL
+	var outOfBudget bool
+
+	ticker := time.NewTicker(dm.api.BlockTime())
+	defer ticker.Stop()
+
+	for {
+		for complete+uint(len(active)) < replicas && len(candidates) > 0 {
+			ask := candidates[0]
+			price := ask.Price.MulBigInt(big.NewInt(int64(size * duration)))
+			if budget != nil && budget.LessThan(spent.Add(price)) {
+				// the other candidates ask more
+				candidates = nil
+				outOfBudget = true
+				break
+			}
+
+			status, funded, err := dm.proposeReplica(ctx, data, duration, ask)
+			if err != nil {
+				return statuses, err
+			}
+			candidates = candidates[1:]
+			statuses = append(statuses, status)
+			if funded {
+				spent = spent.Add(price)
+			}
+			if status.ProposalCid.Defined() {
+				active[status.ProposalCid] = status
+			}
+		}
+
+		if complete == replicas {
+			return statuses, nil
+		}
+		if len(active) == 0 {
+			if outOfBudget {
+				return statuses, fmt.Errorf("%d of %d replicas complete and %s of the budget of %s is left, not enough for another deal", complete, replicas, budget.Sub(spent), budget)
+			}
+			return statuses, fmt.Errorf("%d of %d replicas complete and no other reachable miner asks for at most %s", complete, replicas, maxPrice)
+		}
+
+		select {
+		case <-ctx.Done():
+			return statuses, ctx.Err()
+		case <-ticker.C:
+		}
+
+		// the deal manager records the state of the deals as they progress
+		for proposalCid, status := range active {
+			deal, err := dm.api.DealGet(ctx, proposalCid)
+			if err != nil {
+				return statuses, err
+			}
+			status.State = deal.Response.State
+			status.Message = deal.Response.Message
+			if inProgress(status.State) {
+				continue
+			}
+			delete(active, proposalCid)
+			if status.State == storagedeal.Complete {
+				complete++
+			}
+		}
+	}
+}
+
+// proposeReplica proposes data to the miner of ask if the miner is reachable, and returns
+// whether a payment channel was funded for the proposal. It only returns an error if the
+// proposal failed for another reason than its miner.
+func (dm *DealManager) proposeReplica(ctx context.Context, data cid.Cid, duration uint64, ask porcelain.Ask) (*ReplicaStatus, bool, error) {
+	status := &ReplicaStatus{Miner: ask.Miner}
+
+	pid, err := dm.api.MinerGetPeerID(ctx, ask.Miner)
+	if err == nil {
+		err = dm.api.PingMinerWithTimeout(ctx, pid, replicaPingTimeout)
+	}
+	if err != nil {
+		status.State = storagedeal.Failed
+		status.Message = fmt.Sprintf("miner is unreachable: %s", err)
+		return status, false, nil
+	}
+
+	resp, err := dm.ProposeDeal(ctx, ask.Miner, data, ask.ID, duration, false, false, RetryPolicy{})
+	if err != nil {
+		if !isMinerError(err) {
+			return nil, false, err
+		}
+		status.State = storagedeal.Rejected
+		status.Message = err.Error()
+		// the channel is funded before the proposal is sent, the miner was reachable then
+		return status, true, nil
+	}
+
+	status.ProposalCid = resp.ProposalCid
+	status.State = resp.State
+	status.Message = resp.Message
+	return status, true, nil
+}
+
+// replicaCandidates returns the cheapest ask of every miner asking at most maxPrice, cheapest
+// first.
+func (dm *DealManager) replicaCandidates(ctx context.Context, maxPrice types.AttoFIL) ([]porcelain.Ask, error) {
+	height, err := dm.api.ChainBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	cheapest := make(map[address.Address]porcelain.Ask)
+	for ask := range dm.api.ClientListAsks(ctx) {
+		if ask.Error != nil {
+			return nil, ask.Error
+		}
+		if ask.Expiry != nil && ask.Expiry.LessEqual(height) {
+			continue
+		}
+		if ask.Price.GreaterThan(maxPrice) {
+			continue
+		}
+		if best, ok := cheapest[ask.Miner]; !ok || ask.Price.LessThan(best.Price) {
+			cheapest[ask.Miner] = ask
+		}
+	}
+
+	candidates := make([]porcelain.Ask, 0, len(cheapest))
+	for _, ask := range cheapest {
+		candidates = append(candidates, ask)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Price.LessThan(candidates[j].Price)
+	})
+	return candidates, nil
+}
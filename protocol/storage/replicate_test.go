@@ -0,0 +1,136 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/porcelain"
+	"github.com/filecoin-project/go-filecoin/protocol/storage/storagedeal"
+	tf "github.com/filecoin-project/go-filecoin/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/types"
+)
+
+func TestReplicate(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	addressCreator := address.NewForTestGetter()
+	minerA, minerB, minerC := addressCreator(), addressCreator(), addressCreator()
+
+	asks := []porcelain.Ask{
+		{Miner: minerA, ID: 0, Price: types.NewAttoFILFromFIL(2), Expiry: types.NewBlockHeight(1000)},
+		{Miner: minerA, ID: 1, Price: types.NewAttoFILFromFIL(4), Expiry: types.NewBlockHeight(1000)},
+		{Miner: minerB, ID: 2, Price: types.NewAttoFILFromFIL(3), Expiry: types.NewBlockHeight(1000)},
+		{Miner: minerC, ID: 3, Price: types.NewAttoFILFromFIL(1), Expiry: types.NewBlockHeight(1000)},
+	}
+	maxPrice := types.NewAttoFILFromFIL(5)
+	complete := []storagedeal.State{storagedeal.Staged, storagedeal.Complete}
+
+	t.Run("stores data with the cheapest miners", func(t *testing.T) {
+		miners := newTestDealMiners(t)
+		for _, m := range []address.Address{minerA, minerB, minerC} {
+			miners.states[m] = complete
+		}
+		_, dm := newTestDealManager(t, miners, asks)
+		defer dm.Stop()
+
+		statuses, err := dm.Replicate(ctx, types.SomeCid(), 2, 100, maxPrice, nil)
+		require.NoError(t, err)
+
+		require.Len(t, statuses, 2)
+		assert.Equal(t, minerC, statuses[0].Miner)
+		assert.Equal(t, minerA, statuses[1].Miner)
+		for _, status := range statuses {
+			assert.Equal(t, storagedeal.Complete, status.State)
+			assert.True(t, status.ProposalCid.Defined())
+		}
+	})
+
+	t.Run("skips unreachable miners and replaces failed deals", func(t *testing.T) {
+		miners := newTestDealMiners(t)
+		miners.states[minerA] = []storagedeal.State{storagedeal.Failed}
+		miners.states[minerB] = complete
+		testAPI, dm := newTestDealManager(t, miners, asks)
+		testAPI.unreachable[minerC] = true
+		defer dm.Stop()
+
+		statuses, err := dm.Replicate(ctx, types.SomeCid(), 1, 100, maxPrice, nil)
+		require.NoError(t, err)
+
+		require.Len(t, statuses, 3)
+		assert.Equal(t, minerC, statuses[0].Miner)
+		assert.False(t, statuses[0].ProposalCid.Defined())
+		assert.Equal(t, storagedeal.Failed, statuses[1].State)
+		assert.Equal(t, minerB, statuses[2].Miner)
+		assert.Equal(t, storagedeal.Complete, statuses[2].State)
+		assert.Equal(t, []address.Address{minerA, minerB}, miners.proposedTo())
+	})
+
+	t.Run("fails when no miner is left within the price", func(t *testing.T) {
+		miners := newTestDealMiners(t)
+		miners.rejects[minerC] = true
+		_, dm := newTestDealManager(t, miners, asks)
+		defer dm.Stop()
+
+		statuses, err := dm.Replicate(ctx, types.SomeCid(), 1, 100, types.NewAttoFILFromFIL(1), nil)
+		assert.Error(t, err)
+
+		require.Len(t, statuses, 1)
+		assert.Equal(t, storagedeal.Rejected, statuses[0].State)
+		assert.Equal(t, []address.Address{minerC}, miners.proposedTo())
+	})
+
+	t.Run("stops proposing when the budget is used up", func(t *testing.T) {
+		miners := newTestDealMiners(t)
+		for _, m := range []address.Address{minerA, minerB, minerC} {
+			miners.states[m] = complete
+		}
+		_, dm := newTestDealManager(t, miners, asks)
+		defer dm.Stop()
+
+		// enough for minerC's deal at 1 FIL per byte per block, but not minerA's at 2 as well
+		budget := types.NewAttoFILFromFIL(3 * 1016 * 100).Sub(types.NewAttoFILFromFIL(1))
+		statuses, err := dm.Replicate(ctx, types.SomeCid(), 2, 100, maxPrice, &budget)
+		assert.Error(t, err)
+
+		require.Len(t, statuses, 1)
+		assert.Equal(t, storagedeal.Complete, statuses[0].State)
+		assert.Equal(t, []address.Address{minerC}, miners.proposedTo())
+	})
+
+	t.Run("counts rejected proposals against the budget", func(t *testing.T) {
+		miners := newTestDealMiners(t)
+		miners.rejects[minerC] = true
+		miners.states[minerA] = complete
+		_, dm := newTestDealManager(t, miners, asks)
+		defer dm.Stop()
+
+		// enough for minerA's deal at 2 FIL per byte per block, but not after funding minerC's at 1
+		budget := types.NewAttoFILFromFIL(3 * 1016 * 100).Sub(types.NewAttoFILFromFIL(1))
+		statuses, err := dm.Replicate(ctx, types.SomeCid(), 1, 100, maxPrice, &budget)
+		assert.Error(t, err)
+
+		require.Len(t, statuses, 1)
+		assert.Equal(t, storagedeal.Rejected, statuses[0].State)
+		assert.Equal(t, []address.Address{minerC}, miners.proposedTo())
+	})
+
+	t.Run("stops at a proposal failing for another reason than its miner", func(t *testing.T) {
+		miners := newTestDealMiners(t)
+		testAPI, dm := newTestDealManager(t, miners, asks)
+		testAPI.paymentsErr = errors.New("not enough funds")
+		defer dm.Stop()
+
+		statuses, err := dm.Replicate(ctx, types.SomeCid(), 1, 100, maxPrice, nil)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "not enough funds")
+
+		assert.Empty(t, statuses)
+		assert.Empty(t, miners.proposedTo())
+	})
+}
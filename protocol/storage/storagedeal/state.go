@@ -2,6 +2,8 @@ package storagedeal
 
 import (
 	"fmt"
+
+	"github.com/pkg/errors"
 )
 
 // State signifies the state of a deal
@@ -55,3 +57,13 @@ func (s State) String() string {
 		return fmt.Sprintf("<unrecognized %d>", s)
 	}
 }
+
+// ParseState returns the state named s, as returned by String.
+func ParseState(s string) (State, error) {
+	for state := Unset; state <= Complete; state++ {
+		if state.String() == s {
+			return state, nil
+		}
+	}
+	return Unset, errors.Errorf("unknown deal state %q", s)
+}
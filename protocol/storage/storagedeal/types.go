@@ -1,6 +1,8 @@
 package storagedeal
 
 import (
+	"time"
+
 	"github.com/ipfs/go-cid"
 	cbor "github.com/ipfs/go-ipld-cbor"
 
@@ -16,6 +18,8 @@ func init() {
 	cbor.RegisterCborType(ProofInfo{})
 	cbor.RegisterCborType(QueryRequest{})
 	cbor.RegisterCborType(Deal{})
+	cbor.RegisterCborType(StateChange{})
+	cbor.RegisterCborType(RenewPolicy{})
 }
 
 // PaymentInfo contains all the payment related information for a storage deal.
@@ -61,6 +65,26 @@ type Proposal struct {
 	// will use to pay the miner. It should be verifiable by the
 	// miner using on-chain information.
 	Payment PaymentInfo
+
+	// OfflineTransfer is set when the client sends the data out of band, so the miner waits
+	// for it to be imported rather than fetching it.
+	OfflineTransfer bool
+
+	// RenewalOf is the proposal cid of the deal this one renews, if any. A miner still
+	// holding the piece of that deal in a sector completes the renewal without sealing again.
+	RenewalOf *cid.Cid
+}
+
+// EndHeight returns the block height at which the deal ends, which is when its last
+// payment becomes valid, or nil if the deal has no payments.
+func (dp *Proposal) EndHeight() *types.BlockHeight {
+	var end *types.BlockHeight
+	for _, v := range dp.Payment.Vouchers {
+		if end == nil || v.ValidAt.GreaterThan(end) {
+			end = &v.ValidAt
+		}
+	}
+	return end
 }
 
 // Unmarshal a Proposal from bytes.
@@ -121,6 +145,62 @@ type Deal struct {
 	Miner    address.Address
 	Proposal *Proposal
 	Response *Response
+	// History records each state the deal entered, oldest first.
+	History []StateChange
+	// Renew is the client's policy to renew the deal before it ends, if any.
+	Renew *RenewPolicy
+	// RenewedBy is the proposal cid of the deal that renewed this one, if any.
+	RenewedBy *cid.Cid
+	// Renews is the proposal cid of the deal this one renews, if any.
+	Renews *cid.Cid
+	// RenewAttempts is the number of proposals made to renew the deal, each of which funds a
+	// payment channel.
+	RenewAttempts uint64
+}
+
+// RenewPolicy tells a client to renew a deal before it ends.
+type RenewPolicy struct {
+	// Duration is the number of blocks to renew the deal for.
+	Duration uint64
+	// Before is the number of blocks before the deal ends to renew it.
+	Before uint64
+	// Retries is the number of other miners the deal may be proposed to if its miner
+	// rejects the renewal. Failed renewals count against it, so that at most Retries+1
+	// renewals are proposed.
+	Retries uint64
+	// MaxPrice is the highest ask price, per byte per block, to propose the deal to other
+	// miners at.
+	MaxPrice types.AttoFIL
+}
+
+// StateChange records a deal entering a state.
+type StateChange struct {
+	State   State
+	Message string
+	// Time is when the deal entered the state, in seconds since the Unix epoch.
+	Time int64
+}
+
+// RecordState appends the current state of the deal's response to its history, if it
+// changed since the last record.
+func (d *Deal) RecordState(now time.Time) {
+	if n := len(d.History); n > 0 && d.History[n-1].State == d.Response.State && d.History[n-1].Message == d.Response.Message {
+		return
+	}
+	d.History = append(d.History, StateChange{
+		State:   d.Response.State,
+		Message: d.Response.Message,
+		Time:    now.Unix(),
+	})
+}
+
+// CreatedAt returns when the deal entered its first recorded state, or the zero time if
+// its history is empty.
+func (d *Deal) CreatedAt() time.Time {
+	if len(d.History) == 0 {
+		return time.Time{}
+	}
+	return time.Unix(d.History[0].Time, 0)
 }
 
 // ProofInfo contains the details about a seal proof, that the client needs to know to verify that his deal was posted on chain.
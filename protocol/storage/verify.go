@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/filecoin-project/go-filecoin/porcelain"
+	"github.com/filecoin-project/go-filecoin/protocol/storage/storagedeal"
+)
+
+// verifyDealsInterval is how many blocks apart the deals are verified.
+const verifyDealsInterval = 100
+
+// verifyDeals verifies, every verifyDealsInterval blocks, that the data of the client's
+// complete deals is still proven.
+func (dm *DealManager) verifyDeals() {
+	ticker := time.NewTicker(verifyDealsInterval * dm.api.BlockTime())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-dm.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := dm.VerifyCompleteDeals(dm.ctx); err != nil {
+			log.Warningf("failed to verify deals: %s", err)
+		}
+	}
+}
+
+// VerifyCompleteDeals verifies that the data of the client's complete deals is still proven,
+// and alerts the subscribers to deal updates of the deals whose verdict got worse.
+func (dm *DealManager) VerifyCompleteDeals(ctx context.Context) ([]*porcelain.DealHealth, error) {
+	minerAddr, err := dm.api.ConfigGet("mining.minerAddress")
+	if err != nil {
+		return nil, err
+	}
+
+	deals, err := dm.api.DealsLs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var complete []cid.Cid
+	for result := range deals {
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		d := result.Deal
+		// deals made with the node's own miner are the miner's to prove
+		if d.Miner == minerAddr || d.Response == nil || d.Response.State != storagedeal.Complete || d.Response.ProofInfo == nil {
+			continue
+		}
+		complete = append(complete, d.Response.ProposalCid)
+	}
+
+	var healths []*porcelain.DealHealth
+	for _, proposalCid := range complete {
+		health, err := dm.api.ClientVerifyDeal(ctx, proposalCid)
+		if err != nil {
+			log.Warningf("could not verify deal %s: %s", proposalCid, err)
+			continue
+		}
+		dm.alert(health)
+		healths = append(healths, health)
+	}
+	return healths, nil
+}
+
+// alert publishes the verdict of a deal if it is worse than the previous one.
+func (dm *DealManager) alert(health *porcelain.DealHealth) {
+	dm.lk.Lock()
+	previous := dm.verdicts[health.ProposalCid]
+	dm.verdicts[health.ProposalCid] = health.Verdict
+	dm.lk.Unlock()
+
+	if severity(health.Verdict) <= severity(previous) {
+		return
+	}
+
+	message := string(health.Verdict) + ": " + strings.Join(health.Problems, "; ")
+	log.Warningf("deal %s with %s is %s", health.ProposalCid, health.Miner, message)
+	dm.publish(DealUpdate{
+		ProposalCid: health.ProposalCid,
+		Miner:       health.Miner,
+		State:       storagedeal.Complete,
+		Message:     message,
+	})
+}
+
+func severity(verdict porcelain.DealVerdict) int {
+	switch verdict {
+	case porcelain.DealAtRisk:
+		return 1
+	case porcelain.DealLost:
+		return 2
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,82 @@
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/porcelain"
+	"github.com/filecoin-project/go-filecoin/protocol/storage/storagedeal"
+	tf "github.com/filecoin-project/go-filecoin/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/types"
+)
+
+func TestVerifyCompleteDeals(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+	miner := address.NewForTestGetter()()
+	newCid := types.NewCidForTestGetter()
+
+	miners := newTestDealMiners(t)
+	testAPI, dm := newTestDealManager(t, miners, nil)
+	defer dm.Stop()
+
+	healthy, risky := newCid(), newCid()
+	for _, proposalCid := range []cid.Cid{healthy, risky} {
+		require.NoError(t, testAPI.DealPut(&storagedeal.Deal{
+			Miner:    miner,
+			Proposal: &storagedeal.Proposal{PieceRef: types.SomeCid()},
+			Response: &storagedeal.Response{
+				State:       storagedeal.Complete,
+				ProposalCid: proposalCid,
+				ProofInfo:   &storagedeal.ProofInfo{SectorID: 1},
+			},
+		}))
+	}
+	// deals without proof info can't be verified
+	require.NoError(t, testAPI.DealPut(&storagedeal.Deal{
+		Miner:    miner,
+		Proposal: &storagedeal.Proposal{PieceRef: types.SomeCid()},
+		Response: &storagedeal.Response{State: storagedeal.Complete, ProposalCid: newCid()},
+	}))
+
+	updates, unsubscribe := dm.Subscribe()
+	defer unsubscribe()
+
+	testAPI.health[risky] = &porcelain.DealHealth{
+		ProposalCid: risky,
+		Miner:       miner,
+		Verdict:     porcelain.DealAtRisk,
+		Problems:    []string{"miner's PoSt is 5 blocks late"},
+	}
+
+	healths, err := dm.VerifyCompleteDeals(ctx)
+	require.NoError(t, err)
+	assert.Len(t, healths, 2)
+
+	update := <-updates
+	assert.Equal(t, risky, update.ProposalCid)
+	assert.Equal(t, "at-risk: miner's PoSt is 5 blocks late", update.Message)
+
+	// a deal is alerted on again only when its verdict gets worse
+	_, err = dm.VerifyCompleteDeals(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, updates)
+
+	testAPI.health[risky] = &porcelain.DealHealth{
+		ProposalCid: risky,
+		Miner:       miner,
+		Verdict:     porcelain.DealLost,
+		Problems:    []string{"sector 1 is not committed"},
+	}
+	_, err = dm.VerifyCompleteDeals(ctx)
+	require.NoError(t, err)
+
+	update = <-updates
+	assert.Equal(t, "lost: sector 1 is not committed", update.Message)
+}
@@ -0,0 +1,484 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"sort"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/pkg/errors"
+
+	"github.com/filecoin-project/go-filecoin/actor/builtin/paymentbroker"
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/config"
+	"github.com/filecoin-project/go-filecoin/plumbing/strgdls"
+	"github.com/filecoin-project/go-filecoin/protocol/storage/storagedeal"
+	"github.com/filecoin-project/go-filecoin/types"
+)
+
+// VoucherState is the state of a payment voucher of a storage deal.
+type VoucherState string
+
+const (
+	// VoucherPending is a voucher not redeemed yet, whether it is valid yet or not.
+	VoucherPending = VoucherState("pending")
+	// VoucherRedeeming is a voucher whose redeem message isn't mined yet.
+	VoucherRedeeming = VoucherState("redeeming")
+	// VoucherRedeemed is a voucher redeemed by itself or with a later voucher of its deal.
+	VoucherRedeemed = VoucherState("redeemed")
+	// VoucherExpired is a voucher not redeemed before its payment channel's eol.
+	VoucherExpired = VoucherState("expired")
+)
+
+// VoucherStatus is the state of a payment voucher of a miner's deal.
+type VoucherStatus struct {
+	ProposalCid cid.Cid `json:"proposalCid"`
+	// Amount is the total paid for the deal up to the voucher, as vouchers are cumulative.
+	Amount  types.AttoFIL      `json:"amount"`
+	ValidAt *types.BlockHeight `json:"validAt"`
+	State   VoucherState       `json:"state"`
+}
+
+const (
+	redeemsDatastorePrefix = "redeems"
+	settledDatastorePrefix = "settledPayments"
+)
+
+// redeemMessage is a redeem message of a deal's voucher that isn't mined yet. They are
+// persisted so that a restarted miner neither redeems the voucher again nor forgets the
+// message.
+type redeemMessage struct {
+	ProposalCid cid.Cid       `json:"proposalCid"`
+	Amount      types.AttoFIL `json:"amount"`
+	MsgCid      cid.Cid       `json:"msgCid"`
+}
+
+// settledPayment is the final state of the payment channel of a complete deal, recorded once
+// nothing more can be redeemed from it so that the channel isn't looked up again.
+type settledPayment struct {
+	AmountRedeemed types.AttoFIL      `json:"amountRedeemed"`
+	Eol            *types.BlockHeight `json:"eol"`
+}
+
+// dealPayment is a complete deal and its payment channel.
+type dealPayment struct {
+	deal    *storagedeal.Deal
+	channel *paymentbroker.PaymentChannel
+}
+
+// redemption is the redemption of the latest valid voucher of a deal, which redeems the
+// earlier vouchers with it.
+type redemption struct {
+	dp      *dealPayment
+	voucher *types.PaymentVoucher
+	// gain is the amount the redemption adds to what was redeemed from the channel.
+	gain types.AttoFIL
+	// urgent is whether the channel's eol is near.
+	urgent bool
+}
+
+// Vouchers returns the state of the payment vouchers of the miner's complete deals.
+func (sm *Miner) Vouchers(ctx context.Context) ([]*VoucherStatus, error) {
+	height, err := sm.porcelainAPI.ChainBlockHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	payments, err := sm.completeDealPayments(ctx, height)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []*VoucherStatus
+	for _, dp := range payments {
+		for _, v := range dp.deal.Proposal.Payment.Vouchers {
+			statuses = append(statuses, &VoucherStatus{
+				ProposalCid: dp.deal.Response.ProposalCid,
+				Amount:      v.Amount,
+				ValidAt:     &v.ValidAt,
+				State:       sm.voucherState(dp, v, height),
+			})
+		}
+	}
+	return statuses, nil
+}
+
+func (sm *Miner) voucherState(dp *dealPayment, v *types.PaymentVoucher, height *types.BlockHeight) VoucherState {
+	if v.Amount.LessEqual(dp.channel.AmountRedeemed) {
+		return VoucherRedeemed
+	}
+	if msg := sm.redeemMessage(dp.deal.Response.ProposalCid); msg != nil && v.Amount.LessEqual(msg.Amount) {
+		return VoucherRedeeming
+	}
+	if height.GreaterEqual(dp.channel.Eol) {
+		return VoucherExpired
+	}
+	return VoucherPending
+}
+
+// notifyRedeemer wakes the goroutine redeeming vouchers (see RunRedeemer). It never blocks.
+func (sm *Miner) notifyRedeemer() {
+	select {
+	case sm.redeemCh <- struct{}{}:
+	default:
+	}
+}
+
+// RunRedeemer redeems vouchers each time the miner is notified of a new head, until ctx is
+// done. It first resumes waiting for the redeem messages sent before the miner restarted.
+// Redeeming runs apart from the head handler so that neither waits for the other.
+func (sm *Miner) RunRedeemer(ctx context.Context) {
+	sm.redeemingLk.Lock()
+	for _, msg := range sm.redeeming {
+		go sm.waitForRedeem(ctx, msg)
+	}
+	sm.redeemingLk.Unlock()
+
+	for {
+		select {
+		case <-sm.redeemCh:
+			if err := sm.redeemVouchers(ctx); err != nil {
+				log.Errorf("failed to redeem vouchers: %s", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// redeemVouchers redeems the latest valid voucher of each complete deal, in as many messages
+// as the gas budget allows, the deals whose channel ends soonest first. Vouchers worth less
+// than the gas of redeeming them wait to be redeemed with later vouchers, unless they are
+// the deal's last or their channel ends soon.
+func (sm *Miner) redeemVouchers(ctx context.Context) error {
+	policy, err := sm.getVoucherPolicy()
+	if err != nil {
+		return err
+	}
+	if !policy.AutoRedeem {
+		return nil
+	}
+
+	height, err := sm.porcelainAPI.ChainBlockHeight()
+	if err != nil {
+		return err
+	}
+
+	payments, err := sm.completeDealPayments(ctx, height)
+	if err != nil {
+		return err
+	}
+
+	gasCost := policy.GasPrice.MulBigInt(big.NewInt(int64(policy.GasLimit)))
+	var redemptions []*redemption
+	for _, dp := range payments {
+		r := sm.nextRedemption(dp, height, policy.EolMargin)
+		if r == nil {
+			continue
+		}
+		if r.gain.LessThan(gasCost) && !r.urgent && !isLastVoucher(dp.deal, r.voucher) {
+			continue
+		}
+		redemptions = append(redemptions, r)
+	}
+
+	sort.Slice(redemptions, func(i, j int) bool {
+		ri, rj := redemptions[i], redemptions[j]
+		if ri.urgent != rj.urgent {
+			return ri.urgent
+		}
+		if ri.urgent {
+			return ri.dp.channel.Eol.LessThan(rj.dp.channel.Eol)
+		}
+		return ri.gain.GreaterThan(rj.gain)
+	})
+
+	var gasUsed types.GasUnits
+	for _, r := range redemptions {
+		if policy.MaxGasPerBlock > 0 && gasUsed+policy.GasLimit > policy.MaxGasPerBlock {
+			break
+		}
+		if err := sm.redeem(ctx, r, policy); err != nil {
+			log.Errorf("failed to redeem voucher of deal %s: %s", r.dp.deal.Response.ProposalCid, err)
+			continue
+		}
+		gasUsed += policy.GasLimit
+	}
+	return nil
+}
+
+// nextRedemption returns the redemption of the latest valid voucher of a deal not redeemed
+// yet, or nil if there is none.
+func (sm *Miner) nextRedemption(dp *dealPayment, height *types.BlockHeight, eolMargin uint64) *redemption {
+	if height.GreaterEqual(dp.channel.Eol) || sm.redeemMessage(dp.deal.Response.ProposalCid) != nil {
+		return nil
+	}
+
+	var latest *types.PaymentVoucher
+	for _, v := range dp.deal.Proposal.Payment.Vouchers {
+		if height.LessThan(&v.ValidAt) || v.Amount.LessEqual(dp.channel.AmountRedeemed) {
+			continue
+		}
+		if latest == nil || v.Amount.GreaterThan(latest.Amount) {
+			latest = v
+		}
+	}
+	if latest == nil {
+		return nil
+	}
+
+	return &redemption{
+		dp:      dp,
+		voucher: latest,
+		gain:    latest.Amount.Sub(dp.channel.AmountRedeemed),
+		urgent:  height.Add(types.NewBlockHeight(eolMargin)).GreaterEqual(dp.channel.Eol),
+	}
+}
+
+// redeem sends the message redeeming a voucher, and forgets it once it is mined.
+func (sm *Miner) redeem(ctx context.Context, r *redemption, policy *config.VoucherConfig) error {
+	v := r.voucher
+	msgCid, err := sm.porcelainAPI.MessageSend(
+		ctx,
+		sm.minerOwnerAddr,
+		address.PaymentBrokerAddress,
+		types.ZeroAttoFIL,
+		policy.GasPrice,
+		policy.GasLimit,
+		"redeem",
+		v.Payer,
+		&v.Channel,
+		v.Amount,
+		&v.ValidAt,
+		v.Condition,
+		[]byte(v.Signature),
+		[]interface{}{},
+	)
+	if err != nil {
+		return err
+	}
+
+	msg := &redeemMessage{ProposalCid: r.dp.deal.Response.ProposalCid, Amount: v.Amount, MsgCid: msgCid}
+	sm.redeemingLk.Lock()
+	if sm.redeeming == nil {
+		sm.redeeming = make(map[cid.Cid]*redeemMessage)
+	}
+	sm.redeeming[msg.ProposalCid] = msg
+	if err := sm.saveRedeems(); err != nil {
+		log.Errorf("failed to save redeem messages: %s", err)
+	}
+	sm.redeemingLk.Unlock()
+
+	go sm.waitForRedeem(ctx, msg)
+	return nil
+}
+
+// waitForRedeem waits for a redeem message to be mined and forgets it. The message is kept
+// if ctx is done first, to be waited for again when the miner restarts.
+func (sm *Miner) waitForRedeem(ctx context.Context, msg *redeemMessage) {
+	err := sm.porcelainAPI.MessageWait(ctx, msg.MsgCid, func(blk *types.Block, smsg *types.SignedMessage, receipt *types.MessageReceipt) error {
+		if receipt.ExitCode != 0 {
+			return errors.Errorf("redeem message %s failed with exit code %d", msg.MsgCid, receipt.ExitCode)
+		}
+		return nil
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		log.Errorf("failed to redeem voucher of deal %s: %s", msg.ProposalCid, err)
+	}
+
+	sm.redeemingLk.Lock()
+	defer sm.redeemingLk.Unlock()
+	delete(sm.redeeming, msg.ProposalCid)
+	if err := sm.saveRedeems(); err != nil {
+		log.Errorf("failed to save redeem messages: %s", err)
+	}
+}
+
+func (sm *Miner) redeemMessage(proposalCid cid.Cid) *redeemMessage {
+	sm.redeemingLk.Lock()
+	defer sm.redeemingLk.Unlock()
+	return sm.redeeming[proposalCid]
+}
+
+// completeDealPayments returns the miner's complete deals with vouchers, with their payment
+// channels. Channels are only looked up until they settle, when their eol passes or all of
+// the deal is redeemed. A channel reclaimed or closed before the miner saw it settle is taken
+// as having redeemed nothing and ended.
+func (sm *Miner) completeDealPayments(ctx context.Context, height *types.BlockHeight) ([]*dealPayment, error) {
+	complete, err := sm.porcelainAPI.DealsQuery(strgdls.Query{
+		States: []storagedeal.State{storagedeal.Complete},
+		Miner:  sm.minerAddr,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	channelsByPayer := make(map[address.Address]map[string]*paymentbroker.PaymentChannel)
+	var payments []*dealPayment
+	for _, deal := range complete {
+		if len(deal.Proposal.Payment.Vouchers) == 0 {
+			continue
+		}
+
+		proposalCid := deal.Response.ProposalCid
+		if settled := sm.settledPayment(proposalCid); settled != nil {
+			channel := &paymentbroker.PaymentChannel{AmountRedeemed: settled.AmountRedeemed, Eol: settled.Eol}
+			payments = append(payments, &dealPayment{deal: deal, channel: channel})
+			continue
+		}
+
+		payer := deal.Proposal.Payment.Payer
+		channels, ok := channelsByPayer[payer]
+		if !ok {
+			channels, err = sm.paymentChannels(ctx, payer)
+			if err != nil {
+				log.Errorf("could not get payment channel of deal %s: %s", proposalCid, err)
+				continue
+			}
+			channelsByPayer[payer] = channels
+		}
+
+		channel, ok := channels[deal.Proposal.Payment.Channel.KeyString()]
+		if !ok {
+			channel = &paymentbroker.PaymentChannel{AmountRedeemed: types.ZeroAttoFIL, Eol: types.NewBlockHeight(0)}
+		}
+		if !ok || height.GreaterEqual(channel.Eol) || dealAmount(deal).LessEqual(channel.AmountRedeemed) {
+			if err := sm.settle(proposalCid, channel); err != nil {
+				log.Errorf("failed to record settled payment of deal %s: %s", proposalCid, err)
+			}
+		}
+		payments = append(payments, &dealPayment{deal: deal, channel: channel})
+	}
+	return payments, nil
+}
+
+func (sm *Miner) settledPayment(proposalCid cid.Cid) *settledPayment {
+	sm.redeemingLk.Lock()
+	defer sm.redeemingLk.Unlock()
+	return sm.settled[proposalCid]
+}
+
+// settle records the final state of a deal's payment channel.
+func (sm *Miner) settle(proposalCid cid.Cid, channel *paymentbroker.PaymentChannel) error {
+	settled := &settledPayment{AmountRedeemed: channel.AmountRedeemed, Eol: channel.Eol}
+	marshalled, err := json.Marshal(settled)
+	if err != nil {
+		return errors.Wrap(err, "could not marshal settled payment")
+	}
+	if err := sm.redeemsDs.Put(datastore.KeyWithNamespaces([]string{settledDatastorePrefix, proposalCid.String()}), marshalled); err != nil {
+		return errors.Wrap(err, "could not save settled payment to disk")
+	}
+
+	sm.redeemingLk.Lock()
+	defer sm.redeemingLk.Unlock()
+	if sm.settled == nil {
+		sm.settled = make(map[cid.Cid]*settledPayment)
+	}
+	sm.settled[proposalCid] = settled
+	return nil
+}
+
+func (sm *Miner) getVoucherPolicy() (*config.VoucherConfig, error) {
+	policy, err := sm.porcelainAPI.ConfigGet("vouchers")
+	if err != nil {
+		return nil, err
+	}
+	policyConfig, ok := policy.(*config.VoucherConfig)
+	if !ok || policyConfig == nil {
+		return nil, errors.New("Could not retrieve vouchers from config")
+	}
+	return policyConfig, nil
+}
+
+// loadRedeems reads the redeem messages not mined when the miner last ran, and the settled
+// payments.
+func (sm *Miner) loadRedeems() error {
+	if err := sm.loadSettled(); err != nil {
+		return err
+	}
+
+	sm.redeeming = make(map[cid.Cid]*redeemMessage)
+
+	result, err := sm.redeemsDs.Get(datastore.KeyWithNamespaces([]string{redeemsDatastorePrefix}))
+	if err == datastore.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "failed to read redeem messages")
+	}
+
+	var msgs []*redeemMessage
+	if err := json.Unmarshal(result, &msgs); err != nil {
+		return errors.Wrap(err, "failed to unmarshal redeem messages from datastore")
+	}
+	for _, msg := range msgs {
+		sm.redeeming[msg.ProposalCid] = msg
+	}
+	return nil
+}
+
+func (sm *Miner) loadSettled() error {
+	sm.settled = make(map[cid.Cid]*settledPayment)
+
+	results, err := sm.redeemsDs.Query(query.Query{Prefix: "/" + settledDatastorePrefix})
+	if err != nil {
+		return errors.Wrap(err, "failed to query settled payments")
+	}
+	for entry := range results.Next() {
+		if entry.Error != nil {
+			return errors.Wrap(entry.Error, "failed to read settled payment")
+		}
+		proposalCid, err := cid.Decode(datastore.RawKey(entry.Key).BaseNamespace())
+		if err != nil {
+			return errors.Wrapf(err, "invalid settled payment key %s", entry.Key)
+		}
+		var settled settledPayment
+		if err := json.Unmarshal(entry.Value, &settled); err != nil {
+			return errors.Wrap(err, "failed to unmarshal settled payment from datastore")
+		}
+		sm.settled[proposalCid] = &settled
+	}
+	return nil
+}
+
+// saveRedeems persists the redeem messages not mined yet. redeemingLk must be held.
+func (sm *Miner) saveRedeems() error {
+	msgs := make([]*redeemMessage, 0, len(sm.redeeming))
+	for _, msg := range sm.redeeming {
+		msgs = append(msgs, msg)
+	}
+	marshalled, err := json.Marshal(msgs)
+	if err != nil {
+		return errors.Wrap(err, "could not marshal redeem messages")
+	}
+	if err := sm.redeemsDs.Put(datastore.KeyWithNamespaces([]string{redeemsDatastorePrefix}), marshalled); err != nil {
+		return errors.Wrap(err, "could not save redeem messages to disk")
+	}
+	return nil
+}
+
+// dealAmount returns the amount of the voucher paying for the whole of a deal.
+func dealAmount(deal *storagedeal.Deal) types.AttoFIL {
+	amount := types.ZeroAttoFIL
+	for _, v := range deal.Proposal.Payment.Vouchers {
+		if v.Amount.GreaterThan(amount) {
+			amount = v.Amount
+		}
+	}
+	return amount
+}
+
+// isLastVoucher returns whether v is the voucher paying for the whole of a deal.
+func isLastVoucher(deal *storagedeal.Deal, v *types.PaymentVoucher) bool {
+	for _, other := range deal.Proposal.Payment.Vouchers {
+		if other.Amount.GreaterThan(v.Amount) {
+			return false
+		}
+	}
+	return true
+}
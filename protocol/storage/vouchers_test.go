@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/protocol/storage/storagedeal"
+	"github.com/filecoin-project/go-filecoin/repo"
+	th "github.com/filecoin-project/go-filecoin/testhelpers"
+	tf "github.com/filecoin-project/go-filecoin/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/types"
+)
+
+func TestRedeemVouchers(t *testing.T) {
+	tf.UnitTest(t)
+
+	ctx := context.Background()
+
+	// setup returns a miner with deals in state whose first three vouchers are valid, and the
+	// amounts of the vouchers redeemed.
+	setup := func(t *testing.T, deals int, state storagedeal.State) (*minerTestPorcelain, *Miner, *[]types.AttoFIL) {
+		porcelainAPI, miner, proposal := defaultMinerTestSetup(t, VoucherInterval, defaultAmountInc)
+		porcelainAPI.blockHeight = porcelainAPI.paymentStart.Add(types.NewBlockHeight(3 * VoucherInterval))
+		miner.redeemsDs = repo.NewInMemoryRepo().DealsDs
+		require.NoError(t, miner.loadRedeems())
+
+		newCid := types.NewCidForTestGetter()
+		for i := 0; i < deals; i++ {
+			require.NoError(t, porcelainAPI.DealPut(&storagedeal.Deal{
+				Miner:    miner.minerAddr,
+				Proposal: &proposal.Proposal,
+				Response: &storagedeal.Response{State: state, ProposalCid: newCid()},
+			}))
+		}
+
+		var redeemed []types.AttoFIL
+		porcelainAPI.messageHandlers["redeem"] = func(a address.Address, v types.AttoFIL, p ...interface{}) ([][]byte, error) {
+			redeemed = append(redeemed, p[2].(types.AttoFIL))
+			return nil, nil
+		}
+		return porcelainAPI, miner, &redeemed
+	}
+
+	t.Run("redeems the latest valid voucher", func(t *testing.T) {
+		_, miner, redeemed := setup(t, 1, storagedeal.Complete)
+
+		require.NoError(t, miner.redeemVouchers(ctx))
+		assert.Equal(t, []types.AttoFIL{types.NewAttoFILFromFIL(3 * defaultAmountInc)}, *redeemed)
+	})
+
+	t.Run("does nothing unless auto redeem is on", func(t *testing.T) {
+		porcelainAPI, miner, redeemed := setup(t, 1, storagedeal.Complete)
+		require.NoError(t, porcelainAPI.config.Set("vouchers.autoRedeem", "false"))
+
+		require.NoError(t, miner.redeemVouchers(ctx))
+		assert.Empty(t, *redeemed)
+	})
+
+	t.Run("waits for vouchers worth more than the gas", func(t *testing.T) {
+		porcelainAPI, miner, redeemed := setup(t, 1, storagedeal.Complete)
+		require.NoError(t, porcelainAPI.config.Set("vouchers.gasPrice", `"1000000"`))
+
+		require.NoError(t, miner.redeemVouchers(ctx))
+		assert.Empty(t, *redeemed)
+
+		// unless the channel ends soon
+		porcelainAPI.channelEol = porcelainAPI.blockHeight.Add(types.NewBlockHeight(10))
+		require.NoError(t, miner.redeemVouchers(ctx))
+		assert.Len(t, *redeemed, 1)
+	})
+
+	t.Run("respects the gas budget", func(t *testing.T) {
+		porcelainAPI, miner, redeemed := setup(t, 3, storagedeal.Complete)
+		require.NoError(t, porcelainAPI.config.Set("vouchers.maxGasPerBlock", `"600"`))
+
+		require.NoError(t, miner.redeemVouchers(ctx))
+		assert.Len(t, *redeemed, 2)
+	})
+
+	t.Run("remembers redeem messages not mined across restarts", func(t *testing.T) {
+		porcelainAPI, miner, redeemed := setup(t, 1, storagedeal.Complete)
+		porcelainAPI.blockMessageWait = true
+
+		redeemCtx, cancel := context.WithCancel(ctx)
+		require.NoError(t, miner.redeemVouchers(redeemCtx))
+		require.Len(t, *redeemed, 1)
+		cancel()
+
+		restarted := newTestMiner(porcelainAPI)
+		restarted.redeemsDs = miner.redeemsDs
+		require.NoError(t, restarted.loadRedeems())
+
+		require.NoError(t, restarted.redeemVouchers(ctx))
+		assert.Len(t, *redeemed, 1)
+
+		statuses, err := restarted.Vouchers(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, VoucherRedeeming, statuses[2].State)
+	})
+
+	t.Run("redeems in the background when notified", func(t *testing.T) {
+		porcelainAPI, miner, _ := setup(t, 1, storagedeal.Complete)
+		miner.redeemCh = make(chan struct{}, 1)
+		var redeemedCount int32
+		porcelainAPI.messageHandlers["redeem"] = func(a address.Address, v types.AttoFIL, p ...interface{}) ([][]byte, error) {
+			atomic.AddInt32(&redeemedCount, 1)
+			return nil, nil
+		}
+
+		redeemCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go miner.RunRedeemer(redeemCtx)
+
+		miner.notifyRedeemer()
+		require.NoError(t, th.WaitForIt(50, 10*time.Millisecond, func() (bool, error) {
+			return atomic.LoadInt32(&redeemedCount) == 1, nil
+		}))
+	})
+
+	t.Run("lists vouchers by state", func(t *testing.T) {
+		porcelainAPI, miner, _ := setup(t, 1, storagedeal.Complete)
+		porcelainAPI.amountRedeemed = types.NewAttoFILFromFIL(2 * defaultAmountInc)
+
+		statuses, err := miner.Vouchers(ctx)
+		require.NoError(t, err)
+		require.Len(t, statuses, 10)
+		assert.Equal(t, []VoucherState{
+			VoucherRedeemed, VoucherRedeemed, VoucherPending, VoucherPending, VoucherPending,
+			VoucherPending, VoucherPending, VoucherPending, VoucherPending, VoucherPending,
+		}, voucherStates(statuses))
+
+		porcelainAPI.blockHeight = porcelainAPI.channelEol
+		statuses, err = miner.Vouchers(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, VoucherRedeemed, statuses[1].State)
+		assert.Equal(t, VoucherExpired, statuses[2].State)
+	})
+
+	t.Run("takes a reclaimed channel as ended", func(t *testing.T) {
+		porcelainAPI, miner, redeemed := setup(t, 1, storagedeal.Complete)
+		reclaimed := *porcelainAPI.deals[firstDealCid(porcelainAPI)].Proposal
+		reclaimed.Payment.Channel = types.NewChannelID(99)
+		reclaimedCid := types.NewCidForTestGetter()()
+		require.NoError(t, porcelainAPI.DealPut(&storagedeal.Deal{
+			Miner:    miner.minerAddr,
+			Proposal: &reclaimed,
+			Response: &storagedeal.Response{State: storagedeal.Complete, ProposalCid: reclaimedCid},
+		}))
+
+		require.NoError(t, miner.redeemVouchers(ctx))
+		assert.Len(t, *redeemed, 1)
+
+		statuses, err := miner.Vouchers(ctx)
+		require.NoError(t, err)
+		for _, s := range statuses {
+			if s.ProposalCid.Equals(reclaimedCid) {
+				assert.Equal(t, VoucherExpired, s.State)
+			}
+		}
+	})
+
+	t.Run("stops looking up settled channels", func(t *testing.T) {
+		porcelainAPI, miner, _ := setup(t, 1, storagedeal.Complete)
+		porcelainAPI.amountRedeemed = types.NewAttoFILFromFIL(10 * defaultAmountInc)
+		lookups := 0
+		porcelainAPI.messageHandlers["ls"] = func(a address.Address, v types.AttoFIL, p ...interface{}) ([][]byte, error) {
+			lookups++
+			return porcelainAPI.messageQueryPaymentBrokerLs()
+		}
+
+		require.NoError(t, miner.redeemVouchers(ctx))
+		require.NoError(t, miner.redeemVouchers(ctx))
+		assert.Equal(t, 1, lookups)
+
+		// also after a restart
+		restarted := newTestMiner(porcelainAPI)
+		restarted.redeemsDs = miner.redeemsDs
+		require.NoError(t, restarted.loadRedeems())
+		statuses, err := restarted.Vouchers(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, lookups)
+		assert.Equal(t, VoucherRedeemed, statuses[9].State)
+	})
+
+	t.Run("ignores deals that aren't complete", func(t *testing.T) {
+		_, miner, redeemed := setup(t, 1, storagedeal.Staged)
+
+		require.NoError(t, miner.redeemVouchers(ctx))
+		assert.Empty(t, *redeemed)
+
+		statuses, err := miner.Vouchers(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, statuses)
+	})
+}
+
+func voucherStates(statuses []*VoucherStatus) []VoucherState {
+	var states []VoucherState
+	for _, s := range statuses {
+		states = append(states, s.State)
+	}
+	return states
+}
+
+func firstDealCid(porcelainAPI *minerTestPorcelain) cid.Cid {
+	for c := range porcelainAPI.deals {
+		return c
+	}
+	return cid.Undef
+}
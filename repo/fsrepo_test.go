@@ -44,16 +44,33 @@ const (
 		"type": "badgerds",
 		"path": "badger"
 	},
+	"dealPolicy": {
+		"minPieceSize": 0,
+		"maxPieceSize": 0,
+		"minPrice": "0",
+		"maxDuration": 0,
+		"allowedClients": [],
+		"deniedClients": [],
+		"maxStagedBytes": 0,
+		"maxConcurrentDeals": 0,
+		"decisionHook": ""
+	},
 	"heartbeat": {
 		"beatTarget": "",
 		"beatPeriod": "3s",
 		"reconnectPeriod": "10s",
 		"nickname": ""
 	},
+	"history": {
+		"enabled": false
+	},
 	"mining": {
 		"minerAddress": "empty",
 		"autoSealIntervalSeconds": 120,
-		"storagePrice": "0"
+		"storagePrice": "0",
+		"retrievalPrice": "0",
+		"postSafetyMargin": 20,
+		"postRetries": 3
 	},
 	"mpool": {
 		"maxPoolSize": 10000,
@@ -78,6 +95,13 @@ const (
 	"swarm": {
 		"address": "/ip4/0.0.0.0/tcp/6000"
 	},
+	"vouchers": {
+		"autoRedeem": true,
+		"gasPrice": "0.000000000000000001",
+		"gasLimit": "300",
+		"maxGasPerBlock": "3000",
+		"eolMargin": 100
+	},
 	"wallet": {
 		"defaultAddress": "empty"
 	}
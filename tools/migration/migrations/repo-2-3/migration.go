@@ -0,0 +1,309 @@
+package migration23
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"os"
+	"strings"
+
+	"github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/repo"
+)
+
+// ==============  IMPORTANT ================
+// PLEASE SEE THE README IF YOU ARE HERE BECAUSE YOUR CHANGES BROKE A MIGRATION TEST
+// ==========================================
+
+// PassphraseEnvVar is the environment variable holding the passphrase to encrypt the wallet with.
+const PassphraseEnvVar = "FIL_WALLET_PASSPHRASE"
+
+// The encrypted wallet format is duplicated here from the wallet package to protect against
+// future changes.
+func init() {
+	cbor.RegisterCborType(encryptionParams{})
+	cbor.RegisterCborType(encryptedKeyInfo{})
+}
+
+var encryptionParamsKey = datastore.NewKey("_encryption")
+
+var passphraseCheck = []byte("filecoin wallet")
+
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+type encryptionParams struct {
+	Salt       []byte
+	N          int
+	R          int
+	P          int
+	CheckNonce []byte
+	Check      []byte
+}
+
+type encryptedKeyInfo struct {
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+// WalletEncryption is the migration from version 2 to 3.
+type WalletEncryption struct{}
+
+// Describe describes the steps this migration will take.
+func (m *WalletEncryption) Describe() string {
+	return `WalletEncryption migrates the storage repo from version 2 to 3.
+
+    Version 3 repos may store the wallet's private keys encrypted with a passphrase.
+    If the ` + PassphraseEnvVar + ` environment variable is set, this migration encrypts
+    each key in the wallet with a key derived from its value, and the wallet must
+    be unlocked with 'go-filecoin wallet unlock' before it can sign. Otherwise the
+    wallet is left unencrypted. No other repo data is changed.
+`
+}
+
+// Migrate performs the migration steps
+func (m *WalletEncryption) Migrate(newRepoPath string) error {
+	passphrase, ok := os.LookupEnv(PassphraseEnvVar)
+	if !ok {
+		return nil
+	}
+	if passphrase == "" {
+		return errors.Errorf("%s is set but empty", PassphraseEnvVar)
+	}
+
+	oldVer, _ := m.Versions()
+
+	// This call performs some checks on the repo before we start.
+	fsrepo, err := repo.OpenFSRepo(newRepoPath, oldVer)
+	if err != nil {
+		return err
+	}
+	defer mustCloseRepo(fsrepo)
+
+	return encryptWallet(fsrepo.WalletDatastore(), []byte(passphrase))
+}
+
+// Versions returns the old and new versions that are valid for this migration
+func (m *WalletEncryption) Versions() (from, to uint) {
+	return 2, 3
+}
+
+// Validate performs validation tests for the migration steps:
+// Reads in the old and new wallets, and returns an error unless they hold the same
+// addresses and, after decrypting any encrypted keys, the same keys.
+func (m *WalletEncryption) Validate(oldRepoPath, newRepoPath string) error {
+	oldVer, _ := m.Versions()
+
+	oldFsRepo, err := repo.OpenFSRepo(oldRepoPath, oldVer)
+	if err != nil {
+		return err
+	}
+	defer mustCloseRepo(oldFsRepo)
+
+	// Version hasn't been updated yet.
+	newFsRepo, err := repo.OpenFSRepo(newRepoPath, oldVer)
+	if err != nil {
+		return err
+	}
+	defer mustCloseRepo(newFsRepo)
+
+	oldKeys, err := readKeys(oldFsRepo.WalletDatastore())
+	if err != nil {
+		return errors.Wrap(err, "failed to read old wallet")
+	}
+	newKeys, err := readKeys(newFsRepo.WalletDatastore())
+	if err != nil {
+		return errors.Wrap(err, "failed to read new wallet")
+	}
+	if len(oldKeys) != len(newKeys) {
+		return errors.Errorf("old wallet has %d keys, new wallet has %d", len(oldKeys), len(newKeys))
+	}
+
+	passphrase, encrypt := os.LookupEnv(PassphraseEnvVar)
+	var key []byte
+	if encrypt {
+		raw, err := newFsRepo.WalletDatastore().Get(encryptionParamsKey)
+		if err != nil {
+			return errors.Wrap(err, "new wallet is not encrypted")
+		}
+		var params encryptionParams
+		if err := cbor.DecodeInto(raw, &params); err != nil {
+			return errors.Wrap(err, "failed to decode wallet encryption parameters")
+		}
+		key, err = deriveKey([]byte(passphrase), &params)
+		if err != nil {
+			return err
+		}
+		check, err := open(key, params.CheckNonce, params.Check, nil)
+		if err != nil || !bytes.Equal(check, passphraseCheck) {
+			return errors.New("new wallet's passphrase check does not match")
+		}
+	}
+
+	for addr, oldKey := range oldKeys {
+		newKey, ok := newKeys[addr]
+		if !ok {
+			return errors.Errorf("new wallet is missing key for %s", addr)
+		}
+
+		if encrypt {
+			var eki encryptedKeyInfo
+			if err := cbor.DecodeInto(newKey, &eki); err != nil {
+				return errors.Wrapf(err, "failed to decode encrypted key for %s", addr)
+			}
+			newKey, err = open(key, eki.Nonce, eki.Ciphertext, addrBytes(addr))
+			if err != nil {
+				return errors.Wrapf(err, "failed to decrypt key for %s", addr)
+			}
+		}
+
+		if !bytes.Equal(oldKey, newKey) {
+			return errors.Errorf("old and new keys for %s are not equal", addr)
+		}
+	}
+	return nil
+}
+
+// encryptWallet encrypts each key in an unencrypted wallet datastore, and stores the parameters
+// needed to derive the key from the passphrase. Adapted from wallet.EncryptDatastore.
+func encryptWallet(ds repo.Datastore, passphrase []byte) error {
+	if has, err := ds.Has(encryptionParamsKey); err != nil {
+		return err
+	} else if has {
+		return errors.New("wallet is already encrypted")
+	}
+
+	keys, err := readKeys(ds)
+	if err != nil {
+		return err
+	}
+
+	params := &encryptionParams{
+		Salt: make([]byte, 32),
+		N:    scryptN,
+		R:    scryptR,
+		P:    scryptP,
+	}
+	if _, err := rand.Read(params.Salt); err != nil {
+		return errors.Wrap(err, "failed to generate salt")
+	}
+
+	key, err := deriveKey(passphrase, params)
+	if err != nil {
+		return err
+	}
+	params.CheckNonce, params.Check, err = seal(key, passphraseCheck, nil)
+	if err != nil {
+		return err
+	}
+
+	for addr, kib := range keys {
+		nonce, ciphertext, err := seal(key, kib, addrBytes(addr))
+		if err != nil {
+			return err
+		}
+		raw, err := cbor.DumpObject(encryptedKeyInfo{Nonce: nonce, Ciphertext: ciphertext})
+		if err != nil {
+			return err
+		}
+		if err := ds.Put(datastore.NewKey(addr), raw); err != nil {
+			return errors.Wrapf(err, "failed to store encrypted key for %s", addr)
+		}
+	}
+
+	raw, err := cbor.DumpObject(params)
+	if err != nil {
+		return err
+	}
+	return ds.Put(encryptionParamsKey, raw)
+}
+
+// readKeys returns the raw value of each key in a wallet datastore, by address string.
+func readKeys(ds repo.Datastore) (map[string][]byte, error) {
+	result, err := ds.Query(dsq.Query{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query datastore")
+	}
+
+	list, err := result.Rest()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read query results")
+	}
+
+	keys := make(map[string][]byte)
+	for _, el := range list {
+		if el.Key == encryptionParamsKey.String() {
+			continue
+		}
+		keys[strings.Trim(el.Key, "/")] = el.Value
+	}
+	return keys, nil
+}
+
+// addrBytes returns the bytes of the address with the given string form, which authenticate its
+// encrypted key.
+func addrBytes(addr string) []byte {
+	a, err := address.NewFromString(addr)
+	if err != nil {
+		panic(err)
+	}
+	return a.Bytes()
+}
+
+func deriveKey(passphrase []byte, params *encryptionParams) ([]byte, error) {
+	key, err := scrypt.Key(passphrase, params.Salt, params.N, params.R, params.P, 32)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to derive key from passphrase")
+	}
+	return key, nil
+}
+
+func seal(key, plaintext, additionalData []byte) (nonce, ciphertext []byte, err error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to generate nonce")
+	}
+	return nonce, aead.Seal(nil, nonce, plaintext, additionalData), nil
+}
+
+func open(key, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce")
+	}
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func mustCloseRepo(fsRepo *repo.FSRepo) {
+	err := fsRepo.Close()
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,118 @@
+package migration23_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/repo"
+	tf "github.com/filecoin-project/go-filecoin/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/tools/migration/internal"
+	migration23 "github.com/filecoin-project/go-filecoin/tools/migration/migrations/repo-2-3"
+	"github.com/filecoin-project/go-filecoin/wallet"
+)
+
+// ==============  IMPORTANT ================
+// PLEASE SEE THE README IF YOU ARE HERE BECAUSE YOUR CHANGES BROKE A MIGRATION TEST
+// ==========================================
+func TestVersions(t *testing.T) {
+	tf.UnitTest(t)
+
+	mig := migration23.WalletEncryption{}
+	from, to := mig.Versions()
+	assert.Equal(t, uint(2), from)
+	assert.Equal(t, uint(3), to)
+}
+
+func TestMigrateWallet(t *testing.T) {
+	tf.UnitTest(t)
+
+	mig := migration23.WalletEncryption{}
+	oldVer, newVer := mig.Versions()
+
+	container, repoLink := internal.RequireInitRepo(t, oldVer)
+	defer repo.RequireRemoveAll(t, container)
+	addrs := requireAddKeys(t, repoLink, oldVer, 2)
+
+	t.Run("without a passphrase the wallet is unchanged", func(t *testing.T) {
+		require.NoError(t, os.Unsetenv(migration23.PassphraseEnvVar))
+
+		newRepoPath, err := internal.CloneRepo(repoLink, newVer)
+		require.NoError(t, err)
+		defer repo.RequireRemoveAll(t, newRepoPath)
+
+		require.NoError(t, mig.Migrate(newRepoPath))
+		require.NoError(t, mig.Validate(repoLink, newRepoPath))
+
+		fsrepo, err := repo.OpenFSRepo(newRepoPath, oldVer)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, fsrepo.Close()) }()
+		encrypted, err := wallet.IsEncrypted(fsrepo.WalletDatastore())
+		require.NoError(t, err)
+		assert.False(t, encrypted)
+	})
+
+	t.Run("with a passphrase the wallet is encrypted", func(t *testing.T) {
+		require.NoError(t, os.Setenv(migration23.PassphraseEnvVar, "correct horse"))
+		defer func() { require.NoError(t, os.Unsetenv(migration23.PassphraseEnvVar)) }()
+
+		newRepoPath, err := internal.CloneRepo(repoLink, newVer)
+		require.NoError(t, err)
+		defer repo.RequireRemoveAll(t, newRepoPath)
+
+		require.NoError(t, mig.Migrate(newRepoPath))
+		require.NoError(t, mig.Validate(repoLink, newRepoPath))
+
+		// The wallet package can read and unlock the migrated wallet.
+		fsrepo, err := repo.OpenFSRepo(newRepoPath, oldVer)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, fsrepo.Close()) }()
+
+		backend, err := wallet.NewEncryptedBackend(fsrepo.WalletDatastore())
+		require.NoError(t, err)
+		assert.ElementsMatch(t, addrs, backend.Addresses())
+
+		require.NoError(t, backend.Unlock([]byte("correct horse"), 0))
+		for _, addr := range addrs {
+			_, err := backend.SignBytes([]byte("data"), addr)
+			assert.NoError(t, err)
+		}
+	})
+
+	t.Run("validation fails with the wrong passphrase", func(t *testing.T) {
+		require.NoError(t, os.Setenv(migration23.PassphraseEnvVar, "correct horse"))
+		defer func() { require.NoError(t, os.Unsetenv(migration23.PassphraseEnvVar)) }()
+
+		newRepoPath, err := internal.CloneRepo(repoLink, newVer)
+		require.NoError(t, err)
+		defer repo.RequireRemoveAll(t, newRepoPath)
+
+		require.NoError(t, mig.Migrate(newRepoPath))
+
+		require.NoError(t, os.Setenv(migration23.PassphraseEnvVar, "battery staple"))
+		err = mig.Validate(repoLink, newRepoPath)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "passphrase check does not match")
+	})
+}
+
+// requireAddKeys adds n new keys to the wallet of the repo at repoPath, returning their addresses.
+func requireAddKeys(t *testing.T, repoPath string, version uint, n int) []address.Address {
+	fsrepo, err := repo.OpenFSRepo(repoPath, version)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, fsrepo.Close()) }()
+
+	backend, err := wallet.NewDSBackend(fsrepo.WalletDatastore())
+	require.NoError(t, err)
+
+	var addrs []address.Address
+	for i := 0; i < n; i++ {
+		addr, err := backend.NewAddress()
+		require.NoError(t, err)
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
@@ -0,0 +1,165 @@
+// remote-signer is a reference signer for the go-filecoin remote wallet backend. It holds keys
+// in a datastore of its own, and signs with them for nodes configured with
+// `wallet.remoteSigner` set to its socket.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	badgerds "github.com/ipfs/go-ds-badger"
+	logging "github.com/ipfs/go-log"
+	"github.com/pkg/errors"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/types"
+	"github.com/filecoin-project/go-filecoin/wallet"
+)
+
+var log = logging.Logger("remote-signer")
+
+func init() {
+	// Info level
+	logging.SetAllLoggers(4)
+}
+
+func main() {
+	socketPath := flag.String("socket", "", "(required) path of the unix socket to listen on")
+	keystore := flag.String("keystore", "", "(required) directory of the datastore holding the signer's keys")
+	policyPath := flag.String("policy", "", "JSON file mapping addresses to the methods and recipients they may sign messages for")
+	newAddress := flag.Bool("new-address", false, "create a new key, print its address and exit")
+	importPath := flag.String("import", "", "import the keys in a file written by 'go-filecoin wallet export --enc=json' and exit")
+	flag.Parse()
+
+	if *keystore == "" || (*socketPath == "" && !*newAddress && *importPath == "") {
+		fmt.Println("ERROR: must provide a keystore, and a socket to listen on")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if err := run(*socketPath, *keystore, *policyPath, *newAddress, *importPath); err != nil {
+		log.Error(err)
+		os.Exit(1)
+	}
+}
+
+func run(socketPath, keystore, policyPath string, newAddress bool, importPath string) error {
+	ds, err := badgerds.NewDatastore(keystore, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to open keystore")
+	}
+	defer ds.Close() // nolint: errcheck
+
+	backend, err := wallet.NewDSBackend(ds)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case newAddress:
+		addr, err := backend.NewAddress()
+		if err != nil {
+			return err
+		}
+		fmt.Println(addr)
+		return nil
+	case importPath != "":
+		return importKeys(backend, importPath)
+	}
+
+	policies, err := loadPolicies(policyPath)
+	if err != nil {
+		return err
+	}
+
+	// Remove a socket left behind by a previous run.
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to listen")
+	}
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		return err
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	interrupted := make(chan struct{})
+	go func() {
+		<-sigs
+		close(interrupted)
+		l.Close() // nolint: errcheck
+	}()
+
+	log.Infof("signing for %d addresses on %s", len(backend.Addresses()), socketPath)
+	err = wallet.NewSignerServer(backend, policies).Serve(l)
+	select {
+	case <-interrupted:
+		// Interrupted, so the error is from closing the listener.
+		return nil
+	default:
+		return err
+	}
+}
+
+// loadPolicies reads the signing policies from a JSON file of the form
+// {"<address>": {"methods": ["<method>", ...], "recipients": ["<address>", ...]}}.
+func loadPolicies(path string) (map[address.Address]wallet.SignerPolicy, error) {
+	policies := make(map[address.Address]wallet.SignerPolicy)
+	if path == "" {
+		return policies, nil
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read policy file")
+	}
+
+	var byString map[string]wallet.SignerPolicy
+	if err := json.Unmarshal(raw, &byString); err != nil {
+		return nil, errors.Wrap(err, "failed to parse policy file")
+	}
+
+	for s, policy := range byString {
+		addr, err := address.NewFromString(s)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid address %s in policy file", s)
+		}
+		policies[addr] = policy
+	}
+	return policies, nil
+}
+
+func importKeys(backend *wallet.DSBackend, path string) error {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to read key file")
+	}
+
+	var keys struct {
+		KeyInfo []*types.KeyInfo
+	}
+	if err := json.Unmarshal(raw, &keys); err != nil {
+		return errors.Wrap(err, "failed to parse key file")
+	}
+
+	for _, ki := range keys.KeyInfo {
+		if err := backend.ImportKey(ki); err != nil {
+			return err
+		}
+		addr, err := ki.Address()
+		if err != nil {
+			return err
+		}
+		fmt.Println(addr)
+	}
+	return nil
+}
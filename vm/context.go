@@ -28,6 +28,7 @@ type Context struct {
 	gasTracker  *GasTracker
 	blockHeight *types.BlockHeight
 	ancestors   []types.TipSet
+	transfers   *[]InternalTransfer
 
 	deps *deps // Inject external dependencies so we can unit test robustly.
 }
@@ -44,6 +45,18 @@ type NewContextParams struct {
 	GasTracker  *GasTracker
 	BlockHeight *types.BlockHeight
 	Ancestors   []types.TipSet
+	// Transfers, if set, collects the value transfers made by actors sending messages to
+	// other actors during execution.
+	Transfers *[]InternalTransfer
+}
+
+// InternalTransfer is a transfer of value made by an actor sending a message to another actor,
+// rather than by a message on chain.
+type InternalTransfer struct {
+	From   address.Address
+	To     address.Address
+	Value  types.AttoFIL
+	Method string
 }
 
 // NewVMContext returns an initialized context.
@@ -57,6 +70,7 @@ func NewVMContext(params NewContextParams) *Context {
 		gasTracker:  params.GasTracker,
 		blockHeight: params.BlockHeight,
 		ancestors:   params.Ancestors,
+		transfers:   params.Transfers,
 		deps:        makeDeps(params.State),
 	}
 }
@@ -174,11 +188,24 @@ func (ctx *Context) Send(to address.Address, method string, value types.AttoFIL,
 		GasTracker:  ctx.gasTracker,
 		BlockHeight: ctx.blockHeight,
 		Ancestors:   ctx.ancestors,
+		Transfers:   ctx.transfers,
 	}
 	innerCtx := NewVMContext(innerParams)
 
+	// Record the transfer ahead of any the send makes itself, and forget them all if it fails.
+	var recorded int
+	if ctx.transfers != nil {
+		recorded = len(*ctx.transfers)
+		if value.IsPositive() {
+			*ctx.transfers = append(*ctx.transfers, InternalTransfer{From: from, To: to, Value: value, Method: method})
+		}
+	}
+
 	out, ret, err := deps.Send(context.Background(), innerCtx)
 	if err != nil {
+		if ctx.transfers != nil {
+			*ctx.transfers = (*ctx.transfers)[:recorded]
+		}
 		return nil, ret, err
 	}
 
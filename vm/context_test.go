@@ -263,6 +263,101 @@ func TestVMContextSendFailures(t *testing.T) {
 
 }
 
+func TestVMContextSendRecordsTransfers(t *testing.T) {
+	tf.UnitTest(t)
+
+	newMsg := types.NewMessageForTestGetter()
+	newAddress := address.NewForTestGetter()
+	tree := state.NewCachedStateTree(&state.MockStateTree{})
+	vms := NewStorageMap(blockstore.NewBlockstore(datastore.NewMapDatastore()))
+
+	newDeps := func(sendErr error, nested func(*Context)) *deps {
+		return &deps{
+			EncodeValues: func(_ []*abi.Value) ([]byte, error) { return nil, nil },
+			GetOrCreateActor: func(_ context.Context, _ address.Address, f func() (*actor.Actor, error)) (*actor.Actor, error) {
+				return f()
+			},
+			Send: func(ctx context.Context, vmCtx *Context) ([][]byte, uint8, error) {
+				if nested != nil {
+					nested(vmCtx)
+				}
+				if sendErr != nil {
+					return nil, 1, sendErr
+				}
+				return nil, 0, nil
+			},
+			ToValues: func(_ []interface{}) ([]*abi.Value, error) { return nil, nil },
+		}
+	}
+
+	newCtx := func(transfers *[]InternalTransfer) *Context {
+		return NewVMContext(NewContextParams{
+			From:        actor.NewActor(cid.Undef, types.NewAttoFILFromFIL(100)),
+			To:          actor.NewActor(cid.Undef, types.NewAttoFILFromFIL(100)),
+			Message:     newMsg(),
+			State:       tree,
+			StorageMap:  vms,
+			GasTracker:  NewGasTracker(),
+			BlockHeight: types.NewBlockHeight(0),
+			Transfers:   transfers,
+		})
+	}
+
+	t.Run("records sends with value, outermost first", func(t *testing.T) {
+		var transfers []InternalTransfer
+		ctx := newCtx(&transfers)
+		first, second := newAddress(), newAddress()
+
+		ctx.deps = newDeps(nil, func(inner *Context) {
+			inner.deps = newDeps(nil, nil)
+			_, _, err := inner.Send(second, "bar", types.NewAttoFILFromFIL(2), nil)
+			require.NoError(t, err)
+		})
+		_, _, err := ctx.Send(first, "foo", types.NewAttoFILFromFIL(5), nil)
+		require.NoError(t, err)
+
+		require.Len(t, transfers, 2)
+		assert.Equal(t, ctx.Message().To, transfers[0].From)
+		assert.Equal(t, first, transfers[0].To)
+		assert.Equal(t, types.NewAttoFILFromFIL(5), transfers[0].Value)
+		assert.Equal(t, "foo", transfers[0].Method)
+		assert.Equal(t, first, transfers[1].From)
+		assert.Equal(t, second, transfers[1].To)
+	})
+
+	t.Run("ignores sends without value", func(t *testing.T) {
+		var transfers []InternalTransfer
+		ctx := newCtx(&transfers)
+		ctx.deps = newDeps(nil, nil)
+
+		_, _, err := ctx.Send(newAddress(), "foo", types.ZeroAttoFIL, nil)
+		require.NoError(t, err)
+		assert.Empty(t, transfers)
+	})
+
+	t.Run("forgets failed sends and the sends they made", func(t *testing.T) {
+		var transfers []InternalTransfer
+		ctx := newCtx(&transfers)
+
+		ctx.deps = newDeps(xerrors.New("error"), func(inner *Context) {
+			inner.deps = newDeps(nil, nil)
+			_, _, err := inner.Send(newAddress(), "bar", types.NewAttoFILFromFIL(2), nil)
+			require.NoError(t, err)
+		})
+		_, _, err := ctx.Send(newAddress(), "foo", types.NewAttoFILFromFIL(5), nil)
+		require.Error(t, err)
+		assert.Empty(t, transfers)
+	})
+
+	t.Run("records nothing without a transfer log", func(t *testing.T) {
+		ctx := newCtx(nil)
+		ctx.deps = newDeps(nil, nil)
+
+		_, _, err := ctx.Send(newAddress(), "foo", types.NewAttoFILFromFIL(5), nil)
+		require.NoError(t, err)
+	})
+}
+
 func TestVMContextIsAccountActor(t *testing.T) {
 	tf.UnitTest(t)
 
@@ -1,6 +1,9 @@
 package wallet
 
 import (
+	"strings"
+	"time"
+
 	"github.com/filecoin-project/go-filecoin/address"
 	"github.com/filecoin-project/go-filecoin/types"
 )
@@ -34,3 +37,23 @@ type Importer interface {
 	// into the backend
 	ImportKey(ki *types.KeyInfo) error
 }
+
+// Locker is a specialization of a wallet backend that keeps its keys encrypted
+// at rest, and can only use them while unlocked with a passphrase.
+type Locker interface {
+	// Unlock makes the backend's keys available until Lock is called or,
+	// if timeout is not zero, until the timeout elapses.
+	Unlock(passphrase []byte, timeout time.Duration) error
+
+	// Lock makes the backend's keys unavailable.
+	Lock()
+
+	// IsLocked returns true if the backend's keys are unavailable.
+	IsLocked() bool
+}
+
+// isMetadataKey returns true if a wallet datastore key holds backend metadata, rather than the
+// key of an address. Metadata keys start with an underscore, which addresses never do.
+func isMetadataKey(key string) bool {
+	return strings.HasPrefix(key, "/_")
+}
@@ -0,0 +1,580 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/crypto"
+	"github.com/filecoin-project/go-filecoin/repo"
+	"github.com/filecoin-project/go-filecoin/types"
+	wutil "github.com/filecoin-project/go-filecoin/wallet/util"
+)
+
+func init() {
+	cbor.RegisterCborType(encryptionParams{})
+	cbor.RegisterCborType(encryptedKeyInfo{})
+}
+
+// EncryptedBackendType is the reflect type of the EncryptedBackend.
+var EncryptedBackendType = reflect.TypeOf(&EncryptedBackend{})
+
+// ErrLocked is returned when a key is needed from an encrypted backend that is locked.
+var ErrLocked = errors.New("wallet is locked")
+
+// ErrBadPassphrase is returned when unlocking an encrypted backend with the wrong passphrase.
+var ErrBadPassphrase = errors.New("incorrect wallet passphrase")
+
+// encryptionParamsKey is the wallet datastore key of the encryption parameters. Its presence
+// marks the wallet datastore as encrypted.
+var encryptionParamsKey = ds.NewKey("_encryption")
+
+// Default scrypt cost parameters for new encrypted wallets.
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// passphraseCheck is sealed with the key derived from the passphrase when a wallet is encrypted,
+// so that unlocking can tell a wrong passphrase from a corrupt key.
+var passphraseCheck = []byte("filecoin wallet")
+
+// encryptionParams are the parameters needed to derive the key that encrypts a wallet's keys
+// from its passphrase.
+type encryptionParams struct {
+	Salt []byte
+	N    int
+	R    int
+	P    int
+	// CheckNonce and Check are passphraseCheck sealed with the derived key.
+	CheckNonce []byte
+	Check      []byte
+}
+
+// encryptedKeyInfo is a types.KeyInfo sealed with AES-256-GCM, using the address it belongs to
+// as additional data. The seed info of an HD wallet is sealed the same way, using its datastore
+// key as additional data.
+type encryptedKeyInfo struct {
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+// EncryptedBackend is a wallet backend implementation for storing addresses in a datastore,
+// with their private keys encrypted by a key derived from a passphrase. Keys can only be used
+// or added while the backend is unlocked.
+type EncryptedBackend struct {
+	lk sync.RWMutex
+
+	ds     repo.Datastore
+	params *encryptionParams
+
+	cache map[address.Address]struct{}
+
+	// key is the key derived from the passphrase, nil while locked.
+	key []byte
+	// relock locks the backend again when an unlock times out.
+	relock *time.Timer
+}
+
+var _ Backend = (*EncryptedBackend)(nil)
+var _ Importer = (*EncryptedBackend)(nil)
+var _ Locker = (*EncryptedBackend)(nil)
+
+// IsEncrypted returns true if the given wallet datastore's keys are encrypted.
+func IsEncrypted(d repo.Datastore) (bool, error) {
+	return d.Has(encryptionParamsKey)
+}
+
+// NewBackend constructs the backend appropriate for the passed in wallet datastore: an
+// HDBackend if it derives keys from a seed, otherwise an EncryptedBackend if its keys are
+// encrypted, otherwise a DSBackend.
+func NewBackend(d repo.Datastore) (Backend, error) {
+	encrypted, err := IsEncrypted(d)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read wallet datastore")
+	}
+	hasHD, err := IsHD(d)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read wallet datastore")
+	}
+
+	switch {
+	case encrypted && hasHD:
+		return NewEncryptedHDBackend(d)
+	case encrypted:
+		return NewEncryptedBackend(d)
+	case hasHD:
+		return NewHDBackend(d)
+	}
+	return NewDSBackend(d)
+}
+
+// NewEncryptedBackend constructs a new, locked, backend using the passed in datastore, which must
+// have been encrypted with EncryptDatastore.
+func NewEncryptedBackend(d repo.Datastore) (*EncryptedBackend, error) {
+	raw, err := d.Get(encryptionParamsKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read wallet encryption parameters")
+	}
+	var params encryptionParams
+	if err := cbor.DecodeInto(raw, &params); err != nil {
+		return nil, errors.Wrap(err, "failed to decode wallet encryption parameters")
+	}
+
+	addrs, err := walletAddresses(d)
+	if err != nil {
+		return nil, err
+	}
+
+	cache := make(map[address.Address]struct{})
+	for _, addr := range addrs {
+		cache[addr] = struct{}{}
+	}
+
+	return &EncryptedBackend{
+		ds:     d,
+		params: &params,
+		cache:  cache,
+	}, nil
+}
+
+// EncryptDatastore encrypts the keys in a wallet datastore, and its seed if it has one, with the
+// given passphrase, so that it may be used with an EncryptedBackend or, if it has a seed, with
+// an HDBackend. It fails if the keys are already encrypted.
+func EncryptDatastore(d repo.Datastore, passphrase []byte) error {
+	encrypted, err := IsEncrypted(d)
+	if err != nil {
+		return errors.Wrap(err, "failed to read wallet datastore")
+	}
+	if encrypted {
+		return errors.New("wallet is already encrypted")
+	}
+	hasHD, err := IsHD(d)
+	if err != nil {
+		return errors.Wrap(err, "failed to read wallet datastore")
+	}
+
+	addrs, err := walletAddresses(d)
+	if err != nil {
+		return err
+	}
+
+	params := &encryptionParams{
+		Salt: make([]byte, 32),
+		N:    scryptN,
+		R:    scryptR,
+		P:    scryptP,
+	}
+	if _, err := rand.Read(params.Salt); err != nil {
+		return errors.Wrap(err, "failed to generate salt")
+	}
+
+	key, err := deriveKey(passphrase, params)
+	if err != nil {
+		return err
+	}
+
+	params.CheckNonce, params.Check, err = seal(key, passphraseCheck, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, addr := range addrs {
+		kib, err := d.Get(ds.NewKey(addr.String()))
+		if err != nil {
+			return errors.Wrapf(err, "failed to read key for %s", addr)
+		}
+
+		ki := &types.KeyInfo{}
+		if err := ki.Unmarshal(kib); err != nil {
+			return errors.Wrapf(err, "failed to unmarshal keyinfo for %s", addr)
+		}
+
+		if err := putEncryptedKeyInfo(d, key, addr, ki); err != nil {
+			return err
+		}
+	}
+
+	if hasHD {
+		raw, err := d.Get(hdInfoKey)
+		if err != nil {
+			return errors.Wrap(err, "failed to read wallet seed")
+		}
+		var info hdInfo
+		if err := cbor.DecodeInto(raw, &info); err != nil {
+			return errors.Wrap(err, "failed to decode wallet seed")
+		}
+		if err := putEncryptedHDInfo(d, key, &info); err != nil {
+			return err
+		}
+	}
+
+	raw, err := cbor.DumpObject(params)
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(d.Put(encryptionParamsKey, raw), "failed to store wallet encryption parameters")
+}
+
+// Unlock makes the backend's keys available, until Lock is called or, if timeout is not zero,
+// until the timeout elapses.
+func (backend *EncryptedBackend) Unlock(passphrase []byte, timeout time.Duration) error {
+	key, err := deriveKey(passphrase, backend.params)
+	if err != nil {
+		return err
+	}
+
+	check, err := open(key, backend.params.CheckNonce, backend.params.Check, nil)
+	if err != nil || !bytes.Equal(check, passphraseCheck) {
+		return ErrBadPassphrase
+	}
+
+	backend.lk.Lock()
+	defer backend.lk.Unlock()
+
+	backend.lock()
+	backend.key = key
+	if timeout > 0 {
+		var relock *time.Timer
+		relock = time.AfterFunc(timeout, func() {
+			backend.lk.Lock()
+			defer backend.lk.Unlock()
+
+			// Don't lock if the backend has been unlocked again since.
+			if backend.relock == relock {
+				backend.lock()
+			}
+		})
+		backend.relock = relock
+	}
+	return nil
+}
+
+// Lock makes the backend's keys unavailable until it is unlocked again.
+func (backend *EncryptedBackend) Lock() {
+	backend.lk.Lock()
+	defer backend.lk.Unlock()
+
+	backend.lock()
+}
+
+func (backend *EncryptedBackend) lock() {
+	backend.key = nil
+	if backend.relock != nil {
+		backend.relock.Stop()
+		backend.relock = nil
+	}
+}
+
+// IsLocked returns true if the backend's keys are unavailable.
+func (backend *EncryptedBackend) IsLocked() bool {
+	backend.lk.RLock()
+	defer backend.lk.RUnlock()
+
+	return backend.key == nil
+}
+
+// ImportKey encrypts the KeyInfo `ki` and loads it into the backend. Fails while locked.
+func (backend *EncryptedBackend) ImportKey(ki *types.KeyInfo) error {
+	return backend.putKeyInfo(ki)
+}
+
+// Addresses returns a list of all addresses that are stored in this backend.
+func (backend *EncryptedBackend) Addresses() []address.Address {
+	backend.lk.RLock()
+	defer backend.lk.RUnlock()
+
+	var cpy []address.Address
+	for addr := range backend.cache {
+		cpy = append(cpy, addr)
+	}
+	return cpy
+}
+
+// HasAddress checks if the passed in address is stored in this backend.
+// Safe for concurrent access.
+func (backend *EncryptedBackend) HasAddress(addr address.Address) bool {
+	backend.lk.RLock()
+	defer backend.lk.RUnlock()
+
+	_, ok := backend.cache[addr]
+	return ok
+}
+
+// NewAddress creates a new address and stores its encrypted key. Fails while locked.
+// Safe for concurrent access.
+func (backend *EncryptedBackend) NewAddress() (address.Address, error) {
+	prv, err := crypto.GenerateKey()
+	if err != nil {
+		return address.Undef, err
+	}
+
+	ki := &types.KeyInfo{
+		PrivateKey: prv,
+		Curve:      SECP256K1,
+	}
+
+	if err := backend.putKeyInfo(ki); err != nil {
+		return address.Undef, err
+	}
+
+	return ki.Address()
+}
+
+func (backend *EncryptedBackend) putKeyInfo(ki *types.KeyInfo) error {
+	a, err := ki.Address()
+	if err != nil {
+		return err
+	}
+
+	backend.lk.Lock()
+	defer backend.lk.Unlock()
+
+	if backend.key == nil {
+		return ErrLocked
+	}
+
+	if err := putEncryptedKeyInfo(backend.ds, backend.key, a, ki); err != nil {
+		return err
+	}
+
+	backend.cache[a] = struct{}{}
+	return nil
+}
+
+// SignBytes cryptographically signs `data` using the private key of `addr`. Fails while locked.
+func (backend *EncryptedBackend) SignBytes(data []byte, addr address.Address) (types.Signature, error) {
+	ki, err := backend.GetKeyInfo(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return wutil.Sign(ki.Key(), data)
+}
+
+// Verify cryptographically verifies that 'sig' is the signed hash of 'data' with
+// the public key `pk`.
+func (backend *EncryptedBackend) Verify(data, pk []byte, sig types.Signature) bool {
+	return crypto.Verify(pk, data, sig)
+}
+
+// GetKeyInfo will return the private & public keys associated with address `addr`
+// iff backend contains the addr. Fails while locked.
+func (backend *EncryptedBackend) GetKeyInfo(addr address.Address) (*types.KeyInfo, error) {
+	if !backend.HasAddress(addr) {
+		return nil, errors.New("backend does not contain address")
+	}
+
+	backend.lk.RLock()
+	key := backend.key
+	backend.lk.RUnlock()
+	if key == nil {
+		return nil, ErrLocked
+	}
+
+	raw, err := backend.ds.Get(ds.NewKey(addr.String()))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to fetch private key from backend")
+	}
+
+	var eki encryptedKeyInfo
+	if err := cbor.DecodeInto(raw, &eki); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal encrypted keyinfo from backend")
+	}
+
+	kib, err := open(key, eki.Nonce, eki.Ciphertext, addr.Bytes())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt keyinfo")
+	}
+
+	ki := &types.KeyInfo{}
+	if err := ki.Unmarshal(kib); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal keyinfo from backend")
+	}
+
+	return ki, nil
+}
+
+// initHD makes the backend's wallet derive new keys from the seed of mnemonic, along path, so
+// that it may be used with an HDBackend. Fails while locked.
+func (backend *EncryptedBackend) initHD(mnemonic, path string) error {
+	info, err := newHDInfo(backend.ds, mnemonic, path)
+	if err != nil {
+		return err
+	}
+	return backend.putHDInfo(info)
+}
+
+// getHDInfo decrypts the seed info of the backend's wallet. Fails while locked.
+func (backend *EncryptedBackend) getHDInfo() (*hdInfo, error) {
+	backend.lk.RLock()
+	key := backend.key
+	backend.lk.RUnlock()
+	if key == nil {
+		return nil, ErrLocked
+	}
+
+	raw, err := backend.ds.Get(hdInfoKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read wallet seed")
+	}
+
+	var eki encryptedKeyInfo
+	if err := cbor.DecodeInto(raw, &eki); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal encrypted wallet seed")
+	}
+
+	plaintext, err := open(key, eki.Nonce, eki.Ciphertext, []byte(hdInfoKey.String()))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decrypt wallet seed")
+	}
+
+	var info hdInfo
+	if err := cbor.DecodeInto(plaintext, &info); err != nil {
+		return nil, errors.Wrap(err, "failed to decode wallet seed")
+	}
+	return &info, nil
+}
+
+// putHDInfo encrypts and stores the seed info of the backend's wallet. Fails while locked.
+func (backend *EncryptedBackend) putHDInfo(info *hdInfo) error {
+	backend.lk.RLock()
+	key := backend.key
+	backend.lk.RUnlock()
+	if key == nil {
+		return ErrLocked
+	}
+
+	return putEncryptedHDInfo(backend.ds, key, info)
+}
+
+// walletAddresses returns the addresses with keys in a wallet datastore.
+func walletAddresses(d repo.Datastore) ([]address.Address, error) {
+	result, err := d.Query(dsq.Query{
+		KeysOnly: true,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query datastore")
+	}
+
+	list, err := result.Rest()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read query results")
+	}
+
+	var addrs []address.Address
+	for _, el := range list {
+		if isMetadataKey(el.Key) {
+			continue
+		}
+		parsedAddr, err := address.NewFromString(strings.Trim(el.Key, "/"))
+		if err != nil {
+			return nil, errors.Wrapf(err, "trying to restore invalid address: %s", el.Key)
+		}
+		addrs = append(addrs, parsedAddr)
+	}
+	return addrs, nil
+}
+
+func putEncryptedKeyInfo(d repo.Datastore, key []byte, addr address.Address, ki *types.KeyInfo) error {
+	kib, err := ki.Marshal()
+	if err != nil {
+		return err
+	}
+
+	nonce, ciphertext, err := seal(key, kib, addr.Bytes())
+	if err != nil {
+		return err
+	}
+
+	raw, err := cbor.DumpObject(encryptedKeyInfo{
+		Nonce:      nonce,
+		Ciphertext: ciphertext,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := d.Put(ds.NewKey(addr.String()), raw); err != nil {
+		return errors.Wrap(err, "failed to store new address")
+	}
+	return nil
+}
+
+func putEncryptedHDInfo(d repo.Datastore, key []byte, info *hdInfo) error {
+	plaintext, err := cbor.DumpObject(info)
+	if err != nil {
+		return err
+	}
+
+	nonce, ciphertext, err := seal(key, plaintext, []byte(hdInfoKey.String()))
+	if err != nil {
+		return err
+	}
+
+	raw, err := cbor.DumpObject(encryptedKeyInfo{
+		Nonce:      nonce,
+		Ciphertext: ciphertext,
+	})
+	if err != nil {
+		return err
+	}
+
+	return errors.Wrap(d.Put(hdInfoKey, raw), "failed to store wallet seed")
+}
+
+func deriveKey(passphrase []byte, params *encryptionParams) ([]byte, error) {
+	key, err := scrypt.Key(passphrase, params.Salt, params.N, params.R, params.P, 32)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to derive key from passphrase")
+	}
+	return key, nil
+}
+
+// seal encrypts and authenticates plaintext with AES-256-GCM under a random nonce.
+func seal(key, plaintext, additionalData []byte) (nonce, ciphertext []byte, err error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to generate nonce")
+	}
+	return nonce, aead.Seal(nil, nonce, plaintext, additionalData), nil
+}
+
+// open decrypts and authenticates a ciphertext produced by seal.
+func open(key, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce")
+	}
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
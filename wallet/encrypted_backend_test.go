@@ -0,0 +1,128 @@
+package wallet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	tf "github.com/filecoin-project/go-filecoin/testhelpers/testflags"
+)
+
+var testPassphrase = []byte("correct horse battery staple")
+
+func TestEncryptDatastore(t *testing.T) {
+	tf.UnitTest(t)
+
+	ds := datastore.NewMapDatastore()
+	defer func() {
+		require.NoError(t, ds.Close())
+	}()
+
+	dsb, err := NewDSBackend(ds)
+	require.NoError(t, err)
+	addr, err := dsb.NewAddress()
+	require.NoError(t, err)
+	ki, err := dsb.GetKeyInfo(addr)
+	require.NoError(t, err)
+
+	encrypted, err := IsEncrypted(ds)
+	require.NoError(t, err)
+	assert.False(t, encrypted)
+
+	require.NoError(t, EncryptDatastore(ds, testPassphrase))
+
+	t.Log("datastore is marked encrypted and can't be encrypted twice")
+	encrypted, err = IsEncrypted(ds)
+	require.NoError(t, err)
+	assert.True(t, encrypted)
+	assert.Error(t, EncryptDatastore(ds, testPassphrase))
+
+	t.Log("key is no longer stored in the clear")
+	raw, err := ds.Get(datastore.NewKey(addr.String()))
+	require.NoError(t, err)
+	kib, err := ki.Marshal()
+	require.NoError(t, err)
+	assert.NotEqual(t, kib, raw)
+
+	t.Log("NewBackend picks the encrypted backend, which has the same key once unlocked")
+	backend, err := NewBackend(ds)
+	require.NoError(t, err)
+	eb, ok := backend.(*EncryptedBackend)
+	require.True(t, ok)
+	assert.Equal(t, []address.Address{addr}, eb.Addresses())
+
+	require.NoError(t, eb.Unlock(testPassphrase, 0))
+	got, err := eb.GetKeyInfo(addr)
+	require.NoError(t, err)
+	assert.True(t, ki.Equals(got))
+}
+
+func TestEncryptedBackendLocking(t *testing.T) {
+	tf.UnitTest(t)
+
+	ds := datastore.NewMapDatastore()
+	defer func() {
+		require.NoError(t, ds.Close())
+	}()
+
+	require.NoError(t, EncryptDatastore(ds, testPassphrase))
+	eb, err := NewEncryptedBackend(ds)
+	require.NoError(t, err)
+
+	t.Log("starts locked, and refuses keys while locked")
+	assert.True(t, eb.IsLocked())
+	_, err = eb.NewAddress()
+	assert.Equal(t, ErrLocked, err)
+
+	t.Log("refuses the wrong passphrase")
+	assert.Equal(t, ErrBadPassphrase, eb.Unlock([]byte("wrong"), 0))
+	assert.True(t, eb.IsLocked())
+
+	t.Log("signs once unlocked")
+	require.NoError(t, eb.Unlock(testPassphrase, 0))
+	assert.False(t, eb.IsLocked())
+	addr, err := eb.NewAddress()
+	require.NoError(t, err)
+	assert.True(t, eb.HasAddress(addr))
+
+	data := []byte("data to sign")
+	sig, err := eb.SignBytes(data, addr)
+	require.NoError(t, err)
+	ki, err := eb.GetKeyInfo(addr)
+	require.NoError(t, err)
+	assert.True(t, eb.Verify(data, ki.PublicKey(), sig))
+
+	t.Log("refuses to sign once locked again")
+	eb.Lock()
+	assert.True(t, eb.IsLocked())
+	_, err = eb.SignBytes(data, addr)
+	assert.Equal(t, ErrLocked, err)
+
+	t.Log("address is still listed by a fresh backend")
+	eb2, err := NewEncryptedBackend(ds)
+	require.NoError(t, err)
+	assert.True(t, eb2.HasAddress(addr))
+}
+
+func TestEncryptedBackendUnlockTimeout(t *testing.T) {
+	tf.UnitTest(t)
+
+	ds := datastore.NewMapDatastore()
+	defer func() {
+		require.NoError(t, ds.Close())
+	}()
+
+	require.NoError(t, EncryptDatastore(ds, testPassphrase))
+	eb, err := NewEncryptedBackend(ds)
+	require.NoError(t, err)
+
+	require.NoError(t, eb.Unlock(testPassphrase, 50*time.Millisecond))
+	assert.False(t, eb.IsLocked())
+
+	time.Sleep(200 * time.Millisecond)
+	assert.True(t, eb.IsLocked())
+}
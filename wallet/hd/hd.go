@@ -0,0 +1,153 @@
+// Package hd implements BIP32 hierarchical deterministic derivation of secp256k1 private keys.
+package hd
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	secp256k1 "github.com/ipsn/go-secp256k1"
+	"github.com/pkg/errors"
+
+	"github.com/filecoin-project/go-filecoin/crypto"
+)
+
+// HardenedOffset is added to a child index to derive a hardened child.
+const HardenedOffset uint32 = 1 << 31
+
+// FilecoinCoinType is the SLIP-44 coin type registered for filecoin.
+const FilecoinCoinType = 461
+
+// DefaultAccountPath is the BIP44 path of the first filecoin account's external chain. Addresses
+// are derived as its children.
+var DefaultAccountPath = fmt.Sprintf("m/44'/%d'/0'/0", FilecoinCoinType)
+
+// masterKeyHMACKey is the HMAC key BIP32 uses to derive the master key from a seed.
+var masterKeyHMACKey = []byte("Bitcoin seed")
+
+// ErrInvalidKey is returned in the (astronomically unlikely) case that a derived key is not a
+// valid secp256k1 private key. BIP32 says to proceed with the next index.
+var ErrInvalidKey = errors.New("derived key is invalid")
+
+// ExtendedKey is a private key together with the chain code needed to derive its children.
+type ExtendedKey struct {
+	PrivateKey []byte
+	ChainCode  []byte
+}
+
+// NewMasterKey derives the master key from a seed.
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, errors.Errorf("seed must be between 16 and 64 bytes, got %d", len(seed))
+	}
+
+	mac := hmac.New(sha512.New, masterKeyHMACKey)
+	mac.Write(seed) // nolint: errcheck
+	sum := mac.Sum(nil)
+
+	if !validPrivateKey(sum[:32]) {
+		return nil, ErrInvalidKey
+	}
+	return &ExtendedKey{PrivateKey: sum[:32], ChainCode: sum[32:]}, nil
+}
+
+// Child derives the child key at index. Indices from HardenedOffset up derive hardened children.
+func (k *ExtendedKey) Child(index uint32) (*ExtendedKey, error) {
+	var data []byte
+	if index >= HardenedOffset {
+		data = append([]byte{0}, k.PrivateKey...)
+	} else {
+		data = compressPublicKey(crypto.PublicKey(k.PrivateKey))
+	}
+	var indexBytes [4]byte
+	binary.BigEndian.PutUint32(indexBytes[:], index)
+	data = append(data, indexBytes[:]...)
+
+	mac := hmac.New(sha512.New, k.ChainCode)
+	mac.Write(data) // nolint: errcheck
+	sum := mac.Sum(nil)
+
+	if !validPrivateKey(sum[:32]) {
+		return nil, ErrInvalidKey
+	}
+
+	n := curveOrder()
+	child := new(big.Int).SetBytes(sum[:32])
+	child.Add(child, new(big.Int).SetBytes(k.PrivateKey))
+	child.Mod(child, n)
+	if child.Sign() == 0 {
+		return nil, ErrInvalidKey
+	}
+
+	privateKey := make([]byte, crypto.PrivateKeyBytes)
+	childBytes := child.Bytes()
+	copy(privateKey[crypto.PrivateKeyBytes-len(childBytes):], childBytes)
+
+	return &ExtendedKey{PrivateKey: privateKey, ChainCode: sum[32:]}, nil
+}
+
+// Derive derives the key at path from this key, which must be the master key if the path
+// starts at "m".
+func (k *ExtendedKey) Derive(path string) (*ExtendedKey, error) {
+	indices, err := ParsePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key := k
+	for _, index := range indices {
+		key, err = key.Child(index)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// ParsePath parses a derivation path such as "m/44'/461'/0'/0/1" into child indices. Hardened
+// indices are marked by a trailing ' or h.
+func ParsePath(path string) ([]uint32, error) {
+	parts := strings.Split(strings.TrimSpace(path), "/")
+	if parts[0] != "m" {
+		return nil, errors.Errorf("derivation path %s must start with m", path)
+	}
+
+	var indices []uint32
+	for _, part := range parts[1:] {
+		hardened := strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h")
+		if hardened {
+			part = part[:len(part)-1]
+		}
+
+		index, err := strconv.ParseUint(part, 10, 32)
+		if err != nil || uint32(index) >= HardenedOffset {
+			return nil, errors.Errorf("invalid index %s in derivation path %s", part, path)
+		}
+		if hardened {
+			index += uint64(HardenedOffset)
+		}
+		indices = append(indices, uint32(index))
+	}
+	return indices, nil
+}
+
+func curveOrder() *big.Int {
+	return secp256k1.S256().Params().N
+}
+
+func validPrivateKey(key []byte) bool {
+	k := new(big.Int).SetBytes(key)
+	return k.Sign() > 0 && k.Cmp(curveOrder()) < 0
+}
+
+// compressPublicKey converts an uncompressed public key to its 33 byte compressed form.
+func compressPublicKey(pk []byte) []byte {
+	compressed := make([]byte, 33)
+	compressed[0] = 2 + pk[64]&1
+	copy(compressed[1:], pk[1:33])
+	return compressed
+}
@@ -0,0 +1,82 @@
+package hd_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	tf "github.com/filecoin-project/go-filecoin/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/wallet/hd"
+)
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+// TestDeriveVector1 checks derivation against BIP32 test vector 1.
+func TestDeriveVector1(t *testing.T) {
+	tf.UnitTest(t)
+
+	master, err := hd.NewMasterKey(mustDecodeHex(t, "000102030405060708090a0b0c0d0e0f"))
+	require.NoError(t, err)
+	assert.Equal(t, "e8f32e723decf4051aefac8e2c93c9c5b214313817cdb01a1494b917c8436b35", hex.EncodeToString(master.PrivateKey))
+	assert.Equal(t, "873dff81c02f525623fd1fe5167eac3a55a049de3d314bb42ee227ffed37d508", hex.EncodeToString(master.ChainCode))
+
+	vectors := []struct {
+		path       string
+		privateKey string
+		chainCode  string
+	}{
+		{
+			path:       "m/0'",
+			privateKey: "edb2e14f9ee77d26dd93b4ecede8d16ed408ce149b6cd80b0715a2d911a0afea",
+			chainCode:  "47fdacbd0f1097043b78c63c20c34ef4ed9a111d980047ad16282c7ae6236141",
+		},
+		{
+			path:       "m/0'/1",
+			privateKey: "3c6cb8d0f6a264c91ea8b5030fadaa8e538b020f0a387421a12de9319dc93368",
+			chainCode:  "2a7857631386ba23dacac34180dd1983734e444fdbf774041578e9b6adb37c19",
+		},
+		{
+			path:       "m/0'/1/2'",
+			privateKey: "cbce0d719ecf7431d88e6a89fa1483e02e35092af60c042b1df2ff59fa424dca",
+			chainCode:  "04466b9cc8e161e966409ca52986c584f07e9dc81f735db683c3ff6ec7b1503f",
+		},
+	}
+	for _, v := range vectors {
+		key, err := master.Derive(v.path)
+		require.NoError(t, err)
+		assert.Equal(t, v.privateKey, hex.EncodeToString(key.PrivateKey), v.path)
+		assert.Equal(t, v.chainCode, hex.EncodeToString(key.ChainCode), v.path)
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	tf.UnitTest(t)
+
+	indices, err := hd.ParsePath("m/44'/461h/0'/0/7")
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{44 + hd.HardenedOffset, 461 + hd.HardenedOffset, hd.HardenedOffset, 0, 7}, indices)
+
+	indices, err = hd.ParsePath("m")
+	require.NoError(t, err)
+	assert.Empty(t, indices)
+
+	for _, bad := range []string{"", "44'/0", "m/x", "m/-1", "m/2147483648"} {
+		_, err := hd.ParsePath(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestNewMasterKeySeedLength(t *testing.T) {
+	tf.UnitTest(t)
+
+	_, err := hd.NewMasterKey(make([]byte, 15))
+	assert.Error(t, err)
+	_, err = hd.NewMasterKey(make([]byte, 65))
+	assert.Error(t, err)
+}
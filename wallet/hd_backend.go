@@ -0,0 +1,318 @@
+package wallet
+
+import (
+	"reflect"
+	"sync"
+
+	ds "github.com/ipfs/go-datastore"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/pkg/errors"
+	bip39 "github.com/tyler-smith/go-bip39"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/repo"
+	"github.com/filecoin-project/go-filecoin/types"
+	"github.com/filecoin-project/go-filecoin/wallet/hd"
+)
+
+func init() {
+	cbor.RegisterCborType(hdInfo{})
+}
+
+// HDBackendType is the reflect type of the HDBackend.
+var HDBackendType = reflect.TypeOf(&HDBackend{})
+
+// hdInfoKey is the wallet datastore key of the HD seed. Its presence marks the wallet datastore
+// as hierarchical deterministic.
+var hdInfoKey = ds.NewKey("_hd")
+
+// mnemonicEntropyBits is the entropy of generated mnemonics, which have 24 words.
+const mnemonicEntropyBits = 256
+
+// hdInfo is what's needed to derive a wallet's keys. In an encrypted wallet it is stored sealed
+// with the key derived from the wallet's passphrase.
+type hdInfo struct {
+	Mnemonic string
+	// Path is the derivation path of the account whose children are the wallet's keys.
+	Path string
+	// NextIndex is the index of the next key NewAddress derives.
+	NextIndex uint32
+}
+
+// hdKeyStore is the backend an HDBackend stores the keys it derives in: a DSBackend, or an
+// EncryptedBackend if the wallet is encrypted.
+type hdKeyStore interface {
+	Backend
+	Importer
+	putKeyInfo(ki *types.KeyInfo) error
+}
+
+// HDBackend is a wallet backend that derives new keys from a seed, following BIP32, so that
+// every key it creates can be recovered from the seed's BIP39 mnemonic. Derived keys are stored
+// like those of a DSBackend, or of an EncryptedBackend if the wallet is encrypted, in which case
+// the seed is encrypted too and keys can only be derived while the wallet is unlocked. Keys
+// imported into the backend can't be recovered from the mnemonic.
+type HDBackend struct {
+	hdKeyStore
+
+	ds repo.Datastore
+	// encrypted is the key store if the wallet is encrypted. The seed is then read from the
+	// datastore each time it's needed, so that it isn't kept in memory while locked.
+	encrypted *EncryptedBackend
+
+	hdLk sync.Mutex
+	// info and account are the seed of an unencrypted wallet.
+	info    *hdInfo
+	account *hd.ExtendedKey
+}
+
+var _ Backend = (*HDBackend)(nil)
+var _ Importer = (*HDBackend)(nil)
+
+// NewMnemonic generates the mnemonic of a new random seed.
+func NewMnemonic() (string, error) {
+	entropy, err := bip39.NewEntropy(mnemonicEntropyBits)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to generate entropy")
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// IsHD returns true if the given wallet datastore derives its keys from a seed.
+func IsHD(d repo.Datastore) (bool, error) {
+	return d.Has(hdInfoKey)
+}
+
+// InitHD makes an unencrypted wallet datastore derive new keys from the seed of mnemonic, along
+// path, so that it may be used with an HDBackend. Keys already in the datastore are kept. It
+// fails if the datastore already has a seed. Encrypted wallets take a seed with
+// Wallet.EnableHD while unlocked.
+func InitHD(d repo.Datastore, mnemonic, path string) error {
+	if encrypted, err := IsEncrypted(d); err != nil {
+		return errors.Wrap(err, "failed to read wallet datastore")
+	} else if encrypted {
+		return errors.New("encrypted wallets must be unlocked to take a seed")
+	}
+	info, err := newHDInfo(d, mnemonic, path)
+	if err != nil {
+		return err
+	}
+	return putHDInfo(d, info)
+}
+
+// newHDInfo checks that a wallet datastore has no seed yet, and that mnemonic and path are valid.
+func newHDInfo(d repo.Datastore, mnemonic, path string) (*hdInfo, error) {
+	if hasHD, err := IsHD(d); err != nil {
+		return nil, errors.Wrap(err, "failed to read wallet datastore")
+	} else if hasHD {
+		return nil, errors.New("wallet already has a seed")
+	}
+
+	if !bip39.IsMnemonicValid(mnemonic) {
+		return nil, errors.New("invalid mnemonic")
+	}
+	if _, err := hd.ParsePath(path); err != nil {
+		return nil, err
+	}
+	return &hdInfo{Mnemonic: mnemonic, Path: path}, nil
+}
+
+// NewHDBackend constructs a new backend using the passed in unencrypted datastore, which must
+// have been initialized with InitHD.
+func NewHDBackend(d repo.Datastore) (*HDBackend, error) {
+	dsb, err := NewDSBackend(d)
+	if err != nil {
+		return nil, err
+	}
+	return newHDBackend(d, dsb)
+}
+
+// NewEncryptedHDBackend constructs a new, locked, backend using the passed in encrypted
+// datastore, which must have a seed.
+func NewEncryptedHDBackend(d repo.Datastore) (*HDBackend, error) {
+	eb, err := NewEncryptedBackend(d)
+	if err != nil {
+		return nil, err
+	}
+	return newHDBackend(d, eb)
+}
+
+func newHDBackend(d repo.Datastore, keys hdKeyStore) (*HDBackend, error) {
+	backend := &HDBackend{
+		hdKeyStore: keys,
+		ds:         d,
+	}
+	if eb, ok := keys.(*EncryptedBackend); ok {
+		backend.encrypted = eb
+		return backend, nil
+	}
+
+	raw, err := d.Get(hdInfoKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read wallet seed")
+	}
+	var info hdInfo
+	if err := cbor.DecodeInto(raw, &info); err != nil {
+		return nil, errors.Wrap(err, "failed to decode wallet seed")
+	}
+	account, err := deriveAccount(&info)
+	if err != nil {
+		return nil, err
+	}
+	backend.info = &info
+	backend.account = account
+	return backend, nil
+}
+
+// Mnemonic returns the mnemonic of the backend's seed. Fails while an encrypted wallet is locked.
+func (backend *HDBackend) Mnemonic() (string, error) {
+	info, _, err := backend.seed(false)
+	if err != nil {
+		return "", err
+	}
+	return info.Mnemonic, nil
+}
+
+// Path returns the derivation path of the account whose children are the backend's keys.
+// Fails while an encrypted wallet is locked.
+func (backend *HDBackend) Path() (string, error) {
+	info, _, err := backend.seed(false)
+	if err != nil {
+		return "", err
+	}
+	return info.Path, nil
+}
+
+// KeyInfoAt derives the key at index, without storing it. Fails while an encrypted wallet is
+// locked.
+func (backend *HDBackend) KeyInfoAt(index uint32) (*types.KeyInfo, error) {
+	_, account, err := backend.seed(true)
+	if err != nil {
+		return nil, err
+	}
+	return keyInfoAt(account, index)
+}
+
+// AddIndex derives the key at index and stores it, so that its address is in the backend.
+// NewAddress won't derive keys at or before index afterward.
+// Safe for concurrent access.
+func (backend *HDBackend) AddIndex(index uint32) (address.Address, error) {
+	backend.hdLk.Lock()
+	defer backend.hdLk.Unlock()
+
+	info, account, err := backend.seed(true)
+	if err != nil {
+		return address.Undef, err
+	}
+	return backend.addIndex(info, account, index)
+}
+
+// NewAddress derives the key after the last one derived and stores it.
+// Safe for concurrent access.
+func (backend *HDBackend) NewAddress() (address.Address, error) {
+	backend.hdLk.Lock()
+	defer backend.hdLk.Unlock()
+
+	info, account, err := backend.seed(true)
+	if err != nil {
+		return address.Undef, err
+	}
+	for {
+		addr, err := backend.addIndex(info, account, info.NextIndex)
+		if err == hd.ErrInvalidKey {
+			// BIP32 says to skip indices that derive invalid keys.
+			info.NextIndex++
+			continue
+		}
+		return addr, err
+	}
+}
+
+// seed returns a copy of the backend's seed info, and the account key derived from it if
+// withAccount is true.
+func (backend *HDBackend) seed(withAccount bool) (*hdInfo, *hd.ExtendedKey, error) {
+	if backend.encrypted == nil {
+		info := *backend.info
+		return &info, backend.account, nil
+	}
+
+	info, err := backend.encrypted.getHDInfo()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !withAccount {
+		return info, nil, nil
+	}
+	account, err := deriveAccount(info)
+	if err != nil {
+		return nil, nil, err
+	}
+	return info, account, nil
+}
+
+// addIndex stores the key at index and records the next index to derive in info.
+// hdLk must be held.
+func (backend *HDBackend) addIndex(info *hdInfo, account *hd.ExtendedKey, index uint32) (address.Address, error) {
+	ki, err := keyInfoAt(account, index)
+	if err != nil {
+		return address.Undef, err
+	}
+
+	if err := backend.putKeyInfo(ki); err != nil {
+		return address.Undef, err
+	}
+
+	if index >= info.NextIndex {
+		info.NextIndex = index + 1
+		if err := backend.putSeed(info); err != nil {
+			return address.Undef, err
+		}
+	}
+
+	return ki.Address()
+}
+
+// putSeed stores the backend's seed info, sealed if the wallet is encrypted. hdLk must be held.
+func (backend *HDBackend) putSeed(info *hdInfo) error {
+	if backend.encrypted != nil {
+		return backend.encrypted.putHDInfo(info)
+	}
+	if err := putHDInfo(backend.ds, info); err != nil {
+		return err
+	}
+	backend.info = info
+	return nil
+}
+
+func deriveAccount(info *hdInfo) (*hd.ExtendedKey, error) {
+	master, err := hd.NewMasterKey(bip39.NewSeed(info.Mnemonic, ""))
+	if err != nil {
+		return nil, err
+	}
+	return master.Derive(info.Path)
+}
+
+func keyInfoAt(account *hd.ExtendedKey, index uint32) (*types.KeyInfo, error) {
+	if index >= hd.HardenedOffset {
+		return nil, errors.Errorf("index %d out of range", index)
+	}
+
+	child, err := account.Child(index)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.KeyInfo{
+		PrivateKey: child.PrivateKey,
+		Curve:      SECP256K1,
+	}, nil
+}
+
+// putHDInfo stores the seed info of an unencrypted wallet.
+func putHDInfo(d repo.Datastore, info *hdInfo) error {
+	raw, err := cbor.DumpObject(info)
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(d.Put(hdInfoKey, raw), "failed to store wallet seed")
+}
@@ -0,0 +1,241 @@
+package wallet_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/repo"
+	tf "github.com/filecoin-project/go-filecoin/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/wallet"
+	"github.com/filecoin-project/go-filecoin/wallet/hd"
+)
+
+func requireNewHDBackend(t *testing.T, ds repo.Datastore, mnemonic string) *wallet.HDBackend {
+	require.NoError(t, wallet.InitHD(ds, mnemonic, hd.DefaultAccountPath))
+	backend, err := wallet.NewHDBackend(ds)
+	require.NoError(t, err)
+	return backend
+}
+
+func TestHDBackendDeterministic(t *testing.T) {
+	tf.UnitTest(t)
+
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+	assert.Len(t, strings.Fields(mnemonic), 24)
+
+	b1 := requireNewHDBackend(t, datastore.NewMapDatastore(), mnemonic)
+	b2 := requireNewHDBackend(t, datastore.NewMapDatastore(), mnemonic)
+	got, err := b1.Mnemonic()
+	require.NoError(t, err)
+	assert.Equal(t, mnemonic, got)
+
+	t.Log("the same mnemonic derives the same addresses")
+	var addrs []address.Address
+	for i := 0; i < 3; i++ {
+		a1, err := b1.NewAddress()
+		require.NoError(t, err)
+		a2, err := b2.NewAddress()
+		require.NoError(t, err)
+		assert.Equal(t, a1, a2)
+		addrs = append(addrs, a1)
+	}
+
+	t.Log("addresses are distinct and match their index")
+	for i, addr := range addrs {
+		for _, other := range addrs[:i] {
+			assert.NotEqual(t, other, addr)
+		}
+		ki, err := b1.KeyInfoAt(uint32(i))
+		require.NoError(t, err)
+		kiAddr, err := ki.Address()
+		require.NoError(t, err)
+		assert.Equal(t, addr, kiAddr)
+	}
+
+	t.Log("a different mnemonic derives different addresses")
+	other, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+	b3 := requireNewHDBackend(t, datastore.NewMapDatastore(), other)
+	a3, err := b3.NewAddress()
+	require.NoError(t, err)
+	assert.NotEqual(t, addrs[0], a3)
+}
+
+func TestHDBackendPersists(t *testing.T) {
+	tf.UnitTest(t)
+
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+	ds := datastore.NewMapDatastore()
+	backend := requireNewHDBackend(t, ds, mnemonic)
+
+	_, err = backend.AddIndex(4)
+	require.NoError(t, err)
+
+	t.Log("NewBackend picks the HD backend, which continues after the highest index")
+	reloaded, err := wallet.NewBackend(ds)
+	require.NoError(t, err)
+	hdb, ok := reloaded.(*wallet.HDBackend)
+	require.True(t, ok)
+	assert.Len(t, hdb.Addresses(), 1)
+
+	addr, err := hdb.NewAddress()
+	require.NoError(t, err)
+	ki, err := hdb.KeyInfoAt(5)
+	require.NoError(t, err)
+	expected, err := ki.Address()
+	require.NoError(t, err)
+	assert.Equal(t, expected, addr)
+}
+
+func TestInitHDErrors(t *testing.T) {
+	tf.UnitTest(t)
+
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+
+	t.Log("rejects invalid mnemonics and paths")
+	ds := datastore.NewMapDatastore()
+	assert.Error(t, wallet.InitHD(ds, "not a valid mnemonic", hd.DefaultAccountPath))
+	assert.Error(t, wallet.InitHD(ds, mnemonic, "44'/461'"))
+
+	t.Log("rejects a second seed")
+	require.NoError(t, wallet.InitHD(ds, mnemonic, hd.DefaultAccountPath))
+	assert.Error(t, wallet.InitHD(ds, mnemonic, hd.DefaultAccountPath))
+
+	t.Log("encrypted wallets take a seed through the unlocked wallet")
+	encrypted := datastore.NewMapDatastore()
+	require.NoError(t, wallet.EncryptDatastore(encrypted, []byte("passphrase")))
+	assert.Error(t, wallet.InitHD(encrypted, mnemonic, hd.DefaultAccountPath))
+}
+
+func TestEncryptedHDBackend(t *testing.T) {
+	tf.UnitTest(t)
+
+	passphrase := []byte("passphrase")
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+
+	ds := datastore.NewMapDatastore()
+	plain := requireNewHDBackend(t, ds, mnemonic)
+	addr, err := plain.NewAddress()
+	require.NoError(t, err)
+
+	require.NoError(t, wallet.EncryptDatastore(ds, passphrase))
+
+	t.Log("the seed is no longer stored in the clear")
+	raw, err := ds.Get(datastore.NewKey("_hd"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), mnemonic)
+
+	t.Log("NewBackend picks the HD backend, which refuses to derive keys while locked")
+	backend, err := wallet.NewBackend(ds)
+	require.NoError(t, err)
+	hdb, ok := backend.(*wallet.HDBackend)
+	require.True(t, ok)
+	w := wallet.New(hdb)
+	assert.True(t, w.IsLocked())
+	assert.True(t, hdb.HasAddress(addr))
+
+	_, err = hdb.Mnemonic()
+	assert.Equal(t, wallet.ErrLocked, err)
+	_, err = wallet.NewAddress(w)
+	assert.Equal(t, wallet.ErrLocked, err)
+
+	t.Log("derives the next keys once unlocked")
+	require.NoError(t, w.Unlock(passphrase, 0))
+	got, err := hdb.Mnemonic()
+	require.NoError(t, err)
+	assert.Equal(t, mnemonic, got)
+
+	next, err := wallet.NewAddress(w)
+	require.NoError(t, err)
+	ki, err := plain.KeyInfoAt(1)
+	require.NoError(t, err)
+	expected, err := ki.Address()
+	require.NoError(t, err)
+	assert.Equal(t, expected, next)
+
+	require.NoError(t, w.Lock())
+	_, err = hdb.KeyInfoAt(1)
+	assert.Equal(t, wallet.ErrLocked, err)
+}
+
+func TestWalletEnableHDEncrypted(t *testing.T) {
+	tf.UnitTest(t)
+
+	passphrase := []byte("passphrase")
+	ds := datastore.NewMapDatastore()
+	require.NoError(t, wallet.EncryptDatastore(ds, passphrase))
+	eb, err := wallet.NewEncryptedBackend(ds)
+	require.NoError(t, err)
+	w := wallet.New(eb)
+
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+	assert.Equal(t, wallet.ErrLocked, w.EnableHD(mnemonic, hd.DefaultAccountPath))
+
+	require.NoError(t, w.Unlock(passphrase, 0))
+	require.NoError(t, w.EnableHD(mnemonic, hd.DefaultAccountPath))
+
+	derived, err := wallet.NewAddress(w)
+	require.NoError(t, err)
+	hdb, err := w.HD()
+	require.NoError(t, err)
+	ki, err := hdb.KeyInfoAt(0)
+	require.NoError(t, err)
+	expected, err := ki.Address()
+	require.NoError(t, err)
+	assert.Equal(t, expected, derived)
+
+	t.Log("the seed survives a restart and stays encrypted")
+	reloaded, err := wallet.NewBackend(ds)
+	require.NoError(t, err)
+	reloadedHD, ok := reloaded.(*wallet.HDBackend)
+	require.True(t, ok)
+	_, err = reloadedHD.Mnemonic()
+	assert.Equal(t, wallet.ErrLocked, err)
+}
+
+func TestWalletEnableHD(t *testing.T) {
+	tf.UnitTest(t)
+
+	ds := datastore.NewMapDatastore()
+	dsb, err := wallet.NewDSBackend(ds)
+	require.NoError(t, err)
+	w := wallet.New(dsb)
+
+	random, err := wallet.NewAddress(w)
+	require.NoError(t, err)
+
+	_, err = w.HD()
+	assert.Error(t, err)
+
+	mnemonic, err := wallet.NewMnemonic()
+	require.NoError(t, err)
+	require.NoError(t, w.EnableHD(mnemonic, hd.DefaultAccountPath))
+	assert.Error(t, w.EnableHD(mnemonic, hd.DefaultAccountPath))
+
+	hdb, err := w.HD()
+	require.NoError(t, err)
+	got, err := hdb.Mnemonic()
+	require.NoError(t, err)
+	assert.Equal(t, mnemonic, got)
+
+	t.Log("existing addresses are kept, and new ones are derived")
+	assert.True(t, w.HasAddress(random))
+	derived, err := wallet.NewAddress(w)
+	require.NoError(t, err)
+	ki, err := hdb.KeyInfoAt(0)
+	require.NoError(t, err)
+	expected, err := ki.Address()
+	require.NoError(t, err)
+	assert.Equal(t, expected, derived)
+	assert.Len(t, w.Addresses(), 2)
+}
@@ -0,0 +1,187 @@
+package wallet
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/crypto"
+	"github.com/filecoin-project/go-filecoin/types"
+)
+
+// RemoteBackendType is the reflect type of the RemoteBackend.
+var RemoteBackendType = reflect.TypeOf(&RemoteBackend{})
+
+// ErrKeyNotExportable is returned when the private key of an address held by a remote signer
+// is requested. Keys never leave the signer process.
+var ErrKeyNotExportable = errors.New("keys held by a remote signer cannot be exported")
+
+// remoteSignerTimeout bounds each request to the remote signer, including dialing it.
+var remoteSignerTimeout = 30 * time.Second
+
+// Methods of the remote signer protocol.
+const (
+	signerMethodAddresses = "addresses"
+	signerMethodSign      = "sign"
+)
+
+// signerRequest is a request to a remote signer. Each connection to the signer carries a single
+// JSON encoded request, followed by the signer's JSON encoded signerResponse.
+type signerRequest struct {
+	Method string `json:"method"`
+	// Address and Data are the address to sign with and the bytes to sign, for the sign method.
+	Address address.Address `json:"address,omitempty"`
+	Data    []byte          `json:"data,omitempty"`
+}
+
+// signerResponse is a remote signer's response to a signerRequest. Error is set if the request
+// failed, including when the signer's policy refused to sign.
+type signerResponse struct {
+	Addresses []signerAddress `json:"addresses,omitempty"`
+	Signature types.Signature `json:"signature,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// signerAddress is an address held by a remote signer, along with its public key.
+type signerAddress struct {
+	Address   address.Address `json:"address"`
+	PublicKey []byte          `json:"publicKey"`
+}
+
+// RemoteBackend is a wallet backend that holds no keys itself, forwarding signing requests to a
+// signer in another process over a unix socket. The signer may refuse to sign data that its
+// policy does not allow.
+type RemoteBackend struct {
+	lk sync.RWMutex
+
+	socketPath string
+
+	// cache maps the signer's addresses to their public keys.
+	cache map[address.Address][]byte
+}
+
+var _ Backend = (*RemoteBackend)(nil)
+
+// NewRemoteBackend constructs a new backend signing with the signer listening on the unix
+// socket at socketPath, and loads the addresses the signer holds.
+func NewRemoteBackend(socketPath string) (*RemoteBackend, error) {
+	backend := &RemoteBackend{
+		socketPath: socketPath,
+		cache:      make(map[address.Address][]byte),
+	}
+	if err := backend.Refresh(); err != nil {
+		return nil, err
+	}
+	return backend, nil
+}
+
+// Refresh reloads the addresses the signer holds.
+func (backend *RemoteBackend) Refresh() error {
+	resp, err := backend.request(&signerRequest{Method: signerMethodAddresses})
+	if err != nil {
+		return errors.Wrap(err, "failed to list remote signer addresses")
+	}
+
+	cache := make(map[address.Address][]byte)
+	for _, sa := range resp.Addresses {
+		cache[sa.Address] = sa.PublicKey
+	}
+
+	backend.lk.Lock()
+	defer backend.lk.Unlock()
+	backend.cache = cache
+	return nil
+}
+
+// Addresses returns a list of all addresses the signer held when last loaded.
+func (backend *RemoteBackend) Addresses() []address.Address {
+	backend.lk.RLock()
+	defer backend.lk.RUnlock()
+
+	var cpy []address.Address
+	for addr := range backend.cache {
+		cpy = append(cpy, addr)
+	}
+	return cpy
+}
+
+// HasAddress checks if the signer held the passed in address when last loaded.
+// Safe for concurrent access.
+func (backend *RemoteBackend) HasAddress(addr address.Address) bool {
+	backend.lk.RLock()
+	defer backend.lk.RUnlock()
+
+	_, ok := backend.cache[addr]
+	return ok
+}
+
+// SignBytes asks the signer to cryptographically sign `data` using the private key of `addr`.
+func (backend *RemoteBackend) SignBytes(data []byte, addr address.Address) (types.Signature, error) {
+	if !backend.HasAddress(addr) {
+		return nil, errors.New("backend does not contain address")
+	}
+
+	resp, err := backend.request(&signerRequest{
+		Method:  signerMethodSign,
+		Address: addr,
+		Data:    data,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "remote signer failed to sign")
+	}
+	return resp.Signature, nil
+}
+
+// Verify cryptographically verifies that 'sig' is the signed hash of 'data' with
+// the public key `pk`.
+func (backend *RemoteBackend) Verify(data, pk []byte, sig types.Signature) bool {
+	return crypto.Verify(pk, data, sig)
+}
+
+// GetKeyInfo always fails, as the private keys never leave the signer.
+func (backend *RemoteBackend) GetKeyInfo(addr address.Address) (*types.KeyInfo, error) {
+	return nil, ErrKeyNotExportable
+}
+
+// PublicKey returns the public key of an address held by the signer.
+func (backend *RemoteBackend) PublicKey(addr address.Address) ([]byte, error) {
+	backend.lk.RLock()
+	defer backend.lk.RUnlock()
+
+	pk, ok := backend.cache[addr]
+	if !ok {
+		return nil, errors.New("backend does not contain address")
+	}
+	return pk, nil
+}
+
+// request sends a single request to the signer and reads its response.
+func (backend *RemoteBackend) request(req *signerRequest) (*signerResponse, error) {
+	conn, err := net.DialTimeout("unix", backend.socketPath, remoteSignerTimeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to remote signer at %s", backend.socketPath)
+	}
+	defer conn.Close() // nolint: errcheck
+
+	if err := conn.SetDeadline(time.Now().Add(remoteSignerTimeout)); err != nil {
+		return nil, err
+	}
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, errors.Wrap(err, "failed to send request")
+	}
+
+	var resp signerResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, errors.Wrap(err, "failed to read response")
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return &resp, nil
+}
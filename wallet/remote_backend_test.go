@@ -0,0 +1,149 @@
+package wallet_test
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	tf "github.com/filecoin-project/go-filecoin/testhelpers/testflags"
+	"github.com/filecoin-project/go-filecoin/types"
+	"github.com/filecoin-project/go-filecoin/wallet"
+)
+
+// startSigner serves the keys in signerBackend on a unix socket, returning the socket's path and
+// a function to stop serving.
+func startSigner(t *testing.T, signerBackend wallet.Backend, policies map[address.Address]wallet.SignerPolicy) (string, func()) {
+	dir, err := ioutil.TempDir("", "remote-signer")
+	require.NoError(t, err)
+
+	socketPath := filepath.Join(dir, "signer.sock")
+	l, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+
+	go wallet.NewSignerServer(signerBackend, policies).Serve(l) // nolint: errcheck
+
+	return socketPath, func() {
+		require.NoError(t, l.Close())
+		require.NoError(t, os.RemoveAll(dir))
+	}
+}
+
+func TestRemoteBackend(t *testing.T) {
+	tf.UnitTest(t)
+
+	signerBackend, err := wallet.NewDSBackend(datastore.NewMapDatastore())
+	require.NoError(t, err)
+	addr, err := signerBackend.NewAddress()
+	require.NoError(t, err)
+	ki, err := signerBackend.GetKeyInfo(addr)
+	require.NoError(t, err)
+
+	socketPath, stop := startSigner(t, signerBackend, nil)
+	defer stop()
+
+	remote, err := wallet.NewRemoteBackend(socketPath)
+	require.NoError(t, err)
+	w := wallet.New(remote)
+
+	t.Log("lists the signer's addresses")
+	assert.Equal(t, []address.Address{addr}, w.Addresses())
+	assert.True(t, w.HasAddress(addr))
+
+	t.Log("knows public keys but doesn't export private keys")
+	pk, err := w.GetPubKeyForAddress(addr)
+	require.NoError(t, err)
+	assert.Equal(t, ki.PublicKey(), pk)
+
+	_, err = w.Export([]address.Address{addr})
+	assert.Equal(t, wallet.ErrKeyNotExportable, err)
+
+	t.Log("signs with the signer's key")
+	data := []byte("data to sign")
+	sig, err := w.SignBytes(data, addr)
+	require.NoError(t, err)
+	valid, err := w.Verify(data, pk, sig)
+	require.NoError(t, err)
+	assert.True(t, valid)
+
+	t.Log("refuses addresses the signer doesn't hold")
+	_, err = w.SignBytes(data, address.NewForTestGetter()())
+	assert.Error(t, err)
+
+	t.Log("picks up new signer addresses on refresh")
+	addr2, err := signerBackend.NewAddress()
+	require.NoError(t, err)
+	assert.False(t, w.HasAddress(addr2))
+	require.NoError(t, remote.Refresh())
+	assert.True(t, w.HasAddress(addr2))
+}
+
+func TestRemoteBackendSignerUnavailable(t *testing.T) {
+	tf.UnitTest(t)
+
+	_, err := wallet.NewRemoteBackend(filepath.Join(os.TempDir(), "no-such-signer.sock"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to connect to remote signer")
+}
+
+func TestSignerServerPolicy(t *testing.T) {
+	tf.UnitTest(t)
+
+	signerBackend, err := wallet.NewDSBackend(datastore.NewMapDatastore())
+	require.NoError(t, err)
+	restricted, err := signerBackend.NewAddress()
+	require.NoError(t, err)
+	unrestricted, err := signerBackend.NewAddress()
+	require.NoError(t, err)
+
+	addrGetter := address.NewForTestGetter()
+	allowedTo := addrGetter()
+	otherTo := addrGetter()
+
+	socketPath, stop := startSigner(t, signerBackend, map[address.Address]wallet.SignerPolicy{
+		restricted: {
+			Methods:    []string{"", "addAsk"},
+			Recipients: []address.Address{allowedTo},
+		},
+	})
+	defer stop()
+
+	remote, err := wallet.NewRemoteBackend(socketPath)
+	require.NoError(t, err)
+	w := wallet.New(remote)
+
+	sign := func(from, to address.Address, method string) error {
+		msg := types.NewMessage(from, to, 0, types.NewAttoFILFromFIL(1), method, nil)
+		_, err := types.NewSignedMessage(*msg, w, types.NewGasPrice(1), types.NewGasUnits(0))
+		return err
+	}
+
+	t.Log("allows messages matching the policy")
+	assert.NoError(t, sign(restricted, allowedTo, ""))
+	assert.NoError(t, sign(restricted, allowedTo, "addAsk"))
+
+	t.Log("refuses other methods and recipients")
+	err = sign(restricted, allowedTo, "withdraw")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "does not allow method")
+
+	err = sign(restricted, otherTo, "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "does not allow sending to")
+
+	t.Log("refuses to sign anything but messages for an address with a policy")
+	_, err = w.SignBytes([]byte("not a message"), restricted)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "only allows signing messages")
+
+	t.Log("signs anything for an address without a policy")
+	assert.NoError(t, sign(unrestricted, otherTo, "withdraw"))
+	_, err = w.SignBytes([]byte("not a message"), unrestricted)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,158 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"time"
+
+	logging "github.com/ipfs/go-log"
+	"github.com/pkg/errors"
+
+	"github.com/filecoin-project/go-filecoin/address"
+	"github.com/filecoin-project/go-filecoin/types"
+)
+
+var log = logging.Logger("wallet")
+
+// SignerPolicy restricts what a SignerServer signs with an address. An address with a policy
+// may only sign messages, from itself, whose method and recipient the policy allows.
+type SignerPolicy struct {
+	// Methods are the message methods allowed, all if empty. The empty method name allows plain
+	// value transfers.
+	Methods []string `json:"methods"`
+	// Recipients are the message recipients allowed, all if empty.
+	Recipients []address.Address `json:"recipients"`
+}
+
+// SignerServer serves the remote signer protocol used by RemoteBackend, signing with the keys
+// of a local backend.
+type SignerServer struct {
+	backend  Backend
+	policies map[address.Address]SignerPolicy
+}
+
+// NewSignerServer constructs a new SignerServer signing with the keys in backend. Addresses with
+// an entry in policies are restricted to signing what their policy allows, the rest may sign
+// anything.
+func NewSignerServer(backend Backend, policies map[address.Address]SignerPolicy) *SignerServer {
+	return &SignerServer{
+		backend:  backend,
+		policies: policies,
+	}
+}
+
+// Serve accepts connections on the listener and answers their requests, until the listener is
+// closed.
+func (s *SignerServer) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *SignerServer) handle(conn net.Conn) {
+	defer conn.Close() // nolint: errcheck
+
+	if err := conn.SetDeadline(time.Now().Add(remoteSignerTimeout)); err != nil {
+		log.Warningf("remote signer failed to set deadline: %s", err)
+		return
+	}
+
+	var req signerRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		log.Warningf("remote signer failed to read request: %s", err)
+		return
+	}
+
+	resp, err := s.respond(&req)
+	if err != nil {
+		resp = &signerResponse{Error: err.Error()}
+	}
+
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		log.Warningf("remote signer failed to send response: %s", err)
+	}
+}
+
+func (s *SignerServer) respond(req *signerRequest) (*signerResponse, error) {
+	switch req.Method {
+	case signerMethodAddresses:
+		var resp signerResponse
+		for _, addr := range s.backend.Addresses() {
+			ki, err := s.backend.GetKeyInfo(addr)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to get key for %s", addr)
+			}
+			resp.Addresses = append(resp.Addresses, signerAddress{
+				Address:   addr,
+				PublicKey: ki.PublicKey(),
+			})
+		}
+		return &resp, nil
+	case signerMethodSign:
+		if err := s.checkPolicy(req.Address, req.Data); err != nil {
+			return nil, err
+		}
+
+		sig, err := s.backend.SignBytes(req.Data, req.Address)
+		if err != nil {
+			return nil, err
+		}
+		return &signerResponse{Signature: sig}, nil
+	default:
+		return nil, errors.Errorf("unknown method %s", req.Method)
+	}
+}
+
+// checkPolicy returns an error unless the policy of addr allows signing data.
+func (s *SignerServer) checkPolicy(addr address.Address, data []byte) error {
+	policy, ok := s.policies[addr]
+	if !ok {
+		return nil
+	}
+
+	// Only messages can be checked against a policy, so refuse anything that doesn't encode
+	// exactly as one.
+	var msg types.MeteredMessage
+	if err := msg.Unmarshal(data); err != nil {
+		return errors.Errorf("policy for %s only allows signing messages", addr)
+	}
+	if encoded, err := msg.Marshal(); err != nil || !bytes.Equal(encoded, data) {
+		return errors.Errorf("policy for %s only allows signing messages", addr)
+	}
+
+	if msg.From != addr {
+		return errors.Errorf("policy for %s does not allow signing messages from %s", addr, msg.From)
+	}
+
+	if len(policy.Methods) > 0 && !containsString(policy.Methods, msg.Method) {
+		return errors.Errorf("policy for %s does not allow method %q", addr, msg.Method)
+	}
+
+	if len(policy.Recipients) > 0 && !containsAddress(policy.Recipients, msg.To) {
+		return errors.Errorf("policy for %s does not allow sending to %s", addr, msg.To)
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, el := range list {
+		if el == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAddress(list []address.Address, addr address.Address) bool {
+	for _, el := range list {
+		if el == addr {
+			return true
+		}
+	}
+	return false
+}
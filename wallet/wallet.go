@@ -6,6 +6,7 @@ import (
 	"reflect"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -140,18 +141,136 @@ func (w *Wallet) Ecrecover(data []byte, sig types.Signature) ([]byte, error) {
 
 // NewAddress creates a new account address on the default wallet backend.
 func NewAddress(w *Wallet) (address.Address, error) {
-	backends := w.Backends(DSBackendType)
-	if len(backends) == 0 {
-		return address.Undef, fmt.Errorf("missing default ds backend")
+	if backends := w.Backends(HDBackendType); len(backends) > 0 {
+		return (backends[0]).(*HDBackend).NewAddress()
+	}
+	if backends := w.Backends(DSBackendType); len(backends) > 0 {
+		return (backends[0]).(*DSBackend).NewAddress()
 	}
+	if backends := w.Backends(EncryptedBackendType); len(backends) > 0 {
+		return (backends[0]).(*EncryptedBackend).NewAddress()
+	}
+	return address.Undef, fmt.Errorf("missing default ds backend")
+}
 
-	backend := (backends[0]).(*DSBackend)
-	return backend.NewAddress()
+// Unlock unlocks the wallet's encrypted backends with the given passphrase, making their keys
+// available until Lock is called or, if timeout is not zero, until the timeout elapses.
+func (w *Wallet) Unlock(passphrase []byte, timeout time.Duration) error {
+	lockers := w.lockers()
+	if len(lockers) == 0 {
+		return errors.New("wallet is not encrypted")
+	}
+
+	for _, l := range lockers {
+		if err := l.Unlock(passphrase, timeout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Lock locks the wallet's encrypted backends, making their keys unavailable.
+func (w *Wallet) Lock() error {
+	lockers := w.lockers()
+	if len(lockers) == 0 {
+		return errors.New("wallet is not encrypted")
+	}
+
+	for _, l := range lockers {
+		l.Lock()
+	}
+	return nil
+}
+
+// IsLocked returns true if any of the wallet's encrypted backends is locked.
+func (w *Wallet) IsLocked() bool {
+	for _, l := range w.lockers() {
+		if l.IsLocked() {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *Wallet) lockers() []Locker {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+
+	var out []Locker
+	for _, backends := range w.backends {
+		for _, backend := range backends {
+			if hdb, ok := backend.(*HDBackend); ok && hdb.encrypted != nil {
+				out = append(out, hdb.encrypted)
+			} else if l, ok := backend.(Locker); ok {
+				out = append(out, l)
+			}
+		}
+	}
+	return out
+}
+
+// EnableHD makes the wallet derive new addresses from the seed of mnemonic, along path, by
+// replacing its datastore backend with an HDBackend. Addresses already in the wallet are kept,
+// but can't be recovered from the mnemonic. An encrypted wallet must be unlocked, and its seed
+// is encrypted with its keys.
+func (w *Wallet) EnableHD(mnemonic, path string) error {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+
+	if len(w.backends[HDBackendType]) > 0 {
+		return errors.New("wallet already has a seed")
+	}
+	plain, encrypted := w.backends[DSBackendType], w.backends[EncryptedBackendType]
+	if len(plain)+len(encrypted) != 1 {
+		return errors.New("expected exactly one datastore wallet backend")
+	}
+
+	var hdb *HDBackend
+	if len(plain) == 1 {
+		dsb := plain[0].(*DSBackend)
+		if err := InitHD(dsb.ds, mnemonic, path); err != nil {
+			return err
+		}
+		var err error
+		if hdb, err = newHDBackend(dsb.ds, dsb); err != nil {
+			return err
+		}
+		delete(w.backends, DSBackendType)
+	} else {
+		eb := encrypted[0].(*EncryptedBackend)
+		if err := eb.initHD(mnemonic, path); err != nil {
+			return err
+		}
+		var err error
+		if hdb, err = newHDBackend(eb.ds, eb); err != nil {
+			return err
+		}
+		delete(w.backends, EncryptedBackendType)
+	}
+
+	w.backends[HDBackendType] = []Backend{hdb}
+	return nil
+}
+
+// HD returns the wallet's HDBackend, or an error if the wallet doesn't derive keys from a seed.
+func (w *Wallet) HD() (*HDBackend, error) {
+	backends := w.Backends(HDBackendType)
+	if len(backends) == 0 {
+		return nil, errors.New("wallet has no seed, see 'go-filecoin wallet hd'")
+	}
+	return backends[0].(*HDBackend), nil
 }
 
 // GetPubKeyForAddress returns the public key in the keystore associated with
 // the given address.
 func (w *Wallet) GetPubKeyForAddress(addr address.Address) ([]byte, error) {
+	// Remote backends know their public keys, but can't export key infos.
+	if backend, err := w.Find(addr); err == nil {
+		if remote, ok := backend.(*RemoteBackend); ok {
+			return remote.PublicKey(addr)
+		}
+	}
+
 	info, err := w.keyInfoForAddr(addr)
 	if err != nil {
 		return nil, err
@@ -185,7 +304,8 @@ func (w *Wallet) keyInfoForAddr(addr address.Address) (*types.KeyInfo, error) {
 
 // Import adds the given keyinfos to the wallet
 func (w *Wallet) Import(kinfos []*types.KeyInfo) ([]address.Address, error) {
-	dsb := w.Backends(DSBackendType)
+	dsb := append(w.Backends(DSBackendType), w.Backends(EncryptedBackendType)...)
+	dsb = append(dsb, w.Backends(HDBackendType)...)
 	if len(dsb) != 1 {
 		return nil, fmt.Errorf("expected exactly one datastore wallet backend")
 	}
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/ipfs/go-datastore"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -66,6 +67,42 @@ func TestWalletSimple(t *testing.T) {
 	}
 }
 
+func TestWalletLocking(t *testing.T) {
+	tf.UnitTest(t)
+
+	t.Log("an unencrypted wallet can't be locked")
+	fs, err := wallet.NewDSBackend(datastore.NewMapDatastore())
+	require.NoError(t, err)
+	assert.Error(t, wallet.New(fs).Lock())
+	assert.False(t, wallet.New(fs).IsLocked())
+
+	t.Log("an encrypted wallet starts locked")
+	ds := datastore.NewMapDatastore()
+	require.NoError(t, wallet.EncryptDatastore(ds, []byte("passphrase")))
+	backend, err := wallet.NewBackend(ds)
+	require.NoError(t, err)
+	w := wallet.New(backend)
+	assert.True(t, w.IsLocked())
+
+	_, err = wallet.NewAddress(w)
+	assert.Equal(t, wallet.ErrLocked, errors.Cause(err))
+
+	t.Log("unlocking allows new addresses and signing")
+	assert.Equal(t, wallet.ErrBadPassphrase, w.Unlock([]byte("wrong"), 0))
+	require.NoError(t, w.Unlock([]byte("passphrase"), 0))
+	assert.False(t, w.IsLocked())
+
+	addr, err := wallet.NewAddress(w)
+	require.NoError(t, err)
+	_, err = w.SignBytes([]byte("data"), addr)
+	assert.NoError(t, err)
+
+	t.Log("locking again refuses signing")
+	require.NoError(t, w.Lock())
+	_, err = w.SignBytes([]byte("data"), addr)
+	assert.Equal(t, wallet.ErrLocked, errors.Cause(err))
+}
+
 func TestSimpleSignAndVerify(t *testing.T) {
 	tf.UnitTest(t)
 